@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/gotd/td/tg"
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+)
+
+// aclRule is what one user may do with the bot listener
+type aclRule struct {
+	User     string   `json:"user"`     // @username or user ID
+	Commands []string `json:"commands"` // e.g. "upload", "mirror", "save" or "inline"; empty allows all
+	MaxSize  string   `json:"max_size"` // largest file the user may upload or have saved, e.g. "500MB"; empty for no limit
+	Targets  []string `json:"targets"`  // chats the user may use commands in; empty allows every allowed chat
+
+	maxSize int64
+	targets map[int64]bool
+}
+
+// botACL restricts the bot listener per user. A nil ACL lets everyone in the
+// allowed chats do everything.
+type botACL struct {
+	Users []aclRule `json:"users"`
+
+	rules map[int64]*aclRule
+}
+
+// loadACL reads the ACL at path and resolves its users and chats
+func loadACL(ctx context.Context, api *tg.Client, path string) (*botACL, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ACL: %w", err)
+	}
+	var acl botACL
+	if err := json.Unmarshal(data, &acl); err != nil {
+		return nil, fmt.Errorf("failed to parse ACL %s: %w", path, err)
+	}
+
+	acl.rules = map[int64]*aclRule{}
+	for i := range acl.Users {
+		rule := &acl.Users[i]
+		id, err := aclPeerID(ctx, api, rule.User)
+		if err != nil {
+			return nil, fmt.Errorf("ACL user %q: %w", rule.User, err)
+		}
+		if rule.MaxSize != "" {
+			if rule.maxSize, err = parseSize(rule.MaxSize); err != nil {
+				return nil, fmt.Errorf("ACL user %q: invalid max_size: %w", rule.User, err)
+			}
+		}
+		if len(rule.Targets) > 0 {
+			rule.targets = map[int64]bool{}
+			for _, target := range rule.Targets {
+				chat, err := aclPeerID(ctx, api, target)
+				if err != nil {
+					return nil, fmt.Errorf("ACL user %q: target %q: %w", rule.User, target, err)
+				}
+				rule.targets[chat] = true
+			}
+		}
+		acl.rules[id] = rule
+	}
+	return &acl, nil
+}
+
+// aclPeerID returns the bare ID of a user or chat given as in the ACL file
+func aclPeerID(ctx context.Context, api *tg.Client, name string) (int64, error) {
+	if id, err := strconv.ParseInt(name, 10, 64); err == nil {
+		return telegramuploader.NormalizeChatID(id), nil
+	}
+	p, err := telegramuploader.ResolvePeer(ctx, api, name)
+	if err != nil {
+		return 0, err
+	}
+	return telegramuploader.PeerID(p), nil
+}
+
+// allows reports whether user may run command in chat, and why not. A
+// chat of 0 is for commands that aren't run in a chat, like inline queries.
+func (a *botACL) allows(user, chat int64, command string) (bool, string) {
+	if a == nil {
+		return true, ""
+	}
+	rule, ok := a.rules[user]
+	if !ok {
+		return false, "you're not allowed to use this bot"
+	}
+	if len(rule.Commands) > 0 && !slices.Contains(rule.Commands, command) {
+		return false, fmt.Sprintf("you're not allowed to use /%s", command)
+	}
+	if chat != 0 && rule.targets != nil && !rule.targets[chat] {
+		return false, "you're not allowed to use this bot in this chat"
+	}
+	return true, ""
+}
+
+// sizeLimit returns the largest file user may upload, or 0 for no limit
+func (a *botACL) sizeLimit(user int64) int64 {
+	if a == nil {
+		return 0
+	}
+	if rule, ok := a.rules[user]; ok {
+		return rule.maxSize
+	}
+	return 0
+}
+
+// messageSender returns the ID of the user who sent msg, or 0 if it was
+// posted as a channel
+func messageSender(msg *tg.Message, self int64) int64 {
+	if from, ok := msg.FromID.(*tg.PeerUser); ok {
+		return from.UserID
+	}
+	if _, ok := msg.PeerID.(*tg.PeerUser); ok {
+		// Private chats leave out the sender
+		if msg.Out {
+			return self
+		}
+		return messagePeerID(msg.PeerID)
+	}
+	return 0
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+)
+
+// backupIndex is the local index of a backup repository: which chunks are
+// stored in which message, and the snapshots referencing them
+type backupIndex struct {
+	Repo      string              `json:"repo"`
+	Chunks    map[string]chunkRef `json:"chunks"` // keyed by SHA-256 of the chunk
+	Snapshots []*snapshot         `json:"snapshots"`
+
+	path string
+}
+
+// chunkRef locates a stored chunk in the repository chat
+type chunkRef struct {
+	MessageID int `json:"message_id"`
+	Size      int `json:"size"`
+}
+
+// defaultIndexPath returns the index location for repo
+func defaultIndexPath(repo string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r == '@' {
+			return '_'
+		}
+		return r
+	}, strings.TrimPrefix(repo, "@"))
+	return filepath.Join("backups", name+".json")
+}
+
+// loadBackupIndex reads the index at path, starting an empty one if it doesn't exist yet
+func loadBackupIndex(path, repo string) (*backupIndex, error) {
+	idx := &backupIndex{Repo: repo, Chunks: map[string]chunkRef{}, path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("invalid backup index %s: %w", path, err)
+	}
+	if idx.Repo != repo {
+		return nil, fmt.Errorf("backup index %s belongs to repository %q, not %q", path, idx.Repo, repo)
+	}
+	if idx.Chunks == nil {
+		idx.Chunks = map[string]chunkRef{}
+	}
+	return idx, nil
+}
+
+// save atomically writes the index back to disk
+func (idx *backupIndex) save() error {
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(idx.path), 0700); err != nil {
+		return err
+	}
+	tmp := idx.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, idx.path)
+}
+
+// chunkStore keeps deduplicated chunks as documents in the repository chat
+type chunkStore struct {
+	api   *tg.Client
+	peer  tg.InputPeerClass
+	index *backupIndex
+}
+
+// put stores data unless a chunk with the same hash is already stored
+func (s *chunkStore) put(ctx context.Context, data []byte) (id string, uploaded bool, err error) {
+	sum := sha256.Sum256(data)
+	id = hex.EncodeToString(sum[:])
+	if _, ok := s.index.Chunks[id]; ok {
+		return id, false, nil
+	}
+
+	msg, err := sendDocumentBytes(ctx, s.api, s.peer, id+".chunk", "application/octet-stream", data, "")
+	if err != nil {
+		return "", false, fmt.Errorf("failed to store chunk %s: %w", id, err)
+	}
+	s.index.Chunks[id] = chunkRef{MessageID: msg.ID, Size: len(data)}
+	return id, true, nil
+}
+
+// storeFile chunks the file at path and stores every chunk not yet in the
+// repository, returning the file's chunk list and the number of bytes uploaded
+func (s *chunkStore) storeFile(ctx context.Context, path string) (chunks []string, uploaded int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	c := newChunker(f)
+	defer c.release()
+	for {
+		data, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, uploaded, err
+		}
+		id, isNew, err := s.put(ctx, data)
+		if err != nil {
+			return nil, uploaded, err
+		}
+		if isNew {
+			uploaded += int64(len(data))
+		}
+		chunks = append(chunks, id)
+	}
+	return chunks, uploaded, nil
+}
+
+// runBackup implements the backup subcommands
+func runBackup(args []string) error {
+	if len(args) == 0 {
+		return withExitCode(exitUsage, errors.New("usage: backup create|snapshots|ls|mount|forget|prune -repo <chat> [flags]"))
+	}
+	cmd, args := args[0], args[1:]
+
+	fs := flag.NewFlagSet("backup "+cmd, flag.ExitOnError)
+	config := &Config{}
+	credentialFlags(fs, config)
+	repo := fs.String("repo", "", "Channel or chat holding the backup chunks")
+	indexPath := fs.String("index", "", "Path of the local backup index (default: backups/<repo>.json)")
+
+	// Command-specific flags
+	var (
+		policy   retentionPolicy
+		dryRun   bool
+		cacheDir string
+	)
+	switch cmd {
+	case "mount":
+		fs.StringVar(&cacheDir, "cache", filepath.Join("backups", "cache"), "Directory caching downloaded chunks")
+	case "forget":
+		fs.IntVar(&policy.Last, "keep-last", 0, "Keep the last n snapshots")
+		fs.IntVar(&policy.Daily, "keep-daily", 0, "Keep the last snapshot of each of the last n days")
+		fs.IntVar(&policy.Weekly, "keep-weekly", 0, "Keep the last snapshot of each of the last n weeks")
+		fs.IntVar(&policy.Monthly, "keep-monthly", 0, "Keep the last snapshot of each of the last n months")
+		fallthrough
+	case "prune":
+		fs.BoolVar(&dryRun, "dry-run", false, "Only show what would be deleted")
+	}
+	fs.Parse(args)
+
+	if *repo == "" {
+		return withExitCode(exitUsage, errors.New("-repo is required"))
+	}
+	if *indexPath == "" {
+		*indexPath = defaultIndexPath(*repo)
+	}
+	idx, err := loadBackupIndex(*indexPath, *repo)
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "snapshots":
+		listSnapshots(idx)
+		return nil
+	case "ls":
+		if fs.NArg() < 1 || fs.NArg() > 2 {
+			return withExitCode(exitUsage, errors.New("usage: backup ls -repo <chat> <snapshot|latest> [path]"))
+		}
+		snap, err := idx.findSnapshot(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		listSnapshotTree(snap, fs.Arg(1))
+		return nil
+	case "create":
+		if fs.NArg() != 1 {
+			return withExitCode(exitUsage, errors.New("usage: backup create -repo <chat> [flags] <dir>"))
+		}
+		if err := validateCredentials(config); err != nil {
+			return err
+		}
+		return withClient(config, func(ctx context.Context, client *telegram.Client) error {
+			store, err := openChunkStore(ctx, client.API(), *repo, idx)
+			if err != nil {
+				return err
+			}
+			snap, stats, err := createSnapshot(ctx, store, fs.Arg(0))
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Snapshot %s saved: %d file(s), %d unchanged, %.2f MB uploaded\n",
+				snap.ShortID(), stats.Files, stats.Unchanged, float64(stats.Uploaded)/(1024*1024))
+			return nil
+		})
+	case "mount":
+		if fs.NArg() != 1 {
+			return withExitCode(exitUsage, errors.New("usage: backup mount -repo <chat> [flags] <mountpoint>"))
+		}
+		if err := validateCredentials(config); err != nil {
+			return err
+		}
+		return withClient(config, func(ctx context.Context, client *telegram.Client) error {
+			store, err := openChunkStore(ctx, client.API(), *repo, idx)
+			if err != nil {
+				return err
+			}
+			fetcher := &chunkFetcher{api: store.api, peer: store.peer, index: idx, cacheDir: cacheDir}
+			return mountBackups(ctx, fetcher, fs.Arg(0))
+		})
+	case "forget":
+		if policy.empty() {
+			return errors.New("no retention policy given; use -keep-last, -keep-daily, -keep-weekly or -keep-monthly")
+		}
+		if err := validateCredentials(config); err != nil {
+			return err
+		}
+		return withClient(config, func(ctx context.Context, client *telegram.Client) error {
+			store, err := openChunkStore(ctx, client.API(), *repo, idx)
+			if err != nil {
+				return err
+			}
+			return forgetSnapshots(ctx, store.api, store.peer, idx, policy, dryRun)
+		})
+	case "prune":
+		if err := validateCredentials(config); err != nil {
+			return err
+		}
+		return withClient(config, func(ctx context.Context, client *telegram.Client) error {
+			store, err := openChunkStore(ctx, client.API(), *repo, idx)
+			if err != nil {
+				return err
+			}
+			return pruneChunks(ctx, store.api, store.peer, idx, dryRun)
+		})
+	default:
+		return fmt.Errorf("unknown backup command %q", cmd)
+	}
+}
+
+// openChunkStore resolves the repository chat and returns a store writing to it
+func openChunkStore(ctx context.Context, api *tg.Client, repo string, idx *backupIndex) (*chunkStore, error) {
+	p, err := telegramuploader.ResolvePeer(ctx, api, repo)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkStore{api: api, peer: p, index: idx}, nil
+}
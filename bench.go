@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+)
+
+// benchResult is the outcome of uploading the test data with one setting
+type benchResult struct {
+	PartSize    int
+	Connections int
+	Elapsed     time.Duration
+	Err         error
+}
+
+// runBench implements the "bench" subcommand: uploading generated data to
+// Saved Messages with different part sizes and connection counts and
+// reporting the throughput of each
+func runBench(args []string) error {
+	config := &Config{}
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	credentialFlags(flags, config)
+	sizeFlag := flags.String("size", "256M", "Amount of random data uploaded per setting")
+	partSizes := flags.String("part-sizes", "128K,256K,512K", "Comma-separated part sizes to try (multiples of 1K dividing 512K)")
+	connections := flags.String("connections", "1,2,4", "Comma-separated connection counts to try, as with -connections")
+	keep := flags.Bool("keep", false, "Keep the test messages instead of deleting them")
+	timeoutFlags(flags, &config.Timeouts)
+	flags.Parse(args)
+
+	if err := validateCredentials(config); err != nil {
+		return err
+	}
+	if config.BotToken != "" {
+		return withExitCode(exitUsage, errors.New("bench uploads to Saved Messages, which bots don't have; log in with -phone"))
+	}
+	size, err := parseSize(*sizeFlag)
+	if err != nil || size <= 0 || size > telegramuploader.MaxFileSize {
+		return withExitCode(exitUsage, fmt.Errorf("invalid -size %q", *sizeFlag))
+	}
+	var parts []int
+	for _, s := range splitList(*partSizes) {
+		n, err := parseSize(s)
+		if err != nil || n < 1024 || n%1024 != 0 || (512*1024)%n != 0 {
+			return withExitCode(exitUsage, fmt.Errorf("invalid part size %q: it must be a multiple of 1K dividing 512K", s))
+		}
+		parts = append(parts, int(n))
+	}
+	var conns []int
+	for _, s := range splitList(*connections) {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxConnections {
+			return withExitCode(exitUsage, fmt.Errorf("invalid connection count %q: it must be between 1 and %d", s, maxConnections))
+		}
+		conns = append(conns, n)
+	}
+	if len(parts) == 0 || len(conns) == 0 {
+		return withExitCode(exitUsage, errors.New("usage: bench [-size 256M] [-part-sizes 128K,512K] [-connections 1,4]"))
+	}
+
+	return withClient(config, func(ctx context.Context, client *telegram.Client) error {
+		api := client.API()
+		var (
+			results []benchResult
+			sent    []int
+		)
+	settings:
+		for _, partSize := range parts {
+			for _, n := range conns {
+				fmt.Printf("Uploading %.2f MB with %d KB parts over %d connection(s)...\n", float64(size)/(1024*1024), partSize/1024, n)
+				r := benchResult{PartSize: partSize, Connections: n}
+				var id int
+				r.Elapsed, id, r.Err = benchUpload(ctx, client, config, size, partSize, n)
+				if id != 0 {
+					sent = append(sent, id)
+				}
+				results = append(results, r)
+				if ctx.Err() != nil {
+					break settings
+				}
+			}
+		}
+
+		fmt.Println()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "Part size\tConnections\tTime\tThroughput")
+		for _, r := range results {
+			if r.Err != nil {
+				fmt.Fprintf(w, "%d KB\t%d\t-\tfailed: %v\n", r.PartSize/1024, r.Connections, friendlyError(r.Err))
+				continue
+			}
+			speed := float64(size) / r.Elapsed.Seconds() / (1024 * 1024)
+			fmt.Fprintf(w, "%d KB\t%d\t%s\t%.2f MB/s\n", r.PartSize/1024, r.Connections, r.Elapsed.Round(100*time.Millisecond), speed)
+		}
+		w.Flush()
+
+		if *keep || len(sent) == 0 {
+			return nil
+		}
+		// Clean up even if the run was interrupted
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		defer cancel()
+		if err := deleteMessages(cleanupCtx, api, &tg.InputPeerSelf{}, sent); err != nil {
+			return fmt.Errorf("failed to delete the test messages: %w", err)
+		}
+		fmt.Printf("Deleted %d test message(s)\n", len(sent))
+		return nil
+	})
+}
+
+// benchUpload uploads size bytes of random data with the given part size
+// over n connections and sends them to Saved Messages. It returns how long
+// the upload took and the ID of the sent message.
+func benchUpload(ctx context.Context, client *telegram.Client, config *Config, size int64, partSize, n int) (time.Duration, int, error) {
+	var rpc uploader.Client = client.API()
+	if n > 1 {
+		pool, err := client.Pool(int64(n))
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to open connections: %w", err)
+		}
+		defer pool.Close()
+		rpc = tg.NewClient(pool)
+	}
+	u := uploader.NewUploader(rpc).WithPartSize(partSize).WithThreads(n)
+
+	// Random data, so nothing along the way can compress it
+	data := io.LimitReader(rand.NewChaCha8([32]byte{byte(partSize >> 10), byte(n)}), size)
+	name := fmt.Sprintf("bench-%dk-%d.bin", partSize/1024, n)
+	progress := newFileProgress("upload", name, size, nil)
+	uploadCtx, cancel := phaseContext(ctx, config.Timeouts.Upload)
+	defer cancel()
+	start := time.Now()
+	upload, err := u.Upload(uploadCtx, uploader.NewUpload(name, progress.reader(data), size))
+	elapsed := time.Since(start)
+	progress.finish()
+	if err != nil {
+		return 0, 0, phaseError(uploadCtx, "uploading", err)
+	}
+
+	randomID, err := generateRandomID()
+	if err != nil {
+		return 0, 0, err
+	}
+	sendCtx, cancel := phaseContext(ctx, config.Timeouts.Send)
+	defer cancel()
+	updates, err := client.API().MessagesSendMedia(sendCtx, &tg.MessagesSendMediaRequest{
+		Peer:     &tg.InputPeerSelf{},
+		Media:    documentMedia(upload, "application/octet-stream", name),
+		Message:  fmt.Sprintf("Upload benchmark: %d KB parts, %d connection(s)", partSize/1024, n),
+		RandomID: randomID,
+		Silent:   true,
+	})
+	if err == nil {
+		var msg *tg.Message
+		if msg, err = telegramuploader.SentMessage(updates); err == nil {
+			return elapsed, msg.ID, nil
+		}
+	}
+	// The time is still valid; there's just no message to clean up
+	fmt.Printf("Failed to send the test file: %v\n", friendlyError(err))
+	return elapsed, 0, nil
+}
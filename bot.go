@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/gotd/td/tg"
+)
+
+// botTokenEnv holds the token to log in as a bot with, so it needn't be
+// given on the command line
+const botTokenEnv = "FILEUPLOADER_BOT_TOKEN"
+
+// sessionName returns the name of the session file for config's account
+func sessionName(config *Config) string {
+	if config.BotToken != "" {
+		// The bot's ID, which is the part of the token before the colon
+		id, _, _ := strings.Cut(config.BotToken, ":")
+		return "bot" + id
+	}
+	return strings.ReplaceAll(config.Phone, "+", "")
+}
+
+// buttonFlags collects the repeatable -button flag as inline URL buttons
+type buttonFlags []*tg.KeyboardButtonURL
+
+func (b *buttonFlags) String() string {
+	var s []string
+	for _, button := range *b {
+		s = append(s, button.Text+"|"+button.URL)
+	}
+	return strings.Join(s, ", ")
+}
+
+func (b *buttonFlags) Set(value string) error {
+	text, link, ok := strings.Cut(value, "|")
+	text, link = strings.TrimSpace(text), strings.TrimSpace(link)
+	if !ok || text == "" || link == "" {
+		return fmt.Errorf("expected \"Text|URL\", got %q", value)
+	}
+	if u, err := url.Parse(link); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid button URL %q", link)
+	}
+	*b = append(*b, &tg.KeyboardButtonURL{Text: text, URL: link})
+	return nil
+}
+
+// replyMarkup returns an inline keyboard with one button per row, or nil
+// if there are no buttons
+func (b buttonFlags) replyMarkup() tg.ReplyMarkupClass {
+	if len(b) == 0 {
+		return nil
+	}
+	markup := &tg.ReplyInlineMarkup{}
+	for _, button := range b {
+		markup.Rows = append(markup.Rows, tg.KeyboardButtonRow{Buttons: []tg.KeyboardButtonClass{button}})
+	}
+	return markup
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// maxQueuedJobs is how many jobs may wait in the bot listener's queue
+const maxQueuedJobs = 16
+
+// botJob is one job of the bot listener, like an upload or a search
+type botJob struct {
+	id     int
+	label  string // the command that started it
+	state  string // "queued", "running", "done", "failed" or "cancelled"
+	err    error
+	run    func(ctx context.Context) error
+	cancel context.CancelFunc // set while running
+}
+
+// botQueue runs the listener's jobs one at a time, away from the update
+// loop, and lets them be listed, cancelled, retried and paused
+type botQueue struct {
+	mu     sync.Mutex
+	jobs   []*botJob
+	nextID int
+	paused bool
+	wake   chan struct{}
+}
+
+func newBotQueue() *botQueue {
+	return &botQueue{nextID: 1, wake: make(chan struct{}, 1)}
+}
+
+// notify wakes the worker up
+func (q *botQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// add queues run under label and returns the job's number
+func (q *botQueue) add(label string, run func(ctx context.Context) error) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var queued int
+	for _, j := range q.jobs {
+		if j.state == "queued" {
+			queued++
+		}
+	}
+	if queued >= maxQueuedJobs {
+		return 0, errors.New("too many jobs queued; try again later")
+	}
+	job := &botJob{id: q.nextID, label: label, state: "queued", run: run}
+	q.nextID++
+	q.jobs = append(q.jobs, job)
+	// Forget old finished jobs
+	if len(q.jobs) > 100 {
+		q.jobs = q.jobs[len(q.jobs)-100:]
+	}
+	q.notify()
+	return job.id, nil
+}
+
+// find returns job id; q.mu must be held
+func (q *botQueue) find(id int) (*botJob, error) {
+	for _, j := range q.jobs {
+		if j.id == id {
+			return j, nil
+		}
+	}
+	return nil, fmt.Errorf("there's no job %d", id)
+}
+
+// cancel stops job id if it's queued or running
+func (q *botQueue) cancel(id int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, err := q.find(id)
+	if err != nil {
+		return err
+	}
+	switch job.state {
+	case "queued":
+		job.state = "cancelled"
+	case "running":
+		job.state = "cancelled"
+		job.cancel()
+	default:
+		return fmt.Errorf("job %d is already %s", id, job.state)
+	}
+	return nil
+}
+
+// retry queues failed or cancelled job id again
+func (q *botQueue) retry(id int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, err := q.find(id)
+	if err != nil {
+		return err
+	}
+	if job.state != "failed" && job.state != "cancelled" {
+		return fmt.Errorf("job %d is %s; only failed or cancelled jobs can be retried", id, job.state)
+	}
+	job.state, job.err = "queued", nil
+	q.notify()
+	return nil
+}
+
+// setPaused stops or resumes starting new jobs; a running job finishes
+func (q *botQueue) setPaused(paused bool) {
+	q.mu.Lock()
+	q.paused = paused
+	q.mu.Unlock()
+	q.notify()
+}
+
+// String lists the jobs that are waiting, running or recently finished
+func (q *botQueue) String() string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var b strings.Builder
+	if q.paused {
+		b.WriteString("Queue paused; /resume to continue\n")
+	}
+	if len(q.jobs) == 0 {
+		b.WriteString("No jobs")
+	}
+	for _, j := range q.jobs[max(len(q.jobs)-20, 0):] {
+		fmt.Fprintf(&b, "#%d %s: %s", j.id, j.state, j.label)
+		if j.err != nil {
+			fmt.Fprintf(&b, " (%v)", friendlyError(j.err))
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// next marks the oldest queued job as running and returns it, or nil if
+// there is none or the queue is paused
+func (q *botQueue) next(ctx context.Context) (*botJob, context.Context) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.paused {
+		return nil, nil
+	}
+	for _, j := range q.jobs {
+		if j.state == "queued" {
+			jobCtx, cancel := context.WithCancel(ctx)
+			j.state, j.cancel = "running", cancel
+			return j, jobCtx
+		}
+	}
+	return nil, nil
+}
+
+// run works through the queue until ctx is cancelled
+func (q *botQueue) run(ctx context.Context) {
+	for {
+		job, jobCtx := q.next(ctx)
+		if job == nil {
+			select {
+			case <-q.wake:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		err := job.run(jobCtx)
+		q.mu.Lock()
+		job.cancel()
+		switch {
+		case job.state != "running":
+			// Cancelled, and maybe already queued again
+		case err != nil:
+			job.state, job.err = "failed", err
+		default:
+			job.state = "done"
+		}
+		q.mu.Unlock()
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/updates"
+	"github.com/gotd/td/tg"
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// botCommand is a command received by the bot listener
+type botCommand struct {
+	name string // without the slash or bot username
+	arg  string
+	msg  *tg.Message
+	peer tg.InputPeerClass // the chat the command came from
+
+	maxSize int64 // largest file the sender may upload; 0 for no limit
+}
+
+// parseBotCommand splits "/upload@mybot https://…" into "upload" and its argument
+func parseBotCommand(text string) (name, arg string, ok bool) {
+	if !strings.HasPrefix(text, "/") {
+		return "", "", false
+	}
+	name, arg, _ = strings.Cut(strings.TrimSpace(text[1:]), " ")
+	name, _, _ = strings.Cut(name, "@")
+	return strings.ToLower(name), strings.TrimSpace(arg), name != ""
+}
+
+// inputPeerOf turns the peer of a received message into an input peer,
+// using the access hashes that came with the update
+func inputPeerOf(e tg.Entities, p tg.PeerClass) (tg.InputPeerClass, error) {
+	switch p := p.(type) {
+	case *tg.PeerUser:
+		if u, ok := e.Users[p.UserID]; ok {
+			return u.AsInputPeer(), nil
+		}
+	case *tg.PeerChat:
+		return &tg.InputPeerChat{ChatID: p.ChatID}, nil
+	case *tg.PeerChannel:
+		if ch, ok := e.Channels[p.ChannelID]; ok {
+			return ch.AsInputPeer(), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown peer %v", p)
+}
+
+// messagePeerID returns the bare user, chat or channel ID of p
+func messagePeerID(p tg.PeerClass) int64 {
+	switch p := p.(type) {
+	case *tg.PeerUser:
+		return p.UserID
+	case *tg.PeerChat:
+		return p.ChatID
+	case *tg.PeerChannel:
+		return p.ChannelID
+	default:
+		return 0
+	}
+}
+
+// botReply sends text to p in reply to message replyTo and returns the
+// ID of the reply
+func botReply(ctx context.Context, api *tg.Client, p tg.InputPeerClass, replyTo int, text string) (int, error) {
+	randomID, err := generateRandomID()
+	if err != nil {
+		return 0, err
+	}
+	updates, err := api.MessagesSendMessage(ctx, &tg.MessagesSendMessageRequest{
+		Peer:     p,
+		ReplyTo:  &tg.InputReplyToMessage{ReplyToMsgID: replyTo},
+		Message:  text,
+		RandomID: randomID,
+	})
+	if err != nil {
+		return 0, err
+	}
+	msg, err := telegramuploader.SentMessage(updates)
+	if err != nil {
+		return 0, err
+	}
+	return msg.ID, nil
+}
+
+// runBot implements the "bot" subcommand
+func runBot(args []string) error {
+	if len(args) == 0 || args[0] != "serve" {
+		return withExitCode(exitUsage, errors.New("usage: bot serve -allow <chats>"))
+	}
+	return runBotServe(args[1:])
+}
+
+// runBotServe listens for /upload and /mirror commands in the allowed chats
+// and uploads the file at the given URL back to the chat, and answers /find
+// and /recent from the journal. /jobs, /cancel, /retry, /pause and /resume
+// manage the queue of these jobs. With -save-dir,
+// media sent to those chats is downloaded there as well. Inline queries of
+// allowed users are answered with matching uploads from the journal.
+func runBotServe(args []string) error {
+	config := &Config{}
+	flags := flag.NewFlagSet("bot serve", flag.ExitOnError)
+	credentialFlags(flags, config)
+	allow := flags.String("allow", "", "Comma-separated chats (usernames or IDs) whose commands are accepted")
+	aclPath := flags.String("acl", "", "JSON file restricting which users may use which commands, in which chats and up to what size")
+	rateLimit := flags.Int("rate-limit", 10, "Commands accepted per minute from each user and in each chat (0 for no limit)")
+	saveDir := flags.String("save-dir", "", "Download photos and files sent or forwarded to the allowed chats into this directory")
+	flags.StringVar(&config.JournalPath, "journal", defaultJournalPath, "Record uploads in this file (empty to disable)")
+	flags.StringVar(&config.FileCachePath, "file-cache", defaultFileCachePath, "Keep the Telegram IDs of uploaded files here (empty to disable)")
+	timeoutFlags(flags, &config.Timeouts)
+	applyProgressFlags := progressFlags(flags)
+	applySystemdFlags := systemdFlags(flags)
+	applyLogTargetFlags := logTargetFlags(flags)
+	applyTracingFlags := tracingFlags(flags)
+	applyProfileFlags := profileFlags(flags)
+	applyRateFlags := uploadRateFlags(flags)
+	flags.Parse(args)
+	if err := applyProgressFlags(); err != nil {
+		return err
+	}
+	applySystemdFlags()
+	if err := applyLogTargetFlags(); err != nil {
+		return err
+	}
+	if err := applyTracingFlags(); err != nil {
+		return err
+	}
+	if err := applyProfileFlags(); err != nil {
+		return err
+	}
+	rates, err := applyRateFlags()
+	if err != nil {
+		return err
+	}
+	config.Rates = rates
+
+	if *allow == "" {
+		return withExitCode(exitUsage, errors.New("-allow is required, so that strangers can't drive uploads"))
+	}
+	if err := validateCredentials(config); err != nil {
+		return err
+	}
+
+	dispatcher := tg.NewUpdateDispatcher()
+	gaps := updates.New(updates.Config{Handler: dispatcher})
+	config.UpdateHandler = gaps
+
+	return withClient(config, func(ctx context.Context, client *telegram.Client) error {
+		api := client.API()
+		self, err := client.Self(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get own account: %w", err)
+		}
+
+		allowed := map[int64]bool{}
+		for _, chat := range splitList(*allow) {
+			if id, err := strconv.ParseInt(chat, 10, 64); err == nil {
+				// Bots can't look chats up by ID, so take it as given
+				allowed[telegramuploader.NormalizeChatID(id)] = true
+				continue
+			}
+			p, err := telegramuploader.ResolvePeer(ctx, api, chat)
+			if err != nil {
+				return err
+			}
+			if _, ok := p.(*tg.InputPeerSelf); ok {
+				allowed[self.ID] = true
+			} else {
+				allowed[telegramuploader.PeerID(p)] = true
+			}
+		}
+
+		var acl *botACL
+		if *aclPath != "" {
+			if acl, err = loadACL(ctx, api, *aclPath); err != nil {
+				return err
+			}
+		}
+
+		limiter := newRateLimiter(*rateLimit)
+		jobs := newBotQueue()
+		go jobs.run(ctx)
+		queue := func(uctx context.Context, p tg.InputPeerClass, msg *tg.Message, label string, run func(ctx context.Context) error) (int, error) {
+			id, err := jobs.add(label, run)
+			if err != nil {
+				_, err = botReply(uctx, api, p, msg.ID, "❌ "+err.Error())
+			}
+			return id, err
+		}
+		// Jobs outlive the update that started them, so they don't use
+		// the update's uctx
+		onMessage := func(uctx context.Context, e tg.Entities, m tg.MessageClass) error {
+			msg, ok := m.(*tg.Message)
+			if !ok {
+				return nil
+			}
+			chat, sender := messagePeerID(msg.PeerID), messageSender(msg, self.ID)
+			if *saveDir != "" && msg.Media != nil && allowed[chat] && dropWanted(msg, chat == self.ID) {
+				if ok, _ := acl.allows(sender, chat, "save"); !ok {
+					return nil
+				}
+				if !limiter.allow(chat, sender) {
+					log.Printf("Rate limit: not saving message %d from user %d in chat %d", msg.ID, sender, chat)
+					return nil
+				}
+				p, err := inputPeerOf(e, msg.PeerID)
+				if err != nil {
+					return err
+				}
+				maxSize := acl.sizeLimit(sender)
+				_, err = queue(uctx, p, msg, "save media of message "+strconv.Itoa(msg.ID), func(ctx context.Context) error {
+					return serveSave(ctx, api, p, msg, *saveDir, maxSize)
+				})
+				return err
+			}
+
+			name, arg, ok := parseBotCommand(msg.Message)
+			switch {
+			case !ok:
+				return nil
+			case name == "upload", name == "mirror":
+			case (name == "find" || name == "recent") && config.JournalPath != "":
+			case name == "jobs", name == "cancel", name == "retry", name == "pause", name == "resume":
+			default:
+				return nil
+			}
+			if !allowed[chat] {
+				log.Printf("Ignoring /%s from chat %d, which isn't allowed", name, chat)
+				return nil
+			}
+			p, err := inputPeerOf(e, msg.PeerID)
+			if err != nil {
+				return err
+			}
+			if ok, reason := acl.allows(sender, chat, name); !ok {
+				log.Printf("Refusing /%s from user %d in chat %d: %s", name, sender, chat, reason)
+				_, err := botReply(uctx, api, p, msg.ID, "❌ "+reason)
+				return err
+			}
+			if !limiter.allow(chat, sender) {
+				// Not even a reply, which would only add to the flood
+				log.Printf("Rate limit: ignoring /%s from user %d in chat %d", name, sender, chat)
+				return nil
+			}
+			cmd := botCommand{name: name, arg: arg, msg: msg, peer: p, maxSize: acl.sizeLimit(sender)}
+			label := strings.TrimSpace("/" + name + " " + arg)
+			switch name {
+			case "find", "recent":
+				_, err := queue(uctx, p, msg, label, func(ctx context.Context) error {
+					return serveFind(ctx, api, config, cmd)
+				})
+				return err
+			case "upload", "mirror":
+				id, err := queue(uctx, p, msg, label, func(ctx context.Context) error {
+					return serveUpload(ctx, client, config, cmd)
+				})
+				if err != nil {
+					return err
+				}
+				_, err = botReply(uctx, api, p, msg.ID, fmt.Sprintf("Queued as job #%d", id))
+				return err
+			}
+			_, err = botReply(uctx, api, p, msg.ID, controlQueue(jobs, name, arg))
+			return err
+		}
+		dispatcher.OnNewMessage(func(ctx context.Context, e tg.Entities, u *tg.UpdateNewMessage) error {
+			return onMessage(ctx, e, u.Message)
+		})
+		dispatcher.OnNewChannelMessage(func(ctx context.Context, e tg.Entities, u *tg.UpdateNewChannelMessage) error {
+			return onMessage(ctx, e, u.Message)
+		})
+		dispatcher.OnBotInlineQuery(func(ctx context.Context, e tg.Entities, u *tg.UpdateBotInlineQuery) error {
+			// Anyone can type the bot's name; only allowed users see the files
+			ok, _ := acl.allows(u.UserID, 0, "inline")
+			if !limiter.allow(u.UserID) {
+				return nil
+			}
+			if !ok || !allowed[u.UserID] || config.JournalPath == "" || config.FileCachePath == "" {
+				_, err := api.MessagesSetInlineBotResults(ctx, &tg.MessagesSetInlineBotResultsRequest{Private: true, QueryID: u.QueryID})
+				return err
+			}
+			if err := answerInlineQuery(ctx, api, config, u); err != nil {
+				log.Printf("Failed to answer inline query %q: %v", u.Query, err)
+			}
+			return nil
+		})
+
+		return gaps.Run(ctx, api, self.ID, updates.AuthOptions{
+			IsBot: self.Bot,
+			OnStart: func(ctx context.Context) {
+				daemonReady(ctx, client)
+				fmt.Printf("Listening for commands in %d chat(s); press Ctrl-C to stop\n", len(allowed))
+				if *saveDir != "" {
+					fmt.Printf("Media sent to them is saved to %s\n", *saveDir)
+				}
+			},
+		})
+	})
+}
+
+// controlQueue carries out /jobs, /cancel, /retry, /pause and /resume and
+// returns the reply
+func controlQueue(jobs *botQueue, name, arg string) string {
+	switch name {
+	case "pause":
+		jobs.setPaused(true)
+		return "Paused; running jobs finish, new ones wait for /resume"
+	case "resume":
+		jobs.setPaused(false)
+		return "Resumed"
+	case "cancel", "retry":
+		id, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+		if err != nil {
+			return fmt.Sprintf("Usage: /%s <job number>", name)
+		}
+		if name == "cancel" {
+			err = jobs.cancel(id)
+		} else {
+			err = jobs.retry(id)
+		}
+		if err != nil {
+			return "❌ " + err.Error()
+		}
+		return fmt.Sprintf("Job #%d %s", id, map[string]string{"cancel": "cancelled", "retry": "queued again"}[name])
+	}
+	return jobs.String()
+}
+
+// serveUpload downloads the URL of cmd and uploads it to the chat it came
+// from, reporting how it went in replies
+func serveUpload(ctx context.Context, client *telegram.Client, config *Config, cmd botCommand) (err error) {
+	ctx, span := startSpan(ctx, "bot /"+cmd.name, attribute.String("url", cmd.arg), attribute.Int64("chat", messagePeerID(cmd.msg.PeerID)))
+	defer func() { endSpan(span, err) }()
+	api := client.API()
+	reply := func(text string) {
+		if ctx.Err() != nil {
+			// Cancelled; /cancel has answered already
+			return
+		}
+		if _, err := botReply(ctx, api, cmd.peer, cmd.msg.ID, text); err != nil {
+			log.Printf("Failed to reply to /%s: %v", cmd.name, err)
+		}
+	}
+
+	u, err := url.Parse(cmd.arg)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		reply(fmt.Sprintf("Usage: /%s <http or https URL>", cmd.name))
+		return fmt.Errorf("invalid URL %q", cmd.arg)
+	}
+	fmt.Printf("/%s %s from chat %d\n", cmd.name, cmd.arg, messagePeerID(cmd.msg.PeerID))
+
+	reply("Downloading " + cmd.arg)
+	downloadCtx, downloadSpan := startSpan(ctx, "download")
+	// The limit is checked against Content-Length before anything is
+	// written, and enforced while copying for servers that don't send it
+	tmpPath, err := downloadFileLimited(downloadCtx, cmd.arg, cmd.maxSize)
+	endSpan(downloadSpan, err)
+	if errors.Is(err, errDownloadTooLarge) {
+		reply(fmt.Sprintf("❌ %v; you may upload at most %.2f MB", err, float64(cmd.maxSize)/(1024*1024)))
+		return err
+	}
+	if err != nil {
+		reply(fmt.Sprintf("❌ Download failed: %v", err))
+		return fmt.Errorf("download failed: %w", err)
+	}
+	defer os.Remove(tmpPath)
+
+	name := path.Base(u.Path)
+	if name == "" || name == "/" || name == "." {
+		name = "downloaded_file"
+	}
+	fileConfig := *config
+	fileConfig.Input = telegramuploader.FileSource(tmpPath)
+	fileConfig.FileName = name
+	fileConfig.Source = cmd.arg
+	fileConfig.Peer = cmd.peer
+	fileConfig.TargetID = strconv.FormatInt(messagePeerID(cmd.msg.PeerID), 10)
+	fileConfig.NoPrompt = true
+	fileConfig.UpdateHandler = nil
+	fileConfig.LiveStatus = &liveStatusConfig{Peer: cmd.peer, ReplyTo: cmd.msg.ID}
+
+	// The status message reports how the upload went
+	return uploadFile(ctx, client, &fileConfig)
+}
+
+// dropWanted reports whether the media of msg should be saved. In Saved
+// Messages every message is outgoing, so only the tool's own uploads,
+// recognizable by their caption, are left out; elsewhere only media
+// received from others is saved.
+func dropWanted(msg *tg.Message, savedMessages bool) bool {
+	if savedMessages {
+		return !strings.HasPrefix(msg.Message, "Uploaded file: ")
+	}
+	return !msg.Out
+}
+
+// serveSave downloads the media of msg into dir and replies where it went.
+// Media larger than maxSize bytes is refused, unless maxSize is 0.
+func serveSave(ctx context.Context, api *tg.Client, p tg.InputPeerClass, msg *tg.Message, dir string, maxSize int64) error {
+	if _, _, size, ok := mediaFile(msg); ok && maxSize > 0 && size > maxSize {
+		err := fmt.Errorf("%w: %.2f MB; you may save at most %.2f MB", errDownloadTooLarge, float64(size)/(1024*1024), float64(maxSize)/(1024*1024))
+		log.Printf("Not saving message %d: %v", msg.ID, err)
+		if _, replyErr := botReply(ctx, api, p, msg.ID, "❌ "+err.Error()); replyErr != nil {
+			log.Printf("Failed to reply to message %d: %v", msg.ID, replyErr)
+		}
+		return err
+	}
+	path, saveErr := saveMedia(ctx, api, msg, dir)
+	if ctx.Err() != nil {
+		return saveErr
+	}
+	text := "Saved to " + path
+	if saveErr != nil {
+		text = fmt.Sprintf("❌ Saving failed: %v", saveErr)
+		log.Printf("Failed to save media of message %d: %v", msg.ID, saveErr)
+	} else {
+		fmt.Printf("Saved %s\n", path)
+	}
+	if _, err := botReply(ctx, api, p, msg.ID, text); err != nil {
+		log.Printf("Failed to reply to message %d: %v", msg.ID, err)
+	}
+	return saveErr
+}
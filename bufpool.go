@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// copyBufferSize is the size of the buffers local copies go through, the
+// same as an upload part
+const copyBufferSize = 512 << 10
+
+// bufferPool hands out byte slices of one size and takes them back, so that
+// transfers of many files don't allocate fresh buffers for every file
+type bufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+func newBufferPool(size int) *bufferPool {
+	p := &bufferPool{size: size}
+	p.pool.New = func() any {
+		b := make([]byte, size)
+		return &b
+	}
+	return p
+}
+
+// get returns a buffer of the pool's size
+func (p *bufferPool) get() *[]byte {
+	return p.pool.Get().(*[]byte)
+}
+
+// put returns b to the pool; it must not be used afterwards
+func (p *bufferPool) put(b *[]byte) {
+	*b = (*b)[:cap(*b)]
+	p.pool.Put(b)
+}
+
+var (
+	copyBuffers  = newBufferPool(copyBufferSize)
+	chunkBuffers = newBufferPool(chunkMax)
+
+	// chunkReaders are the read-ahead buffers of chunkers
+	chunkReaders = sync.Pool{New: func() any { return bufio.NewReaderSize(nil, 1<<20) }}
+)
+
+// copyPooled is io.Copy through a buffer from copyBuffers. It always uses
+// the buffer; io.Copy would allocate one of its own whenever src or dst
+// implement the shortcut interfaces without being able to take them.
+func copyPooled(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBuffers.get()
+	defer copyBuffers.put(buf)
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *buf)
+}
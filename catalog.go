@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// catalogQuery filters journal entries
+type catalogQuery struct {
+	Terms   []string
+	Target  string
+	MinSize int64
+	MaxSize int64 // 0 means no limit
+	Since   time.Time
+	Until   time.Time
+}
+
+// catalogResult is a matching journal entry with its relevance
+type catalogResult struct {
+	Entry JournalEntry
+	Score int
+}
+
+// searchCatalog returns the entries matching q, best matches first
+func searchCatalog(entries []JournalEntry, q catalogQuery) []catalogResult {
+	var results []catalogResult
+	for _, e := range entries {
+		if q.Target != "" && normalizeTarget(e.Target) != normalizeTarget(q.Target) {
+			continue
+		}
+		if e.Size < q.MinSize || (q.MaxSize > 0 && e.Size > q.MaxSize) {
+			continue
+		}
+		if (!q.Since.IsZero() && e.Time.Before(q.Since)) || (!q.Until.IsZero() && !e.Time.Before(q.Until)) {
+			continue
+		}
+
+		haystack := strings.ToLower(strings.Join([]string{e.Name, e.OriginalName, e.Source}, " "))
+		score, ok := 0, true
+		for _, term := range q.Terms {
+			s := matchTerm(haystack, strings.ToLower(term))
+			if s == 0 {
+				ok = false
+				break
+			}
+			score += s
+		}
+		if ok {
+			results = append(results, catalogResult{Entry: e, Score: score})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Entry.Time.After(results[j].Entry.Time)
+	})
+	return results
+}
+
+// matchTerm scores how well term matches haystack: 3 for a substring, 2 for
+// a word within one typo, 1 for the letters appearing in order, 0 otherwise
+func matchTerm(haystack, term string) int {
+	if strings.Contains(haystack, term) {
+		return 3
+	}
+	if len(term) >= 4 {
+		words := strings.FieldsFunc(haystack, func(r rune) bool {
+			return r == ' ' || r == '/' || r == '\\' || r == '.' || r == '_' || r == '-'
+		})
+		for _, w := range words {
+			if editDistance(w, term) <= 1 {
+				return 2
+			}
+		}
+	}
+	if isSubsequence(term, haystack) {
+		return 1
+	}
+	return 0
+}
+
+// isSubsequence reports whether the characters of s appear in t in order
+func isSubsequence(s, t string) bool {
+	i := 0
+	for j := 0; i < len(s) && j < len(t); j++ {
+		if s[i] == t[j] {
+			i++
+		}
+	}
+	return i == len(s)
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// normalizeTarget makes targets comparable regardless of "@" and case
+func normalizeTarget(target string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(target), "@"))
+}
+
+// parseInterleaved parses args with fs, allowing flags after positional
+// arguments, and returns the positional arguments
+func parseInterleaved(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			return positional
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// parseDate parses a YYYY-MM-DD date in local time
+func parseDate(s string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+// runSearch implements the search subcommand
+func runSearch(args []string) error {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	journalPath := fs.String("journal", defaultJournalPath, "Path of the upload journal")
+	target := fs.String("target", "", "Only show uploads to this target")
+	minSize := fs.String("min-size", "", "Only show files of at least this size (e.g. 1M)")
+	maxSize := fs.String("max-size", "", "Only show files of at most this size (e.g. 2G)")
+	since := fs.String("since", "", "Only show uploads on or after this date (YYYY-MM-DD)")
+	until := fs.String("until", "", "Only show uploads before this date (YYYY-MM-DD)")
+	limit := fs.Int("limit", 50, "Maximum number of results")
+	terms := parseInterleaved(fs, args)
+
+	q := catalogQuery{Target: *target}
+	for _, t := range terms {
+		q.Terms = append(q.Terms, strings.Fields(t)...)
+	}
+	var err error
+	if *minSize != "" {
+		if q.MinSize, err = parseSize(*minSize); err != nil {
+			return err
+		}
+	}
+	if *maxSize != "" {
+		if q.MaxSize, err = parseSize(*maxSize); err != nil {
+			return err
+		}
+	}
+	if *since != "" {
+		if q.Since, err = parseDate(*since); err != nil {
+			return withExitCode(exitUsage, fmt.Errorf("invalid -since date: %w", err))
+		}
+	}
+	if *until != "" {
+		if q.Until, err = parseDate(*until); err != nil {
+			return withExitCode(exitUsage, fmt.Errorf("invalid -until date: %w", err))
+		}
+	}
+	if len(q.Terms) == 0 && q.Target == "" && q.MinSize == 0 && q.MaxSize == 0 && q.Since.IsZero() && q.Until.IsZero() {
+		return withExitCode(exitUsage, errors.New("usage: search [flags] <query>"))
+	}
+
+	entries, err := readJournal(*journalPath)
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	results := searchCatalog(liveEntries(entries), q)
+	if len(results) == 0 {
+		fmt.Println("No matching uploads found")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Uploaded\tSize\tTarget\tMessage\tName")
+	for i, r := range results {
+		if i == *limit {
+			break
+		}
+		e := r.Entry
+		name := e.Name
+		if e.OriginalName != "" {
+			name = fmt.Sprintf("%s (%s)", e.OriginalName, e.Name)
+		} else if e.Source != "" && filepath.Base(e.Source) != e.Name {
+			name = fmt.Sprintf("%s (%s)", e.Name, e.Source)
+		}
+		fmt.Fprintf(w, "%s\t%.2f MB\t%s\t%d\t%s\n", e.Time.Local().Format("2006-01-02 15:04"),
+			float64(e.Size)/(1024*1024), e.Target, e.MessageID, name)
+	}
+	w.Flush()
+	if len(results) > *limit {
+		fmt.Printf("%d more result(s) not shown; use -limit to see more\n", len(results)-*limit)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"io"
+)
+
+// Content-defined chunk sizes. Chunk boundaries depend only on the data, so
+// an insertion in a file only changes the chunks around it.
+const (
+	chunkMin  = 1 << 20
+	chunkMax  = 16 << 20
+	chunkMask = 1<<22 - 1 // ~4 MiB average chunk size
+)
+
+// gearTable holds the per-byte values of the gear rolling hash. It must
+// never change, or previously stored chunks would stop deduplicating.
+var gearTable = func() (t [256]uint64) {
+	// splitmix64 with a fixed seed
+	x := uint64(0x5f3759df)
+	for i := range t {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		t[i] = z ^ z>>31
+	}
+	return t
+}()
+
+// chunker splits a stream into content-defined chunks
+type chunker struct {
+	r      *bufio.Reader
+	buf    []byte
+	pooled *[]byte // backs buf
+}
+
+// newChunker starts chunking r with buffers from the pools; release
+// returns them once the chunker is no longer used
+func newChunker(r io.Reader) *chunker {
+	br := chunkReaders.Get().(*bufio.Reader)
+	br.Reset(r)
+	buf := chunkBuffers.get()
+	return &chunker{r: br, buf: (*buf)[:0], pooled: buf}
+}
+
+// release returns the chunker's buffers to the pools. Chunks returned by
+// Next are invalid afterwards.
+func (c *chunker) release() {
+	c.r.Reset(nil)
+	chunkReaders.Put(c.r)
+	chunkBuffers.put(c.pooled)
+	c.r, c.buf, c.pooled = nil, nil, nil
+}
+
+// Next returns the next chunk or io.EOF at the end of the stream. The
+// returned slice is only valid until the next call.
+func (c *chunker) Next() ([]byte, error) {
+	c.buf = c.buf[:0]
+	var hash uint64
+	for len(c.buf) < chunkMax {
+		b, err := c.r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.buf = append(c.buf, b)
+
+		// No boundaries before the minimum size
+		if len(c.buf) < chunkMin {
+			continue
+		}
+		hash = hash<<1 + gearTable[b]
+		if hash&chunkMask == 0 {
+			break
+		}
+	}
+
+	if len(c.buf) == 0 {
+		return nil, io.EOF
+	}
+	return c.buf, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// largeFileGuard asks before unusually large files are uploaded, so a disk
+// image isn't shipped over a slow connection by accident
+type largeFileGuard struct {
+	over int64 // files larger than this need confirming; 0 for none
+	yes  bool  // confirmed up front with -yes
+}
+
+// largeFileFlags registers -confirm-over and -yes on fs. The returned
+// function builds the guard once fs has been parsed.
+func largeFileFlags(fs *flag.FlagSet) func() (largeFileGuard, error) {
+	over := fs.String("confirm-over", "0", "Ask before uploading files larger than this, like 500M (0 to never ask)")
+	yes := fs.Bool("yes", false, "Upload files over -confirm-over without asking")
+	return func() (largeFileGuard, error) {
+		size, err := parseSize(*over)
+		if err != nil {
+			return largeFileGuard{}, withExitCode(exitUsage, fmt.Errorf("invalid -confirm-over %q", *over))
+		}
+		return largeFileGuard{over: size, yes: *yes}, nil
+	}
+}
+
+// needsConfirming reports whether a file of size bytes has to be confirmed
+func (g largeFileGuard) needsConfirming(size int64) bool {
+	return g.over > 0 && size > g.over && !g.yes
+}
+
+// confirm asks on the terminal whether to go ahead with what question
+// describes. Without a terminal the answer is no.
+func (g largeFileGuard) confirm(question string) bool {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false
+	}
+	return askYesNo(question)
+}
+
+// askYesNo prints question and reads a yes or no answer, no by default
+func askYesNo(question string) bool {
+	fmt.Print(question + " [y/N] ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gotd/td/tg"
+	xdraw "golang.org/x/image/draw"
+)
+
+// contactSheetTileWidth is the width of each frame on a contact sheet
+const contactSheetTileWidth = 320
+
+// contactSheet is a grid of frames of a video, posted as a photo reply to
+// it so viewers can see what's in it before downloading it
+type contactSheet struct {
+	Cols, Rows int
+}
+
+// parseContactSheet parses a -contact-sheet grid like 4x4
+func parseContactSheet(s string) (*contactSheet, error) {
+	c, r, ok := strings.Cut(strings.ToLower(s), "x")
+	cols, err1 := strconv.Atoi(c)
+	rows, err2 := strconv.Atoi(r)
+	if !ok || err1 != nil || err2 != nil || cols < 1 || rows < 1 || cols > 10 || rows > 10 {
+		return nil, fmt.Errorf("invalid grid %q, like 4x4 (at most 10x10)", s)
+	}
+	return &contactSheet{Cols: cols, Rows: rows}, nil
+}
+
+// render grabs evenly spaced frames of the video at path with ffmpeg and
+// lays them out as a JPEG
+func (c *contactSheet) render(path string) ([]byte, error) {
+	probe, err := probeVideo(path)
+	if err != nil {
+		return nil, err
+	}
+	duration, _ := strconv.ParseFloat(probe.Format.Duration, 64)
+	if duration <= 0 {
+		return nil, fmt.Errorf("the video's duration is unknown")
+	}
+	dir, err := os.MkdirTemp("", "contactsheet-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	count := c.Cols * c.Rows
+	frames := make([]image.Image, 0, count)
+	for i := range count {
+		// The middle of each of count equal parts, skipping the very
+		// start and end, which are often black
+		at := duration * (float64(i) + 0.5) / float64(count)
+		out := filepath.Join(dir, fmt.Sprintf("%d.png", i))
+		var stderr bytes.Buffer
+		cmd := exec.Command("ffmpeg", "-hide_banner", "-nostdin", "-v", "error", "-y",
+			"-ss", strconv.FormatFloat(at, 'f', 3, 64), "-i", path,
+			"-frames:v", "1", "-vf", fmt.Sprintf("scale=%d:-2", contactSheetTileWidth), out)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		frame, err := readImage(out)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read frame: %w", err)
+		}
+		frames = append(frames, frame)
+	}
+
+	// All tiles get the first frame's size
+	tileW, tileH := frames[0].Bounds().Dx(), frames[0].Bounds().Dy()
+	const gap = 4
+	sheet := image.NewRGBA(image.Rect(0, 0, c.Cols*(tileW+gap)+gap, c.Rows*(tileH+gap)+gap))
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	for i, frame := range frames {
+		x, y := gap+(i%c.Cols)*(tileW+gap), gap+(i/c.Cols)*(tileH+gap)
+		xdraw.ApproxBiLinear.Scale(sheet, image.Rect(x, y, x+tileW, y+tileH), frame, frame.Bounds(), draw.Src, nil)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, sheet, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// readImage decodes the image file at path
+func readImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// post renders the contact sheet of the video at path and sends it as a
+// photo replying to the message msgID in target
+func (c *contactSheet) post(ctx context.Context, api *tg.Client, target tg.InputPeerClass, path, name string, msgID int) error {
+	fmt.Printf("Making a %dx%d contact sheet...\n", c.Cols, c.Rows)
+	sheet, err := c.render(path)
+	if err != nil {
+		return err
+	}
+	if _, err := replyPhotoBytes(ctx, api, target, msgID, "contact-sheet.jpg", sheet, "Preview of "+name); err != nil {
+		return err
+	}
+	fmt.Println("Contact sheet sent as a reply")
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gotd/td/telegram/downloader"
+	"github.com/gotd/td/tg"
+)
+
+// mediaFile returns the download location, file name and size of the
+// photo or document of msg
+func mediaFile(msg *tg.Message) (tg.InputFileLocationClass, string, int64, bool) {
+	switch m := msg.Media.(type) {
+	case *tg.MessageMediaDocument:
+		doc, ok := m.Document.AsNotEmpty()
+		if !ok {
+			return nil, "", 0, false
+		}
+		name := documentName(doc)
+		if name == "" {
+			name = fmt.Sprintf("document_%d%s", doc.ID, extensionOf(doc.MimeType))
+		}
+		return &tg.InputDocumentFileLocation{
+			ID:            doc.ID,
+			AccessHash:    doc.AccessHash,
+			FileReference: doc.FileReference,
+		}, name, doc.Size, true
+	case *tg.MessageMediaPhoto:
+		photo, ok := m.Photo.AsNotEmpty()
+		if !ok {
+			return nil, "", 0, false
+		}
+		// The last size is the largest
+		var size tg.PhotoSizeClass
+		for _, s := range photo.Sizes {
+			switch s.(type) {
+			case *tg.PhotoSize, *tg.PhotoSizeProgressive:
+				size = s
+			}
+		}
+		if size == nil {
+			return nil, "", 0, false
+		}
+		var bytes int64
+		switch s := size.(type) {
+		case *tg.PhotoSize:
+			bytes = int64(s.Size)
+		case *tg.PhotoSizeProgressive:
+			if len(s.Sizes) > 0 {
+				bytes = int64(s.Sizes[len(s.Sizes)-1])
+			}
+		}
+		return &tg.InputPhotoFileLocation{
+			ID:            photo.ID,
+			AccessHash:    photo.AccessHash,
+			FileReference: photo.FileReference,
+			ThumbSize:     size.GetType(),
+		}, fmt.Sprintf("photo_%d.jpg", photo.ID), bytes, true
+	}
+	return nil, "", 0, false
+}
+
+// extensionOf returns a file extension for a few common MIME types
+func extensionOf(mimeType string) string {
+	switch mimeType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "video/mp4":
+		return ".mp4"
+	case "audio/mpeg":
+		return ".mp3"
+	case "audio/ogg":
+		return ".ogg"
+	case "application/pdf":
+		return ".pdf"
+	case "application/zip":
+		return ".zip"
+	}
+	return ""
+}
+
+// freePath returns dir/name, or dir/name with a number added if that
+// already exists
+func freePath(dir, name string) string {
+	p := filepath.Join(dir, name)
+	ext := filepath.Ext(name)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(p); errors.Is(err, os.ErrNotExist) {
+			return p
+		}
+		p = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext))
+	}
+}
+
+// saveMedia downloads the photo or document of msg into dir and returns
+// the path it was saved to
+func saveMedia(ctx context.Context, api *tg.Client, msg *tg.Message, dir string) (string, error) {
+	location, name, size, ok := mediaFile(msg)
+	if !ok {
+		return "", errors.New("the message has no photo or document")
+	}
+	// Never trust a sender's name to stay inside dir
+	name = filepath.Base(filepath.Clean("/" + name))
+	if name == "/" || name == "." {
+		name = "file"
+	}
+	tmp, err := downloadMedia(ctx, api, location, name, size, dir)
+	if err != nil {
+		return "", err
+	}
+	dst := freePath(dir, name)
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return dst, nil
+}
+
+// downloadMedia downloads the file at location, called name and size bytes
+// long, into a temporary file in dir and returns its path. The caller
+// renames or removes it.
+func downloadMedia(ctx context.Context, api *tg.Client, location tg.InputFileLocationClass, name string, size int64, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(dir, ".download-*")
+	if err != nil {
+		return "", err
+	}
+	defer tmp.Close()
+
+	progress := newFileProgress("download", name, size, nil)
+	_, err = downloader.NewDownloader().Download(api, location).Stream(ctx, progress.writer(tmp))
+	progress.finish()
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("download failed: %w", err)
+	}
+	return tmp.Name(), nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/term"
+)
+
+// Encrypted file layout:
+//
+//	magic (8) | kdf (1) | time (4) | memory KiB (4) | threads (1) | salt (16) | chunks...
+//
+// Each chunk is encChunkSize bytes of plaintext sealed with AES-256-GCM. The
+// header is authenticated as additional data of every chunk, and the final
+// chunk uses a distinct nonce so truncated files fail to decrypt.
+const (
+	encMagic      = "TFUENC01"
+	encChunkSize  = 64 * 1024
+	encSaltSize   = 16
+	encHeaderSize = len(encMagic) + 1 + 4 + 4 + 1 + encSaltSize
+	encKeySize    = 32
+
+	kdfArgon2id byte = 1
+
+	// Bounds on the Argon2id parameters accepted from a file header, so a
+	// crafted header can't make decryption panic or exhaust memory
+	maxKDFTime    = 64
+	maxKDFMemory  = 4 * 1024 * 1024 // KiB, i.e. 4 GiB
+	maxKDFThreads = 64
+
+	// passphraseEnv is checked when -passphrase-prompt is not given
+	passphraseEnv = "FILEUPLOADER_PASSPHRASE"
+)
+
+// kdfParams holds the Argon2id parameters embedded in the file header
+type kdfParams struct {
+	Time    uint32
+	Memory  uint32 // in KiB
+	Threads uint8
+	Salt    []byte
+}
+
+// newKDFParams returns the default Argon2id parameters with a fresh random salt
+func newKDFParams() (kdfParams, error) {
+	salt := make([]byte, encSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return kdfParams{}, err
+	}
+	return kdfParams{
+		Time:    3,
+		Memory:  64 * 1024,
+		Threads: 4,
+		Salt:    salt,
+	}, nil
+}
+
+func (p kdfParams) deriveKey(passphrase string) []byte {
+	return argon2.IDKey([]byte(passphrase), p.Salt, p.Time, p.Memory, p.Threads, encKeySize)
+}
+
+func (p kdfParams) header() []byte {
+	h := make([]byte, 0, encHeaderSize)
+	h = append(h, encMagic...)
+	h = append(h, kdfArgon2id)
+	h = binary.BigEndian.AppendUint32(h, p.Time)
+	h = binary.BigEndian.AppendUint32(h, p.Memory)
+	h = append(h, p.Threads)
+	h = append(h, p.Salt...)
+	return h
+}
+
+func parseHeader(h []byte) (kdfParams, error) {
+	if len(h) != encHeaderSize || string(h[:len(encMagic)]) != encMagic {
+		return kdfParams{}, errors.New("not an encrypted file")
+	}
+	h = h[len(encMagic):]
+	if h[0] != kdfArgon2id {
+		return kdfParams{}, fmt.Errorf("unsupported key derivation function %d", h[0])
+	}
+	p := kdfParams{
+		Time:    binary.BigEndian.Uint32(h[1:5]),
+		Memory:  binary.BigEndian.Uint32(h[5:9]),
+		Threads: h[9],
+		Salt:    append([]byte(nil), h[10:]...),
+	}
+	if p.Time < 1 || p.Time > maxKDFTime || p.Threads < 1 || p.Threads > maxKDFThreads ||
+		p.Memory < 8*uint32(p.Threads) || p.Memory > maxKDFMemory {
+		return kdfParams{}, fmt.Errorf("invalid key derivation parameters (time %d, memory %d KiB, threads %d)", p.Time, p.Memory, p.Threads)
+	}
+	return p, nil
+}
+
+// chunkNonce builds the GCM nonce for the given chunk index
+func chunkNonce(index uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, index)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptFile encrypts the file at path with a key derived from passphrase
+// and returns the path of the encrypted temporary file
+func encryptFile(path, passphrase string) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	params, err := newKDFParams()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	aead, err := newGCM(params.deriveKey(passphrase))
+	if err != nil {
+		return "", err
+	}
+
+	out, err := os.CreateTemp("", "upload-*.enc")
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	header := params.header()
+	if _, err := out.Write(header); err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+
+	// Read one chunk ahead so the last chunk can be sealed as final
+	r := bufio.NewReaderSize(in, encChunkSize)
+	buf := make([]byte, encChunkSize)
+	sealed := make([]byte, 0, encChunkSize+aead.Overhead())
+	for index := uint64(0); ; index++ {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			os.Remove(out.Name())
+			return "", err
+		}
+		final := n < encChunkSize
+		if !final {
+			if _, err := r.Peek(1); err == io.EOF {
+				final = true
+			}
+		}
+
+		sealed = aead.Seal(sealed[:0], chunkNonce(index, final), buf[:n], header)
+		if _, err := out.Write(sealed); err != nil {
+			os.Remove(out.Name())
+			return "", err
+		}
+		if final {
+			break
+		}
+	}
+
+	return out.Name(), nil
+}
+
+// decryptFile decrypts a file produced by encryptFile into dst
+func decryptFile(src, dst, passphrase string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	r := bufio.NewReader(in)
+	header := make([]byte, encHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return errors.New("not an encrypted file")
+	}
+	params, err := parseHeader(header)
+	if err != nil {
+		return err
+	}
+	aead, err := newGCM(params.deriveKey(passphrase))
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	buf := make([]byte, encChunkSize+aead.Overhead())
+	plain := make([]byte, 0, encChunkSize)
+	for index := uint64(0); ; index++ {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		final := n < len(buf)
+		if !final {
+			if _, err := r.Peek(1); err == io.EOF {
+				final = true
+			}
+		}
+
+		plain, err = aead.Open(plain[:0], chunkNonce(index, final), buf[:n], header)
+		if err != nil {
+			return errors.New("decryption failed: wrong passphrase or corrupted file")
+		}
+		if _, err := out.Write(plain); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
+
+// readPassphrase prompts for a passphrase without echo, asking twice when
+// confirm is set, or falls back to FILEUPLOADER_PASSPHRASE
+func readPassphrase(prompt, confirm bool) (string, error) {
+	if !prompt {
+		if p := os.Getenv(passphraseEnv); p != "" {
+			return p, nil
+		}
+		return "", fmt.Errorf("no passphrase given; use -passphrase-prompt or set %s", passphraseEnv)
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("-passphrase-prompt requires an interactive terminal")
+	}
+
+	fmt.Print("Enter passphrase: ")
+	first, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+	passphrase := string(first)
+	if passphrase == "" {
+		return "", errors.New("passphrase must not be empty")
+	}
+
+	if confirm {
+		fmt.Print("Confirm passphrase: ")
+		second, err := term.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return "", err
+		}
+		if string(second) != passphrase {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+	return passphrase, nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// exifOrientationTag is the TIFF tag holding how a photo must be rotated
+// or flipped to be shown upright
+const exifOrientationTag = 0x0112
+
+// jpegExif returns the TIFF data of the EXIF segment of a JPEG, or nil if
+// it has none
+func jpegExif(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil
+		}
+		marker := data[i+1]
+		if marker == 0xD9 || marker == 0xDA {
+			// End of image, or start of the image data: no more metadata
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		i += 2 + length
+	}
+	return nil
+}
+
+// exifOrientation returns the orientation, 1 to 8, recorded in the TIFF
+// data of an EXIF segment, or 1 (upright) if there is none
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) != exifOrientationTag {
+			continue
+		}
+		// A SHORT, stored in the first two bytes of the value field
+		if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+			return o
+		}
+		break
+	}
+	return 1
+}
+
+// orientationExif returns the TIFF data of an EXIF segment holding only an
+// orientation, which is what stripping leaves of a rotated photo's EXIF
+func orientationExif(orientation int) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, exifOrientationTag)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3) // SHORT
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, uint16(orientation))
+	tiff = binary.BigEndian.AppendUint16(tiff, 0)
+	// No further IFDs
+	return binary.BigEndian.AppendUint32(tiff, 0)
+}
+
+// stripJPEGMetadata returns a JPEG without its EXIF, XMP, IPTC and comment
+// segments. The image data, color profile and Adobe color transform are
+// kept as they are, and so is the orientation of a rotated photo.
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errors.New("not a JPEG file")
+	}
+	orientation := exifOrientation(jpegExif(data))
+	out := []byte{0xFF, 0xD8}
+	wroteOrientation := orientation == 1
+	for i := 2; ; {
+		if i+4 > len(data) || data[i] != 0xFF {
+			return nil, errors.New("malformed JPEG file")
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// Fill byte
+			i++
+			continue
+		}
+		if !wroteOrientation && marker != 0xE0 {
+			// After the JFIF header, which has to come first
+			segment := append([]byte("Exif\x00\x00"), orientationExif(orientation)...)
+			out = append(out, 0xFF, 0xE1)
+			out = binary.BigEndian.AppendUint16(out, uint16(len(segment)+2))
+			out = append(out, segment...)
+			wroteOrientation = true
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			// The image data and everything after it stay as they are
+			return append(out, data[i:]...), nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil, errors.New("malformed JPEG file")
+		}
+		switch {
+		case marker == 0xE1, marker >= 0xE3 && marker <= 0xED, marker == 0xEF, marker == 0xFE:
+			// EXIF and XMP (APP1), IPTC (APP13), other application data
+			// and comments
+		default:
+			out = append(out, data[i:i+2+length]...)
+		}
+		i += 2 + length
+	}
+}
+
+// pngMetadataChunks are the PNG chunks that can hold EXIF data, text like
+// locations and authors, or when the image was made
+var pngMetadataChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+// stripPNGMetadata returns a PNG without its metadata chunks
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(signature)) {
+		return nil, errors.New("not a PNG file")
+	}
+	out := []byte(signature)
+	for i := len(signature); i < len(data); {
+		if i+12 > len(data) {
+			return nil, errors.New("malformed PNG file")
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errors.New("malformed PNG file")
+		}
+		if !pngMetadataChunks[string(data[i+4:i+8])] {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out, nil
+}
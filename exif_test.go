@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// secret marks metadata in the fixtures; none of it may survive stripping
+const secret = "SECRET-LOCATION"
+
+// testImage returns a small image with some detail to encode
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 16), uint8(y * 32), 128, 255})
+		}
+	}
+	return img
+}
+
+// gpsExif returns the TIFF data of an EXIF segment with an orientation,
+// unless it's 0, and a GPS IFD holding secret
+func gpsExif(orientation int) []byte {
+	var entries [][]byte
+	entry := func(tag, typ uint16, count, value uint32) {
+		e := binary.BigEndian.AppendUint16(nil, tag)
+		e = binary.BigEndian.AppendUint16(e, typ)
+		e = binary.BigEndian.AppendUint32(e, count)
+		entries = append(entries, binary.BigEndian.AppendUint32(e, value))
+	}
+	if orientation != 0 {
+		entry(exifOrientationTag, 3, 1, uint32(orientation)<<16)
+	}
+	// IFD0 is at 8, and the GPS IFD follows it
+	gps := uint32(8 + 2 + 12*(len(entries)+1) + 4)
+	entry(0x8825, 4, 1, gps)
+
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, uint16(len(entries)))
+	for _, e := range entries {
+		tiff = append(tiff, e...)
+	}
+	tiff = binary.BigEndian.AppendUint32(tiff, 0)
+	// GPSAreaInformation, pointing right after the GPS IFD
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, 0x001C)
+	tiff = binary.BigEndian.AppendUint16(tiff, 7)
+	tiff = binary.BigEndian.AppendUint32(tiff, uint32(len(secret)))
+	tiff = binary.BigEndian.AppendUint32(tiff, gps+2+12+4)
+	tiff = binary.BigEndian.AppendUint32(tiff, 0)
+	return append(tiff, secret...)
+}
+
+// jpegSegment returns a JPEG marker segment
+func jpegSegment(marker byte, payload []byte) []byte {
+	s := []byte{0xFF, marker}
+	s = binary.BigEndian.AppendUint16(s, uint16(len(payload)+2))
+	return append(s, payload...)
+}
+
+// pngChunk returns a PNG chunk with its CRC
+func pngChunk(typ string, payload []byte) []byte {
+	c := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	c = append(c, typ...)
+	c = append(c, payload...)
+	return binary.BigEndian.AppendUint32(c, crc32.ChecksumIEEE(c[4:]))
+}
+
+func TestStripJPEGMetadata(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	plain := encoded.Bytes()
+	jfif := jpegSegment(0xE0, []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"))
+
+	tests := []struct {
+		name        string
+		head        []byte // segments after SOI
+		orientation int    // what the stripped file must record
+	}{
+		{"upright with GPS", jpegSegment(0xE1, append([]byte("Exif\x00\x00"), gpsExif(0)...)), 1},
+		{"rotated with GPS", jpegSegment(0xE1, append([]byte("Exif\x00\x00"), gpsExif(6)...)), 6},
+		{"rotated after JFIF", append(bytes.Clone(jfif), jpegSegment(0xE1, append([]byte("Exif\x00\x00"), gpsExif(8)...))...), 8},
+		{"XMP and comment", append(jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00"+secret)), jpegSegment(0xFE, []byte(secret))...), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := append([]byte{0xFF, 0xD8}, tt.head...)
+			data = append(data, plain[2:]...)
+			out, err := stripJPEGMetadata(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(out, []byte(secret)) {
+				t.Error("metadata survived")
+			}
+			tiff := jpegExif(out)
+			if got := exifOrientation(tiff); got != tt.orientation {
+				t.Errorf("orientation %d, want %d", got, tt.orientation)
+			}
+			if tt.orientation == 1 && tiff != nil {
+				t.Error("EXIF segment kept for an upright photo")
+			}
+			if tt.orientation != 1 && !bytes.Equal(tiff, orientationExif(tt.orientation)) {
+				t.Error("EXIF segment holds more than the orientation")
+			}
+			if bytes.Contains(tt.head, jfif) && !bytes.HasPrefix(out[2:], jfif) {
+				t.Error("JFIF header no longer first")
+			}
+			// Everything from the start of the image data on is untouched
+			sos := bytes.Index(plain, []byte{0xFF, 0xDA})
+			if !bytes.HasSuffix(out, plain[sos:]) {
+				t.Error("image data changed")
+			}
+			if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+				t.Errorf("stripped file doesn't decode: %v", err)
+			}
+		})
+	}
+}
+
+func TestStripPNGMetadata(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	plain := encoded.Bytes()
+	// The signature and IHDR, which metadata chunks follow
+	const ihdrEnd = 8 + 12 + 13
+
+	tests := []struct {
+		name   string
+		chunks []byte
+	}{
+		{"tEXt", pngChunk("tEXt", []byte("Comment\x00"+secret))},
+		{"eXIf", pngChunk("eXIf", gpsExif(6))},
+		{"zTXt iTXt and tIME", append(append(pngChunk("zTXt", []byte("Author\x00\x00"+secret)),
+			pngChunk("iTXt", []byte("Location\x00\x00\x00\x00\x00"+secret))...),
+			pngChunk("tIME", []byte{0x07, 0xEA, 10, 17, 12, 0, 0})...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := append(bytes.Clone(plain[:ihdrEnd]), tt.chunks...)
+			data = append(data, plain[ihdrEnd:]...)
+			out, err := stripPNGMetadata(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(out, []byte(secret)) || bytes.Contains(out, []byte("tIME")) {
+				t.Error("metadata survived")
+			}
+			if !bytes.Equal(out, plain) {
+				t.Error("image chunks changed")
+			}
+			if _, err := png.Decode(bytes.NewReader(out)); err != nil {
+				t.Errorf("stripped file doesn't decode: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+)
+
+// Exit codes, so scripts can tell failures apart
+const (
+	exitFailure      = 1 // anything not listed below
+	exitUsage        = 2 // invalid flags or arguments
+	exitAuth         = 3 // login failed or the session was revoked
+	exitPeerNotFound = 4 // the target couldn't be resolved
+	exitFileTooLarge = 5 // the file exceeds Telegram's size limit
+	exitFloodWait    = 6 // Telegram asked us to wait before retrying
+	exitPartialBatch = 7 // some files of a batch failed
+	exitNetwork      = 8 // the network or Telegram was unreachable
+	exitForbidden    = 9 // this account may not post to the target
+)
+
+// exitError attaches an exit code to an error
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// withExitCode makes the program exit with code if err ends it
+func withExitCode(code int, err error) error {
+	return &exitError{code: code, err: err}
+}
+
+// exitCode returns the exit code for err, recognising the library's classes
+// of errors, Telegram's among them, and network errors that weren't given
+// one explicitly
+func exitCode(err error) int {
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	classified := telegramuploader.Classify(err)
+	var flood *telegramuploader.FloodWaitError
+	switch {
+	case errors.As(classified, &flood):
+		return exitFloodWait
+	case errors.Is(classified, telegramuploader.ErrPeerNotFound):
+		return exitPeerNotFound
+	case sendForbidden(err):
+		return exitForbidden
+	case errors.Is(classified, telegramuploader.ErrFileTooLarge):
+		return exitFileTooLarge
+	case errors.Is(classified, telegramuploader.ErrUnauthorized):
+		return exitAuth
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return exitNetwork
+	}
+	return exitFailure
+}
+
+// fatal logs err, with advice for known Telegram errors, and exits with the matching exit code
+func fatal(err error) {
+	if systemdLogging || loggingToTarget {
+		// Marks the line as an error for the journal and -log-target
+		log.Print("<3>", friendlyError(err))
+	} else {
+		log.Print(friendlyError(err))
+	}
+	stopProfiling()
+	stopTracing()
+	flushLogs()
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gotd/td/telegram/downloader"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// chunkFetcher downloads stored chunks on demand and keeps them in a local
+// cache directory, so every chunk is fetched from Telegram at most once
+type chunkFetcher struct {
+	api      *tg.Client
+	peer     tg.InputPeerClass
+	index    *backupIndex
+	cacheDir string
+
+	mu sync.Mutex // serializes downloads
+}
+
+// path returns the cache location of chunk id
+func (f *chunkFetcher) path(id string) string {
+	return filepath.Join(f.cacheDir, id[:2], id)
+}
+
+// ensure makes sure chunk id is in the cache and returns its path
+func (f *chunkFetcher) ensure(ctx context.Context, id string) (string, error) {
+	path := f.path(id)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Another reader may have fetched it while we waited
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	ref, ok := f.index.Chunks[id]
+	if !ok {
+		return "", fmt.Errorf("chunk %s is not in the index", id)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), id+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	err = f.download(ctx, ref.MessageID, io.MultiWriter(tmp, h))
+	if tgerr.Is(err, "FILE_REFERENCE_EXPIRED") {
+		// The reference went stale during the download; fetching the
+		// message again gives a fresh one
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		if err := tmp.Truncate(0); err != nil {
+			return "", err
+		}
+		h.Reset()
+		err = f.download(ctx, ref.MessageID, io.MultiWriter(tmp, h))
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to download chunk %s: %w", id, err)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != id {
+		return "", fmt.Errorf("chunk %s is corrupted", id)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp.Name(), path)
+}
+
+// download writes the document of message id to w. The message is fetched
+// first, so the file reference used is always current.
+func (f *chunkFetcher) download(ctx context.Context, id int, w io.Writer) error {
+	msg, err := getMessage(ctx, f.api, f.peer, id)
+	if err != nil {
+		return fmt.Errorf("failed to get chunk message %d: %w", id, err)
+	}
+	media, ok := msg.Media.(*tg.MessageMediaDocument)
+	if !ok {
+		return fmt.Errorf("message %d holds no document", id)
+	}
+	doc, ok := media.Document.(*tg.Document)
+	if !ok {
+		return fmt.Errorf("document of message %d is unavailable", id)
+	}
+
+	_, err = downloader.NewDownloader().Download(f.api, &tg.InputDocumentFileLocation{
+		ID:            doc.ID,
+		AccessHash:    doc.AccessHash,
+		FileReference: doc.FileReference,
+	}).Stream(ctx, w)
+	return err
+}
+
+// readAt reads the content of a file made of chunks at offset off
+func (f *chunkFetcher) readAt(ctx context.Context, chunks []string, dest []byte, off int64) (int, error) {
+	n := 0
+	var start int64
+	for _, id := range chunks {
+		ref, ok := f.index.Chunks[id]
+		if !ok {
+			return n, fmt.Errorf("chunk %s is not in the index", id)
+		}
+		end := start + int64(ref.Size)
+		if off+int64(n) < end && n < len(dest) {
+			path, err := f.ensure(ctx, id)
+			if err != nil {
+				return n, err
+			}
+			m, err := readFileAt(path, dest[n:], off+int64(n)-start)
+			n += m
+			if err != nil && !errors.Is(err, io.EOF) {
+				return n, err
+			}
+		}
+		if n == len(dest) {
+			break
+		}
+		start = end
+	}
+	return n, nil
+}
+
+// readFileAt reads from the file at path at offset off
+func readFileAt(path string, dest []byte, off int64) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return file.ReadAt(dest, off)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+)
+
+// defaultFileCachePath is where the Telegram IDs of uploaded files are kept
+const defaultFileCachePath = "file_ids.jsonl"
+
+// cachedFile is an uploaded photo or document that can be sent again by ID,
+// without the local file
+type cachedFile struct {
+	Time          time.Time `json:"time"`
+	SHA256        string    `json:"sha256"`
+	Source        string    `json:"source"`
+	Name          string    `json:"name"`
+	Size          int64     `json:"size"`
+	MimeType      string    `json:"mime_type"`
+	Photo         bool      `json:"photo,omitempty"`
+	ID            int64     `json:"id"`
+	AccessHash    int64     `json:"access_hash"`
+	FileReference []byte    `json:"file_reference"`
+
+	// The message the file was sent in, to get a fresh file reference from
+	Target    string `json:"target"`
+	MessageID int    `json:"message_id"`
+}
+
+// setMedia takes the file ID and reference from the media of msg
+func (c *cachedFile) setMedia(msg *tg.Message) bool {
+	switch m := msg.Media.(type) {
+	case *tg.MessageMediaDocument:
+		if doc, ok := m.Document.AsNotEmpty(); ok {
+			c.Photo = false
+			c.ID, c.AccessHash, c.FileReference = doc.ID, doc.AccessHash, doc.FileReference
+			return true
+		}
+	case *tg.MessageMediaPhoto:
+		if photo, ok := m.Photo.AsNotEmpty(); ok {
+			c.Photo = true
+			c.ID, c.AccessHash, c.FileReference = photo.ID, photo.AccessHash, photo.FileReference
+			return true
+		}
+	}
+	return false
+}
+
+// inputMedia refers to the cached file for sending it again
+func (c *cachedFile) inputMedia() tg.InputMediaClass {
+	if c.Photo {
+		return &tg.InputMediaPhoto{ID: &tg.InputPhoto{ID: c.ID, AccessHash: c.AccessHash, FileReference: c.FileReference}}
+	}
+	return &tg.InputMediaDocument{ID: &tg.InputDocument{ID: c.ID, AccessHash: c.AccessHash, FileReference: c.FileReference}}
+}
+
+// appendFileCache adds c to the cache at path
+func appendFileCache(path string, c cachedFile) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// readFileCache returns the latest cache entry of every file hash, oldest
+// first. A missing cache is empty.
+func readFileCache(path string) ([]cachedFile, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var files []cachedFile
+	latest := map[string]int{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var c cachedFile
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if i, ok := latest[c.SHA256]; ok {
+			files[i] = c
+			continue
+		}
+		latest[c.SHA256] = len(files)
+		files = append(files, c)
+	}
+	return files, scanner.Err()
+}
+
+// findCachedFile looks a file up by its hash, a hash prefix of at least 8
+// characters, or its name. The most recent upload of a name wins.
+func findCachedFile(files []cachedFile, query string) (*cachedFile, error) {
+	q := strings.ToLower(query)
+	var found *cachedFile
+	for i := len(files) - 1; i >= 0; i-- {
+		c := &files[i]
+		if c.SHA256 == q || c.Name == query {
+			return c, nil
+		}
+		if len(q) >= 8 && strings.HasPrefix(c.SHA256, q) {
+			if found != nil {
+				return nil, fmt.Errorf("%q matches more than one file; give more of the hash", query)
+			}
+			found = c
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no file matching %q in the file ID cache", query)
+	}
+	return found, nil
+}
+
+// refreshFileReference fetches the message c was sent in again for a new
+// file reference
+func refreshFileReference(ctx context.Context, api *tg.Client, c *cachedFile) error {
+	p, err := telegramuploader.ResolvePeer(ctx, api, c.Target)
+	if err != nil {
+		return err
+	}
+	msg, err := getMessage(ctx, api, p, c.MessageID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch message %d of %s: %w", c.MessageID, c.Target, err)
+	}
+	if !c.setMedia(msg) {
+		return fmt.Errorf("message %d of %s no longer has the file", c.MessageID, c.Target)
+	}
+	return nil
+}
+
+// sendCachedFile sends req with the media of c, fetching a fresh file
+// reference once if the cached one expired and recording it in the cache at
+// cachePath
+func sendCachedFile(ctx context.Context, api *tg.Client, cachePath string, c *cachedFile, req *tg.MessagesSendMediaRequest) (tg.UpdatesClass, error) {
+	req.Media = c.inputMedia()
+	updates, err := telegramuploader.SendMedia(ctx, api, req)
+	if !tgerr.Is(err, "FILE_REFERENCE_EXPIRED", "FILE_REFERENCE_INVALID") {
+		return updates, err
+	}
+	fmt.Println("The cached file reference expired; fetching a fresh one")
+	if err := refreshFileReference(ctx, api, c); err != nil {
+		return nil, err
+	}
+	if err := appendFileCache(cachePath, *c); err != nil {
+		return nil, fmt.Errorf("failed to update file ID cache: %w", err)
+	}
+	req.Media = c.inputMedia()
+	return telegramuploader.SendMedia(ctx, api, req)
+}
+
+// runResend implements the "resend" subcommand: sending a previously
+// uploaded file to a chat by its ID, without uploading it again
+func runResend(args []string) error {
+	config := &Config{}
+	flags := flag.NewFlagSet("resend", flag.ExitOnError)
+	credentialFlags(flags, config)
+	flags.StringVar(&config.TargetID, "target", "me", "Target username or chat ID")
+	flags.StringVar(&config.JournalPath, "journal", defaultJournalPath, "Record the send in this file (empty to disable)")
+	cachePath := flags.String("file-cache", defaultFileCachePath, "Path of the file ID cache")
+	timeoutFlags(flags, &config.Timeouts)
+	positional := parseInterleaved(flags, args)
+
+	if len(positional) != 1 {
+		return withExitCode(exitUsage, errors.New("usage: resend -target <chat> <sha256|name>"))
+	}
+	if err := validateCredentials(config); err != nil {
+		return err
+	}
+	files, err := readFileCache(*cachePath)
+	if err != nil {
+		return fmt.Errorf("failed to read file ID cache: %w", err)
+	}
+	c, err := findCachedFile(files, positional[0])
+	if err != nil {
+		return withExitCode(exitUsage, err)
+	}
+
+	return withClient(config, func(ctx context.Context, client *telegram.Client) error {
+		api := client.API()
+		resolveCtx, cancel := phaseContext(ctx, config.Timeouts.Resolve)
+		defer cancel()
+		target, err := telegramuploader.ResolvePeer(resolveCtx, api, config.TargetID)
+		if err != nil {
+			return phaseError(resolveCtx, "resolving the target", err)
+		}
+		cancel()
+
+		fmt.Printf("Sending %s (%.2f MB) to %s...\n", c.Name, float64(c.Size)/(1024*1024), targetLabel(target, config.TargetID))
+		sendCtx, cancel := phaseContext(ctx, config.Timeouts.Send)
+		defer cancel()
+		randomID, err := generateRandomID()
+		if err != nil {
+			return fmt.Errorf("failed to generate random ID: %w", err)
+		}
+		send := &tg.MessagesSendMediaRequest{
+			Peer:     target,
+			Message:  fmt.Sprintf("Uploaded file: %s", c.Name),
+			RandomID: randomID,
+		}
+		updates, err := sendCachedFile(sendCtx, api, *cachePath, c, send)
+		if err != nil {
+			return phaseError(sendCtx, "sending", fmt.Errorf("failed to send media: %w", err))
+		}
+
+		msg, err := telegramuploader.SentMessage(updates)
+		if err != nil {
+			return err
+		}
+		if config.JournalPath != "" {
+			err := appendJournal(config.JournalPath, JournalEntry{
+				Time:      time.Now().UTC(),
+				Source:    c.Source,
+				Name:      c.Name,
+				Size:      c.Size,
+				SHA256:    c.SHA256,
+				MimeType:  c.MimeType,
+				Target:    config.TargetID,
+				MessageID: msg.ID,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to record send in journal: %w", err)
+			}
+		}
+		fmt.Printf("✅ File successfully sent to %s!\n", targetLabel(target, config.TargetID))
+		return nil
+	})
+}
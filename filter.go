@@ -0,0 +1,219 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+)
+
+// fileFilter picks which files of a directory a batch uploads
+type fileFilter struct {
+	MinSize int64 // smaller files are left out
+	MaxSize int64 // larger files are left out; 0 for no limit
+
+	// Files last modified before Since are left out; zero keeps them all
+	Since time.Time
+
+	FollowSymlinks bool // walk into linked directories and upload linked files
+	SkipHidden     bool // leave out files and directories whose names start with a dot
+}
+
+// filterFlags registers the file filter flags of the directory commands on
+// fs. The returned function builds the filter once fs has been parsed.
+func filterFlags(fs *flag.FlagSet) func() (fileFilter, error) {
+	minSize := fs.String("min-size", "0", "Leave out files smaller than this, like 10K for thumbnails")
+	maxSize := fs.String("max-size", "0", fmt.Sprintf("Leave out files larger than this, like 1.9G (0 for no limit; Telegram takes up to %d MB)", telegramuploader.MaxFileSize>>20))
+	newerThan := fs.Duration("newer-than", 0, "Leave out files not modified within this long, like 24h, so scheduled runs only pick up recent changes")
+	since := fs.String("since", "", "Leave out files last modified before this date (YYYY-MM-DD)")
+	followSymlinks := fs.Bool("follow-symlinks", false, "Follow symbolic links to files and directories instead of skipping them; loops are detected")
+	skipHidden := fs.Bool("skip-hidden", false, "Leave out files and directories whose names start with a dot")
+	return func() (fileFilter, error) {
+		f := fileFilter{FollowSymlinks: *followSymlinks, SkipHidden: *skipHidden}
+		var err error
+		if *since != "" {
+			if f.Since, err = parseDate(*since); err != nil {
+				return f, withExitCode(exitUsage, fmt.Errorf("invalid -since date: %w", err))
+			}
+		}
+		if *newerThan < 0 {
+			return f, withExitCode(exitUsage, fmt.Errorf("-newer-than can't be negative"))
+		}
+		// With both, the later cutoff wins
+		if cutoff := time.Now().Add(-*newerThan); *newerThan > 0 && cutoff.After(f.Since) {
+			f.Since = cutoff
+		}
+		if f.MinSize, err = parseSize(*minSize); err != nil {
+			return f, withExitCode(exitUsage, fmt.Errorf("invalid -min-size %q", *minSize))
+		}
+		if f.MaxSize, err = parseSize(*maxSize); err != nil {
+			return f, withExitCode(exitUsage, fmt.Errorf("invalid -max-size %q", *maxSize))
+		}
+		if f.MaxSize > 0 && f.MaxSize < f.MinSize {
+			return f, withExitCode(exitUsage, fmt.Errorf("-max-size is below -min-size"))
+		}
+		return f, nil
+	}
+}
+
+// skip returns why the file described by info is left out, or "" if it's
+// uploaded
+func (f fileFilter) skip(info fs.FileInfo) string {
+	switch {
+	case info.Size() < f.MinSize:
+		return fmt.Sprintf("smaller than %d bytes", f.MinSize)
+	case f.MaxSize > 0 && info.Size() > f.MaxSize:
+		return fmt.Sprintf("larger than %.2f MB", float64(f.MaxSize)/(1024*1024))
+	case info.ModTime().Before(f.Since):
+		return "not modified since " + f.Since.Format("2006-01-02 15:04")
+	}
+	return ""
+}
+
+// walk calls fn for each regular file in the tree at root that the filter's
+// walking policy takes in, in lexical order. Sockets, devices and other
+// special files are always skipped.
+func (f fileFilter) walk(root string, fn func(path string, info fs.FileInfo) error) error {
+	// Real paths of the directories walked, to notice symlink loops
+	walked := map[string]bool{}
+	var walkDir func(dir string) error
+	walkDir = func(dir string) error {
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return err
+		}
+		if walked[real] {
+			fmt.Printf("Skipping %s: it links to a directory walked already\n", dir)
+			return nil
+		}
+		walked[real] = true
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			path := filepath.Join(dir, e.Name())
+			if f.SkipHidden && strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			var info fs.FileInfo
+			if e.Type()&fs.ModeSymlink != 0 {
+				if !f.FollowSymlinks {
+					continue
+				}
+				if info, err = os.Stat(path); err != nil {
+					fmt.Printf("Skipping %s: %v\n", path, err)
+					continue
+				}
+			} else if info, err = e.Info(); err != nil {
+				return err
+			}
+			switch {
+			case info.IsDir():
+				if err := walkDir(path); err != nil {
+					return err
+				}
+			case info.Mode().IsRegular():
+				if err := fn(path, info); err != nil {
+					return err
+				}
+			default:
+				fmt.Printf("Skipping %s: not a regular file\n", path)
+			}
+		}
+		return nil
+	}
+	return walkDir(root)
+}
+
+// fileOrder is the order a batch sends its files in
+type fileOrder struct {
+	Key  string // "name", "mtime", "size" or "none" for the order found
+	Desc bool
+}
+
+// orderFlag registers -order on fs. The returned function parses it once
+// fs has been parsed.
+func orderFlag(fs *flag.FlagSet) func() (fileOrder, error) {
+	order := fs.String("order", "name", "Send files ordered by name (with numbers in names compared as numbers, so part2 comes before part10), mtime or size, adding :desc to reverse it, or none for the order they're found in")
+	return func() (fileOrder, error) {
+		key, dir, _ := strings.Cut(*order, ":")
+		o := fileOrder{Key: key, Desc: dir == "desc"}
+		switch {
+		case key != "name" && key != "mtime" && key != "size" && key != "none",
+			dir != "" && dir != "asc" && dir != "desc":
+			return o, withExitCode(exitUsage, fmt.Errorf("invalid -order %q", *order))
+		}
+		return o, nil
+	}
+}
+
+// fileKey is what files are ordered by
+type fileKey struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// compare orders a and b, for slices.SortStableFunc. Files equal by the
+// key are ordered by name.
+func (o fileOrder) compare(a, b fileKey) int {
+	if o.Key == "none" {
+		return 0
+	}
+	var c int
+	switch o.Key {
+	case "mtime":
+		c = a.ModTime.Compare(b.ModTime)
+	case "size":
+		c = cmp.Compare(a.Size, b.Size)
+	}
+	if c == 0 {
+		c = naturalCompare(a.Path, b.Path)
+	}
+	if o.Desc {
+		c = -c
+	}
+	return c
+}
+
+// naturalCompare compares a and b as text, except that runs of digits are
+// compared by their numeric value
+func naturalCompare(a, b string) int {
+	for a != "" && b != "" {
+		da, db := digitPrefix(a), digitPrefix(b)
+		if da != "" && db != "" {
+			// Longer numbers without leading zeros are larger
+			na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+			if c := cmp.Compare(len(na), len(nb)); c != 0 {
+				return c
+			}
+			if c := strings.Compare(na, nb); c != 0 {
+				return c
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+		if a[0] != b[0] {
+			return cmp.Compare(a[0], b[0])
+		}
+		a, b = a[1:], b[1:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+// digitPrefix returns the digits s starts with
+func digitPrefix(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
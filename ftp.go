@@ -0,0 +1,543 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/textproto"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gotd/td/telegram"
+)
+
+// ftpPasswordEnv holds the password of the FTP server's -user
+const ftpPasswordEnv = "FILEUPLOADER_FTP_PASSWORD"
+
+// runServeFTP implements "serve ftp", an FTP server over the same tree as
+// serve webdav, for devices like scanners and cameras that can only send
+// files over FTP. STOR streams into an upload and RETR streams back from
+// Telegram. With -chat the server's root is that chat's directory.
+func runServeFTP(args []string) error {
+	config := &Config{}
+	flags := flag.NewFlagSet("serve ftp", flag.ExitOnError)
+	credentialFlags(flags, config)
+	listen := flags.String("listen", "127.0.0.1:2121", "Address to serve FTP on")
+	chat := flags.String("chat", "", "Serve only the files of this chat, and upload into it")
+	user := flags.String("user", "", "User name to require at login (default: accept any login, only on a loopback -listen)")
+	password := flags.String("password", os.Getenv(ftpPasswordEnv), "Password of -user (default $"+ftpPasswordEnv+")")
+	passivePorts := flags.String("passive-ports", "", "Port range for passive data connections, like 50000-50100 (default: any free port)")
+	publicIP := flags.String("public-ip", "", "IPv4 address to give clients for passive connections (default: the address they connected to)")
+	flags.StringVar(&config.JournalPath, "journal", defaultJournalPath, "Path of the upload journal, which indexes the files")
+	flags.StringVar(&config.FileCachePath, "file-cache", defaultFileCachePath, "Keep the Telegram IDs of uploaded files here (empty to disable)")
+	timeoutFlags(flags, &config.Timeouts)
+	applyProgressFlags := progressFlags(flags)
+	applySystemdFlags := systemdFlags(flags)
+	applyLogTargetFlags := logTargetFlags(flags)
+	applyTracingFlags := tracingFlags(flags)
+	applyRateFlags := uploadRateFlags(flags)
+	flags.Parse(args)
+	if err := applyProgressFlags(); err != nil {
+		return err
+	}
+	applySystemdFlags()
+	if err := applyLogTargetFlags(); err != nil {
+		return err
+	}
+	if err := applyTracingFlags(); err != nil {
+		return err
+	}
+	rates, err := applyRateFlags()
+	if err != nil {
+		return err
+	}
+	config.Rates = rates
+
+	if config.JournalPath == "" {
+		return withExitCode(exitUsage, errors.New("the FTP server needs the journal to know which files there are"))
+	}
+	switch {
+	case *user != "" && *password == "":
+		return withExitCode(exitUsage, errors.New("-user needs -password or $"+ftpPasswordEnv))
+	case *user == "" && !isLoopback(*listen):
+		return withExitCode(exitUsage, fmt.Errorf("-listen %s can be reached from other machines; set -user and -password to require a login", *listen))
+	}
+	server := &ftpServer{root: "/", user: *user, password: *password}
+	if *chat != "" {
+		server.root = path.Join("/uploads", normalizeTarget(*chat))
+	}
+	if *passivePorts != "" {
+		lo, hi, ok := strings.Cut(*passivePorts, "-")
+		server.portLow, _ = strconv.Atoi(lo)
+		server.portHigh, _ = strconv.Atoi(hi)
+		if !ok || server.portLow <= 0 || server.portHigh < server.portLow || server.portHigh > 65535 {
+			return withExitCode(exitUsage, fmt.Errorf("invalid -passive-ports %q", *passivePorts))
+		}
+	}
+	if *publicIP != "" {
+		if server.publicIP = net.ParseIP(*publicIP).To4(); server.publicIP == nil {
+			return withExitCode(exitUsage, fmt.Errorf("invalid -public-ip %q: give an IPv4 address", *publicIP))
+		}
+	}
+	if err := validateCredentials(config); err != nil {
+		return err
+	}
+	plainProgressForServers()
+
+	return withClient(config, func(ctx context.Context, client *telegram.Client) error {
+		server.fs = newDavFS(client, config)
+		if *chat != "" {
+			// Make sure the root is there even before the first upload
+			if err := server.fs.Mkdir(ctx, server.root, 0755); err != nil && !errors.Is(err, os.ErrExist) {
+				return err
+			}
+		}
+		ln, err := net.Listen("tcp", *listen)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", *listen, err)
+		}
+		go func() {
+			<-ctx.Done()
+			ln.Close()
+		}()
+		daemonReady(ctx, client)
+		fmt.Printf("Serving FTP at %s; press Ctrl-C to stop\n", *listen)
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			go server.serve(ctx, conn)
+		}
+	})
+}
+
+// ftpServer holds the settings shared by the FTP sessions
+type ftpServer struct {
+	fs                *davFS
+	root              string // path of the FTP root in fs
+	user, password    string // required at login unless user is empty
+	portLow, portHigh int    // range of passive ports; 0 for any
+	publicIP          net.IP // announced for passive connections, if set
+}
+
+// ftpSession is the state of one FTP control connection
+type ftpSession struct {
+	*ftpServer
+	ctx  context.Context
+	conn net.Conn
+	text *textproto.Conn
+
+	user     string // given with USER
+	loggedIn bool
+	cwd      string // current directory, as an FTP path
+
+	passive    net.Listener // listening for the next data connection
+	active     string       // address to connect the next data connection to
+	restart    int64        // offset the next RETR starts at
+	renameFrom string       // path given with RNFR
+}
+
+// serve runs the FTP session on conn until the client quits or ctx is done
+func (s *ftpServer) serve(ctx context.Context, conn net.Conn) {
+	session := &ftpSession{ftpServer: s, ctx: ctx, conn: conn, text: textproto.NewConn(conn), cwd: "/"}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer conn.Close()
+	defer session.closeData()
+
+	session.reply(220, "fileuploader FTP ready")
+	for {
+		line, err := session.text.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		if !session.handle(strings.ToUpper(cmd), arg) {
+			return
+		}
+	}
+}
+
+// reply sends a reply with code to the client
+func (s *ftpSession) reply(code int, format string, args ...any) {
+	s.text.PrintfLine("%d %s", code, fmt.Sprintf(format, args...))
+}
+
+// path returns the tree path of the FTP path arg, taken relative to the
+// current directory
+func (s *ftpSession) path(arg string) (ftpPath, treePath string) {
+	if !strings.HasPrefix(arg, "/") {
+		arg = path.Join(s.cwd, arg)
+	}
+	ftpPath = path.Clean("/" + arg)
+	return ftpPath, path.Join(s.root, ftpPath)
+}
+
+// handle carries out one command, reporting false once the session is over
+func (s *ftpSession) handle(cmd, arg string) bool {
+	switch cmd {
+	case "USER":
+		s.user, s.loggedIn = arg, false
+		s.reply(331, "Password required")
+		return true
+	case "PASS":
+		if s.ftpServer.user != "" && (subtle.ConstantTimeCompare([]byte(s.user), []byte(s.ftpServer.user)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(arg), []byte(s.password)) != 1) {
+			log.Printf("FTP: failed login as %q from %s", s.user, s.conn.RemoteAddr())
+			s.reply(530, "Login incorrect")
+			return true
+		}
+		s.loggedIn = true
+		s.reply(230, "Logged in")
+		return true
+	case "QUIT":
+		s.reply(221, "Bye")
+		return false
+	case "NOOP":
+		s.reply(200, "OK")
+		return true
+	case "FEAT":
+		s.text.PrintfLine("211-Features:")
+		for _, feature := range []string{"EPSV", "PASV", "SIZE", "MDTM", "REST STREAM", "UTF8"} {
+			s.text.PrintfLine(" %s", feature)
+		}
+		s.reply(211, "End")
+		return true
+	case "OPTS":
+		s.reply(200, "OK")
+		return true
+	}
+	if !s.loggedIn {
+		s.reply(530, "Log in with USER and PASS first")
+		return true
+	}
+
+	switch cmd {
+	case "SYST":
+		s.reply(215, "UNIX Type: L8")
+	case "TYPE", "MODE", "STRU":
+		// Files are always sent as they are
+		s.reply(200, "OK")
+	case "PWD", "XPWD":
+		s.reply(257, "%q is the current directory", s.cwd)
+	case "CWD", "XCWD", "CDUP", "XCUP":
+		if cmd == "CDUP" || cmd == "XCUP" {
+			arg = ".."
+		}
+		p, tp := s.path(arg)
+		if info, err := s.fs.Stat(s.ctx, tp); err != nil || !info.IsDir() {
+			s.reply(550, "No such directory")
+			return true
+		}
+		s.cwd = p
+		s.reply(250, "Directory changed to %s", p)
+	case "PASV", "EPSV":
+		s.startPassive(cmd == "EPSV")
+	case "PORT":
+		s.setActive(arg)
+	case "LIST", "NLST":
+		s.list(arg, cmd == "NLST")
+	case "SIZE", "MDTM":
+		_, tp := s.path(arg)
+		info, err := s.fs.Stat(s.ctx, tp)
+		switch {
+		case err != nil || info.IsDir():
+			s.reply(550, "No such file")
+		case cmd == "SIZE":
+			s.reply(213, "%d", info.Size())
+		default:
+			s.reply(213, "%s", info.ModTime().UTC().Format("20060102150405"))
+		}
+	case "REST":
+		offset, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || offset < 0 {
+			s.reply(501, "Invalid offset")
+			return true
+		}
+		s.restart = offset
+		s.reply(350, "Restarting at %d", offset)
+	case "RETR":
+		s.retrieve(arg)
+	case "STOR":
+		s.store(arg)
+	case "DELE":
+		_, tp := s.path(arg)
+		if info, err := s.fs.Stat(s.ctx, tp); err != nil || info.IsDir() {
+			s.reply(550, "No such file")
+			return true
+		}
+		s.fsReply(s.fs.RemoveAll(s.ctx, tp), 250, "Deleted")
+	case "RMD", "XRMD":
+		_, tp := s.path(arg)
+		f, err := s.fs.OpenFile(s.ctx, tp, os.O_RDONLY, 0)
+		if err != nil {
+			s.reply(550, "No such directory")
+			return true
+		}
+		entries, err := f.Readdir(-1)
+		f.Close()
+		if err != nil {
+			s.reply(550, "Not a directory")
+			return true
+		}
+		if len(entries) > 0 {
+			s.reply(550, "Directory not empty")
+			return true
+		}
+		s.fsReply(s.fs.RemoveAll(s.ctx, tp), 250, "Removed")
+	case "MKD", "XMKD":
+		p, tp := s.path(arg)
+		s.fsReply(s.fs.Mkdir(s.ctx, tp, 0755), 257, "%q created", p)
+	case "RNFR":
+		_, tp := s.path(arg)
+		if _, err := s.fs.Stat(s.ctx, tp); err != nil {
+			s.reply(550, "No such file")
+			return true
+		}
+		s.renameFrom = tp
+		s.reply(350, "Ready for RNTO")
+	case "RNTO":
+		if s.renameFrom == "" {
+			s.reply(503, "RNFR first")
+			return true
+		}
+		_, tp := s.path(arg)
+		err := s.fs.Rename(s.ctx, s.renameFrom, tp)
+		s.renameFrom = ""
+		s.fsReply(err, 250, "Renamed")
+	default:
+		s.reply(502, "%s not implemented", cmd)
+	}
+	return true
+}
+
+// fsReply replies with code and the message if err is nil, and with the
+// error otherwise
+func (s *ftpSession) fsReply(err error, code int, format string, args ...any) {
+	switch {
+	case err == nil:
+		s.reply(code, format, args...)
+	case errors.Is(err, os.ErrPermission):
+		s.reply(550, "Permission denied")
+	case errors.Is(err, os.ErrExist):
+		s.reply(550, "Already exists")
+	case errors.Is(err, os.ErrNotExist):
+		s.reply(550, "No such file or directory")
+	default:
+		log.Printf("FTP: %v", friendlyError(err))
+		s.reply(451, "%v", friendlyError(err))
+	}
+}
+
+// startPassive listens for the next data connection and tells the client
+// where
+func (s *ftpSession) startPassive(extended bool) {
+	s.closeData()
+	host, _, _ := net.SplitHostPort(s.conn.LocalAddr().String())
+	var err error
+	if s.portLow == 0 {
+		s.passive, err = net.Listen("tcp", net.JoinHostPort(host, "0"))
+	} else {
+		for port := s.portLow; port <= s.portHigh; port++ {
+			if s.passive, err = net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port))); err == nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		s.reply(425, "Can't open a data connection")
+		return
+	}
+	port := s.passive.Addr().(*net.TCPAddr).Port
+	if extended {
+		s.reply(229, "Entering Extended Passive Mode (|||%d|)", port)
+		return
+	}
+	ip := s.publicIP
+	if ip == nil {
+		ip = net.ParseIP(host).To4()
+	}
+	if ip == nil {
+		s.closeData()
+		s.reply(425, "PASV needs IPv4; use EPSV")
+		return
+	}
+	s.reply(227, "Entering Passive Mode (%d,%d,%d,%d,%d,%d)", ip[0], ip[1], ip[2], ip[3], port>>8, port&0xff)
+}
+
+// setActive takes the address of a PORT command for the next data
+// connection. Only the client's own address is accepted, so the server
+// can't be made to connect elsewhere.
+func (s *ftpSession) setActive(arg string) {
+	s.closeData()
+	parts := strings.Split(arg, ",")
+	if len(parts) != 6 {
+		s.reply(501, "Invalid PORT")
+		return
+	}
+	hi, err1 := strconv.Atoi(parts[4])
+	lo, err2 := strconv.Atoi(parts[5])
+	ip := net.ParseIP(strings.Join(parts[:4], "."))
+	client, _, _ := net.SplitHostPort(s.conn.RemoteAddr().String())
+	if err1 != nil || err2 != nil || ip == nil || !ip.Equal(net.ParseIP(client)) {
+		s.reply(501, "Invalid PORT")
+		return
+	}
+	s.active = net.JoinHostPort(ip.String(), strconv.Itoa(hi<<8|lo))
+	s.reply(200, "PORT OK")
+}
+
+// dataConn opens the data connection set up by PASV, EPSV or PORT
+func (s *ftpSession) dataConn() (net.Conn, error) {
+	defer s.closeData()
+	switch {
+	case s.passive != nil:
+		if l, ok := s.passive.(*net.TCPListener); ok {
+			l.SetDeadline(time.Now().Add(30 * time.Second))
+		}
+		// Only the client may connect; anyone else guessing the port is
+		// turned away
+		client, _, _ := net.SplitHostPort(s.conn.RemoteAddr().String())
+		for {
+			conn, err := s.passive.Accept()
+			if err != nil {
+				return nil, err
+			}
+			peer, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+			if net.ParseIP(peer).Equal(net.ParseIP(client)) {
+				return conn, nil
+			}
+			log.Printf("FTP: refused data connection from %s for the client at %s", conn.RemoteAddr(), client)
+			conn.Close()
+		}
+	case s.active != "":
+		var d net.Dialer
+		return d.DialContext(s.ctx, "tcp", s.active)
+	}
+	return nil, errors.New("no PASV or PORT given")
+}
+
+// closeData drops the data connection set up, if any
+func (s *ftpSession) closeData() {
+	if s.passive != nil {
+		s.passive.Close()
+		s.passive = nil
+	}
+	s.active = ""
+}
+
+// list sends the listing of a directory, or the names only for NLST
+func (s *ftpSession) list(arg string, namesOnly bool) {
+	// Options like -la are for ls; there is only one format
+	if strings.HasPrefix(arg, "-") {
+		_, arg, _ = strings.Cut(arg, " ")
+	}
+	_, tp := s.path(arg)
+	f, err := s.fs.OpenFile(s.ctx, tp, os.O_RDONLY, 0)
+	if err != nil {
+		s.reply(550, "No such file or directory")
+		return
+	}
+	defer f.Close()
+	var infos []fs.FileInfo
+	if info, _ := f.Stat(); info != nil && !info.IsDir() {
+		infos = append(infos, info)
+	} else if infos, err = f.Readdir(-1); err != nil {
+		s.reply(550, "%v", err)
+		return
+	}
+
+	s.reply(150, "Here comes the listing")
+	conn, err := s.dataConn()
+	if err != nil {
+		s.reply(425, "Can't open the data connection")
+		return
+	}
+	defer conn.Close()
+	for _, info := range infos {
+		if namesOnly {
+			fmt.Fprintf(conn, "%s\r\n", info.Name())
+			continue
+		}
+		modTime, layout := info.ModTime(), "Jan _2 15:04"
+		if time.Since(modTime) > 180*24*time.Hour {
+			layout = "Jan _2  2006"
+		}
+		fmt.Fprintf(conn, "%s 1 owner group %12d %s %s\r\n", info.Mode(), info.Size(), modTime.Format(layout), info.Name())
+	}
+	s.reply(226, "Listing sent")
+}
+
+// retrieve sends a file, from the REST offset if one was given
+func (s *ftpSession) retrieve(arg string) {
+	offset := s.restart
+	s.restart = 0
+	_, tp := s.path(arg)
+	f, err := s.fs.OpenFile(s.ctx, tp, os.O_RDONLY, 0)
+	if err != nil {
+		s.reply(550, "No such file")
+		return
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || info.IsDir() {
+		s.reply(550, "Not a file")
+		return
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		s.reply(550, "%v", err)
+		return
+	}
+
+	s.reply(150, "Sending %s", path.Base(tp))
+	conn, err := s.dataConn()
+	if err != nil {
+		s.reply(425, "Can't open the data connection")
+		return
+	}
+	_, err = copyPooled(conn, f)
+	conn.Close()
+	if err != nil {
+		log.Printf("FTP: failed to send %s: %v", tp, friendlyError(err))
+		s.reply(426, "Transfer aborted: %v", friendlyError(err))
+		return
+	}
+	s.reply(226, "Transfer complete")
+}
+
+// store uploads the file the client sends
+func (s *ftpSession) store(arg string) {
+	s.restart = 0
+	_, tp := s.path(arg)
+	f, err := s.fs.OpenFile(s.ctx, tp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		s.fsReply(err, 0, "")
+		return
+	}
+
+	s.reply(150, "Ready to receive %s", path.Base(tp))
+	conn, err := s.dataConn()
+	if err != nil {
+		f.Close()
+		s.reply(425, "Can't open the data connection")
+		return
+	}
+	_, err = copyPooled(f, conn)
+	conn.Close()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	s.fsReply(err, 226, "Stored %s", path.Base(tp))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// heicConverter is a command that turns a HEIC photo into an upright JPEG
+type heicConverter struct {
+	name string
+	args func(in, out string, quality int) []string
+}
+
+// heicConverters are tried in order; there is no HEVC decoder in Go, so
+// one of them has to be installed
+var heicConverters = []heicConverter{
+	// macOS
+	{"sips", func(in, out string, quality int) []string {
+		return []string{"-s", "format", "jpeg", "-s", "formatOptions", strconv.Itoa(quality), in, "--out", out}
+	}},
+	// libheif, which applies the rotation stored in the file
+	{"heif-convert", func(in, out string, quality int) []string {
+		return []string{"-q", strconv.Itoa(quality), in, out}
+	}},
+	// ImageMagick 7 and 6
+	{"magick", func(in, out string, quality int) []string {
+		return []string{in, "-auto-orient", "-quality", strconv.Itoa(quality), out}
+	}},
+	{"convert", func(in, out string, quality int) []string {
+		return []string{in, "-auto-orient", "-quality", strconv.Itoa(quality), out}
+	}},
+}
+
+// isHEIC reports whether the file at path is a HEIF image, like an iPhone's
+// HEIC photo
+func isHEIC(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	head := make([]byte, 12)
+	if _, err := f.Read(head); err != nil {
+		return false, nil
+	}
+	return string(head[4:8]) == "ftyp" && heifBrands[string(head[8:12])], nil
+}
+
+// convertHEIC converts the HEIC photo at path to a JPEG of the given
+// quality with the first converter found, turned upright. It returns the
+// path of a temporary file with the result and the name to upload it under.
+func convertHEIC(path, name string, quality int) (string, string, error) {
+	tmp, err := os.CreateTemp("", "heic-*.jpg")
+	if err != nil {
+		return "", "", err
+	}
+	tmp.Close()
+
+	var tried []string
+	for _, c := range heicConverters {
+		if _, err := exec.LookPath(c.name); err != nil {
+			tried = append(tried, c.name)
+			continue
+		}
+		var stderr bytes.Buffer
+		cmd := exec.Command(c.name, c.args(path, tmp.Name(), quality)...)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			os.Remove(tmp.Name())
+			return "", "", fmt.Errorf("%s failed: %w: %s", c.name, err, strings.TrimSpace(stderr.String()))
+		}
+		if info, err := os.Stat(tmp.Name()); err != nil || info.Size() == 0 {
+			os.Remove(tmp.Name())
+			return "", "", fmt.Errorf("%s wrote no image", c.name)
+		}
+		name = strings.TrimSuffix(name, filepath.Ext(name)) + ".jpg"
+		fmt.Printf("Converted HEIC photo to %s with %s\n", name, c.name)
+		return tmp.Name(), name, nil
+	}
+	os.Remove(tmp.Name())
+	return "", "", errors.New("none of " + strings.Join(tried, ", ") + " is installed")
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// heifBrands are the major brands of HEIF images
+var heifBrands = map[string]bool{"heic": true, "heix": true, "heim": true, "heis": true, "hevc": true, "hevx": true, "mif1": true, "msf1": true}
+
+// isoBox is a box of an ISO base media file, like HEIF or MP4: its type and
+// where its payload is
+type isoBox struct {
+	typ        string
+	start, end int
+}
+
+// readBoxes returns the boxes between start and end of data
+func readBoxes(data []byte, start, end int) ([]isoBox, error) {
+	var boxes []isoBox
+	for i := start; i < end; {
+		if i+8 > end {
+			return nil, errors.New("truncated box")
+		}
+		size := int(binary.BigEndian.Uint32(data[i:]))
+		typ := string(data[i+4 : i+8])
+		header := 8
+		switch size {
+		case 0:
+			// To the end of the enclosing box
+			size = end - i
+		case 1:
+			if i+16 > end {
+				return nil, errors.New("truncated box")
+			}
+			large := binary.BigEndian.Uint64(data[i+8:])
+			if large > uint64(end-i) {
+				return nil, errors.New("box larger than its container")
+			}
+			size, header = int(large), 16
+		}
+		if size < header || i+size > end {
+			return nil, errors.New("malformed box")
+		}
+		boxes = append(boxes, isoBox{typ: typ, start: i + header, end: i + size})
+		i += size
+	}
+	return boxes, nil
+}
+
+// findBox returns the first box of type typ among boxes
+func findBox(boxes []isoBox, typ string) (isoBox, bool) {
+	for _, b := range boxes {
+		if b.typ == typ {
+			return b, true
+		}
+	}
+	return isoBox{}, false
+}
+
+// boxReader reads the big-endian fields of a box's payload
+type boxReader struct {
+	data []byte
+	pos  int
+	end  int
+	err  error
+}
+
+// uint reads an n-byte unsigned integer, where n may be 0
+func (r *boxReader) uint(n int) uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if r.pos+n > r.end {
+		r.err = errors.New("truncated box")
+		return 0
+	}
+	var v uint64
+	for _, b := range r.data[r.pos : r.pos+n] {
+		v = v<<8 | uint64(b)
+	}
+	r.pos += n
+	return v
+}
+
+// string reads a NUL-terminated string
+func (r *boxReader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	i := bytes.IndexByte(r.data[r.pos:r.end], 0)
+	if i < 0 {
+		r.err = errors.New("unterminated string")
+		return ""
+	}
+	s := string(r.data[r.pos : r.pos+i])
+	r.pos += i + 1
+	return s
+}
+
+// heifMetadataItems returns the IDs of the items of a HEIF meta box that
+// hold EXIF or XMP metadata, and whether each is XMP
+func heifMetadataItems(data []byte, meta []isoBox) (map[uint64]bool, error) {
+	iinf, ok := findBox(meta, "iinf")
+	if !ok {
+		return nil, nil
+	}
+	r := &boxReader{data: data, pos: iinf.start, end: iinf.end}
+	version := r.uint(1)
+	r.uint(3) // flags
+	if version == 0 {
+		r.uint(2) // entry count
+	} else {
+		r.uint(4)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	entries, err := readBoxes(data, r.pos, iinf.end)
+	if err != nil {
+		return nil, err
+	}
+	items := map[uint64]bool{}
+	for _, e := range entries {
+		if e.typ != "infe" {
+			continue
+		}
+		r := &boxReader{data: data, pos: e.start, end: e.end}
+		version := r.uint(1)
+		r.uint(3)
+		if version < 2 {
+			// Older entries have no item type
+			continue
+		}
+		var id uint64
+		if version == 2 {
+			id = r.uint(2)
+		} else {
+			id = r.uint(4)
+		}
+		r.uint(2) // protection index
+		itemType := string(data[r.pos:min(r.pos+4, r.end)])
+		r.uint(4)
+		r.string() // name
+		switch {
+		case r.err != nil:
+			return nil, r.err
+		case itemType == "Exif":
+			items[id] = false
+		case itemType == "mime" && r.string() == "application/rdf+xml":
+			items[id] = true
+		}
+	}
+	return items, nil
+}
+
+// stripHEIFMetadata returns a HEIF image, like an iPhone's HEIC photo, with
+// its EXIF and XMP items blanked out. The items are overwritten in place
+// rather than removed, so that none of the offsets in the file change. HEIF
+// keeps the orientation outside the EXIF data, so it's kept.
+func stripHEIFMetadata(data []byte) ([]byte, error) {
+	top, err := readBoxes(data, 0, len(data))
+	if err != nil {
+		return nil, err
+	}
+	if ftyp, ok := findBox(top, "ftyp"); !ok || ftyp.start != 8 {
+		return nil, errors.New("not a HEIF file")
+	}
+	metaBox, ok := findBox(top, "meta")
+	if !ok {
+		return nil, errors.New("HEIF file without a meta box")
+	}
+	// meta is a full box: version and flags come first
+	meta, err := readBoxes(data, metaBox.start+4, metaBox.end)
+	if err != nil {
+		return nil, err
+	}
+	items, err := heifMetadataItems(data, meta)
+	if err != nil {
+		return nil, err
+	}
+	out := bytes.Clone(data)
+	if len(items) == 0 {
+		return out, nil
+	}
+	iloc, ok := findBox(meta, "iloc")
+	if !ok {
+		return nil, errors.New("HEIF file without item locations")
+	}
+	idat, _ := findBox(meta, "idat")
+
+	r := &boxReader{data: data, pos: iloc.start, end: iloc.end}
+	version := r.uint(1)
+	r.uint(3)
+	sizes := r.uint(2)
+	offsetSize, lengthSize := int(sizes>>12&0xF), int(sizes>>8&0xF)
+	baseOffsetSize, indexSize := int(sizes>>4&0xF), int(sizes&0xF)
+	var count uint64
+	if version < 2 {
+		count = r.uint(2)
+	} else {
+		count = r.uint(4)
+	}
+	for ; count > 0 && r.err == nil; count-- {
+		var id, method uint64
+		if version < 2 {
+			id = r.uint(2)
+		} else {
+			id = r.uint(4)
+		}
+		if version == 1 || version == 2 {
+			method = r.uint(2) & 0xF
+		}
+		r.uint(2) // data reference index
+		base := r.uint(baseOffsetSize)
+		extents := r.uint(2)
+		for ; extents > 0 && r.err == nil; extents-- {
+			if (version == 1 || version == 2) && indexSize > 0 {
+				r.uint(indexSize)
+			}
+			offset, length := base+r.uint(offsetSize), r.uint(lengthSize)
+			xmp, wanted := items[id]
+			if !wanted || r.err != nil {
+				continue
+			}
+			var start uint64
+			switch method {
+			case 0:
+				start = offset
+			case 1:
+				start = uint64(idat.start) + offset
+			default:
+				return nil, errors.New("HEIF metadata stored in an unsupported way")
+			}
+			if length == 0 || start+length > uint64(len(out)) || (method == 1 && start+length > uint64(idat.end)) {
+				return nil, errors.New("HEIF metadata outside the file")
+			}
+			blankHEIFItem(out[start:start+length], xmp)
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return out, nil
+}
+
+// blankHEIFItem overwrites the payload of an EXIF or XMP item. An EXIF item
+// becomes an empty TIFF structure, and XMP becomes spaces, which is still
+// well-formed whitespace for XMP readers.
+func blankHEIFItem(p []byte, xmp bool) {
+	if xmp {
+		for i := range p {
+			p[i] = ' '
+		}
+		return
+	}
+	clear(p)
+	// The item starts with the offset of the TIFF header, 0 here, then the
+	// header and an IFD without entries
+	empty := []byte("\x00\x00\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00")
+	if len(p) >= len(empty) {
+		copy(p, empty)
+	}
+}
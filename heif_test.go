@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// isoTestBox returns a box of type typ around payload
+func isoTestBox(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, typ...), body...)
+}
+
+// fullTestBox returns a full box, with version and no flags
+func fullTestBox(typ string, version byte, payload ...[]byte) []byte {
+	return isoTestBox(typ, append([][]byte{{version, 0, 0, 0}}, payload...)...)
+}
+
+// heifTestItem is an item of a test HEIF file
+type heifTestItem struct {
+	typ     string // item type, like hvc1 or Exif
+	mime    string // content type of mime items
+	payload []byte
+	inIdat  bool // stored in the meta box's idat rather than mdat
+}
+
+// heifTestFile returns a HEIC file with items, and where the payload of
+// each is in it
+func heifTestFile(items []heifTestItem) ([]byte, [][2]int) {
+	build := func(offsets []uint32) []byte {
+		var infes [][]byte
+		for i, it := range items {
+			e := binary.BigEndian.AppendUint16(nil, uint16(i+1))
+			e = append(e, 0, 0)
+			e = append(e, it.typ...)
+			e = append(e, 0) // name
+			if it.mime != "" {
+				e = append(e, it.mime+"\x00"...)
+			}
+			infes = append(infes, fullTestBox("infe", 2, e))
+		}
+		iinf := fullTestBox("iinf", 0, append([][]byte{binary.BigEndian.AppendUint16(nil, uint16(len(items)))}, infes...)...)
+
+		// Version 1, with 4-byte offsets and lengths and a construction
+		// method per item
+		iloc := []byte{0x44, 0x00}
+		iloc = binary.BigEndian.AppendUint16(iloc, uint16(len(items)))
+		var idat []byte
+		for i, it := range items {
+			iloc = binary.BigEndian.AppendUint16(iloc, uint16(i+1))
+			method := uint16(0)
+			if it.inIdat {
+				method = 1
+			}
+			iloc = binary.BigEndian.AppendUint16(iloc, method)
+			iloc = binary.BigEndian.AppendUint16(iloc, 0) // data reference
+			iloc = binary.BigEndian.AppendUint16(iloc, 1) // extents
+			iloc = binary.BigEndian.AppendUint32(iloc, offsets[i])
+			iloc = binary.BigEndian.AppendUint32(iloc, uint32(len(it.payload)))
+			if it.inIdat {
+				idat = append(idat, it.payload...)
+			}
+		}
+		meta := fullTestBox("meta", 0, iinf, fullTestBox("iloc", 1, iloc), isoTestBox("idat", idat))
+		var mdat []byte
+		for _, it := range items {
+			if !it.inIdat {
+				mdat = append(mdat, it.payload...)
+			}
+		}
+		return bytes.Join([][]byte{isoTestBox("ftyp", []byte("heic\x00\x00\x00\x00mif1heic")), meta, isoTestBox("mdat", mdat)}, nil)
+	}
+
+	// The layout doesn't depend on the offsets, so build once to find
+	// where the payloads go
+	data := build(make([]uint32, len(items)))
+	idatStart := bytes.Index(data, []byte("idat")) + 4
+	mdatStart := bytes.LastIndex(data, []byte("mdat")) + 4
+	offsets := make([]uint32, len(items))
+	where := make([][2]int, len(items))
+	var inIdat, inMdat int
+	for i, it := range items {
+		if it.inIdat {
+			offsets[i] = uint32(inIdat)
+			where[i] = [2]int{idatStart + inIdat, idatStart + inIdat + len(it.payload)}
+			inIdat += len(it.payload)
+		} else {
+			offsets[i] = uint32(mdatStart + inMdat)
+			where[i] = [2]int{mdatStart + inMdat, mdatStart + inMdat + len(it.payload)}
+			inMdat += len(it.payload)
+		}
+	}
+	return build(offsets), where
+}
+
+func TestStripHEIFMetadata(t *testing.T) {
+	image := heifTestItem{typ: "hvc1", payload: []byte("coded image data, kept as it is")}
+	exif := append([]byte("\x00\x00\x00\x00"), gpsExif(6)...)
+	xmp := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/">` + secret + `</x:xmpmeta>`)
+
+	tests := []struct {
+		name  string
+		items []heifTestItem
+	}{
+		{"Exif in mdat", []heifTestItem{image, {typ: "Exif", payload: exif}}},
+		{"Exif in idat", []heifTestItem{image, {typ: "Exif", payload: exif, inIdat: true}}},
+		{"Exif and XMP", []heifTestItem{{typ: "Exif", payload: exif}, image, {typ: "mime", mime: "application/rdf+xml", payload: xmp}}},
+		{"no metadata", []heifTestItem{image}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, where := heifTestFile(tt.items)
+			out, err := stripHEIFMetadata(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(out, []byte(secret)) {
+				t.Error("metadata survived")
+			}
+			if len(out) != len(data) {
+				t.Fatalf("size changed from %d to %d bytes", len(data), len(out))
+			}
+			// Only the bytes of metadata items may change
+			kept := bytes.Clone(data)
+			for i, it := range tt.items {
+				p := out[where[i][0]:where[i][1]]
+				switch it.typ {
+				case "Exif":
+					if exifOrientation(p[4:]) != 1 {
+						t.Error("blanked EXIF item isn't empty TIFF")
+					}
+				case "mime":
+					if len(bytes.TrimSpace(p)) != 0 {
+						t.Error("XMP item isn't blank")
+					}
+				default:
+					if !bytes.Equal(p, it.payload) {
+						t.Error("image item changed")
+					}
+					continue
+				}
+				copy(kept[where[i][0]:where[i][1]], p)
+			}
+			if !bytes.Equal(out, kept) {
+				t.Error("bytes outside the metadata items changed")
+			}
+		})
+	}
+}
+
+func TestStripHEIFMetadataMalformed(t *testing.T) {
+	data, where := heifTestFile([]heifTestItem{{typ: "Exif", payload: []byte("\x00\x00\x00\x00MM\x00\x2a" + secret)}})
+	// Point the Exif item past the end of the file
+	iloc := bytes.Index(data, []byte("iloc")) + 4
+	binary.BigEndian.PutUint32(data[iloc+4+2+2+2+2+2+2:], uint32(len(data)))
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not HEIF", []byte("\x00\x00\x00\x08free")},
+		{"truncated", data[:where[0][0]-20]},
+		{"item outside the file", data},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := stripHEIFMetadata(tt.data); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
+
+func TestBlankHEIFItem(t *testing.T) {
+	tests := []struct {
+		name string
+		p    []byte
+		xmp  bool
+		want []byte
+	}{
+		{"EXIF", []byte("\x00\x00\x00\x00MM\x00\x2a\x00\x00\x00\x08GPS-DATA-SECRET"), false,
+			append([]byte("\x00\x00\x00\x00MM\x00\x2a\x00\x00\x00\x08"), make([]byte, 15)...)},
+		{"short EXIF", []byte("Exif"), false, []byte{0, 0, 0, 0}},
+		{"XMP", []byte("<x:xmpmeta/>"), true, []byte("            ")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blankHEIFItem(tt.p, tt.xmp)
+			if !bytes.Equal(tt.p, tt.want) {
+				t.Errorf("got %q, want %q", tt.p, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+)
+
+// runCatalog implements the catalog subcommand
+func runCatalog(args []string) error {
+	if len(args) == 0 || args[0] != "import" {
+		return withExitCode(exitUsage, errors.New("usage: catalog import -target <chat> [-source-dir <dir>]"))
+	}
+
+	fs := flag.NewFlagSet("catalog import", flag.ExitOnError)
+	config := &Config{}
+	credentialFlags(fs, config)
+	fs.StringVar(&config.TargetID, "target", "", "Chat or channel whose documents to import")
+	fs.StringVar(&config.JournalPath, "journal", defaultJournalPath, "Path of the upload journal")
+	sourceDir := fs.String("source-dir", "", "Local directory holding copies of the documents; files with the same name and size are linked to them so sync treats them as uploaded")
+	dryRun := fs.Bool("dry-run", false, "Only show what would be imported")
+	fs.Parse(args[1:])
+
+	if config.TargetID == "" {
+		return withExitCode(exitUsage, errors.New("-target is required"))
+	}
+	if config.JournalPath == "" {
+		return errors.New("importing needs a journal")
+	}
+	if err := validateCredentials(config); err != nil {
+		return err
+	}
+	if *sourceDir != "" {
+		dir, err := filepath.Abs(*sourceDir)
+		if err != nil {
+			return err
+		}
+		*sourceDir = dir
+	}
+
+	entries, err := readJournal(config.JournalPath)
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	known := map[int]bool{}
+	for _, e := range entries {
+		if normalizeTarget(e.Target) == normalizeTarget(config.TargetID) {
+			known[e.MessageID] = true
+		}
+	}
+
+	return withClient(config, func(ctx context.Context, client *telegram.Client) error {
+		api := client.API()
+		target, err := telegramuploader.ResolvePeer(ctx, api, config.TargetID)
+		if err != nil {
+			return err
+		}
+
+		var imported []JournalEntry
+		err = scanDocuments(ctx, api, target, func(msg *tg.Message, doc *tg.Document) error {
+			if known[msg.ID] {
+				return nil
+			}
+			name := documentName(doc)
+			if name == "" {
+				name = fmt.Sprintf("document-%d", msg.ID)
+			}
+			entry := JournalEntry{
+				Time:      time.Unix(int64(msg.Date), 0).UTC(),
+				Name:      name,
+				Size:      doc.Size,
+				MimeType:  doc.MimeType,
+				Target:    config.TargetID,
+				MessageID: msg.ID,
+			}
+			if *sourceDir != "" {
+				if err := linkLocalCopy(&entry, filepath.Join(*sourceDir, name)); err != nil {
+					return err
+				}
+			}
+			imported = append(imported, entry)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// History is scanned newest first; the journal is kept oldest first
+		var linked int
+		for i := len(imported) - 1; i >= 0; i-- {
+			e := imported[i]
+			if e.Source != "" {
+				linked++
+			}
+			if *dryRun {
+				fmt.Printf("import  %s (message %d)\n", e.Name, e.MessageID)
+				continue
+			}
+			if err := appendJournal(config.JournalPath, e); err != nil {
+				return fmt.Errorf("failed to write journal: %w", err)
+			}
+		}
+		verb := "Imported"
+		if *dryRun {
+			verb = "Would import"
+		}
+		fmt.Printf("%s %d document(s) from %s, %d linked to local files\n", verb, len(imported), config.TargetID, linked)
+		return nil
+	})
+}
+
+// linkLocalCopy sets the source of entry to path if a file of the same size
+// exists there, recording its modification time and hash
+func linkLocalCopy(entry *JournalEntry, path string) error {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != entry.Size {
+		return nil
+	}
+	sum, _, err := hashFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	entry.Source = path
+	entry.ModTime = info.ModTime()
+	entry.SHA256 = sum
+	return nil
+}
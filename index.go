@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+)
+
+// defaultIndexStatePath is where sync -index remembers its index messages
+const defaultIndexStatePath = "index-state.json"
+
+// Limits of one index message: Telegram's message length, counted in
+// UTF-16 code units, and a number of links clients still render
+const (
+	maxIndexLength = 4096
+	maxIndexLinks  = 100
+)
+
+// indexState remembers, per target and synced directory, the messages of
+// the index, so that later runs edit them instead of posting new ones
+type indexState struct {
+	Indexes map[string][]int `json:"indexes"`
+	path    string
+}
+
+// indexKey identifies the index of dir in target
+func indexKey(target, dir string) string {
+	return normalizeTarget(target) + " " + dir
+}
+
+// loadIndexState reads the state at path, starting afresh if it doesn't
+// exist yet
+func loadIndexState(path string) (*indexState, error) {
+	state := &indexState{Indexes: map[string][]int{}, path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index state: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("invalid index state %s: %w", path, err)
+	}
+	if state.Indexes == nil {
+		state.Indexes = map[string][]int{}
+	}
+	return state, nil
+}
+
+// save atomically writes the state back to disk
+func (s *indexState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// indexPage is the text of one index message with its links
+type indexPage struct {
+	Text     string
+	Entities []tg.MessageEntityClass
+}
+
+// indexPages lists the files of dir uploaded to target, as recorded in
+// entries, in as many messages as it takes. In channels and supergroups
+// each file links to its message; other chats have no message links.
+func indexPages(entries []JournalEntry, target string, peer tg.InputPeerClass, dir string) []indexPage {
+	// The latest message of every file still there
+	latest := map[string]JournalEntry{}
+	for _, e := range liveEntries(entries) {
+		if normalizeTarget(e.Target) == normalizeTarget(target) && strings.HasPrefix(e.Source, dir+string(filepath.Separator)) {
+			latest[e.Source] = e
+		}
+	}
+	sources := make([]string, 0, len(latest))
+	for source := range latest {
+		sources = append(sources, source)
+	}
+	slices.SortFunc(sources, naturalCompare)
+
+	ch, linked := peer.(*tg.InputPeerChannel)
+	header := fmt.Sprintf("📑 Index of %s: %d file(s)", filepath.Base(dir), len(sources))
+	var pages []indexPage
+	var page indexPage
+	var length int // of page.Text in UTF-16 code units
+	start := func(text string) {
+		page = indexPage{Text: text}
+		length = len(utf16.Encode([]rune(text)))
+	}
+	start(header + "\n")
+	for _, source := range sources {
+		rel, err := filepath.Rel(dir, source)
+		if err != nil {
+			continue
+		}
+		line := "\n" + filepath.ToSlash(rel)
+		n := len(utf16.Encode([]rune(line)))
+		if length+n > maxIndexLength || len(page.Entities) >= maxIndexLinks {
+			pages = append(pages, page)
+			start(header + " (continued)\n")
+		}
+		if linked {
+			page.Entities = append(page.Entities, &tg.MessageEntityTextURL{
+				Offset: length + 1, // after the newline
+				Length: n - 1,
+				URL:    fmt.Sprintf("https://t.me/c/%d/%d", ch.ChannelID, latest[source].MessageID),
+			})
+		}
+		page.Text += line
+		length += n
+	}
+	return append(pages, page)
+}
+
+// postIndex posts the index of dir in target, or edits the index messages
+// of an earlier run, and records them in state
+func postIndex(ctx context.Context, api *tg.Client, config *Config, dir string, state *indexState) error {
+	entries, err := readJournal(config.JournalPath)
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	peer, err := telegramuploader.ResolvePeer(ctx, api, config.TargetID)
+	if err != nil {
+		return err
+	}
+	pages := indexPages(entries, config.TargetID, peer, dir)
+	key := indexKey(config.TargetID, dir)
+	old := state.Indexes[key]
+
+	var ids []int
+	for i, page := range pages {
+		if i < len(old) {
+			_, err := api.MessagesEditMessage(ctx, &tg.MessagesEditMessageRequest{
+				Peer:     peer,
+				ID:       old[i],
+				Message:  page.Text,
+				Entities: page.Entities,
+			})
+			switch {
+			case err == nil || tgerr.Is(err, "MESSAGE_NOT_MODIFIED"):
+				ids = append(ids, old[i])
+				continue
+			case !tgerr.Is(err, "MESSAGE_ID_INVALID"):
+				return fmt.Errorf("failed to edit index message %d: %w", old[i], err)
+			}
+			// Deleted since; post it again
+		}
+		randomID, err := generateRandomID()
+		if err != nil {
+			return err
+		}
+		updates, err := api.MessagesSendMessage(ctx, &tg.MessagesSendMessageRequest{
+			Peer:      peer,
+			Message:   page.Text,
+			Entities:  page.Entities,
+			RandomID:  randomID,
+			NoWebpage: true,
+			Silent:    true,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to post index: %w", err)
+		}
+		msg, err := telegramuploader.SentMessage(updates)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, msg.ID)
+	}
+	// An index that got shorter leaves messages over
+	var extra []int
+	for _, id := range old {
+		if !slices.Contains(ids, id) {
+			extra = append(extra, id)
+		}
+	}
+	if len(extra) > 0 {
+		if err := deleteMessagesBatched(ctx, api, peer, extra); err != nil {
+			fmt.Printf("Failed to delete old index messages: %v\n", err)
+		}
+	}
+
+	state.Indexes[key] = ids
+	if err := state.save(); err != nil {
+		return fmt.Errorf("failed to save index state: %w", err)
+	}
+	if len(old) > 0 {
+		fmt.Printf("Updated the index in %d message(s)\n", len(ids))
+	} else {
+		fmt.Printf("Posted the index in %d message(s)\n", len(ids))
+	}
+	return nil
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	imapclient "github.com/emersion/go-imap/client"
+	_ "github.com/emersion/go-message/charset" // decodes names and headers in legacy charsets
+	"github.com/emersion/go-message/mail"
+	"github.com/gotd/td/telegram"
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+)
+
+// imapPasswordEnv holds the password of the mailbox ingest imap polls
+const imapPasswordEnv = "FILEUPLOADER_IMAP_PASSWORD"
+
+// defaultIMAPStatePath is where ingest imap remembers how far it got
+const defaultIMAPStatePath = "imap-state.json"
+
+// runIngest implements the "ingest" subcommand, which uploads files that
+// arrive some other way than through the file system
+func runIngest(args []string) error {
+	if len(args) > 0 && args[0] == "imap" {
+		return runIngestIMAP(args[1:])
+	}
+	return withExitCode(exitUsage, errors.New("usage: ingest imap [flags]"))
+}
+
+// imapOptions says which mailbox ingest imap polls and which attachments
+// it takes
+type imapOptions struct {
+	Server   string
+	User     string
+	Password string
+	Mailbox  string
+	StartTLS bool
+	From     string        // Only mail whose From contains this
+	Subject  string        // Only mail whose Subject contains this
+	Match    []string      // Only attachments whose name matches one of these patterns
+	Since    time.Duration // Only mail received within this long
+	MaxSize  int64         // Skip larger attachments
+}
+
+// mailboxURL identifies the mailbox in the state file and in the sources
+// of the journal entries
+func (o imapOptions) mailboxURL() string {
+	u := url.URL{Scheme: "imap", User: url.User(o.User), Host: o.Server, Path: "/" + o.Mailbox}
+	return u.String()
+}
+
+// matches reports whether an attachment named name is wanted
+func (o imapOptions) matches(name string) bool {
+	if len(o.Match) == 0 {
+		return true
+	}
+	for _, pattern := range o.Match {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// runIngestIMAP implements "ingest imap": polling a mailbox and uploading
+// the attachments of new mail that matches the filters
+func runIngestIMAP(args []string) error {
+	config := &Config{}
+	opts := imapOptions{}
+	flags := flag.NewFlagSet("ingest imap", flag.ExitOnError)
+	credentialFlags(flags, config)
+	flags.StringVar(&opts.Server, "server", "", "IMAP server, like imap.example.com or imap.example.com:993")
+	flags.StringVar(&opts.User, "user", "", "User name of the mailbox")
+	flags.StringVar(&opts.Password, "password", os.Getenv(imapPasswordEnv), "Password of the mailbox (default $"+imapPasswordEnv+")")
+	flags.StringVar(&opts.Mailbox, "mailbox", "INBOX", "Mailbox to poll")
+	flags.BoolVar(&opts.StartTLS, "starttls", false, "Connect without TLS and upgrade with STARTTLS, usually on port 143, instead of connecting with TLS")
+	flags.StringVar(&opts.From, "from", "", "Only take mail whose sender contains this, like reports@example.com")
+	flags.StringVar(&opts.Subject, "subject", "", "Only take mail whose subject contains this")
+	match := flags.String("match", "", "Comma-separated patterns of attachment names to upload, like \"*.pdf,*.csv\" (default: all)")
+	maxSize := flags.String("max-size", "", "Skip attachments larger than this, like 50M")
+	flags.DurationVar(&opts.Since, "since", 7*24*time.Hour, "On the first run, only take mail received within this long (0 for all)")
+	interval := flags.Duration("interval", time.Minute, "How often to check for new mail")
+	once := flags.Bool("once", false, "Check once and exit instead of polling")
+	statePath := flags.String("state", defaultIMAPStatePath, "Remember the last mail taken from each mailbox in this file")
+	flags.StringVar(&config.TargetID, "target", "me", "Target username or chat ID")
+	flags.StringVar(&config.JournalPath, "journal", defaultJournalPath, "Record uploads in this file, which also keeps attachments from being uploaded twice")
+	flags.StringVar(&config.FileCachePath, "file-cache", defaultFileCachePath, "Keep the Telegram IDs of uploaded files here (empty to disable)")
+	timeoutFlags(flags, &config.Timeouts)
+	applyProgressFlags := progressFlags(flags)
+	applySystemdFlags := systemdFlags(flags)
+	applyLogTargetFlags := logTargetFlags(flags)
+	applyTracingFlags := tracingFlags(flags)
+	applyRateFlags := uploadRateFlags(flags)
+	flags.Parse(args)
+	if err := applyProgressFlags(); err != nil {
+		return err
+	}
+	applySystemdFlags()
+	if err := applyLogTargetFlags(); err != nil {
+		return err
+	}
+	if err := applyTracingFlags(); err != nil {
+		return err
+	}
+	rates, err := applyRateFlags()
+	if err != nil {
+		return err
+	}
+	config.Rates = rates
+
+	if opts.Server == "" || opts.User == "" {
+		return withExitCode(exitUsage, errors.New("usage: ingest imap -server <host> -user <name> [-target <chat>]"))
+	}
+	if opts.Password == "" {
+		return withExitCode(exitUsage, errors.New("the mailbox password is required; set $"+imapPasswordEnv+" or use -password"))
+	}
+	if config.JournalPath == "" || *statePath == "" {
+		return withExitCode(exitUsage, errors.New("ingest imap needs -journal and -state to know which mail it has taken"))
+	}
+	if *interval <= 0 {
+		return withExitCode(exitUsage, errors.New("-interval must be positive"))
+	}
+	if _, _, err := net.SplitHostPort(opts.Server); err != nil {
+		port := "993"
+		if opts.StartTLS {
+			port = "143"
+		}
+		opts.Server = net.JoinHostPort(opts.Server, port)
+	}
+	opts.Match = splitList(*match)
+	for _, pattern := range opts.Match {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return withExitCode(exitUsage, fmt.Errorf("invalid -match pattern %q: %w", pattern, err))
+		}
+	}
+	if *maxSize != "" {
+		if opts.MaxSize, err = parseSize(*maxSize); err != nil {
+			return withExitCode(exitUsage, fmt.Errorf("invalid -max-size: %w", err))
+		}
+	}
+	if err := validateCredentials(config); err != nil {
+		return err
+	}
+	state, err := loadIMAPState(*statePath)
+	if err != nil {
+		return err
+	}
+
+	return withClient(config, func(ctx context.Context, client *telegram.Client) error {
+		p, err := telegramuploader.ResolvePeer(ctx, client.API(), config.TargetID)
+		if err != nil {
+			return err
+		}
+		config.Peer = p
+		config.NoPrompt = true
+
+		daemonReady(ctx, client)
+		fmt.Printf("Checking %s for attachments every %s; press Ctrl-C to stop\n", opts.mailboxURL(), *interval)
+		for {
+			err := ingestIMAP(ctx, client, config, opts, state)
+			switch {
+			case ctx.Err() != nil:
+				return nil
+			case err != nil && *once:
+				return err
+			case err != nil:
+				log.Printf("Failed to check %s: %v", opts.mailboxURL(), friendlyError(err))
+			}
+			if *once {
+				return nil
+			}
+			select {
+			case <-time.After(*interval):
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	})
+}
+
+// imapState remembers, per mailbox URL, the last mail ingest imap has
+// taken, so that each check only fetches newer mail
+type imapState struct {
+	Mailboxes map[string]imapMailboxState `json:"mailboxes"`
+	path      string
+}
+
+// imapMailboxState is the progress through one mailbox. UIDs only stay
+// valid as long as the mailbox's UIDVALIDITY doesn't change.
+type imapMailboxState struct {
+	UIDValidity uint32 `json:"uid_validity"`
+	LastUID     uint32 `json:"last_uid"`
+}
+
+// loadIMAPState reads the state at path, starting afresh if it doesn't
+// exist yet
+func loadIMAPState(path string) (*imapState, error) {
+	state := &imapState{Mailboxes: map[string]imapMailboxState{}, path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read IMAP state: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("invalid IMAP state %s: %w", path, err)
+	}
+	if state.Mailboxes == nil {
+		state.Mailboxes = map[string]imapMailboxState{}
+	}
+	return state, nil
+}
+
+// save atomically writes the state back to disk
+func (s *imapState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// dialIMAP connects to the server of opts
+func dialIMAP(opts imapOptions) (*imapclient.Client, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if !opts.StartTLS {
+		c, err := imapclient.DialWithDialerTLS(dialer, opts.Server, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w", opts.Server, err)
+		}
+		return c, nil
+	}
+	c, err := imapclient.DialWithDialer(dialer, opts.Server)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", opts.Server, err)
+	}
+	host, _, _ := net.SplitHostPort(opts.Server)
+	if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+		c.Logout()
+		return nil, fmt.Errorf("STARTTLS with %s failed: %w", opts.Server, err)
+	}
+	return c, nil
+}
+
+// ingestIMAP checks the mailbox of opts once, and uploads the matching
+// attachments of the mail that arrived since the last check
+func ingestIMAP(ctx context.Context, client *telegram.Client, config *Config, opts imapOptions, state *imapState) error {
+	c, err := dialIMAP(opts)
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+	// The IMAP client doesn't take contexts; closing the connection
+	// interrupts it instead
+	stop := context.AfterFunc(ctx, func() { c.Terminate() })
+	defer stop()
+
+	if err := c.Login(opts.User, opts.Password); err != nil {
+		return fmt.Errorf("failed to log in to %s: %w", opts.Server, err)
+	}
+	mbox, err := c.Select(opts.Mailbox, true)
+	if err != nil {
+		return fmt.Errorf("failed to open mailbox %s: %w", opts.Mailbox, err)
+	}
+	key := opts.mailboxURL()
+	seen := state.Mailboxes[key]
+	if seen.UIDValidity != mbox.UidValidity {
+		// The server renumbered the mailbox; start over, and let the
+		// journal skip what was uploaded already
+		seen = imapMailboxState{UIDValidity: mbox.UidValidity}
+	}
+
+	criteria := imap.NewSearchCriteria()
+	if seen.LastUID > 0 {
+		criteria.Uid = new(imap.SeqSet)
+		criteria.Uid.AddRange(seen.LastUID+1, 0)
+	} else if opts.Since > 0 {
+		criteria.Since = time.Now().Add(-opts.Since)
+	}
+	if opts.From != "" {
+		criteria.Header.Add("From", opts.From)
+	}
+	if opts.Subject != "" {
+		criteria.Header.Add("Subject", opts.Subject)
+	}
+	uids, err := c.UidSearch(criteria)
+	if err != nil {
+		return fmt.Errorf("failed to search %s: %w", opts.Mailbox, err)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+
+	entries, err := readJournal(config.JournalPath)
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	uploaded := map[string]bool{}
+	for _, e := range entries {
+		uploaded[e.Source] = true
+	}
+
+	prefix := fmt.Sprintf("%s;UIDVALIDITY=%d", key, mbox.UidValidity)
+	for _, uid := range uids {
+		// "n:*" matches the newest mail even when it's older than n
+		if uid <= seen.LastUID {
+			continue
+		}
+		// A mail with failed attachments isn't marked seen, so the next
+		// check starts from it again
+		if err := ingestMessage(ctx, c, client, config, opts, fmt.Sprintf("%s/;UID=%d", prefix, uid), uid, uploaded); err != nil {
+			return err
+		}
+		seen.LastUID = uid
+		state.Mailboxes[key] = seen
+		if err := state.save(); err != nil {
+			return fmt.Errorf("failed to save IMAP state: %w", err)
+		}
+	}
+	if _, known := state.Mailboxes[key]; !known {
+		state.Mailboxes[key] = seen
+		if err := state.save(); err != nil {
+			return fmt.Errorf("failed to save IMAP state: %w", err)
+		}
+	}
+	return nil
+}
+
+// errAttachmentTooLarge means an attachment is over -max-size, and is
+// skipped for good rather than tried again
+var errAttachmentTooLarge = errors.New("the attachment is too large")
+
+// ingestMessage uploads the wanted attachments of the mail with uid, whose
+// journal sources start with source. If any fail to upload it returns an
+// error once it has tried the others, so that the mail is checked again;
+// attachments already uploaded aren't sent twice. Attachments that are too
+// large are only reported.
+func ingestMessage(ctx context.Context, c *imapclient.Client, client *telegram.Client, config *Config, opts imapOptions, source string, uid uint32, uploaded map[string]bool) error {
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uid)
+	section := &imap.BodySectionName{Peek: true}
+	messages := make(chan *imap.Message, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(seqset, []imap.FetchItem{imap.FetchEnvelope, section.FetchItem()}, messages)
+	}()
+	msg := <-messages
+	if err := <-done; err != nil {
+		return fmt.Errorf("failed to fetch mail %d: %w", uid, err)
+	}
+	if msg == nil {
+		// Deleted meanwhile
+		return nil
+	}
+	body := msg.GetBody(section)
+	if body == nil {
+		return fmt.Errorf("the server sent mail %d without its body", uid)
+	}
+	subject := ""
+	if msg.Envelope != nil {
+		subject = msg.Envelope.Subject
+	}
+
+	mr, err := mail.CreateReader(body)
+	if err != nil {
+		log.Printf("Skipping mail %d (%q), which can't be parsed: %v", uid, subject, err)
+		return nil
+	}
+	names := map[string]int{}
+	var failed int
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Skipping the rest of mail %d (%q), which can't be parsed: %v", uid, subject, err)
+			break
+		}
+		h, ok := part.Header.(*mail.AttachmentHeader)
+		if !ok {
+			continue
+		}
+		name, _ := h.Filename()
+		name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+		if name == "" || name == "." || name == "/" || !opts.matches(name) {
+			continue
+		}
+		// Attachments with the same name in one mail are told apart
+		names[name]++
+		attachmentSource := source + "/" + url.PathEscape(name)
+		if n := names[name]; n > 1 {
+			attachmentSource += fmt.Sprintf("#%d", n)
+		}
+		if uploaded[attachmentSource] {
+			continue
+		}
+
+		fmt.Printf("Mail %d (%q): uploading %s\n", uid, subject, name)
+		err = ingestAttachment(ctx, client, config, opts, part.Body, name, attachmentSource)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, errAttachmentTooLarge) {
+			log.Printf("Skipping %s from mail %d: %v", name, uid, err)
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to upload %s from mail %d: %v", name, uid, friendlyError(err))
+			failed++
+			continue
+		}
+		uploaded[attachmentSource] = true
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to upload %d attachment(s) of mail %d (%q); it's tried again on the next check", failed, uid, subject)
+	}
+	return nil
+}
+
+// ingestAttachment saves an attachment to a temporary file and uploads it
+func ingestAttachment(ctx context.Context, client *telegram.Client, config *Config, opts imapOptions, r io.Reader, name, source string) error {
+	tmp, err := os.CreateTemp("", "ingest-*"+filepath.Ext(name))
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	limit := opts.MaxSize
+	if limit <= 0 {
+		limit = telegramuploader.MaxFileSize
+	}
+	n, err := io.Copy(tmp, io.LimitReader(r, limit+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save attachment: %w", err)
+	}
+	if n > limit {
+		return fmt.Errorf("%w: larger than %.2f MB", errAttachmentTooLarge, float64(limit)/(1024*1024))
+	}
+
+	fileConfig := *config
+	fileConfig.Input = telegramuploader.FileSource(tmp.Name())
+	fileConfig.FileName = name
+	fileConfig.Source = source
+	return uploadFile(ctx, client, &fileConfig)
+}
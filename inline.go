@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gotd/td/tg"
+)
+
+// maxInlineResults is the most results Telegram accepts for an inline query
+const maxInlineResults = 50
+
+// inlineResults searches the journal for query and returns the matches
+// whose file IDs are cached, as inline results. Only files the bot sent
+// itself have IDs it may use.
+func inlineResults(config *Config, query string) ([]tg.InputBotInlineResultClass, error) {
+	entries, err := readJournal(config.JournalPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+	files, err := readFileCache(config.FileCachePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file ID cache: %w", err)
+	}
+	byHash := map[string]*cachedFile{}
+	for i := range files {
+		byHash[files[i].SHA256] = &files[i]
+	}
+
+	var results []tg.InputBotInlineResultClass
+	seen := map[string]bool{}
+	for _, r := range searchCatalog(liveEntries(entries), catalogQuery{Terms: strings.Fields(query)}) {
+		c, ok := byHash[r.Entry.SHA256]
+		if !ok || seen[c.SHA256] {
+			continue
+		}
+		seen[c.SHA256] = true
+
+		description := fmt.Sprintf("%.2f MB, uploaded %s", float64(c.Size)/(1024*1024), r.Entry.Time.Local().Format("2006-01-02"))
+		send := &tg.InputBotInlineMessageMediaAuto{Message: fmt.Sprintf("Uploaded file: %s", c.Name)}
+		if c.Photo {
+			results = append(results, &tg.InputBotInlineResultPhoto{
+				ID:          c.SHA256,
+				Type:        "photo",
+				Photo:       &tg.InputPhoto{ID: c.ID, AccessHash: c.AccessHash, FileReference: c.FileReference},
+				SendMessage: send,
+			})
+		} else {
+			results = append(results, &tg.InputBotInlineResultDocument{
+				ID:          c.SHA256,
+				Type:        "file",
+				Title:       c.Name,
+				Description: description,
+				Document:    &tg.InputDocument{ID: c.ID, AccessHash: c.AccessHash, FileReference: c.FileReference},
+				SendMessage: send,
+			})
+		}
+		if len(results) == maxInlineResults {
+			break
+		}
+	}
+	return results, nil
+}
+
+// answerInlineQuery replies to an inline query with matching uploads
+func answerInlineQuery(ctx context.Context, api *tg.Client, config *Config, u *tg.UpdateBotInlineQuery) error {
+	results, err := inlineResults(config, u.Query)
+	if err != nil {
+		return err
+	}
+	_, err = api.MessagesSetInlineBotResults(ctx, &tg.MessagesSetInlineBotResultsRequest{
+		Private:   true,
+		QueryID:   u.QueryID,
+		Results:   results,
+		CacheTime: 10,
+	})
+	return err
+}
+
+// maxFindResults is how many files /find and /recent send at most
+const maxFindResults = 5
+
+// serveFind answers /find <query> and /recent [n] by sending the matching
+// uploads again by file ID, and listing those that can't be
+func serveFind(ctx context.Context, api *tg.Client, config *Config, cmd botCommand) error {
+	entries, err := readJournal(config.JournalPath)
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	files, err := readFileCache(config.FileCachePath)
+	if err != nil {
+		return fmt.Errorf("failed to read file ID cache: %w", err)
+	}
+	byHash := map[string]*cachedFile{}
+	for i := range files {
+		byHash[files[i].SHA256] = &files[i]
+	}
+
+	var matches []JournalEntry
+	limit := maxFindResults
+	switch cmd.name {
+	case "find":
+		if cmd.arg == "" {
+			_, err := botReply(ctx, api, cmd.peer, cmd.msg.ID, "Usage: /find <words>")
+			return err
+		}
+		for _, r := range searchCatalog(liveEntries(entries), catalogQuery{Terms: strings.Fields(cmd.arg)}) {
+			matches = append(matches, r.Entry)
+		}
+	case "recent":
+		if n, err := strconv.Atoi(cmd.arg); err == nil && n > 0 {
+			limit = min(n, 2*maxFindResults)
+		}
+		live := liveEntries(entries)
+		for i := len(live) - 1; i >= 0; i-- {
+			matches = append(matches, live[i])
+		}
+	}
+
+	// Send each file once, even if it was uploaded to several chats
+	var sent int
+	var unavailable []string
+	seen := map[string]bool{}
+	for _, e := range matches {
+		if sent+len(unavailable) == limit {
+			break
+		}
+		key := e.SHA256
+		if key == "" {
+			key = e.Source
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		c, ok := byHash[e.SHA256]
+		if !ok {
+			unavailable = append(unavailable, fmt.Sprintf("%s (%.2f MB, %s)", e.Name, float64(e.Size)/(1024*1024), e.Time.Local().Format("2006-01-02")))
+			continue
+		}
+		randomID, err := generateRandomID()
+		if err != nil {
+			return err
+		}
+		_, err = sendCachedFile(ctx, api, config.FileCachePath, c, &tg.MessagesSendMediaRequest{
+			Peer:     cmd.peer,
+			ReplyTo:  &tg.InputReplyToMessage{ReplyToMsgID: cmd.msg.ID},
+			Message:  fmt.Sprintf("%s, uploaded %s", c.Name, e.Time.Local().Format("2006-01-02")),
+			RandomID: randomID,
+		})
+		if err != nil {
+			unavailable = append(unavailable, fmt.Sprintf("%s (%v)", c.Name, err))
+			continue
+		}
+		sent++
+	}
+
+	switch {
+	case sent == 0 && len(unavailable) == 0:
+		_, err = botReply(ctx, api, cmd.peer, cmd.msg.ID, "No matching uploads")
+	case len(unavailable) > 0:
+		_, err = botReply(ctx, api, cmd.peer, cmd.msg.ID, "Found, but can't be sent from here:\n"+strings.Join(unavailable, "\n"))
+	}
+	return err
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// defaultJournalPath is where successful uploads are recorded
+const defaultJournalPath = "journal.jsonl"
+
+// JournalEntry records one successful upload, or the deletion of an
+// uploaded message when Deleted is set. An upload about to be sent is
+// recorded as Pending first, so that its job survives a crash.
+type JournalEntry struct {
+	Time         time.Time `json:"time"`
+	Source       string    `json:"source"` // local path or URL the file came from
+	Name         string    `json:"name"`   // name the file was uploaded under
+	OriginalName string    `json:"original_name,omitempty"`
+	Size         int64     `json:"size"`
+	ModTime      time.Time `json:"mtime,omitempty"`
+	SHA256       string    `json:"sha256,omitempty"`
+	MimeType     string    `json:"mime_type"`
+	Target       string    `json:"target"`
+	MessageID    int       `json:"message_id"`
+	Path         string    `json:"path,omitempty"` // where the file is in its chat, for remote paths
+	Deleted      bool      `json:"deleted,omitempty"`
+
+	// Job identifies one attempt to send the file, and with it the
+	// message's random ID; sending the file again on purpose is a new job
+	Job     string `json:"job,omitempty"`
+	Pending bool   `json:"pending,omitempty"` // set until the job's message is sent
+}
+
+// appendJournal adds entry to the journal at path
+func appendJournal(path string, entry JournalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// readJournal returns all entries of the journal at path, oldest first,
+// leaving out pending jobs. A missing journal is empty.
+func readJournal(path string) ([]JournalEntry, error) {
+	entries, err := readJournalJobs(path)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(entries, func(e JournalEntry) bool { return e.Pending }), nil
+}
+
+// readJournalJobs is readJournal including the entries of pending jobs
+func readJournalJobs(path string) ([]JournalEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// liveEntries returns the latest entry of every uploaded message that
+// hasn't been deleted since. Messages whose media was replaced appear once,
+// with their current content.
+func liveEntries(entries []JournalEntry) []JournalEntry {
+	type key struct {
+		target    string
+		messageID int
+	}
+	latest := map[key]int{}
+	for i, e := range entries {
+		k := key{normalizeTarget(e.Target), e.MessageID}
+		if e.Deleted {
+			delete(latest, k)
+		} else {
+			latest[k] = i
+		}
+	}
+
+	var live []JournalEntry
+	for i, e := range entries {
+		if !e.Deleted && latest[key{normalizeTarget(e.Target), e.MessageID}] == i {
+			live = append(live, e)
+		}
+	}
+	return live
+}
+
+// latestUpload returns the live entry of the most recent upload of source
+// to target, or nil if there is none
+func latestUpload(entries []JournalEntry, source, target string) *JournalEntry {
+	live := liveEntries(entries)
+	for i := len(live) - 1; i >= 0; i-- {
+		if live[i].Source == source && normalizeTarget(live[i].Target) == normalizeTarget(target) {
+			return &live[i]
+		}
+	}
+	return nil
+}
+
+// pendingJob returns the job of an earlier attempt to send the upload
+// described by entry that never got confirmed, so that sending it again
+// reuses its random ID, or else starts a new job and records it as pending.
+// resumed is the time of the earlier attempt, zero for a new job.
+func pendingJob(path string, entry JournalEntry) (job string, resumed time.Time, err error) {
+	entries, err := readJournalJobs(path)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	done := map[string]bool{}
+	for _, e := range entries {
+		if e.Job != "" && !e.Pending {
+			done[e.Job] = true
+		}
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.Pending && !done[e.Job] && e.Source == entry.Source && e.Name == entry.Name && e.Size == entry.Size &&
+			e.SHA256 == entry.SHA256 && e.ModTime.Equal(entry.ModTime) && normalizeTarget(e.Target) == normalizeTarget(entry.Target) {
+			return e.Job, e.Time, nil
+		}
+	}
+
+	if entry.Job, err = newJobID(); err != nil {
+		return "", time.Time{}, err
+	}
+	entry.Pending = true
+	entry.Time = time.Now().UTC()
+	if err := appendJournal(path, entry); err != nil {
+		return "", time.Time{}, err
+	}
+	return entry.Job, time.Time{}, nil
+}
+
+// newJobID returns a random ID for a new upload job
+func newJobID() (string, error) {
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(nonce), nil
+}
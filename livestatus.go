@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+)
+
+// liveStatusInterval is how often the status message is edited; Telegram
+// doesn't like messages being edited much more often
+const liveStatusInterval = 5 * time.Second
+
+// liveStatusConfig says where the progress of an upload is posted
+type liveStatusConfig struct {
+	Peer    tg.InputPeerClass // chat of the status message; nil for the upload's target
+	ReplyTo int               // message the status replies to, if any
+}
+
+// liveStatus is a message kept up to date with the progress of an upload
+type liveStatus struct {
+	api  *tg.Client
+	peer tg.InputPeerClass
+	id   int
+	text string
+}
+
+// postLiveStatus posts the status message
+func postLiveStatus(ctx context.Context, api *tg.Client, peer tg.InputPeerClass, replyTo int, text string) (*liveStatus, error) {
+	randomID, err := generateRandomID()
+	if err != nil {
+		return nil, err
+	}
+	req := &tg.MessagesSendMessageRequest{Peer: peer, Message: text, RandomID: randomID, Silent: true}
+	if replyTo != 0 {
+		req.ReplyTo = &tg.InputReplyToMessage{ReplyToMsgID: replyTo}
+	}
+	updates, err := api.MessagesSendMessage(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := telegramuploader.SentMessage(updates)
+	if err != nil {
+		return nil, err
+	}
+	return &liveStatus{api: api, peer: peer, id: msg.ID, text: text}, nil
+}
+
+// update replaces the text of the status message. Failures only cost an
+// update, so they are ignored.
+func (s *liveStatus) update(ctx context.Context, text string) {
+	if text == s.text {
+		return
+	}
+	_, err := s.api.MessagesEditMessage(ctx, &tg.MessagesEditMessageRequest{Peer: s.peer, ID: s.id, Message: text})
+	if err == nil || tgerr.Is(err, "MESSAGE_NOT_MODIFIED") {
+		s.text = text
+	}
+}
+
+// liveStatusText describes an upload of done out of size bytes, where size
+// is -1 for a stream of unknown length
+func liveStatusText(name string, done, size int64, start time.Time) string {
+	elapsed := time.Since(start).Seconds()
+	speed := float64(done) / elapsed
+	if size < 0 {
+		return fmt.Sprintf("⏫ %s: %.2f MB so far (%.2f MB/s)", name, float64(done)/(1024*1024), speed/(1024*1024))
+	}
+	if done >= size {
+		return fmt.Sprintf("⏳ %s: uploaded, sending…", name)
+	}
+	text := fmt.Sprintf("⏫ %s: %d%% (%.2f/%.2f MB)", name, done*100/max(size, 1), float64(done)/(1024*1024), float64(size)/(1024*1024))
+	if speed > 0 {
+		eta := time.Duration(float64(size-done) / speed * float64(time.Second))
+		text += fmt.Sprintf("\n%.2f MB/s, %s left", speed/(1024*1024), eta.Round(time.Second))
+	}
+	return text
+}
+
+// uploadFileLive runs uploadFile while keeping a status message in
+// Telegram up to date with its progress, finishing with a summary
+func uploadFileLive(ctx context.Context, client *telegram.Client, config *Config) error {
+	api := client.API()
+	fileConfig := *config
+	fileConfig.LiveStatus = nil
+	if fileConfig.Control == nil {
+		fileConfig.Control = newTransferControl()
+	}
+	control := fileConfig.Control
+
+	peer := config.LiveStatus.Peer
+	if peer == nil {
+		peer = config.Peer
+	}
+	if peer == nil {
+		var err error
+		if peer, err = telegramuploader.ResolvePeer(ctx, api, config.TargetID); err != nil {
+			return err
+		}
+	}
+	size := config.Input.Size()
+
+	start := time.Now()
+	startText := fmt.Sprintf("⏫ %s: starting (%.2f MB)", config.FileName, float64(size)/(1024*1024))
+	if size < 0 {
+		startText = fmt.Sprintf("⏫ %s: starting", config.FileName)
+	}
+	status, err := postLiveStatus(ctx, api, peer, config.LiveStatus.ReplyTo, startText)
+	if err != nil {
+		// The upload matters more than its status
+		fmt.Printf("Failed to post status message: %v\n", err)
+		return uploadFile(ctx, client, &fileConfig)
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(liveStatusInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				status.update(ctx, liveStatusText(config.FileName, control.bytes.Load(), size, start))
+			case <-done:
+				return
+			}
+		}
+	}()
+	err = uploadFile(ctx, client, &fileConfig)
+	close(done)
+	<-stopped
+
+	summary := fmt.Sprintf("✅ %s: %.2f MB sent in %s", config.FileName, float64(control.bytes.Load())/(1024*1024), time.Since(start).Round(time.Second))
+	if err != nil {
+		summary = fmt.Sprintf("❌ %s: %v", config.FileName, friendlyError(err))
+	}
+	// Report the outcome even if ctx was cancelled
+	finishCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	finishCtx, span := startSpan(finishCtx, "notify")
+	status.update(finishCtx, summary)
+	span.End()
+	return err
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logIdentifier is what the program calls itself in syslog and the journal
+const logIdentifier = "fileuploader"
+
+// Syslog priorities of the lines sent to a log target
+const (
+	priorityErr    = 3
+	priorityNotice = 5
+	priorityInfo   = 6
+)
+
+// flushLogs waits for the output written so far to reach the -log-target.
+// Like stopProfiling it's called when main returns and by fatal.
+var flushLogs = func() {}
+
+// loggingToTarget is set once -log-target has redirected the log
+var loggingToTarget bool
+
+// logSink is where -log-target sends lines, each with a syslog priority
+type logSink interface {
+	writeLine(priority int, line string) error
+}
+
+// logTargetFlags registers -log-target on fs. The returned function
+// redirects the log and standard output to it once fs has been parsed.
+func logTargetFlags(fs *flag.FlagSet) func() error {
+	target := fs.String("log-target", "", "Send logs and output to syslog, journald or file:<path> instead of the terminal")
+	return func() error {
+		if *target == "" {
+			return nil
+		}
+		sink, err := openLogSink(*target)
+		if err != nil {
+			return err
+		}
+		// Each target adds its own timestamps, and bars make no sense there
+		log.SetFlags(0)
+		log.SetOutput(&sinkWriter{sink: sink, priority: priorityNotice})
+		loggingToTarget = true
+		if progressMode == "bar" {
+			progressMode = "plain"
+		}
+
+		// Messages printed to standard output are sent as they're written
+		r, w, err := os.Pipe()
+		if err != nil {
+			return fmt.Errorf("failed to redirect output: %w", err)
+		}
+		os.Stdout = w
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			io.Copy(&sinkWriter{sink: sink, priority: priorityInfo}, r)
+		}()
+		var once sync.Once
+		flushLogs = func() {
+			once.Do(func() {
+				w.Close()
+				<-done
+			})
+		}
+		return nil
+	}
+}
+
+// openLogSink opens the -log-target named by target
+func openLogSink(target string) (logSink, error) {
+	switch {
+	case target == "syslog":
+		return openSyslog()
+	case target == "journald":
+		return openJournald()
+	case strings.HasPrefix(target, "file:"):
+		path := strings.TrimPrefix(target, "file:")
+		if path == "" {
+			return nil, withExitCode(exitUsage, errors.New("-log-target file: needs a path"))
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		return &fileSink{f: f}, nil
+	}
+	return nil, withExitCode(exitUsage, fmt.Errorf("invalid -log-target %q: use syslog, journald or file:<path>", target))
+}
+
+// sinkWriter splits what's written to it into lines for a logSink. Lines
+// starting with a "<n>" priority prefix, like the ones -systemd writes, are
+// sent with that priority, and the others with its own. Lines the sink
+// fails to take are dropped, so that a log target that goes away never
+// blocks the program's output; the failure is reported on standard error.
+type sinkWriter struct {
+	mu       sync.Mutex
+	sink     logSink
+	priority int
+	partial  []byte
+	failing  bool // the last line failed to reach the sink
+}
+
+// Write implements io.Writer
+func (s *sinkWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.partial = append(s.partial, p...)
+	for {
+		i := bytes.IndexByte(s.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(s.partial[:i])
+		s.partial = s.partial[i+1:]
+		// Progress lines rewrite themselves with carriage returns
+		if j := strings.LastIndexByte(line, '\r'); j >= 0 {
+			line = line[j+1:]
+		}
+		if line == "" {
+			continue
+		}
+		priority := s.priority
+		if len(line) > 3 && line[0] == '<' && line[2] == '>' && line[1] >= '0' && line[1] <= '7' {
+			priority = int(line[1] - '0')
+			line = line[3:]
+		}
+		err := s.sink.writeLine(priority, line)
+		switch {
+		case err != nil && !s.failing:
+			fmt.Fprintf(os.Stderr, "Failed to write to the log target; dropping lines until it works again: %v\n", err)
+			s.failing = true
+		case err == nil && s.failing:
+			fmt.Fprintln(os.Stderr, "The log target works again")
+			s.failing = false
+		}
+	}
+	return len(p), nil
+}
+
+// fileSink appends timestamped lines to a file
+type fileSink struct {
+	f *os.File
+}
+
+func (s *fileSink) writeLine(priority int, line string) error {
+	level := "INFO"
+	switch {
+	case priority <= priorityErr:
+		level = "ERROR"
+	case priority < priorityInfo:
+		level = "NOTICE"
+	}
+	_, err := fmt.Fprintf(s.f, "%s %-6s %s\n", time.Now().Format(time.RFC3339), level, line)
+	return err
+}
+
+// journaldSocket is where journald takes entries in its native protocol
+const journaldSocket = "/run/systemd/journal/socket"
+
+// journaldSink sends lines to journald as entries with a priority,
+// connecting again when journald was restarted
+type journaldSink struct {
+	conn net.Conn
+}
+
+// openJournald connects to the local journald
+func openJournald() (logSink, error) {
+	conn, err := net.Dial("unixgram", journaldSocket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to journald: %w", err)
+	}
+	return &journaldSink{conn: conn}, nil
+}
+
+func (s *journaldSink) writeLine(priority int, line string) error {
+	// Fields are KEY=value lines, and lines never contain newlines here
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "PRIORITY=%d\n", priority)
+	fmt.Fprintf(&b, "SYSLOG_IDENTIFIER=%s\n", logIdentifier)
+	fmt.Fprintf(&b, "MESSAGE=%s\n", line)
+	if s.conn != nil {
+		if _, err := s.conn.Write(b.Bytes()); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	conn, err := net.Dial("unixgram", journaldSocket)
+	if err != nil {
+		return fmt.Errorf("failed to connect to journald: %w", err)
+	}
+	s.conn = conn
+	_, err = s.conn.Write(b.Bytes())
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build windows || plan9
+
+package main
+
+import "errors"
+
+// openSyslog fails, as there is no syslog here
+func openSyslog() (logSink, error) {
+	return nil, withExitCode(exitUsage, errors.New("syslog isn't available on this system; use -log-target file:<path>"))
+}
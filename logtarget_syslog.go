@@ -0,0 +1,33 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+)
+
+// syslogSink sends lines to the local syslog daemon. syslog.Writer
+// connects again by itself when a write fails.
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+// openSyslog connects to the local syslog daemon
+func openSyslog() (logSink, error) {
+	w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, logIdentifier)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) writeLine(priority int, line string) error {
+	switch {
+	case priority <= priorityErr:
+		return s.w.Err(line)
+	case priority < priorityInfo:
+		return s.w.Notice(line)
+	}
+	return s.w.Info(line)
+}
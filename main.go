@@ -4,66 +4,593 @@ import (
 	"bufio"
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/gotd/td/session"
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/telegram/auth"
 	"github.com/gotd/td/telegram/uploader"
 	"github.com/gotd/td/tg"
-	"github.com/schollz/progressbar/v3"
+	"github.com/gotd/td/tgerr"
+	"github.com/mdp/qrterminal/v3"
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// maxConnections is the most connections -connections opens; Telegram
+// tends to answer many more with flood waits
+const maxConnections = 8
+
 // Config holds application configuration
 type Config struct {
 	AppID    int
 	AppHash  string
 	Phone    string
-	FilePath string
+	BotToken string // Log in as this bot instead of with Phone
+	FileName string // Name the file is uploaded under
+	Source   string // Local path or URL the file came from
 	TargetID string // Username or chat ID to send the file to
+
+	// Input is the data uploaded: a local file, standard input or a
+	// download streamed as it arrives
+	Input telegramuploader.Source
+
+	// Peer is the already resolved target, e.g. the chat a bot command came
+	// from; TargetID then only names it
+	Peer tg.InputPeerClass
+
+	// OriginalName is set when FileName has been obfuscated
+	OriginalName string
+
+	// RemotePath is the file's path in the target chat when it's copied to
+	// a remote like telegram:backups/photos
+	RemotePath string
+
+	DisplayPath string   // Shown in the caption instead of the file name, like a path in a synced tree
+	Hashtags    []string // Added to the caption, without their #
+	TopicID     int      // Forum topic the file is sent to; 0 for the chat itself
+
+	SignManifest  bool   // Upload a signed manifest after the file
+	PostChecksums bool   // Send a SHA256SUMS document after the file
+	ManifestKey   string // Path of the manifest signing key
+
+	// Sent, if set, is given the sent file's manifest entry, for a batch
+	// that sends one manifest and SHA256SUMS for all its files once
+	// they're sent
+	Sent func(ManifestEntry)
+
+	JournalPath   string // Where uploads are recorded; empty disables the journal
+	FileCachePath string // Where the Telegram IDs of uploaded files are kept; empty disables it
+
+	// ReplaceMessageID makes the upload replace the media of this message
+	// instead of sending a new one
+	ReplaceMessageID int
+
+	// SupersedeMessageID is deleted from the target chat once the new
+	// upload has been sent
+	SupersedeMessageID int
+
+	ShowQR   bool // Print the message link as a QR code after sending
+	NoPrompt bool // Never ask questions on the terminal
+
+	// LiveStatus keeps a message in Telegram up to date with the progress
+	// of the upload; nil disables it
+	LiveStatus *liveStatusConfig
+
+	AsDocument     bool           // Send images as plain documents, so they're kept byte for byte
+	Sticker        *stickerConfig // Send the file as a sticker; nil for the usual media
+	Variants       []videoVariant // Renditions of the video posted as an album instead of the file
+	ContactSheet   *contactSheet  // Grid of frames replying to an uploaded video; nil for none
+	Subtitles      []subtitleFile // Sent as documents replying to the uploaded video
+	RawPreview     bool           // Reply to camera RAW files with their embedded preview
+	CaptionAbove   bool           // Show the caption above the file instead of below it
+	NoPDFThumbnail bool           // Don't render the first page of PDFs as their thumbnail
+	Buttons        buttonFlags    // Inline URL keyboard attached to the message; bots only
+
+	AlsoSend []string // More chats to send the uploaded file to, without uploading it again
+
+	// CompanionPath is sent as a document named CompanionName after the
+	// file, like the HEIC original of a converted photo
+	CompanionPath string
+	CompanionName string
+
+	Pin       bool // Pin the sent message in the target chat
+	PinSilent bool // Pin without notifying the chat's members
+
+	Connections int   // Connections the parts of the upload are spread over; 0 or 1 for one
+	ReadAhead   int64 // Bytes of the source read ahead of the upload; 0 disables it
+
+	Rates *uploadRates // Upload speed caps, shared by the uploads of a run; nil for none
+
+	// LargeFiles asks before an unusually large Input is uploaded, for
+	// inputs only measured once they're open; nil not to ask
+	LargeFiles *largeFileGuard
+
+	Timeouts phaseTimeouts // Limits for the phases of an upload
+	RPC      rpcOptions    // Middlewares around the API calls
+
+	Pacer   *sendPacer       // Spaces out sends to a group in slow mode
+	Order   *sendSequence    // Keeps concurrent uploads sending in order; nil if they needn't
+	Turn    int              // This upload's turn in Order
+	Batch   *batchProgress   // Overall progress when uploading several files
+	Control *transferControl // Lets the interactive sync view watch and pause the upload
+
+	Events telegramuploader.EventHandler // Is told of the jobs' progress and flood waits, if set
+
+	UpdateHandler telegram.UpdateHandler // Receives updates while the client runs
+
+	// uploader is the library client withClient runs, which uploads the
+	// files
+	uploader *telegramuploader.Client
+
+	// OwnSignals keeps the client running through Ctrl-C and SIGTERM,
+	// for subcommands that stop on them by themselves and still need the
+	// client to finish
+	OwnSignals bool
+}
+
+// credentialFlags registers the Telegram credential flags of a subcommand
+func credentialFlags(fs *flag.FlagSet, config *Config) {
+	fs.IntVar(&config.AppID, "api-id", 0, "Telegram API ID")
+	fs.StringVar(&config.AppHash, "api-hash", "", "Telegram API Hash")
+	fs.StringVar(&config.Phone, "phone", "", "Phone number in international format")
+	fs.StringVar(&config.BotToken, "bot-token", os.Getenv(botTokenEnv), "Log in as the bot with this token instead of a phone number (default $"+botTokenEnv+")")
+	rpcFlags(fs, &config.RPC)
+}
+
+// validateCredentials checks that the Telegram credentials are set
+func validateCredentials(config *Config) error {
+	if config.AppID == 0 || config.AppHash == "" {
+		return withExitCode(exitUsage, fmt.Errorf("API ID and API Hash are required"))
+	}
+	if config.Phone == "" && config.BotToken == "" {
+		return withExitCode(exitUsage, fmt.Errorf("Phone number or bot token is required"))
+	}
+	return nil
+}
+
+// subcommand returns the function implementing the named subcommand, or
+// nil if there is none
+func subcommand(name string) func([]string) error {
+	switch name {
+	case "backup":
+		return runBackup
+	case "bench":
+		return runBench
+	case "bot":
+		return runBot
+	case "catalog":
+		return runCatalog
+	case "copy":
+		return runCopy
+	case "delete":
+		return runDelete
+	case "ingest":
+		return runIngest
+	case "ls":
+		return runLs
+	case "mirror":
+		return runMirror
+	case "mount":
+		return runMount
+	case "plugins":
+		return runPlugins
+	case "resend":
+		return runResend
+	case "search":
+		return runSearch
+	case "serve":
+		return runServe
+	case "service":
+		return runService
+	case "stats":
+		return runStats
+	case "sync":
+		return runSync
+	case "upload-story":
+		return runStory
+	}
+	return nil
 }
 
 func main() {
+	// stopProfiling, stopTracing and flushLogs are only set once the flags
+	// are parsed
+	defer func() {
+		stopProfiling()
+		stopTracing()
+		flushLogs()
+	}()
+
+	// Subcommands have their own flags
+	if len(os.Args) > 1 {
+		if cmd := subcommand(os.Args[1]); cmd != nil {
+			if err := cmd(os.Args[2:]); err != nil {
+				fatal(err)
+			}
+			return
+		}
+	}
+
 	// Parse command-line flags
 	appID := flag.Int("api-id", 0, "Telegram API ID")
 	appHash := flag.String("api-hash", "", "Telegram API Hash")
 	phone := flag.String("phone", "", "Phone number in international format")
-	filePath := flag.String("file", "", "Path to the file to upload")
-	fileURL := flag.String("url", "", "URL of the file to download and upload")
+	botToken := flag.String("bot-token", os.Getenv(botTokenEnv), "Log in as the bot with this token instead of a phone number (default $"+botTokenEnv+")")
+	filePath := flag.String("file", "", "Path to the file to upload, or - to upload standard input as it's read")
+	fileURL := flag.String("url", "", "URL of the file to download and upload: http, https, or s3://bucket/key for a public S3 object")
+	stream := flag.Bool("stream", false, "Upload the -url download as it arrives instead of saving it to a temporary file first")
+	uploadName := flag.String("name", "", "Name to upload the file under (default: the file's own name, or \"stdin\" for -file -)")
 	targetID := flag.String("target", "me", "Target username or chat ID (default: 'me' for Saved Messages)")
+	maxDimension := flag.Int("max-dimension", 0, "Downscale photos so that their longer side is at most this many pixels, like 2560, recompressing them as JPEG (0 to send them as they are)")
+	quality := flag.Int("quality", 85, "JPEG quality, 1 to 100, of photos recompressed for -max-dimension or -convert-heic")
+	convertHeic := flag.Bool("convert-heic", false, "Convert HEIC photos to JPEG so they show as photos rather than documents (needs sips, heif-convert or ImageMagick)")
+	keepHeic := flag.Bool("keep-heic", false, "Also send the original HEIC of a -convert-heic photo, as a document")
+	asSticker := flag.Bool("as-sticker", false, "Convert the image or video to a 512px WEBP or WEBM sticker with ffmpeg and send it as one")
+	stickerEmoji := flag.String("sticker-emoji", "🙂", "Emoji the -as-sticker sticker stands for")
+	stickerSet := flag.String("sticker-set", "", "Also add the -as-sticker sticker to the sticker set with this short name, creating the set if needed")
+	stickerSetTitle := flag.String("sticker-set-title", "", "Title of a -sticker-set that has to be created (default: its short name)")
+	rawPreviewFlag := flag.Bool("raw-preview", false, "Reply to camera RAW files, like .cr2, .nef and .arw, with the JPEG preview embedded in them as a photo")
+	withSubs := flag.String("with-subs", "", "Send a video's subtitles, from files like movie.en.srt next to it or embedded in it: embed to mux sidecar files into the video, reply to send them as documents replying to it")
+	contactSheetGrid := flag.String("contact-sheet", "", "Reply to an uploaded video with a grid of its frames, like 4x4, made with ffmpeg")
+	qualities := flag.String("qualities", "", "Transcode the video with ffmpeg into these qualities, like 480p,720p,1080p, and post them as an album")
+	transcode := flag.String("transcode", "", "Convert videos with ffmpeg for a profile before uploading: telegram, for H.264/AAC MP4 that plays inline")
+	stripExif := flag.Bool("strip-exif", false, "Remove EXIF data, like GPS positions, and other metadata from JPEG, PNG and HEIC images before uploading them")
+	encrypt := flag.Bool("encrypt", false, "Encrypt the file with a passphrase before uploading")
+	passphrasePrompt := flag.Bool("passphrase-prompt", false, "Prompt for the encryption passphrase (implies -encrypt; otherwise "+passphraseEnv+" is used)")
+	decrypt := flag.String("decrypt", "", "Decrypt a previously downloaded encrypted file and exit")
+	signManifest := flag.Bool("sign-manifest", false, "Upload an ed25519-signed manifest of the upload as a companion document")
+	postChecksums := flag.Bool("post-checksums", false, "Send a SHA256SUMS document listing the uploaded files after the upload")
+	verifyManifestPath := flag.String("verify-manifest", "", "Verify a signed manifest against local files and exit")
+	verifyDir := flag.String("verify-dir", ".", "Directory holding the files to check with -verify-manifest")
+	manifestKey := flag.String("manifest-key", defaultManifestKey, "Path of the manifest signing key")
+	journalPath := flag.String("journal", defaultJournalPath, "Record successful uploads in this file (empty to disable)")
+	fileCachePath := flag.String("file-cache", defaultFileCachePath, "Keep the Telegram IDs of uploaded files here for the resend command (empty to disable)")
+	var timeouts phaseTimeouts
+	timeoutFlags(flag.CommandLine, &timeouts)
+	var rpc rpcOptions
+	rpcFlags(flag.CommandLine, &rpc)
+	readAhead := flag.String("read-ahead", "0", "Read up to this much of the file ahead of the upload, like 64M, to smooth over a slow or bursty source such as a network share")
+	connections := flag.Int("connections", 1, fmt.Sprintf("Upload the file's parts over this many connections at once (at most %d)", maxConnections))
+	showQR := flag.Bool("qr", false, "Show the t.me link of the sent message as a QR code (channels and supergroups only)")
+	replaceMessage := flag.Int("replace-message", 0, "Replace the media of this message in the target chat instead of sending a new one")
+	supersede := flag.String("supersede", "", "Delete this message ID after the upload succeeds, or the journal's previous upload of the same file with \"auto\"")
+	captionAbove := flag.Bool("caption-above", false, "Show the caption above the file preview instead of below it")
+	noPDFThumb := flag.Bool("no-pdf-thumb", false, "Don't show the first page of PDFs as their thumbnail")
+	var buttons buttonFlags
+	flag.Var(&buttons, "button", "Attach an inline URL button given as \"Text|URL\" to the message (repeatable; bots only)")
+	alsoSend := flag.String("also-send", "", "Comma-separated list of more chats to send the file to once it's uploaded")
+	pin := flag.Bool("pin", false, "Pin the sent message in the target chat")
+	pinSilent := flag.Bool("pin-silent", false, "Pin the sent message without notifying anyone (implies -pin)")
+	liveStatus := flag.Bool("live-status", false, "Post the upload's progress as a message in the target chat and keep it updated")
+	notify := flag.Bool("notify-desktop", false, "Show a desktop notification when the upload finishes or fails")
+	notifyPlugins := flag.String("notify", "", "Comma-separated notifier plugins to run when the upload finishes or fails, like slack for fileuploader-notify-slack in the plugin directory")
+	applyProgressFlags := progressFlags(flag.CommandLine)
+	applyProfileFlags := profileFlags(flag.CommandLine)
+	applyTracingFlags := tracingFlags(flag.CommandLine)
+	applyRateFlags := uploadRateFlags(flag.CommandLine)
+	applyLargeFileFlags := largeFileFlags(flag.CommandLine)
+	var tagging tagOptions
+	tagFlags(flag.CommandLine, &tagging)
+	obfuscateNames := flag.Bool("obfuscate-names", false, "Upload under a random name (or an HMAC of the name if "+nameKeyEnv+" is set) and record the mapping in "+manifestPath)
 	flag.Parse()
+	if err := applyProgressFlags(); err != nil {
+		fatal(err)
+	}
+	if err := applyProfileFlags(); err != nil {
+		fatal(err)
+	}
+	if err := applyTracingFlags(); err != nil {
+		fatal(err)
+	}
+	rates, err := applyRateFlags()
+	if err != nil {
+		fatal(err)
+	}
+	largeFiles, err := applyLargeFileFlags()
+	if err != nil {
+		fatal(err)
+	}
+
+	// Decrypting is a local operation and needs no Telegram credentials
+	if *decrypt != "" {
+		passphrase, err := readPassphrase(*passphrasePrompt, false)
+		if err != nil {
+			fatal(err)
+		}
+		dst := strings.TrimSuffix(*decrypt, ".enc")
+		if dst == *decrypt {
+			dst += ".dec"
+		}
+		if err := decryptFile(*decrypt, dst, passphrase); err != nil {
+			fatal(fmt.Errorf("Failed to decrypt file: %w", err))
+		}
+		fmt.Printf("Decrypted to %s\n", dst)
+		return
+	}
+
+	// Verifying a manifest is local as well
+	if *verifyManifestPath != "" {
+		if err := verifyManifest(*verifyManifestPath, *manifestKey, *verifyDir); err != nil {
+			fatal(fmt.Errorf("Manifest verification failed: %w", err))
+		}
+		return
+	}
 
 	// Validate inputs
 	if *appID == 0 || *appHash == "" {
-		log.Fatal("API ID and API Hash are required")
+		fatal(withExitCode(exitUsage, errors.New("API ID and API Hash are required")))
 	}
 	if *filePath == "" && *fileURL == "" {
-		log.Fatal("Either file path or URL is required")
+		fatal(withExitCode(exitUsage, errors.New("Either file path or URL is required")))
+	}
+	if *phone == "" && *botToken == "" {
+		fatal(withExitCode(exitUsage, errors.New("Phone number or bot token is required")))
+	}
+	if *botToken != "" && strings.EqualFold(*targetID, "me") {
+		fatal(withExitCode(exitUsage, errors.New("bots have no Saved Messages; give the chat to send to with -target")))
+	}
+	if *connections < 1 || *connections > maxConnections {
+		fatal(withExitCode(exitUsage, fmt.Errorf("-connections must be between 1 and %d", maxConnections)))
+	}
+	readAheadSize, err := parseReadAhead(*readAhead)
+	if err != nil {
+		fatal(err)
+	}
+	if len(buttons) > 0 && *botToken == "" {
+		fatal(withExitCode(exitUsage, errors.New("-button needs -bot-token: only bots can attach buttons")))
+	}
+
+	if *maxDimension < 0 || *quality < 1 || *quality > 100 {
+		fatal(withExitCode(exitUsage, errors.New("-max-dimension must be positive and -quality between 1 and 100")))
 	}
-	if *phone == "" {
-		log.Fatal("Phone number is required")
+
+	if *transcode != "" && !slices.Contains(transcodeProfiles, *transcode) {
+		fatal(withExitCode(exitUsage, fmt.Errorf("unknown -transcode profile %q (known: %s)", *transcode, strings.Join(transcodeProfiles, ", "))))
+	}
+	if *withSubs != "" {
+		if !slices.Contains(subtitleModes, *withSubs) {
+			fatal(withExitCode(exitUsage, fmt.Errorf("invalid -with-subs value %q (known: %s)", *withSubs, strings.Join(subtitleModes, ", "))))
+		}
+		if *filePath == "-" || *stream || *qualities != "" {
+			fatal(withExitCode(exitUsage, errors.New("-with-subs can't be combined with -file -, -stream or -qualities")))
+		}
+	}
+	var sheet *contactSheet
+	if *contactSheetGrid != "" {
+		if sheet, err = parseContactSheet(*contactSheetGrid); err != nil {
+			fatal(withExitCode(exitUsage, fmt.Errorf("invalid -contact-sheet: %w", err)))
+		}
+	}
+	var qualityHeights []int
+	if *qualities != "" {
+		if qualityHeights, err = parseQualities(*qualities); err != nil {
+			fatal(withExitCode(exitUsage, fmt.Errorf("invalid -qualities: %w", err)))
+		}
+		if *filePath == "-" || *stream || *encrypt || *passphrasePrompt || *transcode != "" || *asSticker || *liveStatus || *replaceMessage != 0 {
+			fatal(withExitCode(exitUsage, errors.New("-qualities can't be combined with -file -, -stream, -encrypt, -transcode, -as-sticker, -live-status or -replace-message")))
+		}
+	}
+	if *transcode != "" && (*filePath == "-" || *stream) {
+		fatal(withExitCode(exitUsage, errors.New("-transcode needs a file; it can't be combined with -file - or -stream")))
+	}
+	if *asSticker && (*filePath == "-" || *stream || *encrypt || *passphrasePrompt) {
+		fatal(withExitCode(exitUsage, errors.New("-as-sticker needs an unencrypted file; it can't be combined with -file -, -stream or -encrypt")))
+	}
+	if (*stickerSet != "" || *stickerSetTitle != "") && !*asSticker {
+		fatal(withExitCode(exitUsage, errors.New("-sticker-set needs -as-sticker")))
+	}
+	if *keepHeic && !*convertHeic {
+		fatal(withExitCode(exitUsage, errors.New("-keep-heic needs -convert-heic")))
+	}
+	if *stripExif && (*filePath == "-" || *stream) {
+		fatal(withExitCode(exitUsage, errors.New("-strip-exif needs a file; it can't be combined with -file - or -stream")))
+	}
+	if *stream && *fileURL == "" {
+		fatal(withExitCode(exitUsage, errors.New("-stream needs -url")))
+	}
+	if (*filePath == "-" || *stream) && (*encrypt || *passphrasePrompt) {
+		fatal(withExitCode(exitUsage, errors.New("-encrypt needs a file; it can't be combined with -file - or -stream")))
 	}
 
-	// If URL is provided, download the file
+	// Every input is a source. Standard input and -stream downloads are
+	// uploaded as they're read, without a local copy; local files may be
+	// converted first, and are made a source once they're ready.
+	var input telegramuploader.Source
 	finalFilePath := *filePath
 	if *fileURL != "" {
-		fmt.Println("Downloading file from URL...")
-		tmpPath, err := downloadFileFromURL(*fileURL)
+		src, err := parseURL(*fileURL)
+		if err != nil {
+			fatal(err)
+		}
+		if *stream {
+			input = src
+		} else {
+			fmt.Println("Downloading file from URL...")
+			tmpPath, err := downloadSource(context.Background(), src, 0)
+			if err != nil {
+				fatal(fmt.Errorf("Failed to download file: %w", err))
+			}
+			finalFilePath = tmpPath
+			defer os.Remove(tmpPath) // Clean up temp file after upload
+		}
+	} else if *filePath == "-" {
+		input = telegramuploader.StdinSource("stdin")
+	}
+	streamed := input != nil
+	fileName := filepath.Base(finalFilePath)
+	if streamed {
+		fileName = input.Name()
+	}
+	if *uploadName != "" {
+		fileName = *uploadName
+	}
+
+	// Make sure an unusually large file is meant to go. Streams are only
+	// measured once they're open, so they're checked then.
+	if !streamed {
+		if info, err := os.Stat(finalFilePath); err == nil && largeFiles.needsConfirming(info.Size()) {
+			question := fmt.Sprintf("%s is %.2f MB. Upload it?", fileName, float64(info.Size())/(1024*1024))
+			if !largeFiles.confirm(question) {
+				fatal(withExitCode(exitUsage, fmt.Errorf("%s is larger than -confirm-over; pass -yes to upload it anyway", fileName)))
+			}
+		}
+	}
+
+	// Convert HEIC photos if requested; encrypted files are sent as
+	// documents
+	var originalPath, originalName string
+	if *convertHeic && !streamed && !*encrypt && !*passphrasePrompt {
+		heic, err := isHEIC(finalFilePath)
 		if err != nil {
-			log.Fatalf("Failed to download file: %v", err)
+			fatal(fmt.Errorf("Failed to read file: %w", err))
+		}
+		if heic {
+			converted, name, err := convertHEIC(finalFilePath, fileName, *quality)
+			if err != nil {
+				fmt.Printf("Sending the HEIC photo as it is: %v\n", err)
+			} else {
+				if *keepHeic {
+					originalPath, originalName = finalFilePath, fileName
+				}
+				finalFilePath, fileName = converted, name
+				defer os.Remove(converted)
+			}
+		}
+	}
+
+	// Shrink photos if requested; encrypted files are sent as documents
+	if *maxDimension > 0 && !streamed && !*encrypt && !*passphrasePrompt && telegramuploader.IsImage(strings.ToLower(filepath.Ext(fileName))) {
+		resized, name, err := resizePhoto(finalFilePath, fileName, *maxDimension, *quality)
+		switch {
+		case err != nil:
+			fmt.Printf("Sending the photo as it is: %v\n", err)
+		case resized != "":
+			finalFilePath, fileName = resized, name
+			defer os.Remove(resized)
+			// Nothing but the pixels was kept
+			*stripExif = false
 		}
-		finalFilePath = tmpPath
-		defer os.Remove(tmpPath) // Clean up temp file after upload
+	}
+
+	// Make videos play inline if requested; encrypted files are sent as
+	// documents
+	if *transcode != "" && !streamed && !*encrypt && !*passphrasePrompt && telegramuploader.IsVideo(strings.ToLower(filepath.Ext(fileName))) {
+		transcoded, name, err := transcodeVideo(finalFilePath, fileName)
+		if err != nil {
+			fatal(fmt.Errorf("Failed to transcode video: %w", err))
+		}
+		finalFilePath, fileName = transcoded, name
+		defer os.Remove(transcoded)
+	}
+
+	// Bring the subtitles along if requested; sidecar files are looked for
+	// next to the original file
+	var subtitles []subtitleFile
+	if *withSubs != "" && !streamed && !*encrypt && !*passphrasePrompt && telegramuploader.IsVideo(strings.ToLower(filepath.Ext(fileName))) {
+		var sidecars []subtitleFile
+		if *filePath != "" {
+			if sidecars, err = findSubtitles(*filePath); err != nil {
+				fatal(fmt.Errorf("Failed to look for subtitles: %w", err))
+			}
+		}
+		switch {
+		case *withSubs == "embed" && len(sidecars) > 0:
+			subbed, name, err := embedSubtitles(finalFilePath, fileName, sidecars)
+			if err != nil {
+				fatal(fmt.Errorf("Failed to embed subtitles: %w", err))
+			}
+			finalFilePath, fileName = subbed, name
+			defer os.Remove(subbed)
+		case *withSubs == "reply":
+			embedded, err := extractSubtitles(finalFilePath, fileName)
+			if err != nil {
+				fatal(fmt.Errorf("Failed to extract subtitles: %w", err))
+			}
+			defer removeSubtitles(embedded)
+			subtitles = append(sidecars, embedded...)
+		}
+		if len(sidecars) == 0 && len(subtitles) == 0 {
+			fmt.Println("No subtitles found for the video")
+		}
+	}
+
+	// Render the qualities of an album if requested
+	var variants []videoVariant
+	if qualityHeights != nil {
+		if !telegramuploader.IsVideo(strings.ToLower(filepath.Ext(fileName))) {
+			fatal(withExitCode(exitUsage, fmt.Errorf("-qualities needs a video, not %s", fileName)))
+		}
+		variants, err = transcodeVariants(finalFilePath, fileName, qualityHeights)
+		if err != nil {
+			fatal(fmt.Errorf("Failed to transcode video: %w", err))
+		}
+		defer removeVariants(variants)
+	}
+
+	// Remove metadata if requested, whether the image goes as a photo or
+	// a document
+	if *stripExif && !streamed {
+		stripped, err := stripPhotoMetadata(finalFilePath)
+		if err != nil {
+			fatal(fmt.Errorf("Failed to remove metadata: %w", err))
+		}
+		if stripped != "" {
+			finalFilePath = stripped
+			defer os.Remove(stripped)
+			fmt.Println("Removed the image's metadata")
+		}
+		if originalPath != "" {
+			stripped, err := stripPhotoMetadata(originalPath)
+			if err != nil {
+				fatal(fmt.Errorf("Failed to remove metadata of the original: %w", err))
+			}
+			if stripped != "" {
+				originalPath = stripped
+				defer os.Remove(stripped)
+			}
+		}
+	}
+
+	// Turn the file into a sticker if requested, after any conversions
+	var sticker *stickerConfig
+	if *asSticker {
+		sticker = &stickerConfig{Emoji: *stickerEmoji, Set: *stickerSet, SetTitle: *stickerSetTitle}
+		converted, name, err := prepareSticker(finalFilePath, fileName, sticker)
+		if err != nil {
+			fatal(fmt.Errorf("Failed to make a sticker: %w", err))
+		}
+		finalFilePath, fileName = converted, name
+		defer os.Remove(converted)
+	}
+
+	// Encrypt the file if requested
+	if *encrypt || *passphrasePrompt {
+		passphrase, err := readPassphrase(*passphrasePrompt, true)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Println("Encrypting file...")
+		encPath, err := encryptFile(finalFilePath, passphrase)
+		if err != nil {
+			fatal(fmt.Errorf("Failed to encrypt file: %w", err))
+		}
+		finalFilePath = encPath
+		fileName += ".enc"
+		defer os.Remove(encPath)
 	}
 
 	// Create config
@@ -71,32 +598,146 @@ func main() {
 		AppID:    *appID,
 		AppHash:  *appHash,
 		Phone:    *phone,
-		FilePath: finalFilePath,
+		BotToken: *botToken,
+		FileName: fileName,
+		Source:   *filePath,
 		TargetID: *targetID,
+		Input:    input,
+
+		SignManifest:  *signManifest,
+		PostChecksums: *postChecksums,
+		ManifestKey:   *manifestKey,
+
+		JournalPath:   *journalPath,
+		FileCachePath: *fileCachePath,
+		ShowQR:        *showQR,
+
+		ReplaceMessageID: *replaceMessage,
+		AlsoSend:         splitList(*alsoSend),
+		CaptionAbove:     *captionAbove,
+		NoPDFThumbnail:   *noPDFThumb,
+		Buttons:          buttons,
+
+		Pin:         *pin || *pinSilent,
+		PinSilent:   *pinSilent,
+		Connections: *connections,
+		ReadAhead:   readAheadSize,
+		Rates:       rates,
+		Timeouts:    timeouts,
+		RPC:         rpc,
+
+		Sticker:       sticker,
+		Variants:      variants,
+		ContactSheet:  sheet,
+		Subtitles:     subtitles,
+		RawPreview:    *rawPreviewFlag,
+		CompanionPath: originalPath,
+		CompanionName: originalName,
+	}
+	if config.Input == nil {
+		config.Input = telegramuploader.FileSource(finalFilePath)
+	}
+	if streamed {
+		config.LargeFiles = &largeFiles
+	}
+	if *filePath != "" && *filePath != "-" {
+		// The file's own directory, and rules for its extension
+		config.Hashtags = tagging.tags(filepath.Base(filepath.Dir(*filePath)) + "/" + fileName)
+	} else {
+		config.Hashtags = tagging.tags(fileName)
+	}
+	if *fileURL != "" {
+		config.Source = *fileURL
+	} else if *filePath == "-" {
+		config.Source = "stdin"
+	} else if abs, err := filepath.Abs(*filePath); err == nil {
+		config.Source = abs
+	}
+
+	// Hide the real file name from anyone reading the target chat
+	if *obfuscateNames {
+		name, err := obfuscateName(fileName)
+		if err != nil {
+			fatal(fmt.Errorf("Failed to obfuscate file name: %w", err))
+		}
+		config.OriginalName = fileName
+		config.FileName = name
+	}
+
+	if *liveStatus {
+		config.LiveStatus = &liveStatusConfig{}
+	}
+
+	// Find the message the upload takes the place of
+	switch *supersede {
+	case "":
+	case "auto":
+		if config.JournalPath == "" {
+			fatal(withExitCode(exitUsage, errors.New("-supersede auto needs the journal")))
+		}
+		entries, err := readJournal(config.JournalPath)
+		if err != nil {
+			fatal(fmt.Errorf("Failed to read journal: %w", err))
+		}
+		if e := latestUpload(entries, config.Source, config.TargetID); e != nil {
+			config.SupersedeMessageID = e.MessageID
+		} else {
+			fmt.Println("No earlier upload of this file in the journal; nothing to supersede")
+		}
+	default:
+		id, err := strconv.Atoi(*supersede)
+		if err != nil || id <= 0 {
+			fatal(withExitCode(exitUsage, fmt.Errorf("invalid -supersede value %q", *supersede)))
+		}
+		config.SupersedeMessageID = id
 	}
 
 	// Run the application
-	if err := run(config); err != nil {
-		log.Fatal(err)
+	err = run(config)
+	notifier := notifyOptions{Desktop: *notify, Plugins: splitList(*notifyPlugins)}
+	notifier.result(fileName, err)
+	if err != nil {
+		fatal(err)
 	}
 }
 
-// downloadFileFromURL downloads a file from the given URL and returns the local file path
-func downloadFileFromURL(url string) (string, error) {
-	resp, err := http.Get(url)
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// downloadFileLimited downloads a file from the given URL and returns the
+// local file path, giving up as soon as the file turns out to be larger
+// than maxSize bytes, unless maxSize is 0
+func downloadFileLimited(ctx context.Context, url string, maxSize int64) (string, error) {
+	src, err := parseURL(url)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
+	return downloadSource(ctx, src, maxSize)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("bad status: %s", resp.Status)
-	}
+// errDownloadTooLarge means a download is larger than it may be
+var errDownloadTooLarge = errors.New("the file is too large")
 
-	// Try to get filename from URL or Content-Disposition
-	filename := filepath.Base(resp.Request.URL.Path)
-	if filename == "" || filename == "/" {
-		filename = "downloaded_file"
+// downloadSource saves src to a temporary file and returns its path,
+// giving up as soon as it turns out to be larger than maxSize bytes, unless
+// maxSize is 0
+func downloadSource(ctx context.Context, src telegramuploader.Source, maxSize int64) (string, error) {
+	body, err := src.Open(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	filename, size := src.Name(), src.Size()
+	if maxSize > 0 && size > maxSize {
+		return "", fmt.Errorf("%w: %.2f MB, more than %.2f MB", errDownloadTooLarge, float64(size)/(1024*1024), float64(maxSize)/(1024*1024))
 	}
 
 	tmpFile, err := os.CreateTemp("", filename)
@@ -105,25 +746,23 @@ func downloadFileFromURL(url string) (string, error) {
 	}
 	defer tmpFile.Close()
 
-	// Create progress bar for download
+	// Show download progress
 	fmt.Printf("Downloading %s...\n", filename)
-	bar := progressbar.NewOptions64(
-		resp.ContentLength,
-		progressbar.OptionSetDescription("Downloading"),
-		progressbar.OptionShowBytes(true),
-		progressbar.OptionSetWidth(30),
-		progressbar.OptionThrottle(100*time.Millisecond),
-		progressbar.OptionShowCount(),
-		progressbar.OptionOnCompletion(func() { fmt.Println() }),
-		progressbar.OptionSetRenderBlankState(true),
-		progressbar.OptionSetElapsedTime(true),
-		progressbar.OptionSetPredictTime(true),
-		progressbar.OptionFullWidth(),
-	)
-
-	// Copy the body to the file with progress bar
-	_, err = io.Copy(io.MultiWriter(tmpFile, bar), resp.Body)
+	progress := newFileProgress("download", filename, size, nil)
+
+	// Copy the body to the file, stopping just past the limit when the
+	// size wasn't known or was wrong
+	var r io.Reader = body
+	if maxSize > 0 {
+		r = io.LimitReader(body, maxSize+1)
+	}
+	n, err := copyPooled(tmpFile, progress.reader(r))
+	progress.finish()
+	if err == nil && maxSize > 0 && n > maxSize {
+		err = fmt.Errorf("%w: more than %.2f MB", errDownloadTooLarge, float64(maxSize)/(1024*1024))
+	}
 	if err != nil {
+		os.Remove(tmpFile.Name())
 		return "", err
 	}
 
@@ -131,7 +770,27 @@ func downloadFileFromURL(url string) (string, error) {
 }
 
 func run(config *Config) error {
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	return withClient(config, func(ctx context.Context, client *telegram.Client) error {
+		if len(config.Variants) > 0 {
+			return uploadVariants(ctx, client, config)
+		}
+		// Upload the file
+		return uploadFile(ctx, client, config)
+	})
+}
+
+// baseContext is done when the program is asked to stop other than by a
+// signal, like by the Windows service manager
+var baseContext = context.Background()
+
+// withClient starts a Telegram client for config, authenticates if needed
+// and calls fn with the running client
+func withClient(config *Config, fn func(ctx context.Context, client *telegram.Client) error) error {
+	ctx, cancel := signal.NotifyContext(baseContext, os.Interrupt, syscall.SIGTERM)
+	if config.OwnSignals {
+		cancel()
+		ctx, cancel = context.WithCancel(baseContext)
+	}
 	defer cancel()
 
 	// Setup session storage
@@ -139,192 +798,580 @@ func run(config *Config) error {
 	if err := os.MkdirAll(sessionDir, 0700); err != nil {
 		return fmt.Errorf("failed to create session directory: %w", err)
 	}
-	sessionPath := filepath.Join(sessionDir, fmt.Sprintf("%s.session", strings.ReplaceAll(config.Phone, "+", "")))
-	sessStorage := &session.FileStorage{
-		Path: sessionPath,
-	}
 
-	// Initialize client
-	client := telegram.NewClient(config.AppID, config.AppHash, telegram.Options{
-		SessionStorage: sessStorage,
+	// The library logs in, and starts over if the saved session was
+	// revoked
+	config.uploader = telegramuploader.New(telegramuploader.Config{
+		AppID:          config.AppID,
+		AppHash:        config.AppHash,
+		SessionPath:    filepath.Join(sessionDir, sessionName(config)+".session"),
+		Middlewares:    config.RPC.middlewares(),
+		Events:         config.Events,
+		UpdateHandler:  config.UpdateHandler,
+		TracerProvider: tracerProvider,
+		BotToken:       config.BotToken,
+		UserAuth:       termAuth{phone: config.Phone},
+		LoginTimeout:   config.Timeouts.Auth,
+		Logf:           log.Printf,
 	})
+	return config.uploader.Run(ctx, fn)
+}
+
+func uploadFile(ctx context.Context, client *telegram.Client, config *Config) (err error) {
+	if config.LiveStatus != nil {
+		return uploadFileLive(ctx, client, config)
+	}
+	ctx, span := startSpan(ctx, "upload", attribute.String("file.name", config.FileName), attribute.String("target", config.TargetID))
+	defer func() { endSpan(span, err) }()
 
-	// Start the client and handle authentication
-	return client.Run(ctx, func(ctx context.Context) error {
-		// Check if we're logged in
-		status, err := client.Auth().Status(ctx)
+	u := &fileUpload{config: config, size: -1}
+	if local, ok := config.Input.(telegramuploader.LocalFile); ok {
+		info, err := os.Stat(local.Path())
 		if err != nil {
-			return fmt.Errorf("failed to get auth status: %w", err)
-		}
-
-		// Authenticate if needed
-		if !status.Authorized {
-			log.Println("Starting authentication flow...")
-			flow := auth.NewFlow(
-				termAuth{phone: config.Phone},
-				auth.SendCodeOptions{},
-			)
-			if err := client.Auth().IfNecessary(ctx, flow); err != nil {
-				return fmt.Errorf("authentication failed: %w", err)
-			}
+			return fmt.Errorf("failed to get file info: %w", err)
 		}
-		log.Println("Successfully authenticated!")
+		u.path, u.modTime = local.Path(), info.ModTime()
+	}
+	defer u.close()
 
-		// Upload the file
-		return uploadFile(ctx, client, config)
-	})
+	caption := fmt.Sprintf("Uploaded file: %s", config.FileName)
+	if config.DisplayPath != "" {
+		caption = fmt.Sprintf("Uploaded file: %s", config.DisplayPath)
+	}
+	caption = captionWithTags(caption, config.Hashtags)
+	if config.Sticker != nil {
+		// Stickers have no captions
+		caption = ""
+	}
+	u.caption = caption
+	opts := telegramuploader.Options{
+		Caption:      caption,
+		Name:         config.FileName,
+		Peer:         config.Peer,
+		AsDocument:   config.AsDocument,
+		CaptionAbove: config.CaptionAbove,
+		Connections:  config.Connections,
+		TopicID:      config.TopicID,
+		ReplyMarkup:  config.Buttons.replyMarkup(),
+		Hooks: telegramuploader.Hooks{
+			Phase:    u.phase,
+			Resolved: u.resolved,
+			Reader:   u.reader,
+			Media:    u.media,
+			Send:     u.send,
+			Sent:     u.sent,
+		},
+	}
+	if tracerProvider != nil {
+		opts.Hooks.Parts = func(rpc uploader.Client) uploader.Client { return tracedParts{rpc} }
+	}
+	_, err = config.uploader.Upload(ctx, client, config.Input, config.TargetID, opts)
+	return err
 }
 
-func uploadFile(ctx context.Context, client *telegram.Client, config *Config) error {
-	// Check if file exists
-	fileInfo, err := os.Stat(config.FilePath)
-	if err != nil {
-		return fmt.Errorf("failed to get file info: %w", err)
+// fileUpload is the state uploadFile's hooks share while the library
+// uploads config.Input
+type fileUpload struct {
+	config  *Config
+	caption string
+	path    string    // of the input, if it's a local file
+	modTime time.Time // of the local file
+
+	size      int64 // of the input; -1 if it's only known once it's read
+	streamed  bool  // the input's size was only known once it was read
+	progress  *fileProgress
+	readAhead *readAhead
+	started   time.Time
+
+	entry     JournalEntry
+	identity  string    // identifies the data for the random IDs of its messages
+	resumedAt time.Time // when the resumed job was first tried
+}
+
+// close stops reading ahead, if the upload did
+func (u *fileUpload) close() {
+	if u.readAhead != nil {
+		u.readAhead.Close()
 	}
+}
 
-	fileSize := fileInfo.Size()
+// phase limits each phase of the upload to its timeout, traces it, and
+// names it in errors if it was cut short
+func (u *fileUpload) phase(ctx context.Context, name string) (context.Context, func(error) error) {
+	timeout, label, attrs := u.config.Timeouts.Resolve, "resolving the target", []attribute.KeyValue(nil)
+	switch name {
+	case "upload":
+		timeout, label = u.config.Timeouts.Upload, "uploading"
+		name = "upload parts"
+		attrs = []attribute.KeyValue{attribute.Int64("file.size", u.size), attribute.Int("connections", u.config.Connections)}
+		u.started = time.Now()
+	case "send":
+		timeout, label = u.config.Timeouts.Send, "sending"
+	}
+	ctx, cancel := phaseContext(ctx, timeout)
+	ctx, span := startSpan(ctx, name, attrs...)
+	return ctx, func(err error) error {
+		span.End()
+		if name == "upload parts" && u.progress != nil {
+			u.progress.finish()
+			if err == nil {
+				fmt.Printf("\nUpload completed successfully in %s!\n", time.Since(u.started).Round(time.Second))
+			}
+		}
+		err = phaseError(ctx, label, err)
+		cancel()
+		return err
+	}
+}
 
-	// Log info
-	fmt.Printf("Preparing to upload file: %s (%.2f MB)\n", config.FilePath, float64(fileSize)/(1024*1024))
+// resolved checks the resolved target can take the file, and that an
+// unusually large stream is meant to go
+func (u *fileUpload) resolved(ctx context.Context, step *telegramuploader.Step) error {
+	config, size := u.config, step.Result.Size
+	u.size = size
+	if size < 0 {
+		u.streamed = true
+		fmt.Printf("Preparing to upload %s as it's read (size unknown)\n", config.FileName)
+	} else {
+		fmt.Printf("Preparing to upload file: %s (%.2f MB)\n", config.Input, float64(size)/(1024*1024))
+	}
+	if config.LargeFiles != nil && config.LargeFiles.needsConfirming(size) {
+		question := fmt.Sprintf("%s is %.2f MB. Upload it?", config.FileName, float64(size)/(1024*1024))
+		if !config.LargeFiles.confirm(question) {
+			return withExitCode(exitUsage, fmt.Errorf("%s is larger than -confirm-over; pass -yes to upload it anyway", config.FileName))
+		}
+	}
+	return checkCanSend(ctx, step.API, step.Peer, config.FileName, size)
+}
 
-	// Create Telegram API client
-	api := client.API()
+// reader reads slow sources ahead of the upload, keeps to the speed caps
+// and shows the progress of the upload, and of the batch it belongs to
+func (u *fileUpload) reader(ctx context.Context, step *telegramuploader.Step, src io.Reader) io.Reader {
+	if u.config.ReadAhead > 0 {
+		u.readAhead = newReadAhead(src, u.config.ReadAhead)
+		src = u.readAhead
+	}
+	src = u.config.Rates.reader(ctx, step.Target, src)
+	u.progress = newFileProgress("upload", u.config.FileName, step.Result.Size, u.config.Batch)
+	u.progress.control = u.config.Control
+	return u.progress.reader(src)
+}
 
-	// Create uploader with larger part size for big files
-	// Use 512KB parts for better performance with large files
-	u := uploader.NewUploader(api).WithPartSize(512 * 1024)
+// media sends stickers as stickers and PDFs with their first page as the
+// thumbnail, and waits for the uploads queued before this one to send
+func (u *fileUpload) media(ctx context.Context, step *telegramuploader.Step, media tg.InputMediaClass) (tg.InputMediaClass, error) {
+	config, name := u.config, step.Result.Name
+	if u.streamed {
+		fmt.Printf("Read %.2f MB from the stream\n", float64(step.Result.Size)/(1024*1024))
+	}
+	fmt.Printf("Sending to %s...\n", targetLabel(step.Peer, step.Target))
 
-	// Open the file
-	file, err := os.Open(config.FilePath)
-	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
-	}
-	defer file.Close()
-
-	// Create progress bar
-	bar := progressbar.NewOptions64(
-		fileSize,
-		progressbar.OptionSetDescription("Uploading"),
-		progressbar.OptionShowBytes(true),
-		progressbar.OptionSetWidth(30),
-		progressbar.OptionThrottle(100*time.Millisecond),
-		progressbar.OptionShowCount(),
-		progressbar.OptionOnCompletion(func() { fmt.Println() }),
-		progressbar.OptionSetRenderBlankState(true),
-		progressbar.OptionSetElapsedTime(true),
-		progressbar.OptionSetPredictTime(true),
-		progressbar.OptionFullWidth(),
-	)
-
-	// Create a wrapper around the file to track progress
-	reader := &progressReader{
-		Reader: file,
-		bar:    bar,
-	}
-
-	// Start time for calculating upload speed
-	startTime := time.Now()
-
-	// Create a ticker for updating the speed display
-	ticker := time.NewTicker(500 * time.Millisecond)
-	defer ticker.Stop()
-
-	// Start a goroutine to update the speed display
-	done := make(chan bool)
-
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				elapsed := time.Since(startTime).Seconds()
-				if elapsed > 0 {
-					// Using State() instead of Current()
-					state := bar.State()
-					speed := float64(state.CurrentBytes) / elapsed / (1024 * 1024) // MB/s
-					bar.Describe(fmt.Sprintf("Uploading (%.2f MB/s)", speed))
+	ext := strings.ToLower(filepath.Ext(name))
+	switch doc, isDoc := media.(*tg.InputMediaUploadedDocument); {
+	case config.Sticker != nil:
+		media = config.Sticker.media(step.File, name)
+		fmt.Println("Processing as sticker")
+	case telegramuploader.IsVideo(ext):
+		fmt.Println("Processing as video")
+	case !isDoc:
+		fmt.Println("Processing as photo")
+	default:
+		if ext == ".pdf" && u.path != "" && !config.NoPDFThumbnail {
+			// Show the first page in the chat; the PDF goes either way
+			thumb, err := pdfThumbnail(u.path)
+			if err == nil {
+				thumbFile, err := uploader.NewUploader(step.API).FromBytes(ctx, "thumb.jpg", thumb)
+				if err != nil {
+					fmt.Printf("Sending the PDF without a thumbnail: failed to upload it: %v\n", err)
+				} else {
+					doc.Thumb = thumbFile
 				}
-			case <-done:
-				return
+			} else if !errors.Is(err, errNoPDFRenderer) {
+				fmt.Printf("Sending the PDF without a thumbnail: %v\n", err)
 			}
 		}
-	}()
+		fmt.Println("Processing as document")
+	}
 
-	// Upload the file (using the correct method and parameters)
-	fileName := filepath.Base(config.FilePath)
-	upload, err := u.Upload(ctx, uploader.NewUpload(fileName, reader, fileSize))
+	// Let the uploads queued before this one send first
+	if config.ReplaceMessageID == 0 {
+		if err := config.Order.wait(ctx, config.Turn); err != nil {
+			return nil, err
+		}
+	}
+	return media, nil
+}
 
-	// Signal the speed update goroutine to stop
-	close(done)
+// send sends the message with the uploaded media, or swaps it into the
+// message being replaced. Its random ID is derived from the job, so
+// Telegram drops the send of an earlier attempt that got through before
+// the program stopped; slow mode is waited out, and a chat that refuses
+// the file can be swapped for Saved Messages.
+func (u *fileUpload) send(ctx context.Context, step *telegramuploader.Step, req *tg.MessagesSendMediaRequest, send telegramuploader.SendFunc) (*tg.Message, error) {
+	config, api := u.config, step.API
+	name, size := step.Result.Name, step.Result.Size
 
-	if err != nil {
-		return fmt.Errorf("upload failed: %w", err)
+	// The journal keeps the job until its message is confirmed; sending
+	// the file again later is a new job
+	u.entry = JournalEntry{
+		Source:       config.Source,
+		Name:         name,
+		OriginalName: config.OriginalName,
+		Size:         size,
+		ModTime:      u.modTime.UTC(),
+		SHA256:       step.Result.SHA256,
+		MimeType:     step.Result.MimeType,
+		Target:       step.Target,
+		Path:         config.RemotePath,
+	}
+	u.identity = step.Result.SHA256
+	var err error
+	if config.JournalPath != "" && config.ReplaceMessageID == 0 {
+		if u.entry.Job, u.resumedAt, err = pendingJob(config.JournalPath, u.entry); err != nil {
+			return nil, fmt.Errorf("failed to record upload in journal: %w", err)
+		}
+	} else if u.entry.Job, err = newJobID(); err != nil {
+		return nil, err
 	}
 
-	fmt.Printf("\nUpload completed successfully in %s!\n", time.Since(startTime).Round(time.Second))
-
-	// Get mime type based on file extension
-	mimeType := getMimeType(fileName)
+	if config.ReplaceMessageID != 0 {
+		fmt.Printf("Replacing media of message %d...\n", config.ReplaceMessageID)
+		edit := &tg.MessagesEditMessageRequest{
+			InvertMedia: req.InvertMedia,
+			Peer:        step.Peer,
+			ReplyMarkup: req.ReplyMarkup,
+			ID:          config.ReplaceMessageID,
+			Media:       req.Media,
+			Message:     req.Message,
+		}
+		updates, err := api.MessagesEditMessage(ctx, edit)
+		if _, isPhoto := edit.Media.(*tg.InputMediaUploadedPhoto); isPhoto && telegramuploader.PhotoRejected(err) {
+			fmt.Printf("Telegram rejected the photo (%v); sending it as a document instead\n", err)
+			edit.Media = telegramuploader.Media(step.File, name, step.Result.MimeType, size, true)
+			updates, err = api.MessagesEditMessage(ctx, edit)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to replace media: %w", err)
+		}
+		return telegramuploader.SentMessage(updates)
+	}
 
-	// Determine target user or chat
-	var target tg.InputPeerClass
-	// For simplicity, we'll use "Saved Messages" (self) as the target
-	target = &tg.InputPeerSelf{}
-	fmt.Println("Sending to Saved Messages...")
+	fmt.Println("Finalizing file in Telegram...")
+	if config.Pacer != nil {
+		if err := config.Pacer.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	req.RandomID = jobRandomID(step.Target, name, u.identity, u.entry.Job)
+	msg, err := send(ctx, req)
+	if tgerr.Is(err, "RANDOM_ID_DUPLICATE") {
+		// An earlier attempt of the job got through; use its message, or
+		// send afresh if it can't be found any more
+		found, findErr := findSentMessage(ctx, api, step.Peer, name, size, req.Message, u.resumedAt)
+		switch {
+		case findErr != nil:
+			err = findErr
+		case found != nil:
+			fmt.Printf("An earlier attempt already sent this file as message %d\n", found.ID)
+			msg, err = found, nil
+		default:
+			fmt.Println("An earlier attempt sent this file, but its message wasn't found; sending it again")
+			if req.RandomID, err = generateRandomID(); err == nil {
+				msg, err = send(ctx, req)
+			}
+		}
+	}
+	if wait, ok := slowModeWait(err); ok {
+		// Someone else's pacing: wait it out once
+		fmt.Printf("Slow mode: waiting %s before sending...\n", wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		msg, err = send(ctx, req)
+	}
+	if _, self := step.Peer.(*tg.InputPeerSelf); err != nil && !self && sendForbidden(err) {
+		// The file is already uploaded, so it can still go somewhere useful
+		fmt.Printf("Can't send to %s: %v\n", step.Target, friendlyError(err))
+		if !config.NoPrompt && config.BotToken == "" && confirmSavedFallback() {
+			step.Peer, step.Target = &tg.InputPeerSelf{}, "me"
+			req.Peer, req.ReplyTo = step.Peer, nil
+			msg, err = send(ctx, req)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if config.Pacer != nil {
+		config.Pacer.sent()
+	}
+	return msg, nil
+}
 
-	// Prepare media
-	var media tg.InputMediaClass
-	// Determine type of file and use appropriate media type
+// sent records the sent file and posts what goes with it: the manifest,
+// checksums, previews, subtitles and copies
+func (u *fileUpload) sent(ctx context.Context, step *telegramuploader.Step, msg *tg.Message) error {
+	config, api := u.config, step.API
+	target, targetID := step.Peer, step.Target
+	fileName, fileSize, fileHash := step.Result.Name, step.Result.Size, step.Result.SHA256
 	ext := strings.ToLower(filepath.Ext(fileName))
+
+	entry := u.entry
+	entry.Time = time.Now().UTC()
+	entry.Target = targetID
+	entry.MessageID = msg.ID
+	if config.JournalPath != "" {
+		if err := appendJournal(config.JournalPath, entry); err != nil {
+			return fmt.Errorf("failed to record upload in journal: %w", err)
+		}
+	}
+	if config.FileCachePath != "" {
+		c := cachedFile{
+			Time:      entry.Time,
+			SHA256:    fileHash,
+			Source:    config.Source,
+			Name:      fileName,
+			Size:      fileSize,
+			MimeType:  step.Result.MimeType,
+			Target:    targetID,
+			MessageID: msg.ID,
+		}
+		if c.setMedia(msg) {
+			if err := appendFileCache(config.FileCachePath, c); err != nil {
+				return fmt.Errorf("failed to record file ID: %w", err)
+			}
+		}
+	}
+	if config.OriginalName != "" {
+		if err := recordNameMapping(fileName, config.OriginalName, targetID); err != nil {
+			return fmt.Errorf("failed to record name mapping: %w", err)
+		}
+		fmt.Printf("Recorded %s -> %s in %s\n", fileName, config.OriginalName, manifestPath)
+	}
+	if config.Sticker != nil && config.Sticker.Set != "" {
+		if err := config.Sticker.addToSet(ctx, api, msg); err != nil {
+			return fmt.Errorf("failed to add the sticker to set %s: %w", config.Sticker.Set, err)
+		}
+	}
+	sentFile := ManifestEntry{Name: fileName, Size: fileSize, SHA256: fileHash, MessageID: msg.ID}
 	switch {
-	case isImageFile(ext):
-		media = &tg.InputMediaUploadedPhoto{
-			File: upload,
+	case config.Sent != nil:
+		config.Sent(sentFile)
+	case config.SignManifest:
+		manifest := Manifest{Created: time.Now().UTC(), Target: targetID, Files: []ManifestEntry{sentFile}}
+		if err := sendManifest(ctx, api, target, manifest, config.ManifestKey); err != nil {
+			return err
 		}
-		fmt.Println("Processing as photo")
-	case isVideoFile(ext):
-		media = &tg.InputMediaUploadedDocument{
-			File:     upload,
-			MimeType: mimeType,
-			Attributes: []tg.DocumentAttributeClass{
-				&tg.DocumentAttributeFilename{FileName: fileName},
-				&tg.DocumentAttributeVideo{
-					SupportsStreaming: true,
-				},
-			},
+	}
+	if config.PostChecksums && config.Sent == nil {
+		if err := sendChecksums(ctx, api, target, []ManifestEntry{sentFile}); err != nil {
+			return err
 		}
-		fmt.Println("Processing as video")
-	default:
-		// Upload as generic document
-		media = &tg.InputMediaUploadedDocument{
-			File:     upload,
-			MimeType: mimeType,
-			Attributes: []tg.DocumentAttributeClass{
-				&tg.DocumentAttributeFilename{FileName: fileName},
-			},
+	}
+	if config.ContactSheet != nil && u.path != "" && telegramuploader.IsVideo(ext) {
+		// The video is sent either way, so a failed preview is only reported
+		if err := config.ContactSheet.post(ctx, api, target, u.path, fileName, msg.ID); err != nil {
+			fmt.Printf("Failed to send the contact sheet: %v\n", err)
+		}
+	}
+	if config.RawPreview && u.path != "" && isRawFile(ext) {
+		// The RAW file is sent either way, so a missing preview is only
+		// reported
+		preview, err := rawPreview(u.path)
+		if err == nil {
+			name := strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ".jpg"
+			_, err = replyPhotoBytes(ctx, api, target, msg.ID, name, preview, "Preview of "+fileName)
+		}
+		if err != nil {
+			fmt.Printf("Failed to send the RAW preview: %v\n", err)
+		} else {
+			fmt.Println("RAW preview sent as a reply")
+		}
+	}
+	for _, sub := range config.Subtitles {
+		data, err := os.ReadFile(sub.Path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", sub.Name, err)
+		}
+		caption := "Subtitles"
+		if sub.Language != "" {
+			caption += " (" + sub.Language + ")"
+		}
+		if _, err := replyDocumentBytes(ctx, api, target, msg.ID, sub.Name, "text/plain", data, caption); err != nil {
+			return fmt.Errorf("failed to send %s: %w", sub.Name, err)
+		}
+		fmt.Printf("Subtitles sent as %s\n", sub.Name)
+	}
+	if config.CompanionPath != "" {
+		data, err := os.ReadFile(config.CompanionPath)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", config.CompanionName, err)
+		}
+		if _, err := sendDocumentBytes(ctx, api, target, config.CompanionName, telegramuploader.MimeType(config.CompanionName), data, "Original of "+fileName); err != nil {
+			return fmt.Errorf("failed to send %s: %w", config.CompanionName, err)
+		}
+		fmt.Printf("Original sent as %s\n", config.CompanionName)
+	}
+	if config.Pin {
+		// The file is sent either way, so a failed pin is only reported
+		_, err := api.MessagesUpdatePinnedMessage(ctx, &tg.MessagesUpdatePinnedMessageRequest{
+			Peer:   target,
+			ID:     msg.ID,
+			Silent: config.PinSilent,
+		})
+		if err != nil {
+			fmt.Printf("Failed to pin the message: %v\n", err)
+		} else {
+			fmt.Println("Message pinned")
+		}
+	}
+	var alsoFailed int
+	if len(config.AlsoSend) > 0 {
+		alsoFailed = sendCopies(ctx, api, config, msg, u.caption, u.identity, entry)
+	}
+	if id := config.SupersedeMessageID; id != 0 && id != msg.ID {
+		// Only the chat the old message is in
+		if targetID != config.TargetID {
+			fmt.Printf("Not deleting message %d: the file went to %s instead\n", id, targetLabel(target, targetID))
+		} else if err := supersedeMessage(ctx, api, target, config, id); err != nil {
+			fmt.Printf("Failed to delete superseded message %d: %v\n", id, err)
+		} else {
+			fmt.Printf("Deleted superseded message %d\n", id)
 		}
-		fmt.Println("Processing as document")
 	}
+	fmt.Printf("✅ File successfully sent to %s!\n", targetLabel(target, targetID))
+	fmt.Printf("Open your Telegram app and check %s to access the file.\n", targetLabel(target, targetID))
+	if config.ShowQR {
+		link, err := messageLink(ctx, api, target, msg.ID)
+		if err != nil {
+			fmt.Printf("No QR code: %v\n", err)
+		} else {
+			fmt.Println(link)
+			qrterminal.GenerateHalfBlock(link, qrterminal.L, os.Stdout)
+		}
+	}
+	if alsoFailed > 0 {
+		return withExitCode(exitPartialBatch, fmt.Errorf("failed to send to %d of %d additional chat(s)", alsoFailed, len(config.AlsoSend)))
+	}
+	return nil
+}
 
-	// Generate a random ID for the message
-	randomID, err := generateRandomID()
-	if err != nil {
-		return fmt.Errorf("failed to generate random ID: %w", err)
+// sendCopies sends the media of msg to the chats of config.AlsoSend,
+// reusing the uploaded file, and returns how many sends failed. Each copy is
+// journaled like entry, the journal entry of msg.
+func sendCopies(ctx context.Context, api *tg.Client, config *Config, msg *tg.Message, caption, identity string, entry JournalEntry) int {
+	media, ok := sentMedia(msg)
+	if !ok {
+		fmt.Println("The sent message has no reusable media; not sending it to the other chats")
+		return len(config.AlsoSend)
+	}
+	var failed int
+	for _, chat := range config.AlsoSend {
+		err := func() error {
+			resolveCtx, cancel := phaseContext(ctx, config.Timeouts.Resolve)
+			defer cancel()
+			p, err := telegramuploader.ResolvePeer(resolveCtx, api, chat)
+			if err != nil {
+				return phaseError(resolveCtx, "resolving the target", err)
+			}
+			sendCtx, cancel := phaseContext(ctx, config.Timeouts.Send)
+			defer cancel()
+			updates, err := telegramuploader.SendMedia(sendCtx, api, &tg.MessagesSendMediaRequest{
+				InvertMedia: config.CaptionAbove,
+				Peer:        p,
+				Media:       media,
+				Message:     caption,
+				ReplyMarkup: config.Buttons.replyMarkup(),
+				RandomID:    jobRandomID(chat, config.FileName, identity, entry.Job),
+			})
+			if tgerr.Is(err, "RANDOM_ID_DUPLICATE") {
+				fmt.Printf("Already sent to %s\n", chat)
+				return nil
+			}
+			if err != nil {
+				return phaseError(sendCtx, "sending", err)
+			}
+
+			copied, err := telegramuploader.SentMessage(updates)
+			if err != nil || config.JournalPath == "" {
+				return err
+			}
+			entry.Target = chat
+			entry.MessageID = copied.ID
+			if err := appendJournal(config.JournalPath, entry); err != nil {
+				return fmt.Errorf("failed to record copy in journal: %w", err)
+			}
+			return nil
+		}()
+		if err != nil {
+			fmt.Printf("Failed to send to %s: %v\n", chat, friendlyError(err))
+			failed++
+			continue
+		}
+		fmt.Printf("Also sent to %s\n", chat)
 	}
+	return failed
+}
 
-	// Send the message with the uploaded media
-	fmt.Println("Finalizing file in Telegram...")
-	_, err = api.MessagesSendMedia(ctx, &tg.MessagesSendMediaRequest{
-		Peer:     target,
-		Media:    media,
-		Message:  fmt.Sprintf("Uploaded file: %s", fileName),
-		RandomID: randomID, // Add the random ID here
+// sentMedia returns input media referring to the photo or document of msg,
+// so it can be sent again without uploading it
+func sentMedia(msg *tg.Message) (tg.InputMediaClass, bool) {
+	switch m := msg.Media.(type) {
+	case *tg.MessageMediaDocument:
+		if doc, ok := m.Document.AsNotEmpty(); ok {
+			return &tg.InputMediaDocument{ID: doc.AsInput()}, true
+		}
+	case *tg.MessageMediaPhoto:
+		if photo, ok := m.Photo.AsNotEmpty(); ok {
+			return &tg.InputMediaPhoto{ID: photo.AsInput()}, true
+		}
+	}
+	return nil, false
+}
+
+// supersedeMessage deletes message id of target and records the deletion
+// in the journal
+func supersedeMessage(ctx context.Context, api *tg.Client, target tg.InputPeerClass, config *Config, id int) error {
+	if err := deleteMessages(ctx, api, target, []int{id}); err != nil {
+		return err
+	}
+	if config.JournalPath == "" {
+		return nil
+	}
+	return appendJournal(config.JournalPath, JournalEntry{
+		Time:      time.Now().UTC(),
+		Source:    config.Source,
+		Target:    config.TargetID,
+		MessageID: id,
+		Deleted:   true,
 	})
-	if err != nil {
-		return fmt.Errorf("failed to send media: %w", err)
+}
+
+// documentMedia sends an uploaded file as a generic document
+func documentMedia(upload tg.InputFileClass, mimeType, fileName string) *tg.InputMediaUploadedDocument {
+	return &tg.InputMediaUploadedDocument{
+		File:     upload,
+		MimeType: mimeType,
+		Attributes: []tg.DocumentAttributeClass{
+			&tg.DocumentAttributeFilename{FileName: fileName},
+		},
 	}
-	fmt.Println("✅ File successfully sent to Saved Messages!")
-	fmt.Println("Open your Telegram app and check your Saved Messages to access the file.")
-	return nil
+}
+
+// targetLabel describes the resolved target for messages to the user
+func targetLabel(target tg.InputPeerClass, name string) string {
+	if _, ok := target.(*tg.InputPeerSelf); ok {
+		return "Saved Messages"
+	}
+	return name
+}
+
+// jobRandomID derives a message random ID from values identifying an
+// upload job, so that sending the same job twice gives the same ID
+func jobRandomID(parts ...string) int64 {
+	h := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return int64(binary.LittleEndian.Uint64(h[:8]))
 }
 
 // generateRandomID generates a random int64 to use as message ID
@@ -337,19 +1384,71 @@ func generateRandomID() (int64, error) {
 	return id, nil
 }
 
-// progressReader is an io.Reader that updates a progress bar as data is read
-type progressReader struct {
-	io.Reader
-	bar *progressbar.ProgressBar
+// sendDocumentBytes uploads data as a document named name, sends it to
+// target and returns the sent message
+func sendDocumentBytes(ctx context.Context, api *tg.Client, target tg.InputPeerClass, name, mimeType string, data []byte, caption string) (*tg.Message, error) {
+	return replyDocumentBytes(ctx, api, target, 0, name, mimeType, data, caption)
+}
+
+// replyDocumentBytes is sendDocumentBytes sending a reply to the message
+// replyTo, unless it's 0
+func replyDocumentBytes(ctx context.Context, api *tg.Client, target tg.InputPeerClass, replyTo int, name, mimeType string, data []byte, caption string) (*tg.Message, error) {
+	upload, err := uploader.NewUploader(api).FromBytes(ctx, name, data)
+	if err != nil {
+		return nil, fmt.Errorf("upload failed: %w", err)
+	}
+
+	randomID, err := generateRandomID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate random ID: %w", err)
+	}
+	req := &tg.MessagesSendMediaRequest{
+		Peer: target,
+		Media: &tg.InputMediaUploadedDocument{
+			File:     upload,
+			MimeType: mimeType,
+			Attributes: []tg.DocumentAttributeClass{
+				&tg.DocumentAttributeFilename{FileName: name},
+			},
+		},
+		Message:  caption,
+		RandomID: randomID,
+	}
+	if replyTo != 0 {
+		req.ReplyTo = &tg.InputReplyToMessage{ReplyToMsgID: replyTo}
+	}
+	updates, err := api.MessagesSendMedia(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return telegramuploader.SentMessage(updates)
 }
 
-// Read implements io.Reader
-func (pr *progressReader) Read(p []byte) (n int, err error) {
-	n, err = pr.Reader.Read(p)
-	if n > 0 {
-		pr.bar.Add(n)
+// replyPhotoBytes uploads the JPEG data as a photo and sends it to target,
+// replying to the message replyTo unless it's 0
+func replyPhotoBytes(ctx context.Context, api *tg.Client, target tg.InputPeerClass, replyTo int, name string, data []byte, caption string) (*tg.Message, error) {
+	upload, err := uploader.NewUploader(api).FromBytes(ctx, name, data)
+	if err != nil {
+		return nil, fmt.Errorf("upload failed: %w", err)
+	}
+	randomID, err := generateRandomID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate random ID: %w", err)
+	}
+	req := &tg.MessagesSendMediaRequest{
+		Peer:     target,
+		Media:    &tg.InputMediaUploadedPhoto{File: upload},
+		Message:  caption,
+		RandomID: randomID,
+	}
+	if replyTo != 0 {
+		req.ReplyTo = &tg.InputReplyToMessage{ReplyToMsgID: replyTo}
 	}
-	return
+	updates, err := api.MessagesSendMedia(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return telegramuploader.SentMessage(updates)
 }
 
 // termAuth implements auth.UserAuthenticator interface for terminal authentication
@@ -412,56 +1511,3 @@ func (a termAuth) SignUp(_ context.Context) (auth.UserInfo, error) {
 	info.LastName = strings.TrimSpace(lastName)
 	return info, nil
 }
-
-// Helper functions for file types
-func isImageFile(ext string) bool {
-	imageExts := []string{".jpg", ".jpeg", ".png", ".gif", ".webp", ".bmp"}
-	for _, imgExt := range imageExts {
-		if ext == imgExt {
-			return true
-		}
-	}
-	return false
-}
-
-func isVideoFile(ext string) bool {
-	videoExts := []string{".mp4", ".mov", ".avi", ".mkv", ".webm", ".flv", ".3gp"}
-	for _, vidExt := range videoExts {
-		if ext == vidExt {
-			return true
-		}
-	}
-	return false
-}
-
-func getMimeType(filename string) string {
-	ext := strings.ToLower(filepath.Ext(filename))
-	switch ext {
-	case ".jpg", ".jpeg":
-		return "image/jpeg"
-	case ".png":
-		return "image/png"
-	case ".gif":
-		return "image/gif"
-	case ".webp":
-		return "image/webp"
-	case ".mp4":
-		return "video/mp4"
-	case ".mov":
-		return "video/quicktime"
-	case ".avi":
-		return "video/x-msvideo"
-	case ".mkv":
-		return "video/x-matroska"
-	case ".mp3":
-		return "audio/mpeg"
-	case ".wav":
-		return "audio/wav"
-	case ".pdf":
-		return "application/pdf"
-	case ".zip":
-		return "application/zip"
-	default:
-		return "application/octet-stream"
-	}
-}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gotd/td/tg"
+)
+
+// defaultManifestKey is where the manifest signing key is kept
+const defaultManifestKey = "manifest.key"
+
+// Manifest describes a batch of uploaded files
+type Manifest struct {
+	Created time.Time       `json:"created"`
+	Target  string          `json:"target"`
+	Files   []ManifestEntry `json:"files"`
+}
+
+// ManifestEntry describes a single uploaded file
+type ManifestEntry struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	MessageID int    `json:"message_id"`
+}
+
+// signedManifest is the document uploaded next to the files. The signature
+// covers the exact bytes of Manifest so verification doesn't depend on
+// re-encoding.
+type signedManifest struct {
+	Manifest  json.RawMessage `json:"manifest"`
+	PublicKey string          `json:"public_key"`
+	Signature string          `json:"signature"`
+}
+
+// loadSigningKey reads the hex-encoded ed25519 seed at path
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := hex.DecodeString(string(data))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid signing key in %s", path)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// loadOrCreateSigningKey is like loadSigningKey but generates a new key on first use
+func loadOrCreateSigningKey(path string) (ed25519.PrivateKey, error) {
+	key, err := loadSigningKey(path)
+	if !errors.Is(err, os.ErrNotExist) {
+		return key, err
+	}
+
+	_, key, err = ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key.Seed())), 0600); err != nil {
+		return nil, err
+	}
+	fmt.Printf("Generated new manifest signing key in %s\n", path)
+	return key, nil
+}
+
+// signManifest encodes and signs m
+func signManifest(m Manifest, key ed25519.PrivateKey) ([]byte, error) {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	// Not indented: that would reformat the signed bytes
+	return json.Marshal(signedManifest{
+		Manifest:  body,
+		PublicKey: hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+		Signature: hex.EncodeToString(ed25519.Sign(key, body)),
+	})
+}
+
+// sendManifest signs m and uploads it as a companion document to target
+func sendManifest(ctx context.Context, api *tg.Client, target tg.InputPeerClass, m Manifest, keyPath string) error {
+	key, err := loadOrCreateSigningKey(keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load signing key: %w", err)
+	}
+	data, err := signManifest(m, key)
+	if err != nil {
+		return fmt.Errorf("failed to sign manifest: %w", err)
+	}
+
+	name := fmt.Sprintf("manifest-%s.json", m.Created.Format("20060102-150405"))
+	caption := fmt.Sprintf("Signed manifest for %d file(s)", len(m.Files))
+	if _, err := sendDocumentBytes(ctx, api, target, name, "application/json", data, caption); err != nil {
+		return fmt.Errorf("failed to send manifest: %w", err)
+	}
+	fmt.Printf("Signed manifest sent as %s\n", name)
+	return nil
+}
+
+// sendChecksums uploads a SHA256SUMS document listing files to target
+func sendChecksums(ctx context.Context, api *tg.Client, target tg.InputPeerClass, files []ManifestEntry) error {
+	var sums strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&sums, "%s  %s\n", f.SHA256, f.Name)
+	}
+	if _, err := sendDocumentBytes(ctx, api, target, "SHA256SUMS", "text/plain", []byte(sums.String()), "Checksums"); err != nil {
+		return fmt.Errorf("failed to send checksums: %w", err)
+	}
+	fmt.Println("Checksums sent as SHA256SUMS")
+	return nil
+}
+
+// verifyManifest checks the signature of the manifest at path against the
+// local signing key and compares the listed files found in dir
+func verifyManifest(path, keyPath, dir string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var signed signedManifest
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	key, err := loadSigningKey(keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load signing key: %w", err)
+	}
+	sig, err := hex.DecodeString(signed.Signature)
+	if err != nil || !ed25519.Verify(key.Public().(ed25519.PublicKey), signed.Manifest, sig) {
+		return errors.New("manifest signature is invalid or was made with a different key")
+	}
+	fmt.Println("✅ Manifest signature is valid")
+
+	var m Manifest
+	if err := json.Unmarshal(signed.Manifest, &m); err != nil {
+		return fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	failed := 0
+	for _, entry := range m.Files {
+		sum, size, err := hashFile(filepath.Join(dir, entry.Name))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			fmt.Printf("MISSING  %s\n", entry.Name)
+			failed++
+		case err != nil:
+			return err
+		case size != entry.Size || sum != entry.SHA256:
+			fmt.Printf("CHANGED  %s\n", entry.Name)
+			failed++
+		default:
+			fmt.Printf("OK       %s\n", entry.Name)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d file(s) failed verification", failed, len(m.Files))
+	}
+	return nil
+}
+
+// hashFile returns the hex SHA-256 and size of the file at path
+func hashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := copyPooled(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/updates"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+)
+
+// reuseRefused reports whether err means media can't be sent on by ID, so
+// it has to be downloaded and uploaded again
+func reuseRefused(err error) bool {
+	return tgerr.Is(err, "CHAT_FORWARDS_RESTRICTED", "MEDIA_EMPTY", "MEDIA_INVALID",
+		"FILE_REFERENCE_EXPIRED", "FILE_REFERENCE_INVALID")
+}
+
+// runMirror implements the "mirror" subcommand: watching a chat and
+// posting every new photo or file in it to another chat
+func runMirror(args []string) error {
+	config := &Config{}
+	flags := flag.NewFlagSet("mirror", flag.ExitOnError)
+	credentialFlags(flags, config)
+	from := flags.String("from", "", "Chat to watch for new media")
+	flags.StringVar(&config.TargetID, "to", "", "Chat to post the media to")
+	flags.StringVar(&config.JournalPath, "journal", defaultJournalPath, "Record re-uploaded files in this file (empty to disable)")
+	rateLimit := flags.Int("rate-limit", 20, "Most media posted to the destination per minute; more waits (0 for no limit)")
+	timeoutFlags(flags, &config.Timeouts)
+	applyProgressFlags := progressFlags(flags)
+	applySystemdFlags := systemdFlags(flags)
+	applyLogTargetFlags := logTargetFlags(flags)
+	applyTracingFlags := tracingFlags(flags)
+	applyProfileFlags := profileFlags(flags)
+	applyRateFlags := uploadRateFlags(flags)
+	flags.Parse(args)
+	if err := applyProgressFlags(); err != nil {
+		return err
+	}
+	applySystemdFlags()
+	if err := applyLogTargetFlags(); err != nil {
+		return err
+	}
+	if err := applyTracingFlags(); err != nil {
+		return err
+	}
+	if err := applyProfileFlags(); err != nil {
+		return err
+	}
+	rates, err := applyRateFlags()
+	if err != nil {
+		return err
+	}
+	config.Rates = rates
+
+	if *from == "" || config.TargetID == "" {
+		return withExitCode(exitUsage, errors.New("usage: mirror -from <chat> -to <chat>"))
+	}
+	if err := validateCredentials(config); err != nil {
+		return err
+	}
+
+	dispatcher := tg.NewUpdateDispatcher()
+	gaps := updates.New(updates.Config{Handler: dispatcher})
+	config.UpdateHandler = gaps
+
+	return withClient(config, func(ctx context.Context, client *telegram.Client) error {
+		api := client.API()
+		self, err := client.Self(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get own account: %w", err)
+		}
+		source, err := telegramuploader.ResolvePeer(ctx, api, *from)
+		if err != nil {
+			return err
+		}
+		sourceID := telegramuploader.PeerID(source)
+		if _, ok := source.(*tg.InputPeerSelf); ok {
+			sourceID = self.ID
+		}
+		dest, err := telegramuploader.ResolvePeer(ctx, api, config.TargetID)
+		if err != nil {
+			return err
+		}
+		destID := telegramuploader.PeerID(dest)
+		if _, ok := dest.(*tg.InputPeerSelf); ok {
+			destID = self.ID
+		}
+		if destID == sourceID {
+			return withExitCode(exitUsage, errors.New("-from and -to are the same chat"))
+		}
+		config.Peer = dest
+
+		pending := make(chan *tg.Message, 64)
+		onMessage := func(ctx context.Context, e tg.Entities, m tg.MessageClass) error {
+			msg, ok := m.(*tg.Message)
+			if !ok || msg.Media == nil || messagePeerID(msg.PeerID) != sourceID {
+				return nil
+			}
+			if _, _, _, ok := mediaFile(msg); !ok {
+				// Only photos and files; polls, locations and the like aren't mirrored
+				return nil
+			}
+			select {
+			case pending <- msg:
+			default:
+				log.Printf("Mirror queue full; skipping message %d", msg.ID)
+			}
+			return nil
+		}
+		dispatcher.OnNewMessage(func(ctx context.Context, e tg.Entities, u *tg.UpdateNewMessage) error {
+			return onMessage(ctx, e, u.Message)
+		})
+		dispatcher.OnNewChannelMessage(func(ctx context.Context, e tg.Entities, u *tg.UpdateNewChannelMessage) error {
+			return onMessage(ctx, e, u.Message)
+		})
+
+		limiter := newRateLimiter(*rateLimit)
+		go func() {
+			for {
+				select {
+				case msg := <-pending:
+					if err := limiter.wait(ctx, destID); err != nil {
+						return
+					}
+					if err := mirrorMessage(ctx, client, config, *from, msg); err != nil {
+						log.Printf("Failed to mirror message %d: %v", msg.ID, friendlyError(err))
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		return gaps.Run(ctx, api, self.ID, updates.AuthOptions{
+			IsBot: self.Bot,
+			OnStart: func(ctx context.Context) {
+				daemonReady(ctx, client)
+				fmt.Printf("Mirroring new media from %s to %s; press Ctrl-C to stop\n", *from, config.TargetID)
+			},
+		})
+	})
+}
+
+// mirrorMessage posts the media of msg to config.Peer, by file ID if
+// Telegram allows it and by downloading and uploading it otherwise
+func mirrorMessage(ctx context.Context, client *telegram.Client, config *Config, source string, msg *tg.Message) error {
+	api := client.API()
+	media, ok := sentMedia(msg)
+	if !ok {
+		return errors.New("the message has no photo or document")
+	}
+
+	sendCtx, cancel := phaseContext(ctx, config.Timeouts.Send)
+	defer cancel()
+	_, err := telegramuploader.SendMedia(sendCtx, api, &tg.MessagesSendMediaRequest{
+		Peer:    config.Peer,
+		Media:   media,
+		Message: msg.Message,
+		// The same source message always gets the same ID, so a repeated
+		// update doesn't post it twice
+		RandomID: jobRandomID("mirror", source, strconv.Itoa(msg.ID)),
+	})
+	switch {
+	case err == nil:
+		fmt.Printf("Mirrored message %d\n", msg.ID)
+		return nil
+	case tgerr.Is(err, "RANDOM_ID_DUPLICATE"):
+		return nil
+	case !reuseRefused(err):
+		return phaseError(sendCtx, "sending", err)
+	}
+	cancel()
+
+	// The file can't be sent on as is, e.g. from a chat with protected
+	// content; transfer it through a temporary directory instead
+	fmt.Printf("Can't reuse the media of message %d (%v); downloading it\n", msg.ID, err)
+	dir, err := os.MkdirTemp("", "mirror")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	path, err := saveMedia(ctx, api, msg, dir)
+	if err != nil {
+		return err
+	}
+
+	fileConfig := *config
+	fileConfig.Input = telegramuploader.FileSource(path)
+	fileConfig.FileName = filepath.Base(path)
+	fileConfig.Source = fmt.Sprintf("%s#%d", source, msg.ID)
+	fileConfig.NoPrompt = true
+	fileConfig.UpdateHandler = nil
+	return uploadFile(ctx, client, &fileConfig)
+}
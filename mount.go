@@ -0,0 +1,135 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// backupRoot is the root of the mounted repository: one directory per
+// snapshot, named after its short ID
+type backupRoot struct {
+	fs.Inode
+	fetcher *chunkFetcher
+}
+
+var _ = (fs.NodeOnAdder)((*backupRoot)(nil))
+
+func (r *backupRoot) OnAdd(ctx context.Context) {
+	for _, snap := range r.fetcher.index.Snapshots {
+		dir := r.NewPersistentInode(ctx, &fs.Inode{}, fs.StableAttr{Mode: syscall.S_IFDIR})
+		r.AddChild(snap.ShortID(), dir, true)
+
+		for _, node := range snap.Nodes {
+			if node.Path == "." {
+				continue
+			}
+			parent := mkdirAll(ctx, dir, path.Dir(node.Path))
+			name := path.Base(node.Path)
+
+			var child *fs.Inode
+			switch node.Type {
+			case "dir":
+				if parent.GetChild(name) != nil {
+					continue
+				}
+				child = parent.NewPersistentInode(ctx, &fs.Inode{}, fs.StableAttr{Mode: syscall.S_IFDIR})
+			case "symlink":
+				child = parent.NewPersistentInode(ctx, &fs.MemSymlink{Data: []byte(node.Target)}, fs.StableAttr{Mode: syscall.S_IFLNK})
+			case "file":
+				child = parent.NewPersistentInode(ctx, &backupFileNode{fetcher: r.fetcher, node: node}, fs.StableAttr{})
+			default:
+				continue
+			}
+			parent.AddChild(name, child, true)
+		}
+	}
+}
+
+// mkdirAll returns the directory inode for dir below root, creating missing ones
+func mkdirAll(ctx context.Context, root *fs.Inode, dir string) *fs.Inode {
+	p := root
+	for _, component := range strings.Split(dir, "/") {
+		if component == "" || component == "." {
+			continue
+		}
+		ch := p.GetChild(component)
+		if ch == nil {
+			ch = p.NewPersistentInode(ctx, &fs.Inode{}, fs.StableAttr{Mode: syscall.S_IFDIR})
+			p.AddChild(component, ch, true)
+		}
+		p = ch
+	}
+	return p
+}
+
+// backupFileNode is a file of a snapshot whose content is read from its chunks
+type backupFileNode struct {
+	fs.Inode
+	fetcher *chunkFetcher
+	node    snapshotNode
+}
+
+var (
+	_ = (fs.NodeGetattrer)((*backupFileNode)(nil))
+	_ = (fs.NodeOpener)((*backupFileNode)(nil))
+	_ = (fs.NodeReader)((*backupFileNode)(nil))
+)
+
+func (f *backupFileNode) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = uint32(f.node.Mode.Perm())
+	out.Size = uint64(f.node.Size)
+	mtime := f.node.ModTime
+	out.SetTimes(nil, &mtime, &mtime)
+	return 0
+}
+
+func (f *backupFileNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	return nil, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+func (f *backupFileNode) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	if off >= f.node.Size {
+		return fuse.ReadResultData(nil), 0
+	}
+	n, err := f.fetcher.readAt(ctx, f.node.Chunks, dest, off)
+	if err != nil {
+		log.Printf("Failed to read %s: %v", f.node.Path, err)
+		return nil, syscall.EIO
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+// mountBackups serves the snapshots of the repository read-only at mountpoint
+// until ctx is cancelled or the file system is unmounted
+func mountBackups(ctx context.Context, fetcher *chunkFetcher, mountpoint string) error {
+	server, err := fs.Mount(mountpoint, &backupRoot{fetcher: fetcher}, &fs.Options{
+		MountOptions: fuse.MountOptions{
+			FsName:  "telegram-backup",
+			Name:    "tgbackup",
+			Options: []string{"ro"},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mount: %w", err)
+	}
+	fmt.Printf("Mounted %d snapshot(s) on %s; press Ctrl-C to unmount\n", len(fetcher.index.Snapshots), mountpoint)
+
+	go func() {
+		<-ctx.Done()
+		server.Unmount()
+	}()
+	server.Wait()
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// mountBackups is only available where FUSE is
+func mountBackups(ctx context.Context, fetcher *chunkFetcher, mountpoint string) error {
+	return errors.New("backup mount is only supported on Linux and macOS")
+}
@@ -0,0 +1,787 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// mountDrainTimeout is how long unmounting waits for the queued uploads
+const mountDrainTimeout = 30 * time.Minute
+
+// spoolPathExt names the file next to a spool that holds its tree path, so
+// that a later mount finds the files left waiting for upload
+const spoolPathExt = ".path"
+
+// remoteMount is a remote path mounted as a file system. Its files are
+// the ones of the davFS tree below root. Files written to it are kept in
+// the spool until the single upload worker has sent them.
+type remoteMount struct {
+	ctx       context.Context
+	dav       *davFS
+	chat      string
+	root      string // tree path of the mounted directory
+	cacheDir  string // blocks read from Telegram, by file hash
+	cacheSize int64  // most bytes kept in cacheDir; 0 for no limit
+	spoolDir  string
+
+	mu      sync.Mutex
+	pending map[string]*pendingFile // by tree path
+	queue   chan *pendingFile
+}
+
+// pendingFile is a file written to the mount that hasn't been uploaded yet
+type pendingFile struct {
+	path      string // tree path, changed by renames
+	spool     string
+	writers   int  // handles open for writing
+	uploading bool // the worker is sending the spool as it is
+	deleted   bool // removed while uploading; the upload is deleted once sent
+}
+
+// mountRemote mounts the remote path r at mountpoint until stop is done or
+// the file system is unmounted, then finishes the uploads queued. ctx is
+// the client's, and has to outlive stop for the queue to be sent.
+func mountRemote(ctx, stop context.Context, dav *davFS, r remotePath, cacheDir string, cacheSize int64, mountpoint string) error {
+	// Uploads go on after stop, until they're done, time out or a second
+	// interrupt
+	uploadCtx, cancelUploads := context.WithCancel(ctx)
+	defer cancelUploads()
+	m := &remoteMount{
+		ctx:       uploadCtx,
+		dav:       dav,
+		chat:      normalizeTarget(r.Chat),
+		cacheDir:  filepath.Join(cacheDir, "blocks"),
+		cacheSize: cacheSize,
+		spoolDir:  filepath.Join(cacheDir, "spool"),
+		pending:   map[string]*pendingFile{},
+		queue:     make(chan *pendingFile, 1024),
+	}
+	m.root = path.Join("/uploads", m.chat, r.Path)
+	if err := os.MkdirAll(m.spoolDir, 0700); err != nil {
+		return err
+	}
+	leftover, err := m.recoverSpool()
+	if err != nil {
+		return fmt.Errorf("failed to read the spool: %w", err)
+	}
+	m.trimCache()
+	// The mounted directory exists even before anything is put into it
+	dir := "/uploads"
+	for _, name := range strings.Split(strings.TrimPrefix(m.root, "/uploads/"), "/") {
+		dir = path.Join(dir, name)
+		if err := dav.Mkdir(ctx, dir, 0755); err != nil && !errors.Is(err, os.ErrExist) {
+			return err
+		}
+	}
+
+	timeout := time.Second
+	server, err := fs.Mount(mountpoint, &remoteNode{m: m}, &fs.Options{
+		// Uploads made elsewhere show up after a second
+		EntryTimeout: &timeout,
+		AttrTimeout:  &timeout,
+		MountOptions: fuse.MountOptions{
+			FsName: r.String(),
+			Name:   "tgremote",
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mount: %w", err)
+	}
+	daemonReady(ctx, dav.client)
+	fmt.Printf("Mounted %s on %s; press Ctrl-C to unmount\n", r, mountpoint)
+
+	if len(leftover) > 0 {
+		fmt.Printf("Uploading %d file(s) left in the spool by an earlier mount\n", len(leftover))
+		for _, p := range leftover {
+			m.queue <- p
+		}
+	}
+
+	done := make(chan struct{})
+	go m.uploadQueued(done)
+	go func() {
+		select {
+		case <-stop.Done():
+		case <-ctx.Done():
+		}
+		server.Unmount()
+	}()
+	trimmed := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.trimCache()
+			case <-trimmed:
+				return
+			}
+		}
+	}()
+	server.Wait()
+	close(trimmed)
+
+	close(m.queue)
+	if len(m.queue) > 0 {
+		fmt.Printf("Finishing %d queued upload(s); interrupt again to stop\n", len(m.queue))
+	}
+	abort, cancelAbort := signal.NotifyContext(uploadCtx, os.Interrupt, syscall.SIGTERM)
+	defer cancelAbort()
+	drain := time.AfterFunc(mountDrainTimeout, cancelUploads)
+	defer drain.Stop()
+	go func() {
+		<-abort.Done()
+		cancelUploads()
+	}()
+	<-done
+	if n := len(m.pending); n > 0 {
+		return fmt.Errorf("%d file(s) weren't uploaded; they're left in %s for the next mount", n, m.spoolDir)
+	}
+	return nil
+}
+
+// recoverSpool returns the files an earlier mount of the same directory
+// left in the spool, now pending again
+func (m *remoteMount) recoverSpool() ([]*pendingFile, error) {
+	names, err := os.ReadDir(m.spoolDir)
+	if err != nil {
+		return nil, err
+	}
+	var recovered []*pendingFile
+	for _, e := range names {
+		spool := filepath.Join(m.spoolDir, e.Name())
+		if !strings.HasSuffix(spool, spoolPathExt) {
+			continue
+		}
+		data, err := os.ReadFile(spool)
+		if err != nil {
+			return nil, err
+		}
+		p := &pendingFile{path: string(data), spool: strings.TrimSuffix(spool, spoolPathExt)}
+		if _, err := os.Stat(p.spool); err != nil {
+			os.Remove(spool)
+			continue
+		}
+		// Other mounts sharing the cache send their own files
+		if !strings.HasPrefix(p.path, m.root+"/") || m.pending[p.path] != nil {
+			continue
+		}
+		m.pending[p.path] = p
+		recovered = append(recovered, p)
+	}
+	return recovered, nil
+}
+
+// track records the tree path of p next to its spool
+func (m *remoteMount) track(p *pendingFile) error {
+	return os.WriteFile(p.spool+spoolPathExt, []byte(p.path), 0600)
+}
+
+// discard removes the spool of p
+func (m *remoteMount) discard(p *pendingFile) error {
+	os.Remove(p.spool + spoolPathExt)
+	return os.Remove(p.spool)
+}
+
+// trimCache removes the blocks read longest ago until the cache is within
+// its size
+func (m *remoteMount) trimCache() {
+	if m.cacheSize <= 0 {
+		return
+	}
+	type block struct {
+		path string
+		size int64
+		used time.Time
+	}
+	var blocks []block
+	var total int64
+	filepath.WalkDir(m.cacheDir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			blocks = append(blocks, block{p, info.Size(), info.ModTime()})
+			total += info.Size()
+		}
+		return nil
+	})
+	if total <= m.cacheSize {
+		return
+	}
+	slices.SortFunc(blocks, func(a, b block) int { return a.used.Compare(b.used) })
+	for _, b := range blocks {
+		if total <= m.cacheSize {
+			break
+		}
+		if os.Remove(b.path) == nil {
+			total -= b.size
+			// Drop directories of files that have no blocks left
+			os.Remove(filepath.Dir(b.path))
+		}
+	}
+}
+
+// uploadQueued uploads the files released by their writers, one at a time
+func (m *remoteMount) uploadQueued(done chan struct{}) {
+	defer close(done)
+	for p := range m.queue {
+		if err := m.upload(p); err != nil {
+			log.Printf("Failed to upload %s: %v", p.path, friendlyError(err))
+		}
+	}
+}
+
+// upload sends the spool of p to Telegram, unless p has been replaced,
+// removed or opened for writing again since it was queued
+func (m *remoteMount) upload(p *pendingFile) error {
+	m.mu.Lock()
+	if m.pending[p.path] != p {
+		m.mu.Unlock()
+		return m.discard(p)
+	}
+	if p.writers > 0 {
+		// Queued again when they're done
+		m.mu.Unlock()
+		return nil
+	}
+	p.uploading = true
+	from := p.path
+	m.mu.Unlock()
+
+	err := m.send(from, p.spool)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p.uploading = false
+	if err != nil {
+		return err
+	}
+	if p.deleted {
+		// Removed while it was being sent
+		if err := m.dav.RemoveAll(m.ctx, from); err != nil {
+			return err
+		}
+		return m.discard(p)
+	}
+	if p.path != from {
+		// Renamed meanwhile
+		if err := m.dav.Rename(m.ctx, from, p.path); err != nil {
+			return err
+		}
+	}
+	if m.pending[p.path] == p {
+		delete(m.pending, p.path)
+	}
+	return m.discard(p)
+}
+
+// send uploads the file at spool to the tree path p, replacing what's there
+func (m *remoteMount) send(p, spool string) error {
+	f, err := os.Open(spool)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		if err := m.dav.RemoveAll(m.ctx, p); err != nil {
+			return err
+		}
+		m.dav.keepEmpty(p)
+		return nil
+	}
+	config, err := m.dav.configFor(m.ctx, m.chat)
+	if err != nil {
+		return err
+	}
+	entries, err := readJournal(config.JournalPath)
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	r, _ := uploadPath(p)
+	return putRemote(m.ctx, m.dav.client, config, entries, r, f, info.Size())
+}
+
+// stat returns the file info of the tree path p, written or uploaded
+func (m *remoteMount) stat(ctx context.Context, p string) (os.FileInfo, error) {
+	m.mu.Lock()
+	pending := m.pending[p]
+	m.mu.Unlock()
+	if pending != nil {
+		if info, err := os.Stat(pending.spool); err == nil {
+			return info, nil
+		}
+	}
+	return m.dav.Stat(ctx, p)
+}
+
+// openWrite opens the file at the tree path p for writing, in the spool.
+// Unless it's truncated, the spool starts with the current content.
+func (m *remoteMount) openWrite(ctx context.Context, p string, truncate bool) (*spoolHandle, error) {
+	m.mu.Lock()
+	pending := m.pending[p]
+	if pending != nil && !pending.uploading {
+		pending.writers++
+		m.mu.Unlock()
+		flag := os.O_RDWR
+		if truncate {
+			flag |= os.O_TRUNC
+		}
+		file, err := os.OpenFile(pending.spool, flag, 0600)
+		if err != nil {
+			m.release(pending)
+			return nil, err
+		}
+		return &spoolHandle{m: m, pending: pending, file: file}, nil
+	}
+	m.mu.Unlock()
+
+	// A spool of its own, so that an upload in progress isn't disturbed
+	file, err := os.CreateTemp(m.spoolDir, "*"+path.Ext(p))
+	if err != nil {
+		return nil, err
+	}
+	if !truncate {
+		if err := m.copyCurrent(ctx, p, pending, file); err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return nil, err
+		}
+	}
+	pending = &pendingFile{path: p, spool: file.Name(), writers: 1}
+	if err := m.track(pending); err != nil {
+		file.Close()
+		m.discard(pending)
+		return nil, err
+	}
+	m.mu.Lock()
+	m.pending[p] = pending
+	m.mu.Unlock()
+	return &spoolHandle{m: m, pending: pending, file: file}, nil
+}
+
+// copyCurrent writes the current content of the tree path p to w: the
+// spool of pending if set, or else the uploaded file, if any
+func (m *remoteMount) copyCurrent(ctx context.Context, p string, pending *pendingFile, w io.Writer) error {
+	if pending != nil {
+		src, err := os.Open(pending.spool)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = copyPooled(w, src)
+		return err
+	}
+	info, err := m.dav.Stat(ctx, p)
+	if err != nil {
+		return nil
+	}
+	node, ok := info.(*davNode)
+	if !ok || node.upload == nil {
+		return nil
+	}
+	src, err := m.openUpload(node.upload)
+	if err != nil {
+		return err
+	}
+	_, err = copyPooled(w, src)
+	return err
+}
+
+// openUpload opens the uploaded file of e for reading through the cache
+func (m *remoteMount) openUpload(e *JournalEntry) (*remoteFile, error) {
+	config, err := m.dav.configFor(m.ctx, e.Target)
+	if err != nil {
+		return nil, err
+	}
+	f, err := openRemote(m.ctx, m.dav.client.API(), config.Peer, *e)
+	if err != nil {
+		return nil, err
+	}
+	if e.SHA256 != "" {
+		f.cacheDir = filepath.Join(m.cacheDir, e.SHA256)
+	}
+	return f, nil
+}
+
+// release ends a writer of pending, queueing the upload after the last one
+func (m *remoteMount) release(pending *pendingFile) {
+	m.mu.Lock()
+	pending.writers--
+	last := pending.writers == 0
+	m.mu.Unlock()
+	if last {
+		m.queue <- pending
+	}
+}
+
+// errno returns the errno for err, logging errors that aren't about files
+func errno(err error) syscall.Errno {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, os.ErrNotExist):
+		return syscall.ENOENT
+	case errors.Is(err, os.ErrExist):
+		return syscall.EEXIST
+	case errors.Is(err, os.ErrPermission):
+		return syscall.EPERM
+	}
+	log.Printf("Mount: %v", friendlyError(err))
+	return syscall.EIO
+}
+
+// setAttr fills out with the attributes of info
+func setAttr(info os.FileInfo, out *fuse.Attr) {
+	if info.IsDir() {
+		out.Mode = syscall.S_IFDIR | 0755
+	} else {
+		out.Mode = syscall.S_IFREG | 0644
+	}
+	out.Size = uint64(info.Size())
+	out.Blocks = (out.Size + 511) / 512
+	mtime := info.ModTime()
+	out.SetTimes(nil, &mtime, &mtime)
+}
+
+// remoteNode is a file or directory of the mount. Its path is where it is
+// in the inode tree, so renames carry over.
+type remoteNode struct {
+	fs.Inode
+	m *remoteMount
+}
+
+var (
+	_ = (fs.NodeLookuper)((*remoteNode)(nil))
+	_ = (fs.NodeReaddirer)((*remoteNode)(nil))
+	_ = (fs.NodeGetattrer)((*remoteNode)(nil))
+	_ = (fs.NodeSetattrer)((*remoteNode)(nil))
+	_ = (fs.NodeOpener)((*remoteNode)(nil))
+	_ = (fs.NodeCreater)((*remoteNode)(nil))
+	_ = (fs.NodeMkdirer)((*remoteNode)(nil))
+	_ = (fs.NodeUnlinker)((*remoteNode)(nil))
+	_ = (fs.NodeRmdirer)((*remoteNode)(nil))
+	_ = (fs.NodeRenamer)((*remoteNode)(nil))
+)
+
+// treePath returns the path of n in the davFS tree
+func (n *remoteNode) treePath() string {
+	return path.Join(n.m.root, n.Path(n.Root()))
+}
+
+// newChild returns a new inode for the child of n described by info
+func (n *remoteNode) newChild(ctx context.Context, info os.FileInfo) *fs.Inode {
+	mode := uint32(syscall.S_IFREG)
+	if info.IsDir() {
+		mode = syscall.S_IFDIR
+	}
+	return n.NewInode(ctx, &remoteNode{m: n.m}, fs.StableAttr{Mode: mode})
+}
+
+func (n *remoteNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	info, err := n.m.stat(ctx, path.Join(n.treePath(), name))
+	if err != nil {
+		return nil, errno(err)
+	}
+	setAttr(info, &out.Attr)
+	return n.newChild(ctx, info), 0
+}
+
+func (n *remoteNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	p := n.treePath()
+	f, err := n.m.dav.OpenFile(ctx, p, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, errno(err)
+	}
+	defer f.Close()
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		return nil, errno(err)
+	}
+	seen := map[string]bool{}
+	var entries []fuse.DirEntry
+	for _, info := range infos {
+		mode := uint32(syscall.S_IFREG)
+		if info.IsDir() {
+			mode = syscall.S_IFDIR
+		}
+		entries = append(entries, fuse.DirEntry{Name: info.Name(), Mode: mode})
+		seen[info.Name()] = true
+	}
+	// Files written here that aren't uploaded yet
+	n.m.mu.Lock()
+	for pendingPath := range n.m.pending {
+		if name := path.Base(pendingPath); path.Dir(pendingPath) == p && !seen[name] {
+			entries = append(entries, fuse.DirEntry{Name: name, Mode: syscall.S_IFREG})
+		}
+	}
+	n.m.mu.Unlock()
+	return fs.NewListDirStream(entries), 0
+}
+
+func (n *remoteNode) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	if h, ok := fh.(*spoolHandle); ok {
+		return h.Getattr(ctx, out)
+	}
+	info, err := n.m.stat(ctx, n.treePath())
+	if err != nil {
+		return errno(err)
+	}
+	setAttr(info, &out.Attr)
+	return 0
+}
+
+// Setattr supports truncating files; other changes, like of the times,
+// are ignored
+func (n *remoteNode) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	if size, ok := in.GetSize(); ok {
+		h, ok := fh.(*spoolHandle)
+		if !ok {
+			if size != 0 {
+				return syscall.ENOTSUP
+			}
+			var err error
+			if h, err = n.m.openWrite(ctx, n.treePath(), true); err != nil {
+				return errno(err)
+			}
+			defer h.Release(ctx)
+		}
+		if err := h.file.Truncate(int64(size)); err != nil {
+			return errno(err)
+		}
+	}
+	return n.Getattr(ctx, fh, out)
+}
+
+func (n *remoteNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	p := n.treePath()
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC|syscall.O_APPEND) != 0 {
+		h, err := n.m.openWrite(ctx, p, flags&syscall.O_TRUNC != 0)
+		if err != nil {
+			return nil, 0, errno(err)
+		}
+		return h, 0, 0
+	}
+
+	n.m.mu.Lock()
+	pending := n.m.pending[p]
+	n.m.mu.Unlock()
+	if pending != nil {
+		file, err := os.Open(pending.spool)
+		if err != nil {
+			return nil, 0, errno(err)
+		}
+		return &spoolHandle{m: n.m, file: file}, 0, 0
+	}
+	info, err := n.m.dav.Stat(ctx, p)
+	if err != nil {
+		return nil, 0, errno(err)
+	}
+	node, ok := info.(*davNode)
+	if !ok || node.upload == nil {
+		// An empty file
+		return &remoteHandle{}, 0, 0
+	}
+	f, err := n.m.openUpload(node.upload)
+	if err != nil {
+		return nil, 0, errno(err)
+	}
+	return &remoteHandle{f: f}, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+func (n *remoteNode) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, uint32, syscall.Errno) {
+	h, err := n.m.openWrite(ctx, path.Join(n.treePath(), name), true)
+	if err != nil {
+		return nil, nil, 0, errno(err)
+	}
+	info, err := h.file.Stat()
+	if err != nil {
+		h.Release(ctx)
+		return nil, nil, 0, errno(err)
+	}
+	setAttr(info, &out.Attr)
+	return n.newChild(ctx, info), h, 0, 0
+}
+
+func (n *remoteNode) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	p := path.Join(n.treePath(), name)
+	if err := n.m.dav.Mkdir(ctx, p, 0755); err != nil {
+		return nil, errno(err)
+	}
+	info, err := n.m.dav.Stat(ctx, p)
+	if err != nil {
+		return nil, errno(err)
+	}
+	setAttr(info, &out.Attr)
+	return n.newChild(ctx, info), 0
+}
+
+func (n *remoteNode) Unlink(ctx context.Context, name string) syscall.Errno {
+	p := path.Join(n.treePath(), name)
+	if _, err := n.m.stat(ctx, p); err != nil {
+		return errno(err)
+	}
+	n.m.mu.Lock()
+	if pending := n.m.pending[p]; pending != nil {
+		delete(n.m.pending, p)
+		// The worker deletes the upload once it's sent, or drops the
+		// spool if it hasn't started on it
+		pending.deleted = pending.uploading
+	}
+	n.m.mu.Unlock()
+	return errno(n.m.dav.RemoveAll(ctx, p))
+}
+
+func (n *remoteNode) Rmdir(ctx context.Context, name string) syscall.Errno {
+	p := path.Join(n.treePath(), name)
+	f, err := n.m.dav.OpenFile(ctx, p, os.O_RDONLY, 0)
+	if err != nil {
+		return errno(err)
+	}
+	infos, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return syscall.ENOTDIR
+	}
+	n.m.mu.Lock()
+	for pendingPath := range n.m.pending {
+		if strings.HasPrefix(pendingPath, p+"/") {
+			infos = append(infos, nil)
+		}
+	}
+	n.m.mu.Unlock()
+	if len(infos) > 0 {
+		return syscall.ENOTEMPTY
+	}
+	return errno(n.m.dav.RemoveAll(ctx, p))
+}
+
+// Rename moves the uploaded files in the journal and the pending ones in
+// the spool; a file at the destination is replaced
+func (n *remoteNode) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	parent, ok := newParent.(*remoteNode)
+	if !ok {
+		return syscall.EXDEV
+	}
+	from, to := path.Join(n.treePath(), name), path.Join(parent.treePath(), newName)
+	if info, err := n.m.stat(ctx, to); err == nil && !info.IsDir() {
+		if e := parent.Unlink(ctx, newName); e != 0 {
+			return e
+		}
+	}
+	if err := n.m.dav.Rename(ctx, from, to); err != nil {
+		return errno(err)
+	}
+	n.m.mu.Lock()
+	defer n.m.mu.Unlock()
+	for p, pending := range n.m.pending {
+		if p == from || strings.HasPrefix(p, from+"/") {
+			delete(n.m.pending, p)
+			pending.path = to + strings.TrimPrefix(p, from)
+			n.m.pending[pending.path] = pending
+			if err := n.m.track(pending); err != nil {
+				log.Printf("Mount: failed to record the rename of %s: %v", p, err)
+			}
+		}
+	}
+	return 0
+}
+
+// remoteHandle reads an uploaded file; a nil f is an empty file
+type remoteHandle struct {
+	mu sync.Mutex
+	f  *remoteFile
+}
+
+var _ = (fs.FileReader)((*remoteHandle)(nil))
+
+func (h *remoteHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	if h.f == nil {
+		return fuse.ReadResultData(nil), 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.f.off = off
+	n, err := io.ReadFull(h.f, dest)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		log.Printf("Mount: failed to read: %v", friendlyError(err))
+		return nil, syscall.EIO
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+// spoolHandle is a file of the spool: one being written, whose upload is
+// queued when it's released, or one read before it's uploaded if pending
+// is nil
+type spoolHandle struct {
+	m       *remoteMount
+	pending *pendingFile
+	file    *os.File
+}
+
+var (
+	_ = (fs.FileReader)((*spoolHandle)(nil))
+	_ = (fs.FileWriter)((*spoolHandle)(nil))
+	_ = (fs.FileGetattrer)((*spoolHandle)(nil))
+	_ = (fs.FileFsyncer)((*spoolHandle)(nil))
+	_ = (fs.FileReleaser)((*spoolHandle)(nil))
+)
+
+func (h *spoolHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	n, err := h.file.ReadAt(dest, off)
+	if err != nil && err != io.EOF {
+		return nil, errno(err)
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+func (h *spoolHandle) Write(ctx context.Context, data []byte, off int64) (uint32, syscall.Errno) {
+	if h.pending == nil {
+		return 0, syscall.EBADF
+	}
+	n, err := h.file.WriteAt(data, off)
+	return uint32(n), errno(err)
+}
+
+func (h *spoolHandle) Getattr(ctx context.Context, out *fuse.AttrOut) syscall.Errno {
+	info, err := h.file.Stat()
+	if err != nil {
+		return errno(err)
+	}
+	setAttr(info, &out.Attr)
+	return 0
+}
+
+func (h *spoolHandle) Fsync(ctx context.Context, flags uint32) syscall.Errno {
+	return errno(h.file.Sync())
+}
+
+func (h *spoolHandle) Release(ctx context.Context) syscall.Errno {
+	err := h.file.Close()
+	if h.pending != nil {
+		h.m.release(h.pending)
+	}
+	return errno(err)
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// mountRemote is only available where FUSE is
+func mountRemote(ctx, stop context.Context, dav *davFS, r remotePath, cacheDir string, cacheSize int64, mountpoint string) error {
+	return errors.New("mount is only supported on Linux and macOS")
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// multiBar draws the transfers of a batch that run at the same time, a bar
+// each with a bar for the batch's totals under them, and redraws them in
+// place. Lines printed meanwhile go above the bars instead of being drawn
+// over.
+type multiBar struct {
+	batch *batchProgress
+
+	mu     sync.Mutex
+	active []*fileProgress
+	lines  int // lines drawn last time, to go back over
+
+	stdout  *os.File // the terminal, while os.Stdout is captured
+	pipe    *os.File // what os.Stdout is meanwhile
+	copied  chan struct{}
+	logOut  io.Writer // where the log went before it was captured
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// showBars starts drawing the transfers of batch together. stop ends it,
+// leaving the totals bar.
+func showBars(batch *batchProgress) *multiBar {
+	m := &multiBar{batch: batch, done: make(chan struct{}), stopped: make(chan struct{})}
+	batch.bars = m
+
+	// Catch what the uploads print on the terminal, to print it above
+	// the bars
+	if term.IsTerminal(int(os.Stdout.Fd())) {
+		if r, w, err := os.Pipe(); err == nil {
+			m.stdout, m.pipe, m.copied = os.Stdout, w, make(chan struct{})
+			os.Stdout = w
+			go m.copyLines(r)
+		}
+	}
+	if log.Writer() == os.Stderr {
+		m.logOut = os.Stderr
+		log.SetOutput(multiBarLog{m})
+	}
+
+	go func() {
+		defer close(m.stopped)
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.mu.Lock()
+				fmt.Fprint(progressOut, m.erase()+m.render())
+				m.mu.Unlock()
+			case <-m.done:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// stop stops redrawing and gives stdout and the log back
+func (m *multiBar) stop() {
+	close(m.done)
+	<-m.stopped
+	if m.pipe != nil {
+		os.Stdout = m.stdout
+		m.pipe.Close()
+		<-m.copied
+	}
+	if m.logOut != nil {
+		log.SetOutput(m.logOut)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprint(progressOut, m.erase()+m.render())
+	m.lines = 0
+	m.batch.bars = nil
+}
+
+// add starts drawing p
+func (m *multiBar) add(p *fileProgress) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.active = append(m.active, p)
+}
+
+// remove stops drawing p, leaving its last state above the bars
+func (m *multiBar) remove(p *fileProgress) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.active = slices.DeleteFunc(m.active, func(a *fileProgress) bool { return a == p })
+	fmt.Fprint(progressOut, m.erase()+m.fileLine(p, progressBarWidth(80))+"\n"+m.render())
+}
+
+// print writes s to w above the bars
+func (m *multiBar) print(w io.Writer, s string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprint(progressOut, m.erase())
+	io.WriteString(w, s)
+	fmt.Fprint(progressOut, m.render())
+}
+
+// copyLines prints the lines written to the captured stdout
+func (m *multiBar) copyLines(r *os.File) {
+	defer close(m.copied)
+	defer r.Close()
+	lines := bufio.NewReader(r)
+	for {
+		line, err := lines.ReadString('\n')
+		if line != "" {
+			m.print(m.stdout, line)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// erase returns the terminal codes going back over the bars last drawn
+func (m *multiBar) erase() string {
+	if m.lines == 0 {
+		return ""
+	}
+	return fmt.Sprintf("\r\033[%dA\033[J", m.lines)
+}
+
+// render returns the bars as they stand, and counts their lines
+func (m *multiBar) render() string {
+	width := progressBarWidth(80)
+	var b strings.Builder
+	for _, p := range m.active {
+		b.WriteString(m.fileLine(p, width) + "\n")
+	}
+	e := m.batch.event()
+	var percent int64
+	if e.Total > 0 {
+		percent = e.Bytes * 100 / e.Total
+	}
+	fmt.Fprintf(&b, "%-24s %s %3d%% %s\n", "Total", drawBar(e.Bytes, e.Total, width), percent, m.batch)
+	m.lines = len(m.active) + 1
+	return b.String()
+}
+
+// fileLine returns the line drawn for p
+func (m *multiBar) fileLine(p *fileProgress, width int) string {
+	done, speed := p.bytes.Load(), p.speed()/(1024*1024)
+	name := []rune(p.name)
+	if len(name) > 24 {
+		name = append(name[:23], '…')
+	}
+	if p.size <= 0 {
+		return fmt.Sprintf("%-24s %s %.2f MB (%.2f MB/s)", string(name), strings.Repeat(" ", width+2), float64(done)/(1024*1024), speed)
+	}
+	return fmt.Sprintf("%-24s %s %3d%% %.2f/%.2f MB (%.2f MB/s)", string(name), drawBar(done, p.size, width), done*100/p.size,
+		float64(done)/(1024*1024), float64(p.size)/(1024*1024), speed)
+}
+
+// drawBar returns a bar width characters wide, filled for done of total
+func drawBar(done, total int64, width int) string {
+	filled := 0
+	if total > 0 {
+		filled = int(min(done, total) * int64(width) / total)
+	}
+	return "|" + strings.Repeat("█", filled) + strings.Repeat(" ", width-filled) + "|"
+}
+
+// multiBarLog prints the log above the bars
+type multiBarLog struct{ m *multiBar }
+
+// Write implements io.Writer
+func (l multiBarLog) Write(p []byte) (int, error) {
+	l.m.print(l.m.logOut, string(p))
+	return len(p), nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// windowsToast shows a toast with the title and message passed in the
+// environment, which avoids quoting them into the script
+const windowsToast = `
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $xml.GetElementsByTagName('text')
+$text[0].AppendChild($xml.CreateTextNode($env:NOTIFY_TITLE)) > $null
+$text[1].AppendChild($xml.CreateTextNode($env:NOTIFY_MESSAGE)) > $null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('Telegram File Uploader').Show([Windows.UI.Notifications.ToastNotification]::new($xml))
+`
+
+// notifyDesktop shows a native desktop notification. Failures are only
+// logged, since there may be no desktop to notify at all.
+func notifyDesktop(title, message string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("osascript",
+			"-e", "on run argv",
+			"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+			"-e", "end run",
+			title, message)
+	case "windows":
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", windowsToast)
+		cmd.Env = append(os.Environ(), "NOTIFY_TITLE="+title, "NOTIFY_MESSAGE="+message)
+	default:
+		cmd = exec.Command("notify-send", "--app-name=Telegram File Uploader", title, message)
+	}
+	if err := cmd.Run(); err != nil {
+		log.Printf("Failed to show desktop notification: %v", err)
+	}
+}
+
+// notifyOptions says who is told when a run finishes or fails
+type notifyOptions struct {
+	Desktop bool     // show a desktop notification
+	Plugins []string // run these notifier plugins
+}
+
+// result reports the outcome of a run described by what
+func (o notifyOptions) result(what string, err error) {
+	n := notification{Title: "Upload finished", Message: what, Subject: what}
+	if err != nil {
+		n.Title, n.Message, n.Error = "Upload failed", what+": "+err.Error(), err.Error()
+	}
+	if o.Desktop {
+		notifyDesktop(n.Title, n.Message)
+	}
+	for _, name := range o.Plugins {
+		if err := notifyPlugin(name, n); err != nil {
+			log.Printf("Failed to notify with %s: %v", name, err)
+		}
+	}
+}
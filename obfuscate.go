@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// nameKeyEnv switches obfuscation from random names to HMACs of the name
+	nameKeyEnv = "FILEUPLOADER_NAME_KEY"
+
+	// manifestPath is the local file recording obfuscated names
+	manifestPath = "manifest.tsv"
+)
+
+// obfuscateName returns a replacement for name that keeps only its extension.
+// With FILEUPLOADER_NAME_KEY set the result is an HMAC of the name, so the same
+// file always gets the same identifier; otherwise it is random.
+func obfuscateName(name string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(name))
+
+	if key := os.Getenv(nameKeyEnv); key != "" {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(name))
+		return hex.EncodeToString(mac.Sum(nil)[:16]) + ext, nil
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id) + ext, nil
+}
+
+// recordNameMapping appends the obfuscated -> original name mapping to the local manifest
+func recordNameMapping(obfuscated, original, target string) error {
+	f, err := os.OpenFile(manifestPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "%s\t%s\t%s\t%s\n", time.Now().Format(time.RFC3339), obfuscated, original, target)
+	return err
+}
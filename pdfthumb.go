@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// maxThumbSide is the longest side Telegram accepts for a document's
+// thumbnail, in pixels
+const maxThumbSide = 320
+
+// errNoPDFRenderer means none of the programs that render PDF pages is
+// installed
+var errNoPDFRenderer = errors.New("no PDF renderer installed")
+
+// pdfThumbnail renders the first page of the PDF at path as a JPEG that
+// fits the thumbnail size. The page is rendered by pdftoppm (poppler) or
+// mutool (MuPDF), whichever is installed.
+func pdfThumbnail(path string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "pdfthumb-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "page.png")
+
+	var cmd *exec.Cmd
+	switch {
+	case hasCommand("pdftoppm"):
+		// Writes page.png from the prefix
+		cmd = exec.Command("pdftoppm", "-png", "-f", "1", "-l", "1", "-singlefile",
+			"-scale-to", fmt.Sprint(maxThumbSide), path, strings.TrimSuffix(out, ".png"))
+	case hasCommand("mutool"):
+		cmd = exec.Command("mutool", "draw", "-q", "-F", "png", "-o", out,
+			"-w", fmt.Sprint(maxThumbSide), "-h", fmt.Sprint(maxThumbSide), path, "1")
+	default:
+		return nil, errNoPDFRenderer
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w: %s", filepath.Base(cmd.Path), err, strings.TrimSpace(stderr.String()))
+	}
+
+	page, err := readImage(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the rendered page: %w", err)
+	}
+	return thumbnailJPEG(page)
+}
+
+// thumbnailJPEG scales img down to fit the thumbnail size, on white, and
+// encodes it as a JPEG
+func thumbnailJPEG(img image.Image) ([]byte, error) {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width > maxThumbSide || height > maxThumbSide {
+		if width >= height {
+			width, height = maxThumbSide, max(1, height*maxThumbSide/width)
+		} else {
+			width, height = max(1, width*maxThumbSide/height), maxThumbSide
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	xdraw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// hasCommand reports whether the program name is on the PATH
+func hasCommand(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gotd/td/telegram/query/messages"
+	"github.com/gotd/td/tg"
+)
+
+// deleteMessages deletes the given messages from p
+func deleteMessages(ctx context.Context, api *tg.Client, p tg.InputPeerClass, ids []int) error {
+	if ch, ok := p.(*tg.InputPeerChannel); ok {
+		_, err := api.ChannelsDeleteMessages(ctx, &tg.ChannelsDeleteMessagesRequest{
+			Channel: &tg.InputChannel{ChannelID: ch.ChannelID, AccessHash: ch.AccessHash},
+			ID:      ids,
+		})
+		return err
+	}
+	_, err := api.MessagesDeleteMessages(ctx, &tg.MessagesDeleteMessagesRequest{
+		Revoke: true,
+		ID:     ids,
+	})
+	return err
+}
+
+// getMessage fetches a single message of p by ID
+func getMessage(ctx context.Context, api *tg.Client, p tg.InputPeerClass, id int) (*tg.Message, error) {
+	ids := []tg.InputMessageClass{&tg.InputMessageID{ID: id}}
+
+	var (
+		res tg.MessagesMessagesClass
+		err error
+	)
+	if ch, ok := p.(*tg.InputPeerChannel); ok {
+		res, err = api.ChannelsGetMessages(ctx, &tg.ChannelsGetMessagesRequest{
+			Channel: &tg.InputChannel{ChannelID: ch.ChannelID, AccessHash: ch.AccessHash},
+			ID:      ids,
+		})
+	} else {
+		res, err = api.MessagesGetMessages(ctx, ids)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if modified, ok := res.AsModified(); ok {
+		for _, m := range modified.GetMessages() {
+			if msg, ok := m.(*tg.Message); ok && msg.ID == id {
+				return msg, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("message %d not found", id)
+}
+
+// scanDocuments calls fn for every message of p carrying a document, newest first
+func scanDocuments(ctx context.Context, api *tg.Client, p tg.InputPeerClass, fn func(msg *tg.Message, doc *tg.Document) error) error {
+	iter := messages.NewQueryBuilder(api).GetHistory(p).BatchSize(100).Iter()
+	for iter.Next(ctx) {
+		elem := iter.Value()
+		doc, ok := elem.Document()
+		if !ok {
+			continue
+		}
+		if err := fn(elem.Msg.(*tg.Message), doc); err != nil {
+			return err
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("failed to read chat history: %w", err)
+	}
+	return nil
+}
+
+// documentName returns the file name of doc, if it has one
+func documentName(doc *tg.Document) string {
+	for _, attr := range doc.Attributes {
+		if a, ok := attr.(*tg.DocumentAttributeFilename); ok {
+			return a.FileName
+		}
+	}
+	return ""
+}
+
+// messageLink returns the t.me permalink of message id in p. Only channels
+// and supergroups have them.
+func messageLink(ctx context.Context, api *tg.Client, p tg.InputPeerClass, id int) (string, error) {
+	ch, ok := p.(*tg.InputPeerChannel)
+	if !ok {
+		return "", errors.New("only messages in channels and supergroups have links")
+	}
+	link, err := api.ChannelsExportMessageLink(ctx, &tg.ChannelsExportMessageLinkRequest{
+		Channel: &tg.InputChannel{ChannelID: ch.ChannelID, AccessHash: ch.AccessHash},
+		ID:      id,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get message link: %w", err)
+	}
+	return link.Link, nil
+}
+
+// findSentMessage looks among the outgoing messages of p sent since since
+// for one carrying the document name of size bytes, or a photo captioned
+// caption, and returns nil if there is none. Without since it only looks
+// at the latest messages.
+func findSentMessage(ctx context.Context, api *tg.Client, p tg.InputPeerClass, name string, size int64, caption string, since time.Time) (*tg.Message, error) {
+	limit := 20
+	if !since.IsZero() {
+		limit = 500
+		// Allow for clocks that differ a little
+		since = since.Add(-time.Minute)
+	}
+	iter := messages.NewQueryBuilder(api).GetHistory(p).BatchSize(100).Iter()
+	for i := 0; i < limit && iter.Next(ctx); i++ {
+		msg, ok := iter.Value().Msg.(*tg.Message)
+		if !ok {
+			continue
+		}
+		if !since.IsZero() && time.Unix(int64(msg.Date), 0).Before(since) {
+			break
+		}
+		if !msg.Out {
+			continue
+		}
+		switch m := msg.Media.(type) {
+		case *tg.MessageMediaDocument:
+			if doc, ok := m.Document.AsNotEmpty(); ok && documentName(doc) == name && doc.Size == size {
+				return msg, nil
+			}
+		case *tg.MessageMediaPhoto:
+			if msg.Message == caption {
+				return msg, nil
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read chat history: %w", err)
+	}
+	return nil, nil
+}
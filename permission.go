@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/gotd/td/tg"
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+)
+
+// freeFileSize is the largest file accounts without Telegram Premium can send
+const freeFileSize = 2000 << 20
+
+// checkCanSend verifies that this account may send the file name of size
+// bytes to target, so a doomed upload fails before any data is sent
+func checkCanSend(ctx context.Context, api *tg.Client, target tg.InputPeerClass, name string, size int64) error {
+	if size > freeFileSize {
+		premium, err := isPremium(ctx, api)
+		if err != nil {
+			return err
+		}
+		if !premium {
+			return withExitCode(exitFileTooLarge, fmt.Errorf("%s is %.2f MB; files over %d MB need Telegram Premium",
+				name, float64(size)/(1024*1024), freeFileSize>>20))
+		}
+	}
+
+	var err error
+	switch p := target.(type) {
+	case *tg.InputPeerChannel:
+		err = checkChannelRights(ctx, api, p, name)
+	case *tg.InputPeerChat:
+		err = checkChatRights(ctx, api, p, name)
+	}
+	if err != nil {
+		return withExitCode(exitForbidden, err)
+	}
+	return nil
+}
+
+// isPremium reports whether the logged in account has Telegram Premium
+func isPremium(ctx context.Context, api *tg.Client) (bool, error) {
+	users, err := api.UsersGetUsers(ctx, []tg.InputUserClass{&tg.InputUserSelf{}})
+	if err != nil {
+		return false, fmt.Errorf("failed to get account details: %w", err)
+	}
+	for _, u := range users {
+		if u, ok := u.(*tg.User); ok && u.Self {
+			return u.Premium, nil
+		}
+	}
+	return false, nil
+}
+
+// checkChannelRights checks a channel or supergroup
+func checkChannelRights(ctx context.Context, api *tg.Client, p *tg.InputPeerChannel, name string) error {
+	res, err := api.ChannelsGetChannels(ctx, []tg.InputChannelClass{
+		&tg.InputChannel{ChannelID: p.ChannelID, AccessHash: p.AccessHash},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get channel details: %w", err)
+	}
+	for _, c := range res.GetChats() {
+		switch c := c.(type) {
+		case *tg.ChannelForbidden:
+			return fmt.Errorf("you no longer have access to %s", c.Title)
+		case *tg.Channel:
+			if c.Left {
+				return fmt.Errorf("you aren't a member of %s", c.Title)
+			}
+			if c.Creator {
+				return nil
+			}
+			admin, isAdmin := c.GetAdminRights()
+			if c.Broadcast {
+				if !isAdmin || !admin.PostMessages {
+					return fmt.Errorf("only admins with the right to post can send to the channel %s", c.Title)
+				}
+				return nil
+			}
+			if isAdmin {
+				return nil
+			}
+			if banned, ok := c.GetBannedRights(); ok {
+				if reason := bannedReason(banned, name); reason != "" {
+					return fmt.Errorf("you are restricted in %s: %s", c.Title, reason)
+				}
+			}
+			if banned, ok := c.GetDefaultBannedRights(); ok {
+				if reason := bannedReason(banned, name); reason != "" {
+					return fmt.Errorf("members of %s are restricted: %s", c.Title, reason)
+				}
+			}
+			return nil
+		}
+	}
+	return errors.New("channel not found")
+}
+
+// checkChatRights checks a basic group
+func checkChatRights(ctx context.Context, api *tg.Client, p *tg.InputPeerChat, name string) error {
+	res, err := api.MessagesGetChats(ctx, []int64{p.ChatID})
+	if err != nil {
+		return fmt.Errorf("failed to get group details: %w", err)
+	}
+	for _, c := range res.GetChats() {
+		switch c := c.(type) {
+		case *tg.ChatForbidden:
+			return fmt.Errorf("you no longer have access to %s", c.Title)
+		case *tg.Chat:
+			if c.Left || c.Deactivated {
+				return fmt.Errorf("you can't post to %s any more", c.Title)
+			}
+			if _, isAdmin := c.GetAdminRights(); c.Creator || isAdmin {
+				return nil
+			}
+			if banned, ok := c.GetDefaultBannedRights(); ok {
+				if reason := bannedReason(banned, name); reason != "" {
+					return fmt.Errorf("members of %s are restricted: %s", c.Title, reason)
+				}
+			}
+			return nil
+		}
+	}
+	return errors.New("group not found")
+}
+
+// bannedReason describes why rights forbid sending the file name, or
+// returns "" if they allow it
+func bannedReason(rights tg.ChatBannedRights, name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	switch {
+	case rights.SendMessages:
+		return "sending messages is not allowed"
+	case rights.SendMedia:
+		return "sending media is not allowed"
+	case telegramuploader.IsImage(ext) && rights.SendPhotos:
+		return "sending photos is not allowed"
+	case telegramuploader.IsVideo(ext) && rights.SendVideos:
+		return "sending videos is not allowed"
+	case !telegramuploader.IsImage(ext) && !telegramuploader.IsVideo(ext) && rights.SendDocs:
+		return "sending files is not allowed"
+	}
+	return ""
+}
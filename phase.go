@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// phaseTimeouts limits how long each phase of an upload may take; zero
+// means no limit
+type phaseTimeouts struct {
+	Auth    time.Duration
+	Resolve time.Duration
+	Upload  time.Duration
+	Send    time.Duration
+}
+
+// timeoutFlags registers the phase timeout flags on fs
+func timeoutFlags(fs *flag.FlagSet, t *phaseTimeouts) {
+	fs.DurationVar(&t.Auth, "auth-timeout", 0, "Give up logging in after this long (e.g. 2m; 0 for no limit)")
+	fs.DurationVar(&t.Resolve, "resolve-timeout", 0, "Give up resolving the target after this long")
+	fs.DurationVar(&t.Upload, "upload-timeout", 0, "Give up uploading a file after this long")
+	fs.DurationVar(&t.Send, "send-timeout", 0, "Give up sending the uploaded file after this long")
+}
+
+// phaseContext returns the context for one phase, limited to timeout if set
+func phaseContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// phaseError names the phase err happened in if the phase was cut short,
+// so an interrupted run says where it stopped
+func phaseError(ctx context.Context, phase string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("%s timed out: %w", phase, err)
+	case errors.Is(ctx.Err(), context.Canceled):
+		return fmt.Errorf("interrupted while %s: %w", phase, err)
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // decodes GIFs, which are left alone
+	"image/jpeg"
+	_ "image/png" // decodes PNGs
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+	_ "golang.org/x/image/bmp" // decodes BMPs
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // decodes WebPs
+)
+
+// resizePhoto downscales the image at path so that its longer side is at
+// most maxDimension pixels, turned upright as its EXIF orientation says,
+// and recompresses it as a JPEG of the given quality. It returns the path
+// of a temporary file with the result and the name to upload it under, or
+// "" if the image is better sent as it is: small enough already, animated,
+// or not smaller once recompressed.
+func resizePhoto(path, name string, maxDimension, quality int) (string, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read image: %w", err)
+	}
+	scale := cfg.Width > maxDimension || cfg.Height > maxDimension
+	if format == "gif" || (!scale && len(data) <= telegramuploader.MaxPhotoSize) {
+		return "", "", nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	width, height := cfg.Width, cfg.Height
+	if scale {
+		if width >= height {
+			width, height = maxDimension, max(1, height*maxDimension/width)
+		} else {
+			width, height = max(1, width*maxDimension/height), maxDimension
+		}
+	}
+	// Transparent areas become white, as JPEG has no alpha
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Over, nil)
+	// The EXIF data is dropped with the rest of the file, so the pixels
+	// have to be turned upright themselves
+	upright := orientImage(dst, exifOrientation(jpegExif(data)))
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, upright, &jpeg.Options{Quality: quality}); err != nil {
+		return "", "", fmt.Errorf("failed to encode image: %w", err)
+	}
+	if out.Len() >= len(data) && !scale {
+		return "", "", nil
+	}
+	tmp, err := os.CreateTemp("", "photo-*.jpg")
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := tmp.Write(out.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", "", err
+	}
+
+	switch ext := filepath.Ext(name); strings.ToLower(ext) {
+	case ".jpg", ".jpeg":
+	default:
+		name = strings.TrimSuffix(name, ext) + ".jpg"
+	}
+	b := upright.Bounds()
+	fmt.Printf("Resized photo from %dx%d to %dx%d (%.2f MB to %.2f MB)\n", cfg.Width, cfg.Height, b.Dx(), b.Dy(), float64(len(data))/(1024*1024), float64(out.Len())/(1024*1024))
+	return tmp.Name(), name, nil
+}
+
+// orientImage returns img turned upright according to an EXIF orientation
+func orientImage(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		// Orientations 5 to 8 swap width and height
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // flipped horizontally
+				sx, sy = w-1-x, y
+			case 3: // upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: // flipped vertically
+				sx, sy = x, h-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // turned counter-clockwise, so turn it clockwise
+				sx, sy = y, h-1-x
+			case 7: // transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // turned clockwise, so turn it back
+				sx, sy = w-1-y, x
+			}
+			dst.SetRGBA(x, y, img.RGBAAt(sx, sy))
+		}
+	}
+	return dst
+}
+
+// stripPhotoMetadata writes a copy of the JPEG, PNG or HEIF image at path
+// without its EXIF and other metadata to a temporary file, and returns its
+// path. It returns "" for files in other formats.
+func stripPhotoMetadata(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var strip func([]byte) ([]byte, error)
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		strip = stripJPEGMetadata
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		strip = stripPNGMetadata
+	case len(data) >= 12 && string(data[4:8]) == "ftyp" && heifBrands[string(data[8:12])]:
+		strip = stripHEIFMetadata
+	default:
+		return "", nil
+	}
+	stripped, err := strip(data)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp("", "stripped-*"+filepath.Ext(path))
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(stripped); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
@@ -0,0 +1,353 @@
+// Package telegramuploader uploads files to Telegram chats from Go
+// programs, with the same upload and send logic as the fileuploader
+// command.
+//
+// A Client logs in with an app's API ID and hash and keeps its session in
+// a file, so that it only has to log in once:
+//
+//	client := telegramuploader.New(telegramuploader.Config{
+//		AppID:       12345,
+//		AppHash:     "0123456789abcdef0123456789abcdef",
+//		SessionPath: "uploader.session",
+//		BotToken:    os.Getenv("BOT_TOKEN"),
+//	})
+//	result, err := client.UploadFile(ctx, telegramuploader.FileSource("report.pdf"), "@mychannel", telegramuploader.Options{
+//		Caption:  "Monthly report",
+//		Progress: telegramuploader.ProgressFunc(func(done, total int64) {
+//			fmt.Printf("%d of %d bytes\n", done, total)
+//		}),
+//	})
+package telegramuploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/session"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/auth"
+	"github.com/gotd/td/tgerr"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Config says how a Client connects and logs in
+type Config struct {
+	AppID   int    // API ID from my.telegram.org
+	AppHash string // API hash from my.telegram.org
+
+	// SessionPath is the file the session is kept in; without one, every
+	// connection logs in again. A saved session that Telegram no longer
+	// accepts is removed, and the Client logs in afresh.
+	SessionPath string
+
+	// SessionStorage keeps the session somewhere else than a file, like
+	// a database shared by several servers. It takes precedence over
+	// SessionPath.
+	SessionStorage telegram.SessionStorage
+
+	// Journal, if set, is given a Record of every file sent
+	Journal Journal
+
+	// Middlewares wrap every API call, like FloodWaiter or RateLimiter
+	Middlewares []telegram.Middleware
+
+	// Events, if set, is given the events of the Client's transfers
+	Events EventHandler
+
+	// UpdateHandler, if set, is given the updates Telegram pushes while
+	// the Client is connected
+	UpdateHandler telegram.UpdateHandler
+
+	// TracerProvider, if set, traces the API calls
+	TracerProvider trace.TracerProvider
+
+	// BotToken logs in as a bot
+	BotToken string
+
+	// UserAuth logs in as a user, asking for the phone number, code and
+	// password as needed. It's used when there is no BotToken.
+	UserAuth auth.UserAuthenticator
+
+	// LoginTimeout limits how long logging in may take; 0 for no limit
+	LoginTimeout time.Duration
+
+	// Logf, if set, is told about logging in, like log.Printf
+	Logf func(format string, args ...any)
+}
+
+// Client uploads files with one Telegram account. Its methods may be
+// called from several goroutines. Calls running at the same time share
+// one connection, which is closed when the last of them returns.
+type Client struct {
+	config Config
+
+	mu   sync.Mutex
+	conn *conn // the shared connection, if there is one
+}
+
+// conn is a connection shared by the calls of a Client
+type conn struct {
+	client   *telegram.Client
+	stop     context.CancelFunc
+	users    int  // calls using the connection
+	stopping bool // no new calls may use it
+
+	ready    chan struct{} // closed once logged in, or failed to
+	loginErr error         // why logging in failed, once ready is closed
+	done     chan struct{} // closed once the connection has stopped
+	err      error         // why it stopped, once done is closed
+}
+
+// errSessionRevoked means Telegram no longer accepts the saved session,
+// because it was logged out from another device or expired
+var errSessionRevoked = errors.New("the saved session was revoked")
+
+// sessionRevoked reports whether err means the session's authorization
+// is gone
+func sessionRevoked(err error) bool {
+	return errors.Is(err, errSessionRevoked) ||
+		tgerr.Is(err, "AUTH_KEY_UNREGISTERED", "AUTH_KEY_INVALID", "SESSION_REVOKED", "SESSION_EXPIRED")
+}
+
+// New returns a Client for config. It doesn't connect until it's used.
+func New(config Config) *Client {
+	return &Client{config: config}
+}
+
+// Run connects and logs in, unless another call has already, and calls fn
+// with the connected client. It's for programs that also want to use the
+// API themselves. fn's context ends when ctx does or the connection is
+// lost.
+func (c *Client) Run(ctx context.Context, fn func(ctx context.Context, client *telegram.Client) error) error {
+	conn, err := c.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.release(conn)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-conn.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	err = fn(ctx, conn.client)
+	select {
+	case <-conn.done:
+		if conn.err != nil && ctx.Err() != nil {
+			return fmt.Errorf("connection lost: %w", conn.err)
+		}
+	default:
+	}
+	if sessionRevoked(err) {
+		// Logged out mid-run: whatever fn was doing can't simply be
+		// repeated, so drop the session for the next call to log in
+		return c.dropSession(conn, err)
+	}
+	return err
+}
+
+// acquire returns the shared connection once it's logged in, connecting
+// if there is none
+func (c *Client) acquire(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	for c.conn != nil && c.conn.stopping {
+		// Let it save the session before the next one reads it
+		done := c.conn.done
+		c.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		c.mu.Lock()
+	}
+	if c.conn == nil {
+		c.conn = c.connect()
+	}
+	conn := c.conn
+	conn.users++
+	c.mu.Unlock()
+
+	select {
+	case <-conn.ready:
+	case <-ctx.Done():
+		c.release(conn)
+		return nil, ctx.Err()
+	}
+	if conn.loginErr != nil {
+		c.release(conn)
+		return nil, conn.loginErr
+	}
+	return conn, nil
+}
+
+// release ends a call's use of conn, and stops conn after the last one
+func (c *Client) release(conn *conn) {
+	c.mu.Lock()
+	conn.users--
+	last := conn.users == 0
+	if last {
+		conn.stopping = true
+	}
+	c.mu.Unlock()
+	if last {
+		conn.stop()
+		<-conn.done
+	}
+}
+
+// dropSession stops conn and removes the session file after err showed
+// the session was revoked
+func (c *Client) dropSession(conn *conn, err error) error {
+	c.mu.Lock()
+	conn.stopping = true
+	c.mu.Unlock()
+	conn.stop()
+	<-conn.done
+	if c.config.SessionPath == "" || c.config.SessionStorage != nil {
+		return fmt.Errorf("%w: the session was revoked during the run: %w", ErrUnauthorized, err)
+	}
+	if rmErr := os.Remove(c.config.SessionPath); rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) {
+		return fmt.Errorf("%w: the session was revoked during the run; delete %s to log in again: %w", ErrUnauthorized, c.config.SessionPath, err)
+	}
+	return fmt.Errorf("%w: the session was revoked during the run and has been removed; log in again: %w", ErrUnauthorized, err)
+}
+
+// connect starts a connection in the background
+func (c *Client) connect() *conn {
+	ctx, stop := context.WithCancel(context.Background())
+	conn := &conn{stop: stop, ready: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer stop()
+		err := c.dial(ctx, conn)
+		if errors.Is(err, errSessionRevoked) && c.config.SessionPath != "" && c.config.SessionStorage == nil {
+			// The login step found the saved session dead; start over
+			// with a new one
+			c.logf("The saved session %s was revoked or has expired; removing it and logging in again", c.config.SessionPath)
+			if rmErr := os.Remove(c.config.SessionPath); rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) {
+				err = fmt.Errorf("%w: failed to remove revoked session, delete %s and try again: %w", ErrUnauthorized, c.config.SessionPath, rmErr)
+			} else {
+				err = c.dial(ctx, conn)
+			}
+		}
+		if errors.Is(err, errSessionRevoked) {
+			err = fmt.Errorf("%w: %w", ErrUnauthorized, err)
+		}
+
+		c.mu.Lock()
+		if c.conn == conn {
+			c.conn = nil
+		}
+		c.mu.Unlock()
+		select {
+		case <-conn.ready:
+		default:
+			conn.loginErr = err
+			if err == nil {
+				conn.loginErr = context.Canceled
+			}
+			close(conn.ready)
+		}
+		conn.err = err
+		close(conn.done)
+	}()
+	return conn
+}
+
+// dial connects, logs in and keeps the connection until ctx ends. It
+// returns errSessionRevoked if the saved session turns out to be unusable.
+func (c *Client) dial(ctx context.Context, conn *conn) error {
+	var storage telegram.SessionStorage = &session.StorageMemory{}
+	hadSession := false
+	switch {
+	case c.config.SessionStorage != nil:
+		storage = c.config.SessionStorage
+	case c.config.SessionPath != "":
+		storage = &session.FileStorage{Path: c.config.SessionPath}
+		_, err := os.Stat(c.config.SessionPath)
+		hadSession = err == nil
+	}
+	middlewares := c.config.Middlewares
+	if c.config.Events != nil {
+		middlewares = append(slices.Clip(middlewares), FloodWatcher(c.config.Events))
+	}
+	client := telegram.NewClient(c.config.AppID, c.config.AppHash, telegram.Options{
+		SessionStorage: storage,
+		Middlewares:    middlewares,
+		UpdateHandler:  c.config.UpdateHandler,
+		TracerProvider: c.config.TracerProvider,
+	})
+	err := client.Run(ctx, func(ctx context.Context) error {
+		if err := c.login(ctx, client, hadSession); err != nil {
+			return err
+		}
+		conn.client = client
+		close(conn.ready)
+		<-ctx.Done()
+		return nil
+	})
+	if ctx.Err() != nil && !errors.Is(err, errSessionRevoked) {
+		// Stopped on purpose
+		return nil
+	}
+	return err
+}
+
+// login logs client in unless its session already is. A saved session
+// that isn't authorized any more was logged out elsewhere, and its key
+// can't be reused.
+func (c *Client) login(ctx context.Context, client *telegram.Client, hadSession bool) (err error) {
+	if c.config.LoginTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.config.LoginTimeout)
+		defer cancel()
+		defer func() {
+			if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				err = fmt.Errorf("logging in timed out: %w", err)
+			}
+		}()
+	}
+	status, err := client.Auth().Status(ctx)
+	if err != nil {
+		if hadSession && sessionRevoked(err) {
+			return errSessionRevoked
+		}
+		return fmt.Errorf("failed to get auth status: %w", Classify(err))
+	}
+	switch {
+	case status.Authorized:
+	case hadSession:
+		return errSessionRevoked
+	case c.config.BotToken != "":
+		c.logf("Logging in as bot...")
+		if _, err := client.Auth().Bot(ctx, c.config.BotToken); err != nil {
+			return fmt.Errorf("%w: bot authentication failed: %w", ErrUnauthorized, err)
+		}
+	case c.config.UserAuth != nil:
+		c.logf("Starting authentication flow...")
+		flow := auth.NewFlow(c.config.UserAuth, auth.SendCodeOptions{})
+		if err := client.Auth().IfNecessary(ctx, flow); err != nil {
+			return fmt.Errorf("%w: authentication failed: %w", ErrUnauthorized, err)
+		}
+	default:
+		return fmt.Errorf("%w: set a bot token or a user authenticator", ErrUnauthorized)
+	}
+	c.logf("Successfully authenticated!")
+	return nil
+}
+
+// logf logs through Config.Logf, if it's set
+func (c *Client) logf(format string, args ...any) {
+	if c.config.Logf != nil {
+		c.config.Logf(format, args...)
+	}
+}
@@ -0,0 +1,35 @@
+package telegramuploader
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gotd/td/tgerr"
+)
+
+func TestSessionRevoked(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil"},
+		{name: "other error", err: errors.New("connection reset")},
+		{name: "flood wait", err: tgerr.New(420, "FLOOD_WAIT_3")},
+		{name: "deactivated user", err: tgerr.New(401, "USER_DEACTIVATED")},
+		{name: "revoked at login", err: errSessionRevoked, want: true},
+		{name: "revoked at login, wrapped", err: fmt.Errorf("%w: %w", ErrUnauthorized, errSessionRevoked), want: true},
+		{name: "unregistered key", err: tgerr.New(401, "AUTH_KEY_UNREGISTERED"), want: true},
+		{name: "invalid key", err: tgerr.New(401, "AUTH_KEY_INVALID"), want: true},
+		{name: "revoked", err: tgerr.New(401, "SESSION_REVOKED"), want: true},
+		{name: "expired, wrapped", err: fmt.Errorf("upload failed: %w", tgerr.New(401, "SESSION_EXPIRED")), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sessionRevoked(tt.err); got != tt.want {
+				t.Errorf("sessionRevoked(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
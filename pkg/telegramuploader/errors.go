@@ -0,0 +1,62 @@
+package telegramuploader
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gotd/td/tgerr"
+)
+
+// MaxFileSize is the largest file Telegram accepts, for Premium accounts;
+// other accounts are limited to half of it
+const MaxFileSize = 4000 << 20
+
+// Classes of failures, for errors.Is. The library's errors wrap them
+// along with the error Telegram returned, if any.
+var (
+	// ErrPeerNotFound means the target couldn't be resolved
+	ErrPeerNotFound = errors.New("chat not found")
+	// ErrFileTooLarge means the file exceeds Telegram's size limit
+	ErrFileTooLarge = errors.New("file too large")
+	// ErrUnauthorized means the client isn't logged in, or its session was
+	// revoked
+	ErrUnauthorized = errors.New("not logged in")
+)
+
+// FloodWaitError means Telegram asked to wait for Duration before making
+// the request again
+type FloodWaitError struct {
+	Duration time.Duration
+	Err      error // the error Telegram returned
+}
+
+func (e *FloodWaitError) Error() string {
+	return fmt.Sprintf("flood wait of %s: %v", e.Duration, e.Err)
+}
+
+func (e *FloodWaitError) Unwrap() error { return e.Err }
+
+// Classify wraps Telegram errors of the classes above in ErrPeerNotFound,
+// ErrFileTooLarge, ErrUnauthorized or a FloodWaitError, so they can be
+// told apart with errors.Is and errors.As. Other errors, and errors
+// classified already, are returned as they are.
+func Classify(err error) error {
+	var flood *FloodWaitError
+	switch {
+	case err == nil, errors.As(err, &flood), errors.Is(err, ErrPeerNotFound), errors.Is(err, ErrFileTooLarge), errors.Is(err, ErrUnauthorized):
+		return err
+	}
+	if d, ok := tgerr.AsFloodWait(err); ok {
+		return &FloodWaitError{Duration: d, Err: err}
+	}
+	switch {
+	case tgerr.Is(err, "USERNAME_NOT_OCCUPIED", "USERNAME_INVALID", "PEER_ID_INVALID", "CHANNEL_INVALID", "CHANNEL_PRIVATE", "CHAT_ID_INVALID"):
+		return fmt.Errorf("%w: %w", ErrPeerNotFound, err)
+	case tgerr.Is(err, "FILE_PARTS_INVALID", "FILE_PART_SIZE_INVALID", "FILE_PART_TOO_BIG"):
+		return fmt.Errorf("%w: %w", ErrFileTooLarge, err)
+	case tgerr.Is(err, "AUTH_KEY_UNREGISTERED", "AUTH_KEY_INVALID", "SESSION_REVOKED", "SESSION_EXPIRED", "USER_DEACTIVATED", "USER_DEACTIVATED_BAN"):
+		return fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+	return err
+}
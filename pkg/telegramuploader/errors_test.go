@@ -0,0 +1,62 @@
+package telegramuploader
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/tgerr"
+)
+
+func TestClassify(t *testing.T) {
+	plain := errors.New("connection reset")
+	classified := fmt.Errorf("%w: earlier", ErrPeerNotFound)
+	tests := []struct {
+		name  string
+		err   error
+		class error // nil if the error is returned as it is
+		flood time.Duration
+	}{
+		{name: "nil"},
+		{name: "other error", err: plain},
+		{name: "unknown username", err: tgerr.New(400, "USERNAME_NOT_OCCUPIED"), class: ErrPeerNotFound},
+		{name: "private channel", err: tgerr.New(400, "CHANNEL_PRIVATE"), class: ErrPeerNotFound},
+		{name: "bad parts", err: tgerr.New(400, "FILE_PARTS_INVALID"), class: ErrFileTooLarge},
+		{name: "unregistered key", err: tgerr.New(401, "AUTH_KEY_UNREGISTERED"), class: ErrUnauthorized},
+		{name: "revoked session", err: tgerr.New(401, "SESSION_REVOKED"), class: ErrUnauthorized},
+		{name: "wrapped", err: fmt.Errorf("send failed: %w", tgerr.New(401, "USER_DEACTIVATED")), class: ErrUnauthorized},
+		{name: "flood wait", err: tgerr.New(420, "FLOOD_WAIT_30"), flood: 30 * time.Second},
+		{name: "classified already", err: classified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Classify(tt.err)
+			var flood *FloodWaitError
+			switch {
+			case tt.flood != 0:
+				if !errors.As(got, &flood) || flood.Duration != tt.flood {
+					t.Fatalf("Classify(%v) = %v, want a flood wait of %s", tt.err, got, tt.flood)
+				}
+				if !errors.Is(got, tt.err) {
+					t.Errorf("Classify(%v) = %v, which doesn't wrap Telegram's error", tt.err, got)
+				}
+			case tt.class != nil:
+				if !errors.Is(got, tt.class) || !errors.Is(got, tt.err) {
+					t.Errorf("Classify(%v) = %v, want it wrapped in %v", tt.err, got, tt.class)
+				}
+			default:
+				if got != tt.err {
+					t.Errorf("Classify(%v) = %v, want it unchanged", tt.err, got)
+				}
+			}
+		})
+	}
+}
+
+func TestClassifyFloodWaitOnce(t *testing.T) {
+	err := Classify(tgerr.New(420, "FLOOD_WAIT_5"))
+	if again := Classify(err); again != err {
+		t.Errorf("Classify wrapped a flood wait twice: %v", again)
+	}
+}
@@ -0,0 +1,190 @@
+package telegramuploader
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// Event is something that happened to an upload job: one of JobQueued,
+// PartUploaded, FloodWait, JobCompleted and JobFailed
+type Event interface {
+	// JobID identifies the job the event belongs to; "" for API calls
+	// outside of a job
+	JobID() string
+	event()
+}
+
+// JobQueued is sent when a job is started
+type JobQueued struct {
+	Job  string
+	Name string
+	Size int64 // -1 if it isn't known yet
+}
+
+// PartUploaded is sent for every part Telegram has accepted
+type PartUploaded struct {
+	Job      string
+	Part     int
+	Uploaded int64 // bytes so far
+	Total    int64 // -1 if it isn't known
+}
+
+// FloodWait is sent when Telegram asks to wait before repeating a call
+type FloodWait struct {
+	Job    string
+	Method string
+	Wait   time.Duration
+}
+
+// JobCompleted is sent when a job's file has been sent
+type JobCompleted struct {
+	Job    string
+	Result Result
+}
+
+// JobFailed is sent when a job fails or is cancelled
+type JobFailed struct {
+	Job string
+	Err error
+}
+
+func (e JobQueued) JobID() string    { return e.Job }
+func (e PartUploaded) JobID() string { return e.Job }
+func (e FloodWait) JobID() string    { return e.Job }
+func (e JobCompleted) JobID() string { return e.Job }
+func (e JobFailed) JobID() string    { return e.Job }
+
+func (JobQueued) event()    {}
+func (PartUploaded) event() {}
+func (FloodWait) event()    {}
+func (JobCompleted) event() {}
+func (JobFailed) event()    {}
+
+// EventHandler is given the events of a Client's jobs. HandleEvent is
+// called from the jobs' goroutines and should return quickly.
+type EventHandler interface {
+	HandleEvent(e Event)
+}
+
+// EventFunc lets an ordinary function be an EventHandler
+type EventFunc func(e Event)
+
+// HandleEvent calls f
+func (f EventFunc) HandleEvent(e Event) {
+	f(e)
+}
+
+// EventChannel sends the events to ch. It blocks while ch is full, so ch
+// has to be read for the uploads to go on.
+func EventChannel(ch chan<- Event) EventHandler {
+	return EventFunc(func(e Event) { ch <- e })
+}
+
+// EventWriter writes the events to w as JSON lines, each with a "type"
+// naming the event
+func EventWriter(w io.Writer) EventHandler {
+	var mu sync.Mutex
+	return EventFunc(func(e Event) {
+		data, err := MarshalEvent(e)
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write(append(data, '\n'))
+	})
+}
+
+// MarshalEvent returns the JSON form of e, with a "type" naming the event
+// and errors as their messages
+func MarshalEvent(e Event) ([]byte, error) {
+	var v any
+	switch e := e.(type) {
+	case JobQueued:
+		v = struct {
+			Type string `json:"type"`
+			JobQueued
+		}{"JobQueued", e}
+	case PartUploaded:
+		v = struct {
+			Type string `json:"type"`
+			PartUploaded
+		}{"PartUploaded", e}
+	case FloodWait:
+		v = struct {
+			Type    string  `json:"type"`
+			Job     string  `json:"Job"`
+			Method  string  `json:"Method"`
+			Seconds float64 `json:"WaitSeconds"`
+		}{"FloodWait", e.Job, e.Method, e.Wait.Seconds()}
+	case JobCompleted:
+		v = struct {
+			Type string `json:"type"`
+			JobCompleted
+		}{"JobCompleted", e}
+	case JobFailed:
+		v = struct {
+			Type  string `json:"type"`
+			Job   string `json:"Job"`
+			Error string `json:"Error"`
+		}{"JobFailed", e.Job, e.Err.Error()}
+	}
+	return json.Marshal(v)
+}
+
+// jobKey is the context key of the job an API call belongs to
+type jobKey struct{}
+
+// WithJob marks the API calls made with ctx as belonging to job, for the
+// events of FloodWatcher and PartEvents
+func WithJob(ctx context.Context, job string) context.Context {
+	return context.WithValue(ctx, jobKey{}, job)
+}
+
+// jobOf returns the job of ctx, or ""
+func jobOf(ctx context.Context) string {
+	job, _ := ctx.Value(jobKey{}).(string)
+	return job
+}
+
+// FloodWatcher is a middleware sending a FloodWait event to h for every
+// FLOOD_WAIT error. Put it after FloodWaiter to see the waits it absorbs.
+func FloodWatcher(h EventHandler) telegram.Middleware {
+	return telegram.MiddlewareFunc(func(next tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			err := next.Invoke(ctx, input, output)
+			if d, ok := tgerr.AsFloodWait(err); ok {
+				e := FloodWait{Job: jobOf(ctx), Wait: d}
+				if t, ok := input.(interface{ TypeName() string }); ok {
+					e.Method = t.TypeName()
+				}
+				h.HandleEvent(e)
+			}
+			return err
+		}
+	})
+}
+
+// PartEvents is an uploader progress sending a PartUploaded event to h for
+// every part, for the job of the upload's context
+func PartEvents(h EventHandler) uploader.Progress {
+	return partEvents{h}
+}
+
+type partEvents struct {
+	h EventHandler
+}
+
+func (p partEvents) Chunk(ctx context.Context, state uploader.ProgressState) error {
+	p.h.HandleEvent(PartUploaded{Job: jobOf(ctx), Part: state.Part, Uploaded: state.Uploaded, Total: state.Total})
+	return nil
+}
@@ -0,0 +1,148 @@
+package telegramuploader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// ExecSource is the file at rawURL as provided by program, a plugin that
+// adds a URL scheme. The plugin is run once for every Open, and speaks
+// this protocol over its standard input and output:
+//
+// It's given a JSON request on its standard input:
+//
+//	{"action": "open", "url": "myapp://album/42"}
+//
+// It answers with one line of JSON describing the file, where every field
+// may be left out, followed by the file's data until it exits:
+//
+//	{"name": "42.jpg", "size": 123456, "mime_type": "image/jpeg"}
+//
+// If it can't provide the file it answers {"error": "..."} instead. It
+// exits with a non-zero status if the data stops short. Its standard
+// error is passed through.
+func ExecSource(program, rawURL string) Source {
+	s := &execSource{program: program, url: rawURL, size: -1}
+	if u, err := url.Parse(rawURL); err == nil {
+		s.name = path.Base(u.Path)
+	}
+	return s
+}
+
+// execSource is a file provided by a plugin program
+type execSource struct {
+	program  string
+	url      string
+	name     string
+	size     int64
+	mimeType string
+}
+
+// execHeader is the line a source plugin starts its answer with
+type execHeader struct {
+	Name     string `json:"name"`
+	Size     *int64 `json:"size"`
+	MimeType string `json:"mime_type"`
+	Error    string `json:"error"`
+}
+
+func (s *execSource) Name() string {
+	if s.name == "" || s.name == "/" || s.name == "." {
+		return "downloaded_file"
+	}
+	return s.name
+}
+
+func (s *execSource) Size() int64    { return s.size }
+func (s *execSource) String() string { return s.url }
+
+func (s *execSource) MimeType() string {
+	if s.mimeType != "" {
+		return s.mimeType
+	}
+	return MimeType(s.Name())
+}
+
+func (s *execSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	req, err := json.Marshal(map[string]string{"action": "open", "url": s.url})
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, s.program)
+	cmd.Stdin = bytes.NewReader(req)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run plugin %s: %w", s.program, err)
+	}
+	r := &execReader{Reader: bufio.NewReader(stdout), cmd: cmd}
+
+	line, err := r.Reader.(*bufio.Reader).ReadBytes('\n')
+	var header execHeader
+	if err == nil {
+		err = json.Unmarshal(line, &header)
+	}
+	if err == nil && header.Error != "" {
+		err = errors.New(header.Error)
+	}
+	if err == nil && strings.ContainsAny(header.Name, "/\\\x00") {
+		// The name ends up in temporary file names, and must not lead out
+		// of their directory
+		err = fmt.Errorf("invalid file name %q", header.Name)
+	}
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("plugin %s failed to open %s: %w", s.program, s.url, err)
+	}
+	if header.Name != "" {
+		s.name = header.Name
+	}
+	if header.Size != nil {
+		s.size = *header.Size
+	}
+	s.mimeType = header.MimeType
+	return r, nil
+}
+
+// execReader reads a plugin's data, and fails if the plugin doesn't exit
+// successfully after it
+type execReader struct {
+	io.Reader
+	cmd    *exec.Cmd
+	waited bool
+}
+
+func (r *execReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF && !r.waited {
+		r.waited = true
+		if err := r.cmd.Wait(); err != nil {
+			return n, fmt.Errorf("plugin failed: %w", err)
+		}
+	}
+	return n, err
+}
+
+// Close stops the plugin if it's still running
+func (r *execReader) Close() error {
+	if r.waited {
+		return nil
+	}
+	r.waited = true
+	r.cmd.Process.Kill()
+	r.cmd.Wait()
+	return nil
+}
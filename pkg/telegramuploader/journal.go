@@ -0,0 +1,109 @@
+package telegramuploader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Record describes a file a Client has sent. Its JSON form is the same as
+// an entry of the fileuploader command's journal.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Source    string    `json:"source"` // path or URL the file came from, or its name
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	MimeType  string    `json:"mime_type"`
+	SHA256    string    `json:"sha256,omitempty"`
+	Target    string    `json:"target"`
+	MessageID int       `json:"message_id"`
+}
+
+// Journal keeps the records of sent files. Programs can implement it to
+// keep them in a database instead of a file.
+type Journal interface {
+	Record(ctx context.Context, r Record) error
+}
+
+// MemoryJournal keeps records in memory
+type MemoryJournal struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+// Record adds r
+func (j *MemoryJournal) Record(ctx context.Context, r Record) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.records = append(j.records, r)
+	return nil
+}
+
+// Records returns the records so far, oldest first
+func (j *MemoryJournal) Records() []Record {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]Record(nil), j.records...)
+}
+
+// fileJournal appends records to a JSON lines file
+type fileJournal struct {
+	mu   sync.Mutex
+	path string
+}
+
+// FileJournal keeps records in the JSON lines file at path, which the
+// fileuploader command can read as its journal
+func FileJournal(path string) Journal {
+	return &fileJournal{path: path}
+}
+
+func (j *fileJournal) Record(ctx context.Context, r Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	f, err := os.OpenFile(j.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// record adds the sent file to the Client's journal, if it has one
+func (c *Client) record(ctx context.Context, src Source, target string, result Result) error {
+	if c.config.Journal == nil {
+		return nil
+	}
+	err := c.config.Journal.Record(ctx, Record{
+		Time:      time.Now().UTC(),
+		Source:    sourceID(src),
+		Name:      result.Name,
+		Size:      result.Size,
+		MimeType:  result.MimeType,
+		SHA256:    result.SHA256,
+		Target:    target,
+		MessageID: result.MessageID,
+	})
+	if err != nil {
+		return fmt.Errorf("sent message %d, but failed to record it: %w", result.MessageID, err)
+	}
+	return nil
+}
+
+// sourceID identifies src in records: its path or URL where it has one
+func sourceID(src Source) string {
+	if s, ok := src.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return src.Name()
+}
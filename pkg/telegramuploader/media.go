@@ -0,0 +1,63 @@
+package telegramuploader
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// MaxPhotoSize is the largest image Telegram accepts as a photo; larger
+// ones are sent as documents
+const MaxPhotoSize = 10 << 20
+
+// IsImage reports whether files with the extension ext, like ".jpg", can
+// be sent as photos
+func IsImage(ext string) bool {
+	return slices.Contains([]string{".jpg", ".jpeg", ".png", ".gif", ".webp", ".bmp"}, ext)
+}
+
+// IsVideo reports whether files with the extension ext, like ".mp4", are
+// sent as streamable videos
+func IsVideo(ext string) bool {
+	return slices.Contains([]string{".mp4", ".mov", ".avi", ".mkv", ".webm", ".flv", ".3gp"}, ext)
+}
+
+// MimeType returns the MIME type of a file named filename, going by its
+// extension
+func MimeType(filename string) string {
+	ext := strings.ToLower(filepath.Ext(filename))
+	switch ext {
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	case ".webp":
+		return "image/webp"
+	case ".heic":
+		return "image/heic"
+	case ".heif":
+		return "image/heif"
+	case ".mp4":
+		return "video/mp4"
+	case ".mov":
+		return "video/quicktime"
+	case ".avi":
+		return "video/x-msvideo"
+	case ".mkv":
+		return "video/x-matroska"
+	case ".webm":
+		return "video/webm"
+	case ".mp3":
+		return "audio/mpeg"
+	case ".wav":
+		return "audio/wav"
+	case ".pdf":
+		return "application/pdf"
+	case ".zip":
+		return "application/zip"
+	default:
+		return "application/octet-stream"
+	}
+}
@@ -0,0 +1,119 @@
+package telegramuploader
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// The middlewares below wrap every API call a client makes. Pass them in
+// Config.Middlewares, or in telegram.Options of a client of your own. The
+// first one is called first, so a useful order is FloodWaiter, Retrier,
+// RateLimiter, RequestLogger: waits and retries are then rate limited and
+// logged like the first attempt.
+
+// FloodWaiter waits out FLOOD_WAIT errors of up to max and repeats the
+// call, instead of failing it. Longer waits are returned as they are.
+func FloodWaiter(max time.Duration) telegram.Middleware {
+	return telegram.MiddlewareFunc(func(next tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			for {
+				err := next.Invoke(ctx, input, output)
+				d, ok := tgerr.AsFloodWait(err)
+				if !ok || d > max {
+					return err
+				}
+				if err := sleep(ctx, d); err != nil {
+					return err
+				}
+			}
+		}
+	})
+}
+
+// Retrier repeats calls that fail with an internal server error, up to
+// attempts times in all, waiting longer before each retry
+func Retrier(attempts int) telegram.Middleware {
+	return telegram.MiddlewareFunc(func(next tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			wait := time.Second
+			for i := 1; ; i++ {
+				err := next.Invoke(ctx, input, output)
+				rpcErr, ok := tgerr.As(err)
+				if !ok || rpcErr.Code < 500 || i >= attempts {
+					return err
+				}
+				if err := sleep(ctx, wait); err != nil {
+					return err
+				}
+				wait *= 2
+			}
+		}
+	})
+}
+
+// RateLimiter spaces out calls to at most perSecond a second
+func RateLimiter(perSecond float64) telegram.Middleware {
+	interval := time.Duration(float64(time.Second) / perSecond)
+	var (
+		mu   sync.Mutex
+		next time.Time // when the next call may go
+	)
+	return telegram.MiddlewareFunc(func(inner tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			mu.Lock()
+			now := time.Now()
+			at := next
+			if at.Before(now) {
+				at = now
+			}
+			next = at.Add(interval)
+			mu.Unlock()
+			if err := sleep(ctx, at.Sub(now)); err != nil {
+				return err
+			}
+			return inner.Invoke(ctx, input, output)
+		}
+	})
+}
+
+// RequestLogger logs every call with how long it took and its error, if
+// any, through logf, like log.Printf
+func RequestLogger(logf func(format string, args ...any)) telegram.Middleware {
+	return telegram.MiddlewareFunc(func(next tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			name := "unknown"
+			if t, ok := input.(interface{ TypeName() string }); ok {
+				name = t.TypeName()
+			}
+			start := time.Now()
+			err := next.Invoke(ctx, input, output)
+			if err != nil {
+				logf("%s failed after %s: %v", name, time.Since(start).Round(time.Millisecond), err)
+			} else {
+				logf("%s took %s", name, time.Since(start).Round(time.Millisecond))
+			}
+			return err
+		}
+	})
+}
+
+// sleep waits for d, or until ctx ends
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,76 @@
+package telegramuploader
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gotd/td/telegram/message/peer"
+	"github.com/gotd/td/telegram/query"
+	"github.com/gotd/td/tg"
+)
+
+// ResolvePeer resolves a target given as "me", a numeric chat ID, @username
+// or t.me link into an input peer
+func ResolvePeer(ctx context.Context, api *tg.Client, target string) (tg.InputPeerClass, error) {
+	target = strings.TrimSpace(target)
+	switch strings.ToLower(target) {
+	case "", "me", "self":
+		return &tg.InputPeerSelf{}, nil
+	}
+
+	// Numeric IDs can't be resolved directly, so look them up in the dialogs.
+	// Bot API style IDs (-100… for channels, -… for groups) are accepted too.
+	if id, err := strconv.ParseInt(target, 10, 64); err == nil {
+		return findDialogPeer(ctx, api, NormalizeChatID(id))
+	}
+
+	p, err := peer.Resolve(peer.Plain(api), target)(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %q: %w", target, Classify(err))
+	}
+	return p, nil
+}
+
+// NormalizeChatID strips the Bot API prefixes from a chat ID
+func NormalizeChatID(id int64) int64 {
+	const channelPrefix = 1000000000000
+	switch {
+	case id < -channelPrefix:
+		return -id - channelPrefix
+	case id < 0:
+		return -id
+	default:
+		return id
+	}
+}
+
+// findDialogPeer returns the dialog of the current account with the given peer ID
+func findDialogPeer(ctx context.Context, api *tg.Client, id int64) (tg.InputPeerClass, error) {
+	iter := query.GetDialogs(api).BatchSize(100).Iter()
+	for iter.Next(ctx) {
+		p := iter.Value().Peer
+		if PeerID(p) == id {
+			return p, nil
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list dialogs: %w", err)
+	}
+	return nil, fmt.Errorf("no chat with ID %d found in your dialogs: %w", id, ErrPeerNotFound)
+}
+
+// PeerID returns the bare user, chat or channel ID of p
+func PeerID(p tg.InputPeerClass) int64 {
+	switch p := p.(type) {
+	case *tg.InputPeerUser:
+		return p.UserID
+	case *tg.InputPeerChat:
+		return p.ChatID
+	case *tg.InputPeerChannel:
+		return p.ChannelID
+	default:
+		return 0
+	}
+}
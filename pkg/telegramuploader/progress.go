@@ -0,0 +1,45 @@
+package telegramuploader
+
+import (
+	"context"
+
+	"github.com/gotd/td/telegram/uploader"
+)
+
+// Progress is told how far an upload has got, so that programs can show
+// it their own way. Update is called from the upload's goroutines, after
+// each part Telegram has accepted; total is -1 for readers of unknown
+// size.
+type Progress interface {
+	Update(bytesDone, total int64)
+}
+
+// ProgressFunc lets an ordinary function be a Progress
+type ProgressFunc func(bytesDone, total int64)
+
+// Update calls f
+func (f ProgressFunc) Update(bytesDone, total int64) {
+	f(bytesDone, total)
+}
+
+// uploaderProgress passes the uploader's part confirmations on to a
+// Progress and the Client's events, and holds the next part back while
+// the transfer, if any, is paused
+type uploaderProgress struct {
+	progress Progress
+	events   EventHandler
+	transfer *Transfer
+}
+
+func (p uploaderProgress) Chunk(ctx context.Context, state uploader.ProgressState) error {
+	if p.progress != nil {
+		p.progress.Update(state.Uploaded, state.Total)
+	}
+	if p.events != nil {
+		p.events.HandleEvent(PartUploaded{Job: jobOf(ctx), Part: state.Part, Uploaded: state.Uploaded, Total: state.Total})
+	}
+	if p.transfer != nil {
+		return p.transfer.checkpoint(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package telegramuploader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Source is where the data of an upload comes from. New kinds of sources
+// only need to implement it to be uploaded like the others.
+type Source interface {
+	// Name is the file name the data is sent under
+	Name() string
+	// Size is the size of the data, or -1 if it isn't known. Some
+	// sources only know it once they're open.
+	Size() int64
+	// MimeType is the data's MIME type
+	MimeType() string
+	// Open starts reading the data. Sources backed by a stream can only
+	// be opened once.
+	Open(ctx context.Context) (io.ReadCloser, error)
+}
+
+// LocalFile is a Source backed by a file on disk, which steps that need
+// the whole file, like rendering a thumbnail, can read again
+type LocalFile interface {
+	Source
+	// Path is where the file is
+	Path() string
+}
+
+// ParseSource returns the source s names: "-" for standard input, a URL
+// as ParseURL takes, or otherwise the path of a local file
+func ParseSource(s string) (Source, error) {
+	if s == "-" {
+		return StdinSource("stdin"), nil
+	}
+	if strings.Contains(s, "://") {
+		return ParseURL(s)
+	}
+	return FileSource(s), nil
+}
+
+// ParseURL returns the source of an http, https or s3 URL. s3 URLs are
+// s3://bucket/key and are fetched anonymously, so the object has to be
+// public. Anything without a scheme and a host, like a local path, is
+// rejected.
+func ParseURL(s string) (Source, error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("%q isn't a URL", s)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return URLSource(s), nil
+	case "s3":
+		return URLSource(fmt.Sprintf("https://%s.s3.amazonaws.com/%s", u.Host, strings.TrimPrefix(u.Path, "/"))), nil
+	default:
+		return nil, fmt.Errorf("unsupported source %q", s)
+	}
+}
+
+// fileSource is a local file
+type fileSource struct {
+	path string
+}
+
+// FileSource is the file at path
+func FileSource(path string) Source {
+	return fileSource{path}
+}
+
+func (s fileSource) Name() string     { return filepath.Base(s.path) }
+func (s fileSource) MimeType() string { return MimeType(s.path) }
+func (s fileSource) Path() string     { return s.path }
+
+func (s fileSource) String() string {
+	if abs, err := filepath.Abs(s.path); err == nil {
+		return abs
+	}
+	return s.path
+}
+
+func (s fileSource) Size() int64 {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return -1
+	}
+	return info.Size()
+}
+
+func (s fileSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return file, nil
+}
+
+// readerSource is data read from a reader, once
+type readerSource struct {
+	name string
+	r    io.Reader
+	size int64
+}
+
+// ReaderSource is the data of r, sent as a file named name. size is -1 if
+// it isn't known in advance.
+func ReaderSource(name string, r io.Reader, size int64) Source {
+	return readerSource{name, r, size}
+}
+
+// StdinSource is standard input, sent as a file named name
+func StdinSource(name string) Source {
+	return ReaderSource(name, os.Stdin, -1)
+}
+
+func (s readerSource) Name() string     { return s.name }
+func (s readerSource) Size() int64      { return s.size }
+func (s readerSource) MimeType() string { return MimeType(s.name) }
+
+func (s readerSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	return io.NopCloser(s.r), nil
+}
+
+// urlSource is a file downloaded over HTTP as it's uploaded. Its name and
+// size come from the response once it's open.
+type urlSource struct {
+	url      string
+	name     string
+	size     int64
+	mimeType string
+}
+
+// URLSource is the file at the http or https URL rawURL
+func URLSource(rawURL string) Source {
+	s := &urlSource{url: rawURL, size: -1}
+	if u, err := url.Parse(rawURL); err == nil {
+		s.name = path.Base(u.Path)
+	}
+	return s
+}
+
+func (s *urlSource) Name() string {
+	if s.name == "" || s.name == "/" || s.name == "." {
+		return "downloaded_file"
+	}
+	return s.name
+}
+
+func (s *urlSource) Size() int64    { return s.size }
+func (s *urlSource) String() string { return s.url }
+
+func (s *urlSource) MimeType() string {
+	if t := MimeType(s.Name()); t != "application/octet-stream" || s.mimeType == "" {
+		return t
+	}
+	return s.mimeType
+}
+
+func (s *urlSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("bad status: %s", resp.Status)
+	}
+	// Redirects may lead to a better name
+	s.name = path.Base(resp.Request.URL.Path)
+	s.size = resp.ContentLength
+	s.mimeType, _, _ = strings.Cut(resp.Header.Get("Content-Type"), ";")
+	return resp.Body, nil
+}
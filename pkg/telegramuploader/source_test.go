@@ -0,0 +1,136 @@
+package telegramuploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		in      string
+		url     string // of the source; empty if in is rejected
+		name    string
+		wantErr bool
+	}{
+		{in: "https://example.com/files/report.pdf", url: "https://example.com/files/report.pdf", name: "report.pdf"},
+		{in: "http://example.com/a.txt?x=1", url: "http://example.com/a.txt?x=1", name: "a.txt"},
+		{in: "https://example.com/", url: "https://example.com/", name: "downloaded_file"},
+		{in: "s3://bucket/dir/photo.jpg", url: "https://bucket.s3.amazonaws.com/dir/photo.jpg", name: "photo.jpg"},
+		{in: "/home/user/report.pdf", wantErr: true},
+		{in: "report.pdf", wantErr: true},
+		{in: "example.com/report.pdf", wantErr: true},
+		{in: "https:///report.pdf", wantErr: true},
+		{in: "ftp://example.com/report.pdf", wantErr: true},
+		{in: "file:///etc/passwd", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			src, err := ParseURL(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseURL(%q) = %v, want an error", tt.in, src)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseURL(%q): %v", tt.in, err)
+			}
+			u, ok := src.(*urlSource)
+			if !ok {
+				t.Fatalf("ParseURL(%q) = %T, want a URL source", tt.in, src)
+			}
+			if u.url != tt.url {
+				t.Errorf("URL = %q, want %q", u.url, tt.url)
+			}
+			if src.Name() != tt.name {
+				t.Errorf("Name() = %q, want %q", src.Name(), tt.name)
+			}
+			if src.Size() != -1 {
+				t.Errorf("Size() = %d before opening, want -1", src.Size())
+			}
+		})
+	}
+}
+
+func TestParseSource(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // type of the source, as %T prints it
+	}{
+		{in: "-", want: "telegramuploader.readerSource"},
+		{in: "report.pdf", want: "telegramuploader.fileSource"},
+		{in: "dir/sub/report.pdf", want: "telegramuploader.fileSource"},
+		{in: "https://example.com/report.pdf", want: "*telegramuploader.urlSource"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			src, err := ParseSource(tt.in)
+			if err != nil {
+				t.Fatalf("ParseSource(%q): %v", tt.in, err)
+			}
+			if got := fmt.Sprintf("%T", src); got != tt.want {
+				t.Errorf("ParseSource(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+	if _, err := ParseSource("ftp://example.com/report.pdf"); err == nil {
+		t.Error("ParseSource accepted an ftp URL")
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		data     string
+		mimeType string
+	}{
+		{name: "notes.txt", data: "hello\n", mimeType: "application/octet-stream"},
+		{name: "photo.jpg", data: "\xff\xd8\xff", mimeType: "image/jpeg"},
+		{name: "empty.pdf", data: "", mimeType: "application/pdf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			src := FileSource(path)
+			if src.Name() != tt.name {
+				t.Errorf("Name() = %q, want %q", src.Name(), tt.name)
+			}
+			if src.Size() != int64(len(tt.data)) {
+				t.Errorf("Size() = %d, want %d", src.Size(), len(tt.data))
+			}
+			if src.MimeType() != tt.mimeType {
+				t.Errorf("MimeType() = %q, want %q", src.MimeType(), tt.mimeType)
+			}
+			local, ok := src.(LocalFile)
+			if !ok || local.Path() != path {
+				t.Errorf("FileSource(%q) isn't a LocalFile at its path", path)
+			}
+			r, err := src.Open(context.Background())
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer r.Close()
+			data, err := io.ReadAll(r)
+			if err != nil || string(data) != tt.data {
+				t.Errorf("read %q, %v, want %q", data, err, tt.data)
+			}
+		})
+	}
+
+	missing := FileSource(filepath.Join(dir, "missing.bin"))
+	if missing.Size() != -1 {
+		t.Errorf("Size() of a missing file = %d, want -1", missing.Size())
+	}
+	if _, err := missing.Open(context.Background()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Open of a missing file = %v, want a not-exist error", err)
+	}
+}
@@ -0,0 +1,147 @@
+package telegramuploader
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gotd/td/telegram"
+)
+
+// Transfer is an upload running in the background, which can be paused,
+// resumed and cancelled while it goes on. Pausing takes effect at the next
+// part boundary: parts already on their way finish, and no more are
+// uploaded until the transfer is resumed. A paused transfer doesn't send
+// its message either. Transfers running at the same time share the
+// Client's connection, so a paused one costs no connection of its own.
+type Transfer struct {
+	id     string
+	events EventHandler // nil for no events
+	cancel context.CancelFunc
+	done   chan struct{}
+	result Result
+	err    error
+
+	mu      sync.Mutex
+	resumed chan struct{} // closed unless the transfer is paused
+}
+
+// lastTransferID numbers the transfers of the program
+var lastTransferID atomic.Int64
+
+// Start starts uploading src to target like UploadFile, and returns the
+// transfer's handle at once. The transfer's events carry the job given to
+// ctx with WithJob, or else the transfer's ID.
+func (c *Client) Start(ctx context.Context, src Source, target string, opts Options) *Transfer {
+	ctx, cancel := context.WithCancel(ctx)
+	t := &Transfer{
+		id:      jobOf(ctx),
+		events:  c.config.Events,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		resumed: make(chan struct{}),
+	}
+	if t.id == "" {
+		t.id = strconv.FormatInt(lastTransferID.Add(1), 10)
+		ctx = WithJob(ctx, t.id)
+	}
+	close(t.resumed)
+	t.emit(JobQueued{Job: t.id, Name: src.Name(), Size: src.Size()})
+	go func() {
+		defer close(t.done)
+		defer cancel()
+		t.err = c.Run(ctx, func(ctx context.Context, client *telegram.Client) error {
+			var err error
+			if t.result, err = c.upload(ctx, client, src, target, opts, t); err != nil {
+				return err
+			}
+			return c.record(ctx, src, target, t.result)
+		})
+		if t.err != nil {
+			t.emit(JobFailed{Job: t.id, Err: t.err})
+		} else {
+			t.emit(JobCompleted{Job: t.id, Result: t.result})
+		}
+	}()
+	return t
+}
+
+// ID identifies the transfer in its events
+func (t *Transfer) ID() string {
+	return t.id
+}
+
+// emit sends e to the transfer's event handler, if it has one
+func (t *Transfer) emit(e Event) {
+	if t.events != nil {
+		t.events.HandleEvent(e)
+	}
+}
+
+// Pause stops the transfer at the next part boundary until Resume
+func (t *Transfer) Pause() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	select {
+	case <-t.resumed:
+		t.resumed = make(chan struct{})
+	default:
+		// Paused already
+	}
+}
+
+// Resume continues a paused transfer where it stopped
+func (t *Transfer) Resume() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	select {
+	case <-t.resumed:
+		// Not paused
+	default:
+		close(t.resumed)
+	}
+}
+
+// Paused reports whether the transfer is paused
+func (t *Transfer) Paused() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	select {
+	case <-t.resumed:
+		return false
+	default:
+		return true
+	}
+}
+
+// Cancel stops the transfer, paused or not. Wait then returns an error
+// wrapping context.Canceled, unless the file was sent already.
+func (t *Transfer) Cancel() {
+	t.cancel()
+}
+
+// Done is closed when the transfer has finished, failed or been cancelled
+func (t *Transfer) Done() <-chan struct{} {
+	return t.done
+}
+
+// Wait waits for the transfer to end and returns its result
+func (t *Transfer) Wait() (Result, error) {
+	<-t.done
+	return t.result, t.err
+}
+
+// checkpoint blocks while the transfer is paused. It's called between
+// parts, and returns early if ctx ends.
+func (t *Transfer) checkpoint(ctx context.Context) error {
+	t.mu.Lock()
+	resumed := t.resumed
+	t.mu.Unlock()
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
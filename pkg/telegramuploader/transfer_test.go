@@ -0,0 +1,145 @@
+package telegramuploader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+)
+
+// partSize is the size of the parts the tests upload
+const partSize = 512 * 1024
+
+// fakeParts is an uploader.Client that takes every part, and counts them
+type fakeParts struct {
+	parts atomic.Int32
+}
+
+func (f *fakeParts) UploadSaveFilePart(ctx context.Context, req *tg.UploadSaveFilePartRequest) (bool, error) {
+	f.parts.Add(1)
+	return true, nil
+}
+
+func (f *fakeParts) UploadSaveBigFilePart(ctx context.Context, req *tg.UploadSaveBigFilePartRequest) (bool, error) {
+	f.parts.Add(1)
+	return true, nil
+}
+
+// newTestTransfer returns a transfer that isn't paused, as Start does
+func newTestTransfer() *Transfer {
+	t := &Transfer{resumed: make(chan struct{}), done: make(chan struct{})}
+	close(t.resumed)
+	return t
+}
+
+// startParts uploads parts parts of fake data through transfer, one at a
+// time, calling onPart after each part is taken
+func startParts(ctx context.Context, transfer *Transfer, rpc *fakeParts, parts int, onPart func(done int64)) <-chan error {
+	u := uploader.NewUploader(rpc).
+		WithPartSize(partSize).
+		WithThreads(1).
+		WithProgress(uploaderProgress{progress: ProgressFunc(func(done, total int64) { onPart(done) }), transfer: transfer})
+	data := bytes.Repeat([]byte{0x5a}, parts*partSize)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := u.Upload(ctx, uploader.NewUpload("fake.bin", bytes.NewReader(data), int64(len(data))))
+		errc <- err
+	}()
+	return errc
+}
+
+func TestTransferPauseResume(t *testing.T) {
+	transfer := newTestTransfer()
+	rpc := &fakeParts{}
+	paused := make(chan struct{})
+	errc := startParts(context.Background(), transfer, rpc, 4, func(done int64) {
+		if done == partSize {
+			transfer.Pause()
+			close(paused)
+		}
+	})
+
+	<-paused
+	if !transfer.Paused() {
+		t.Fatal("Paused() = false after Pause")
+	}
+	// No part may go out while paused
+	time.Sleep(100 * time.Millisecond)
+	if n := rpc.parts.Load(); n != 1 {
+		t.Fatalf("%d part(s) uploaded while paused after the first, want 1", n)
+	}
+	select {
+	case err := <-errc:
+		t.Fatalf("upload ended while paused: %v", err)
+	default:
+	}
+
+	transfer.Resume()
+	if transfer.Paused() {
+		t.Fatal("Paused() = true after Resume")
+	}
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("upload failed after resuming: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("upload didn't finish after resuming")
+	}
+	if n := rpc.parts.Load(); n != 4 {
+		t.Errorf("%d part(s) uploaded, want 4", n)
+	}
+}
+
+func TestTransferCancelWhilePaused(t *testing.T) {
+	transfer := newTestTransfer()
+	ctx, cancel := context.WithCancel(context.Background())
+	transfer.cancel = cancel
+	rpc := &fakeParts{}
+	paused := make(chan struct{})
+	errc := startParts(ctx, transfer, rpc, 4, func(done int64) {
+		if done == partSize {
+			transfer.Pause()
+			close(paused)
+		}
+	})
+
+	<-paused
+	transfer.Cancel()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("upload cancelled while paused = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a paused upload didn't stop when cancelled")
+	}
+	if n := rpc.parts.Load(); n != 1 {
+		t.Errorf("%d part(s) uploaded, want only the one before the pause", n)
+	}
+}
+
+func TestTransferPauseTwice(t *testing.T) {
+	transfer := newTestTransfer()
+	transfer.Resume() // not paused: nothing to do
+	if transfer.Paused() {
+		t.Fatal("Resume paused the transfer")
+	}
+	transfer.Pause()
+	transfer.Pause()
+	if !transfer.Paused() {
+		t.Fatal("Paused() = false after pausing twice")
+	}
+	transfer.Resume()
+	if transfer.Paused() {
+		t.Fatal("a transfer paused twice needed more than one Resume")
+	}
+	if err := transfer.checkpoint(context.Background()); err != nil {
+		t.Errorf("checkpoint of a running transfer = %v", err)
+	}
+}
@@ -0,0 +1,385 @@
+package telegramuploader
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// Options says how a file is sent
+type Options struct {
+	Caption      string
+	AsDocument   bool // send images as files rather than compressed photos
+	Silent       bool // send without a notification
+	CaptionAbove bool // show the caption above the file instead of below it
+	Threads      int  // parts uploaded at the same time; 1 if not set
+
+	// Name is the file name the data is sent under, instead of the
+	// source's own
+	Name string
+
+	// Peer is the target already resolved, like the chat a bot command
+	// came from; the target string then only names it
+	Peer tg.InputPeerClass
+
+	// Connections spreads the parts over this many connections, to get
+	// past the throughput of a single one; 0 or 1 for the client's own
+	Connections int
+
+	TopicID     int                 // forum topic the file is sent to; 0 for the chat itself
+	ReplyMarkup tg.ReplyMarkupClass // keyboard attached to the message, for bots
+
+	// Progress is told how much has been uploaded as the upload goes on;
+	// nil for no progress
+	Progress Progress
+
+	// Hooks add steps of the program's own to the upload
+	Hooks Hooks
+}
+
+// Result describes the message a file was sent in
+type Result struct {
+	MessageID int
+	Date      time.Time
+	Name      string
+	Size      int64
+	MimeType  string
+	SHA256    string // hex SHA-256 of the data, hashed as it was uploaded
+}
+
+// Step is an upload under way, as its hooks see it. Hooks may change
+// Peer and Target, like to send somewhere else than asked.
+type Step struct {
+	API    *tg.Client
+	Peer   tg.InputPeerClass // the resolved target
+	Target string            // the target as it was given
+	File   tg.InputFileClass // the uploaded file, once it's uploaded
+
+	// Result is filled in as the upload goes on: the name, size and MIME
+	// type from the start, the size of streams and the hash once the data
+	// is uploaded, and the message once it's sent
+	Result Result
+}
+
+// SendFunc sends the message of an upload, as the upload does unless a
+// Send hook does it instead
+type SendFunc func(ctx context.Context, req *tg.MessagesSendMediaRequest) (*tg.Message, error)
+
+// Hooks are steps a program adds to an upload; unset hooks are skipped.
+// An error from a hook fails the upload.
+type Hooks struct {
+	// Phase wraps each phase of the upload: "resolve", "upload" and
+	// "send". It returns the context the phase runs with and a function
+	// given the phase's error once it ends, which returns the error to
+	// report; it's how timeouts and tracing spans are added.
+	Phase func(ctx context.Context, name string) (context.Context, func(err error) error)
+
+	// Resolved is called once the target is resolved, before anything
+	// is uploaded, to check the upload should go ahead
+	Resolved func(ctx context.Context, step *Step) error
+
+	// Reader wraps the data as the upload reads it, like to limit the
+	// speed or show the progress
+	Reader func(ctx context.Context, step *Step, r io.Reader) io.Reader
+
+	// Parts wraps the client the parts are uploaded with
+	Parts func(rpc uploader.Client) uploader.Client
+
+	// Media is given the media the uploaded file is about to be sent as,
+	// and returns the media to send instead, if any other
+	Media func(ctx context.Context, step *Step, media tg.InputMediaClass) (tg.InputMediaClass, error)
+
+	// Send sends the message instead of the upload, calling send, which
+	// sends req as the upload would, as it sees fit
+	Send func(ctx context.Context, step *Step, req *tg.MessagesSendMediaRequest, send SendFunc) (*tg.Message, error)
+
+	// Sent is called with the sent message. The file is sent even if it
+	// returns an error.
+	Sent func(ctx context.Context, step *Step, msg *tg.Message) error
+}
+
+// phase runs fn as the phase called name
+func (h Hooks) phase(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	if h.Phase == nil {
+		return fn(ctx)
+	}
+	ctx, end := h.Phase(ctx, name)
+	return end(fn(ctx))
+}
+
+// UploadFile uploads src and sends it to target, which is "me" for Saved
+// Messages, a numeric chat ID, @username or t.me link. It connects for the
+// call; cancelling ctx stops the upload. Start does the same in the
+// background, for uploads that are paused or cancelled along the way.
+func (c *Client) UploadFile(ctx context.Context, src Source, target string, opts Options) (Result, error) {
+	return c.Start(ctx, src, target, opts).Wait()
+}
+
+// Upload is UploadFile over a client already connected with Run. It
+// doesn't keep a record in the Client's journal.
+func (c *Client) Upload(ctx context.Context, client *telegram.Client, src Source, target string, opts Options) (Result, error) {
+	return c.upload(ctx, client, src, target, opts, nil)
+}
+
+// upload uploads and sends src, stopping between parts while transfer, if
+// any, is paused. The result holds the message even if a Sent hook fails.
+func (c *Client) upload(ctx context.Context, client *telegram.Client, src Source, target string, opts Options, transfer *Transfer) (Result, error) {
+	hooks := opts.Hooks
+	api := client.API()
+
+	// Open the source first: streams are only measured once they're open
+	r, err := src.Open(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	defer r.Close()
+	step := &Step{API: api, Peer: opts.Peer, Target: target}
+	step.Result = Result{Name: src.Name(), Size: src.Size(), MimeType: src.MimeType()}
+	if opts.Name != "" {
+		step.Result.Name, step.Result.MimeType = opts.Name, MimeType(opts.Name)
+	}
+	name, size := step.Result.Name, step.Result.Size
+	if name == "" {
+		return Result{}, errors.New("the source needs a name")
+	}
+	if size > MaxFileSize {
+		return Result{}, fmt.Errorf("%s is %d MB, more than Telegram's limit of %d MB: %w", name, size>>20, MaxFileSize>>20, ErrFileTooLarge)
+	}
+
+	err = hooks.phase(ctx, "resolve", func(ctx context.Context) error {
+		if step.Peer == nil {
+			if step.Peer, err = ResolvePeer(ctx, api, target); err != nil {
+				return err
+			}
+		}
+		if hooks.Resolved != nil {
+			return hooks.Resolved(ctx, step)
+		}
+		return nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	// Spread the parts over several connections, with a part in flight
+	// on each
+	var rpc uploader.Client = api
+	if opts.Connections > 1 {
+		pool, err := client.Pool(int64(opts.Connections))
+		if err != nil {
+			return Result{}, fmt.Errorf("failed to open connections: %w", err)
+		}
+		defer pool.Close()
+		rpc = tg.NewClient(pool)
+	}
+	if hooks.Parts != nil {
+		rpc = hooks.Parts(rpc)
+	}
+	var data io.Reader = r
+	if hooks.Reader != nil {
+		data = hooks.Reader(ctx, step, data)
+	}
+	// Streams of unknown size are measured as they're read, and every
+	// upload is hashed on the way instead of reading it a second time
+	counter := &countingReader{Reader: data}
+	hasher := sha256.New()
+	u := uploader.NewUploader(rpc).
+		WithPartSize(512 * 1024).
+		WithThreads(max(opts.Threads, opts.Connections, 1)).
+		WithProgress(uploaderProgress{opts.Progress, c.config.Events, transfer})
+	err = hooks.phase(ctx, "upload", func(ctx context.Context) error {
+		if step.File, err = u.Upload(ctx, uploader.NewUpload(name, io.TeeReader(counter, hasher), size)); err != nil {
+			return fmt.Errorf("upload failed: %w", Classify(err))
+		}
+		return nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	if size < 0 {
+		size = counter.n
+		step.Result.Size = size
+	}
+	step.Result.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+
+	media := Media(step.File, name, step.Result.MimeType, size, opts.AsDocument)
+	if hooks.Media != nil {
+		if media, err = hooks.Media(ctx, step, media); err != nil {
+			return Result{}, err
+		}
+	}
+	if transfer != nil {
+		if err := transfer.checkpoint(ctx); err != nil {
+			return Result{}, err
+		}
+	}
+
+	req := &tg.MessagesSendMediaRequest{
+		InvertMedia: opts.CaptionAbove,
+		Silent:      opts.Silent,
+		Peer:        step.Peer,
+		Media:       media,
+		Message:     opts.Caption,
+		ReplyMarkup: opts.ReplyMarkup,
+	}
+	if opts.TopicID != 0 {
+		req.ReplyTo = &tg.InputReplyToMessage{ReplyToMsgID: opts.TopicID}
+	}
+	if req.RandomID, err = randomID(); err != nil {
+		return Result{}, err
+	}
+	send := func(ctx context.Context, req *tg.MessagesSendMediaRequest) (*tg.Message, error) {
+		updates, err := SendMedia(ctx, api, req)
+		if _, isPhoto := req.Media.(*tg.InputMediaUploadedPhoto); isPhoto && PhotoRejected(err) {
+			// The upload itself is fine; only the photo processing failed
+			c.logf("Telegram rejected the photo (%v); sending it as a document instead", err)
+			req.Media = Media(step.File, name, step.Result.MimeType, size, true)
+			updates, err = SendMedia(ctx, api, req)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to send file: %w", Classify(err))
+		}
+		return SentMessage(updates)
+	}
+	var msg *tg.Message
+	err = hooks.phase(ctx, "send", func(ctx context.Context) error {
+		if hooks.Send != nil {
+			msg, err = hooks.Send(ctx, step, req, send)
+		} else {
+			msg, err = send(ctx, req)
+		}
+		return err
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	step.Result.MessageID = msg.ID
+	step.Result.Date = time.Unix(int64(msg.Date), 0)
+	if hooks.Sent != nil {
+		if err := hooks.Sent(ctx, step, msg); err != nil {
+			return step.Result, err
+		}
+	}
+	return step.Result, nil
+}
+
+// Media is the media a file uploaded as upload is sent as: a photo for
+// images small enough, unless asDocument is set, a streamable video for
+// videos and a document otherwise. size is -1 if it isn't known.
+func Media(upload tg.InputFileClass, name, mimeType string, size int64, asDocument bool) tg.InputMediaClass {
+	ext := strings.ToLower(filepath.Ext(name))
+	switch {
+	case IsImage(ext) && size >= 0 && size <= MaxPhotoSize && !asDocument:
+		return &tg.InputMediaUploadedPhoto{File: upload}
+	case IsVideo(ext):
+		return &tg.InputMediaUploadedDocument{
+			File:     upload,
+			MimeType: mimeType,
+			Attributes: []tg.DocumentAttributeClass{
+				&tg.DocumentAttributeFilename{FileName: name},
+				&tg.DocumentAttributeVideo{SupportsStreaming: true},
+			},
+		}
+	default:
+		return &tg.InputMediaUploadedDocument{
+			File:     upload,
+			MimeType: mimeType,
+			Attributes: []tg.DocumentAttributeClass{
+				&tg.DocumentAttributeFilename{FileName: name},
+			},
+		}
+	}
+}
+
+// PhotoRejected reports whether err means Telegram couldn't process an
+// image as a photo, although it would accept it as a document
+func PhotoRejected(err error) bool {
+	return tgerr.Is(err, "PHOTO_INVALID_DIMENSIONS", "PHOTO_SAVE_FILE_INVALID", "PHOTO_EXT_INVALID",
+		"PHOTO_INVALID", "PHOTO_FILE_MISSING", "IMAGE_PROCESS_FAILED")
+}
+
+// SendMedia sends req, retrying failures below the RPC layer, where it's
+// unknown whether the message arrived. The random ID stays the same, so
+// Telegram rejects a retry of an attempt that did arrive as a duplicate.
+func SendMedia(ctx context.Context, api *tg.Client, req *tg.MessagesSendMediaRequest) (tg.UpdatesClass, error) {
+	for attempt := 1; ; attempt++ {
+		updates, err := api.MessagesSendMedia(ctx, req)
+		if _, isRPC := tgerr.As(err); err == nil || isRPC || attempt == 3 || ctx.Err() != nil {
+			return updates, err
+		}
+		select {
+		case <-time.After(time.Duration(attempt) * 2 * time.Second):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// SentMessage returns the message created or edited by a send request
+func SentMessage(updates tg.UpdatesClass) (*tg.Message, error) {
+	var list []tg.UpdateClass
+	switch u := updates.(type) {
+	case *tg.Updates:
+		list = u.Updates
+	case *tg.UpdatesCombined:
+		list = u.Updates
+	case *tg.UpdateShortSentMessage:
+		return &tg.Message{ID: u.ID, Date: u.Date, Media: u.Media}, nil
+	default:
+		return nil, fmt.Errorf("unexpected response type %T", updates)
+	}
+
+	for _, update := range list {
+		switch u := update.(type) {
+		case *tg.UpdateNewMessage:
+			if msg, ok := u.Message.(*tg.Message); ok {
+				return msg, nil
+			}
+		case *tg.UpdateNewChannelMessage:
+			if msg, ok := u.Message.(*tg.Message); ok {
+				return msg, nil
+			}
+		case *tg.UpdateEditMessage:
+			if msg, ok := u.Message.(*tg.Message); ok {
+				return msg, nil
+			}
+		case *tg.UpdateEditChannelMessage:
+			if msg, ok := u.Message.(*tg.Message); ok {
+				return msg, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("sent message not found in response")
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// randomID returns a random ID for a send request
+func randomID() (int64, error) {
+	var id int64
+	err := binary.Read(rand.Reader, binary.LittleEndian, &id)
+	return id, err
+}
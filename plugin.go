@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+)
+
+// pluginDirEnv overrides where plugins are looked for
+const pluginDirEnv = "FILEUPLOADER_PLUGINS"
+
+// pluginPrefix starts the file names of plugins, which are
+// fileuploader-source-<scheme> for sources of scheme:// URLs and
+// fileuploader-notify-<type> for notifiers used with -notify <type>
+const pluginPrefix = "fileuploader-"
+
+// pluginDir returns the directory plugins are looked for in:
+// $FILEUPLOADER_PLUGINS, or fileuploader/plugins in the user's config
+// directory. It's never relative to the working directory, where a checkout
+// or an unpacked download could bring programs that would then run.
+func pluginDir() (string, error) {
+	if dir := os.Getenv(pluginDirEnv); dir != "" {
+		if !filepath.IsAbs(dir) {
+			return "", withExitCode(exitUsage, fmt.Errorf("%s must be an absolute path, not %q", pluginDirEnv, dir))
+		}
+		return dir, nil
+	}
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the plugin directory: %w; set %s", err, pluginDirEnv)
+	}
+	return filepath.Join(config, "fileuploader", "plugins"), nil
+}
+
+// findPlugin returns the path of the plugin of kind ("source" or
+// "notify") called name in dir, and whether there is one
+func findPlugin(dir, kind, name string) (string, bool) {
+	file := pluginPrefix + kind + "-" + strings.ToLower(name)
+	if runtime.GOOS == "windows" {
+		file += ".exe"
+	}
+	path := filepath.Join(dir, file)
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() || (runtime.GOOS != "windows" && info.Mode()&0111 == 0) {
+		return "", false
+	}
+	return path, true
+}
+
+// parseURL is telegramuploader.ParseURL, with the URL schemes of source
+// plugins added. Values that aren't URLs are usage errors.
+func parseURL(s string) (telegramuploader.Source, error) {
+	if u, err := url.Parse(s); err == nil && u.Host != "" {
+		switch u.Scheme {
+		case "http", "https", "s3":
+		default:
+			dir, err := pluginDir()
+			if err != nil {
+				return nil, err
+			}
+			path, ok := findPlugin(dir, "source", u.Scheme)
+			if !ok {
+				return nil, withExitCode(exitUsage, fmt.Errorf("no plugin for %s:// URLs in %s", u.Scheme, dir))
+			}
+			return telegramuploader.ExecSource(path, s), nil
+		}
+	}
+	src, err := telegramuploader.ParseURL(s)
+	if err != nil {
+		return nil, withExitCode(exitUsage, err)
+	}
+	return src, nil
+}
+
+// notification is what a notifier plugin is given on its standard input
+type notification struct {
+	Title   string `json:"title"`
+	Message string `json:"message"`
+	Subject string `json:"subject"`         // what finished or failed, like a file name
+	Error   string `json:"error,omitempty"` // set if it failed
+}
+
+// notifyPlugin runs the notifier plugin called name with n. A plugin that
+// fails may explain why with {"error": "..."} on its standard output.
+func notifyPlugin(name string, n notification) error {
+	dir, err := pluginDir()
+	if err != nil {
+		return err
+	}
+	path, ok := findPlugin(dir, "notify", name)
+	if !ok {
+		return fmt.Errorf("no notifier plugin %q in %s", name, dir)
+	}
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	var stdout bytes.Buffer
+	cmd := exec.Command(path)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		var answer struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(stdout.Bytes(), &answer) == nil && answer.Error != "" {
+			return errors.New(answer.Error)
+		}
+		return err
+	}
+	return nil
+}
+
+// runPlugins implements the "plugins" subcommand, which lists the plugins
+// found in the plugin directory
+func runPlugins(args []string) error {
+	flags := flag.NewFlagSet("plugins", flag.ExitOnError)
+	flags.Parse(args)
+
+	dir, err := pluginDir()
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("No plugin directory %s; set %s to use another one\n", dir, pluginDirEnv)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read plugin directory: %w", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAME\tPATH")
+	var found int
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".exe")
+		kind, plugin, ok := strings.Cut(strings.TrimPrefix(name, pluginPrefix), "-")
+		if !strings.HasPrefix(name, pluginPrefix) || !ok || plugin == "" {
+			continue
+		}
+		if _, ok := findPlugin(dir, kind, plugin); !ok || (kind != "source" && kind != "notify") {
+			continue
+		}
+		if kind == "source" {
+			plugin += "://"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", kind, plugin, filepath.Join(dir, e.Name()))
+		found++
+	}
+	if found == 0 {
+		fmt.Printf("No plugins in %s\n", dir)
+		return nil
+	}
+	return w.Flush()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	_ "net/http/pprof" // registers the /debug/pprof handlers
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+)
+
+// stopProfiling writes the profiles asked for with the profiling flags. It's
+// called when main returns and by fatal, since os.Exit skips deferred calls;
+// only the first call does anything.
+var stopProfiling = func() {}
+
+// profileFlags registers the profiling flags on fs. The returned function
+// starts profiling as they ask once fs has been parsed.
+func profileFlags(fs *flag.FlagSet) func() error {
+	addr := fs.String("pprof", "", "Serve the pprof endpoints on this address, like :6060, while running")
+	cpuPath := fs.String("cpu-profile", "", "Write a CPU profile of the run to this file")
+	heapPath := fs.String("heap-profile", "", "Write a heap profile to this file on exit")
+	return func() error {
+		if *addr != "" {
+			ln, err := net.Listen("tcp", *addr)
+			if err != nil {
+				return withExitCode(exitUsage, fmt.Errorf("failed to listen on -pprof address: %w", err))
+			}
+			fmt.Printf("Serving profiles on http://%s/debug/pprof/\n", ln.Addr())
+			go func() {
+				log.Printf("pprof server stopped: %v", http.Serve(ln, nil))
+			}()
+		}
+
+		var cpuFile *os.File
+		if *cpuPath != "" {
+			f, err := os.Create(*cpuPath)
+			if err != nil {
+				return fmt.Errorf("failed to create CPU profile: %w", err)
+			}
+			if err := pprof.StartCPUProfile(f); err != nil {
+				f.Close()
+				return fmt.Errorf("failed to start CPU profile: %w", err)
+			}
+			cpuFile = f
+		}
+
+		var once sync.Once
+		stopProfiling = func() {
+			once.Do(func() {
+				if cpuFile != nil {
+					pprof.StopCPUProfile()
+					cpuFile.Close()
+					fmt.Printf("CPU profile written to %s\n", *cpuPath)
+				}
+				if *heapPath != "" {
+					if err := writeHeapProfile(*heapPath); err != nil {
+						log.Printf("Failed to write heap profile: %v", err)
+					} else {
+						fmt.Printf("Heap profile written to %s\n", *heapPath)
+					}
+				}
+			})
+		}
+		return nil
+	}
+}
+
+// writeHeapProfile writes a profile of the live heap to path
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Get up-to-date statistics
+	runtime.GC()
+	return pprof.WriteHeapProfile(f)
+}
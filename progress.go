@@ -0,0 +1,474 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+	"golang.org/x/term"
+)
+
+// How transfer progress is displayed, set from the -progress flags. It goes
+// to stderr by default so it doesn't mix with output meant for pipes.
+var (
+	progressMode             = "bar" // "bar", "plain", "json" or "none"
+	progressOut    io.Writer = os.Stderr
+	progressFormat           = json.NewEncoder(os.Stderr)
+)
+
+// progressFlags registers the progress display flags on fs. The returned
+// function applies them once fs has been parsed.
+func progressFlags(fs *flag.FlagSet) func() error {
+	mode := fs.String("progress", "bar", "Progress display: bar, plain for periodic lines, or json for one JSON object per update")
+	fd := fs.Int("progress-fd", 2, "File descriptor progress is written to (1 for stdout)")
+	noProgress := fs.Bool("no-progress", false, "Don't show progress at all")
+	return func() error {
+		switch *mode {
+		case "bar", "plain", "json":
+		default:
+			return withExitCode(exitUsage, fmt.Errorf("invalid -progress value %q", *mode))
+		}
+		progressMode = *mode
+		if *noProgress {
+			progressMode = "none"
+		}
+		switch *fd {
+		case 1:
+			progressOut = os.Stdout
+		case 2:
+		default:
+			f := os.NewFile(uintptr(*fd), fmt.Sprintf("fd%d", *fd))
+			if f == nil {
+				return withExitCode(exitUsage, fmt.Errorf("invalid -progress-fd %d", *fd))
+			}
+			progressOut = f
+		}
+		progressFormat = json.NewEncoder(progressOut)
+		if progressMode == "bar" && plainOutput(progressOut) {
+			progressMode = "plain"
+		}
+		return nil
+	}
+}
+
+// newProgressBar creates the progress bar shown for a transfer of size
+// bytes, width characters wide
+func newProgressBar(size int64, description string, width int) *progressbar.ProgressBar {
+	return progressbar.NewOptions64(
+		size,
+		progressbar.OptionSetWriter(progressOut),
+		progressbar.OptionSetDescription(description),
+		progressbar.OptionShowBytes(true),
+		progressbar.OptionSetWidth(width),
+		progressbar.OptionThrottle(100*time.Millisecond),
+		progressbar.OptionShowCount(),
+		progressbar.OptionOnCompletion(func() { fmt.Fprintln(progressOut) }),
+		progressbar.OptionSetRenderBlankState(true),
+	)
+}
+
+// progressBarWidth returns the bar width that fits the terminal progress is
+// shown on, leaving reserve characters for the description and counters
+func progressBarWidth(reserve int) int {
+	width := 80
+	if f, ok := progressOut.(*os.File); ok {
+		if w, _, err := term.GetSize(int(f.Fd())); err == nil {
+			width = w
+		}
+	}
+	return min(max(width-reserve, 10), 50)
+}
+
+// plainOutput reports whether w should get plain progress lines instead of
+// a bar: when it isn't a terminal, the terminal is dumb or NO_COLOR is set
+func plainOutput(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return true
+	}
+	f, ok := w.(*os.File)
+	return !ok || !term.IsTerminal(int(f.Fd()))
+}
+
+// progressEvent is one line of -progress json output
+type progressEvent struct {
+	Phase string      `json:"phase"` // "download", "transcode", "upload" or "done"
+	File  string      `json:"file"`
+	Bytes int64       `json:"bytes"`
+	Total int64       `json:"total"`         // -1 if unknown
+	Speed float64     `json:"speed"`         // bytes per second
+	ETA   float64     `json:"eta,omitempty"` // seconds
+	Batch *batchEvent `json:"batch,omitempty"`
+}
+
+// batchEvent is the progress of the batch a transfer belongs to
+type batchEvent struct {
+	File  int     `json:"file"`
+	Files int     `json:"files"`
+	Bytes int64   `json:"bytes"`
+	Total int64   `json:"total"`
+	ETA   float64 `json:"eta,omitempty"`
+}
+
+// batchProgress tracks the overall progress of a run uploading several
+// files, one after the other or several at once
+type batchProgress struct {
+	mu    sync.Mutex
+	files int
+	total int64
+	file  int       // number of files started so far
+	done  int64     // bytes transferred so far, including the current files
+	start time.Time // when the first file started
+
+	bars *multiBar // draws the files' bars together while several run at once
+}
+
+// newBatchProgress starts tracking a batch of files totalling total bytes
+func newBatchProgress(files int, total int64) *batchProgress {
+	return &batchProgress{files: files, total: total}
+}
+
+// startFile moves on to the next file of the batch
+func (b *batchProgress) startFile() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.file == 0 {
+		b.start = time.Now()
+	}
+	b.file++
+}
+
+// finishFile accounts for the whole of a file of size bytes, of which
+// transferred were counted, whether it was transferred completely or not
+func (b *batchProgress) finishFile(size, transferred int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done += size - transferred
+}
+
+func (b *batchProgress) add(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done += n
+}
+
+// event returns the current state of the batch
+func (b *batchProgress) event() *batchEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e := &batchEvent{File: b.file, Files: b.files, Bytes: b.done, Total: b.total}
+	if elapsed := time.Since(b.start).Seconds(); b.done > 0 && elapsed > 1 {
+		e.ETA = float64(b.total-b.done) / (float64(b.done) / elapsed)
+	}
+	return e
+}
+
+// String describes the batch as "file 17/230, 4.2/18.9 GB, ETA 1h12m"
+func (b *batchProgress) String() string {
+	e := b.event()
+	unit, div := "MB", float64(1<<20)
+	if e.Total >= 1<<30 {
+		unit, div = "GB", float64(1<<30)
+	}
+	s := fmt.Sprintf("file %d/%d, %.1f/%.1f %s", e.File, e.Files, float64(e.Bytes)/div, float64(e.Total)/div, unit)
+	if e.ETA > 0 {
+		s += fmt.Sprintf(", ETA %s", (time.Duration(e.ETA) * time.Second).Round(time.Second))
+	}
+	return s
+}
+
+// transferControl lets a transfer be watched and paused from outside, for
+// the interactive sync view
+type transferControl struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	paused bool
+	bytes  atomic.Int64
+}
+
+func newTransferControl() *transferControl {
+	c := &transferControl{}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// setPaused pauses or resumes the transfer
+func (c *transferControl) setPaused(paused bool) {
+	c.mu.Lock()
+	c.paused = paused
+	c.mu.Unlock()
+	c.cond.Broadcast()
+}
+
+func (c *transferControl) isPaused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// wait blocks while the transfer is paused
+func (c *transferControl) wait() {
+	c.mu.Lock()
+	for c.paused {
+		c.cond.Wait()
+	}
+	c.mu.Unlock()
+}
+
+// fileProgress displays the progress of one transfer, and of its batch if
+// it's part of one
+type fileProgress struct {
+	phase   string
+	name    string
+	mu      sync.Mutex               // guards bar, which is replaced when the terminal is resized
+	bar     *progressbar.ProgressBar // nil unless progress is shown as a bar
+	batch   *batchProgress
+	control *transferControl
+	size    int64
+	bytes   atomic.Int64
+	start   time.Time
+	done    chan struct{}
+}
+
+// newFileProgress starts displaying the transfer of size bytes of name, or
+// of a running byte count if size is -1; phase is "upload", "download" or
+// "transcode" and batch may be nil
+func newFileProgress(phase, name string, size int64, batch *batchProgress) *fileProgress {
+	p := &fileProgress{
+		phase: phase,
+		name:  name,
+		batch: batch,
+		size:  size,
+		start: time.Now(),
+		done:  make(chan struct{}),
+	}
+	if batch != nil {
+		batch.startFile()
+	}
+	switch progressMode {
+	case "none":
+		return p
+	case "bar":
+		if batch != nil && batch.bars != nil {
+			batch.bars.add(p)
+			return p
+		}
+		if batch != nil {
+			fmt.Fprintf(progressOut, "[%s]\n", batch)
+		}
+		p.bar = newProgressBar(size, p.verb(), p.barWidth())
+	}
+
+	// Update the display periodically
+	go func() {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		resize := make(chan os.Signal, 1)
+		if p.bar != nil {
+			notifyResize(resize)
+			defer signal.Stop(resize)
+		}
+		lastLine := time.Now()
+		for {
+			select {
+			case <-ticker.C:
+				switch progressMode {
+				case "json":
+					p.emit(p.phase)
+				case "plain":
+					if time.Since(lastLine) >= plainInterval {
+						fmt.Fprintln(progressOut, p.line())
+						lastLine = time.Now()
+					}
+				default:
+					p.mu.Lock()
+					p.bar.Describe(p.description())
+					p.mu.Unlock()
+				}
+			case <-resize:
+				// Redraw the bar at the new width
+				p.mu.Lock()
+				fmt.Fprint(progressOut, "\r\033[2K")
+				p.bar = newProgressBar(p.size, p.description(), p.barWidth())
+				p.bar.Set64(p.bytes.Load())
+				p.mu.Unlock()
+			case <-p.done:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// plainInterval is how often -progress plain prints a line
+const plainInterval = 5 * time.Second
+
+func (p *fileProgress) verb() string {
+	switch p.phase {
+	case "download":
+		return "Downloading"
+	case "transcode":
+		return "Transcoding"
+	}
+	return "Uploading"
+}
+
+// barWidth returns the width of the bar, leaving room for the description
+func (p *fileProgress) barWidth() int {
+	if p.batch != nil {
+		return progressBarWidth(110)
+	}
+	return progressBarWidth(75)
+}
+
+// description returns the text shown next to the bar
+func (p *fileProgress) description() string {
+	speed := p.speed()
+	desc := fmt.Sprintf("%s (%.2f MB/s", p.verb(), speed/(1024*1024))
+	if speed > 0 && p.size > 0 {
+		eta := time.Duration(float64(p.size-p.bytes.Load())/speed) * time.Second
+		desc += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+	desc += ")"
+	if p.batch != nil {
+		desc += " [" + p.batch.String() + "]"
+	}
+	return desc
+}
+
+// line returns the progress as a line of -progress plain output
+func (p *fileProgress) line() string {
+	done := p.bytes.Load()
+	var s string
+	if p.size > 0 {
+		s = fmt.Sprintf("%s %s: %d%% (%.2f/%.2f MB, %.2f MB/s)", p.verb(), p.name, done*100/p.size,
+			float64(done)/(1024*1024), float64(p.size)/(1024*1024), p.speed()/(1024*1024))
+	} else {
+		s = fmt.Sprintf("%s %s: %.2f MB (%.2f MB/s)", p.verb(), p.name, float64(done)/(1024*1024), p.speed()/(1024*1024))
+	}
+	if p.batch != nil {
+		s += " [" + p.batch.String() + "]"
+	}
+	return s
+}
+
+// speed returns the average transfer speed in bytes per second
+func (p *fileProgress) speed() float64 {
+	elapsed := time.Since(p.start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(p.bytes.Load()) / elapsed
+}
+
+// emit writes the current progress as a JSON line
+func (p *fileProgress) emit(phase string) {
+	e := progressEvent{
+		Phase: phase,
+		File:  p.name,
+		Bytes: p.bytes.Load(),
+		Total: p.size,
+		Speed: p.speed(),
+	}
+	if e.Speed > 0 && p.size > 0 {
+		e.ETA = float64(p.size-e.Bytes) / e.Speed
+	}
+	if p.batch != nil {
+		e.Batch = p.batch.event()
+	}
+	progressFormat.Encode(e)
+}
+
+// reader wraps r to update the progress as it's read
+func (p *fileProgress) reader(r io.Reader) io.Reader {
+	if p.control != nil {
+		p.control.bytes.Store(0)
+	}
+	return &progressReader{Reader: r, progress: p}
+}
+
+// finish stops updating the display
+func (p *fileProgress) finish() {
+	close(p.done)
+	size := p.size
+	if size < 0 {
+		size = p.bytes.Load()
+	}
+	if p.batch != nil {
+		p.batch.finishFile(size, p.bytes.Load())
+	}
+	switch progressMode {
+	case "json":
+		p.emit("done")
+	case "plain":
+		fmt.Fprintln(progressOut, p.line())
+	case "bar":
+		if p.batch != nil && p.batch.bars != nil {
+			p.batch.bars.remove(p)
+			return
+		}
+		// A bar of unknown size is a byte counter that never completes
+		if p.size < 0 {
+			fmt.Fprintln(progressOut)
+		}
+	}
+}
+
+// progressReader is an io.Reader that updates a progress display as data is read
+type progressReader struct {
+	io.Reader
+	progress *fileProgress
+}
+
+// Read implements io.Reader
+func (pr *progressReader) Read(p []byte) (n int, err error) {
+	if pr.progress.control != nil {
+		pr.progress.control.wait()
+	}
+	n, err = pr.Reader.Read(p)
+	pr.progress.add(n)
+	return
+}
+
+// writer returns w counting the bytes written to it toward the progress
+func (p *fileProgress) writer(w io.Writer) io.Writer {
+	return &progressWriter{Writer: w, progress: p}
+}
+
+// progressWriter is the io.Writer counterpart of progressReader
+type progressWriter struct {
+	io.Writer
+	progress *fileProgress
+}
+
+// Write implements io.Writer
+func (pw *progressWriter) Write(p []byte) (n int, err error) {
+	n, err = pw.Writer.Write(p)
+	pw.progress.add(n)
+	return
+}
+
+// add counts n more bytes transferred
+func (p *fileProgress) add(n int) {
+	if n <= 0 {
+		return
+	}
+	p.bytes.Add(int64(n))
+	if p.control != nil {
+		p.control.bytes.Add(int64(n))
+	}
+	if p.bar != nil {
+		p.mu.Lock()
+		p.bar.Add(n)
+		p.mu.Unlock()
+	}
+	if p.batch != nil {
+		p.batch.add(int64(n))
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/gotd/td/tg"
+)
+
+// retentionPolicy decides which snapshots survive a forget. Within every
+// period the newest snapshot is kept, for as many periods as configured.
+type retentionPolicy struct {
+	Last    int
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+func (p retentionPolicy) empty() bool {
+	return p.Last == 0 && p.Daily == 0 && p.Weekly == 0 && p.Monthly == 0
+}
+
+// apply splits snapshots into those to keep and those to forget. Snapshots of
+// different roots are handled independently.
+func (p retentionPolicy) apply(snapshots []*snapshot) (keep, forget []*snapshot) {
+	byRoot := map[string][]*snapshot{}
+	for _, s := range snapshots {
+		byRoot[s.Root] = append(byRoot[s.Root], s)
+	}
+
+	for _, group := range byRoot {
+		sort.Slice(group, func(i, j int) bool { return group[i].Time.After(group[j].Time) })
+
+		buckets := []struct {
+			remaining int
+			period    func(s *snapshot) string
+			last      string
+		}{
+			{p.Last, func(s *snapshot) string { return s.ID }, ""},
+			{p.Daily, func(s *snapshot) string { return s.Time.Format("2006-01-02") }, ""},
+			{p.Weekly, func(s *snapshot) string {
+				year, week := s.Time.ISOWeek()
+				return fmt.Sprintf("%d-%02d", year, week)
+			}, ""},
+			{p.Monthly, func(s *snapshot) string { return s.Time.Format("2006-01") }, ""},
+		}
+
+		for _, s := range group {
+			kept := false
+			for i := range buckets {
+				b := &buckets[i]
+				if b.remaining == 0 {
+					continue
+				}
+				if period := b.period(s); period != b.last {
+					b.last = period
+					b.remaining--
+					kept = true
+				}
+			}
+			if kept {
+				keep = append(keep, s)
+			} else {
+				forget = append(forget, s)
+			}
+		}
+	}
+	return keep, forget
+}
+
+// forgetSnapshots removes the snapshots not retained by policy from the
+// index and deletes their copies from the repository. The chunks they
+// reference are left for prune.
+func forgetSnapshots(ctx context.Context, api *tg.Client, peer tg.InputPeerClass, idx *backupIndex, policy retentionPolicy, dryRun bool) error {
+	keep, forget := policy.apply(idx.Snapshots)
+	for _, s := range forget {
+		fmt.Printf("forget %s  %s  %s\n", s.ShortID(), s.Time.Local().Format("2006-01-02 15:04"), s.Root)
+	}
+	fmt.Printf("%d snapshot(s) kept, %d to forget\n", len(keep), len(forget))
+	if dryRun || len(forget) == 0 {
+		return nil
+	}
+
+	var ids []int
+	for _, s := range forget {
+		if s.MessageID != 0 {
+			ids = append(ids, s.MessageID)
+		}
+	}
+	if err := deleteMessagesBatched(ctx, api, peer, ids); err != nil {
+		return fmt.Errorf("failed to delete snapshot messages: %w", err)
+	}
+	idx.Snapshots = keep
+	return idx.save()
+}
+
+// pruneChunks deletes stored chunks that no snapshot references anymore
+func pruneChunks(ctx context.Context, api *tg.Client, peer tg.InputPeerClass, idx *backupIndex, dryRun bool) error {
+	referenced := map[string]bool{}
+	for _, s := range idx.Snapshots {
+		for _, n := range s.Nodes {
+			for _, c := range n.Chunks {
+				referenced[c] = true
+			}
+		}
+	}
+
+	var (
+		unused []string
+		ids    []int
+		size   int64
+	)
+	for id, ref := range idx.Chunks {
+		if !referenced[id] {
+			unused = append(unused, id)
+			ids = append(ids, ref.MessageID)
+			size += int64(ref.Size)
+		}
+	}
+	fmt.Printf("%d of %d chunk(s) unreferenced (%.2f MB)\n", len(unused), len(idx.Chunks), float64(size)/(1024*1024))
+	if dryRun || len(unused) == 0 {
+		return nil
+	}
+
+	if err := deleteMessagesBatched(ctx, api, peer, ids); err != nil {
+		return fmt.Errorf("failed to delete chunk messages: %w", err)
+	}
+	for _, id := range unused {
+		delete(idx.Chunks, id)
+	}
+	if err := idx.save(); err != nil {
+		return err
+	}
+	fmt.Printf("Reclaimed %.2f MB\n", float64(size)/(1024*1024))
+	return nil
+}
+
+// deleteMessagesBatched deletes ids in batches of the size Telegram accepts
+func deleteMessagesBatched(ctx context.Context, api *tg.Client, peer tg.InputPeerClass, ids []int) error {
+	const batch = 100
+	for len(ids) > 0 {
+		n := min(batch, len(ids))
+		if err := deleteMessages(ctx, api, peer, ids[:n]); err != nil {
+			return err
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter allows a number of actions per minute for each key, like a
+// chat or user ID, so a flood of requests can't drive the account into
+// FLOOD_WAITs that would hold up everything else it does
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration       // between actions at the sustained rate
+	burst    time.Duration       // how far ahead of the rate a key may get
+	next     map[int64]time.Time // when each key is back at the sustained rate
+}
+
+// newRateLimiter allows perMinute actions per key and minute, in bursts of
+// up to perMinute. It returns nil, which allows everything, for perMinute <= 0.
+func newRateLimiter(perMinute int) *rateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	interval := time.Minute / time.Duration(perMinute)
+	return &rateLimiter{
+		interval: interval,
+		burst:    time.Duration(perMinute-1) * interval,
+		next:     map[int64]time.Time{},
+	}
+}
+
+// delay returns how long the action for keys has to wait; l.mu must be held
+func (l *rateLimiter) delay(now time.Time, keys []int64) time.Duration {
+	var d time.Duration
+	for _, key := range keys {
+		d = max(d, l.next[key].Sub(now)-l.burst)
+	}
+	return d
+}
+
+// take counts an action at now for keys; l.mu must be held
+func (l *rateLimiter) take(now time.Time, keys []int64) {
+	for _, key := range keys {
+		if l.next[key].Before(now) {
+			l.next[key] = now
+		}
+		l.next[key] = l.next[key].Add(l.interval)
+	}
+}
+
+// allow counts an action for all of keys and reports whether it's within
+// the rate of each. Refused actions aren't counted.
+func (l *rateLimiter) allow(keys ...int64) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.delay(now, keys) > 0 {
+		return false
+	}
+	l.take(now, keys)
+	return true
+}
+
+// wait blocks until an action for keys is within the rate and counts it
+func (l *rateLimiter) wait(ctx context.Context, keys ...int64) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	d := max(l.delay(now, keys), 0)
+	l.take(now.Add(d), keys)
+	l.mu.Unlock()
+	if d == 0 {
+		return nil
+	}
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"os"
+)
+
+// rawExts are the camera RAW formats built on TIFF, whose IFDs point at
+// the JPEG previews the camera embedded
+var rawExts = []string{".cr2", ".nef", ".nrw", ".arw", ".dng", ".pef"}
+
+// TIFF tags leading to embedded JPEG previews
+const (
+	tiffCompression     = 0x0103
+	tiffStripOffsets    = 0x0111
+	tiffStripByteCounts = 0x0117
+	tiffSubIFDs         = 0x014A
+	tiffJPEGOffset      = 0x0201
+	tiffJPEGLength      = 0x0202
+)
+
+// isRawFile reports whether ext is that of a camera RAW file
+func isRawFile(ext string) bool {
+	for _, rawExt := range rawExts {
+		if ext == rawExt {
+			return true
+		}
+	}
+	return false
+}
+
+// rawPreview returns the largest JPEG preview embedded in the RAW file at
+// path, turned upright as the file's orientation says
+func rawPreview(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(data, []byte("II*\x00")):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(data, []byte("MM\x00*")):
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("not a TIFF-based RAW file")
+	}
+	if len(data) < 8 {
+		return nil, errors.New("truncated TIFF header")
+	}
+
+	var best []byte
+	orientation := 1
+	seen := map[uint32]bool{}
+	first := order.Uint32(data[4:])
+	queue := []uint32{first}
+	for len(queue) > 0 && len(seen) < 64 {
+		ifd := queue[0]
+		queue = queue[1:]
+		if ifd == 0 || seen[ifd] || uint64(ifd)+2 > uint64(len(data)) {
+			continue
+		}
+		seen[ifd] = true
+		tags := readIFD(data, order, ifd)
+		if ifd == first && len(tags[exifOrientationTag]) > 0 {
+			if o := int(tags[exifOrientationTag][0]); o >= 1 && o <= 8 {
+				orientation = o
+			}
+		}
+		queue = append(queue, tags[tiffSubIFDs]...)
+		if next := ifdNext(data, order, ifd); next != 0 {
+			queue = append(queue, next)
+		}
+
+		// A preview is either a JPEG the IFD points at, or a single strip
+		// of old-style JPEG compression
+		var offset, length uint32
+		switch {
+		case len(tags[tiffJPEGOffset]) > 0 && len(tags[tiffJPEGLength]) > 0:
+			offset, length = tags[tiffJPEGOffset][0], tags[tiffJPEGLength][0]
+		case len(tags[tiffCompression]) > 0 && tags[tiffCompression][0] == 6 &&
+			len(tags[tiffStripOffsets]) == 1 && len(tags[tiffStripByteCounts]) == 1:
+			offset, length = tags[tiffStripOffsets][0], tags[tiffStripByteCounts][0]
+		default:
+			continue
+		}
+		if uint64(offset)+uint64(length) > uint64(len(data)) || length <= uint32(len(best)) {
+			continue
+		}
+		if preview := data[offset : offset+length]; bytes.HasPrefix(preview, []byte{0xFF, 0xD8}) {
+			best = preview
+		}
+	}
+	if best == nil {
+		return nil, errors.New("no JPEG preview in the file")
+	}
+	if orientation == 1 {
+		return best, nil
+	}
+
+	// The preview has no orientation of its own, so turn its pixels
+	img, err := jpeg.Decode(bytes.NewReader(best))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode preview: %w", err)
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, orientImage(rgba, orientation), &jpeg.Options{Quality: 90}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// readIFD returns the values of the SHORT and LONG tags of the TIFF IFD at
+// offset ifd, leaving out entries that point outside data
+func readIFD(data []byte, order binary.ByteOrder, ifd uint32) map[uint16][]uint32 {
+	tags := map[uint16][]uint32{}
+	if uint64(ifd)+2 > uint64(len(data)) {
+		return tags
+	}
+	count := uint64(order.Uint16(data[ifd:]))
+	for i := uint64(0); i < count; i++ {
+		entry := uint64(ifd) + 2 + i*12
+		if entry+12 > uint64(len(data)) {
+			break
+		}
+		tag, typ, n := order.Uint16(data[entry:]), order.Uint16(data[entry+2:]), order.Uint32(data[entry+4:])
+		var size uint32
+		switch typ {
+		case 3: // SHORT
+			size = 2
+		case 4, 13: // LONG, IFD
+			size = 4
+		default:
+			continue
+		}
+		if n == 0 || n > 1024 {
+			continue
+		}
+		// Values that don't fit the entry are stored elsewhere
+		values := data[entry+8 : entry+12]
+		if n*size > 4 {
+			at := order.Uint32(values)
+			if uint64(at)+uint64(n*size) > uint64(len(data)) {
+				continue
+			}
+			values = data[at : at+n*size]
+		}
+		for j := uint32(0); j < n; j++ {
+			if size == 2 {
+				tags[tag] = append(tags[tag], uint32(order.Uint16(values[j*2:])))
+			} else {
+				tags[tag] = append(tags[tag], order.Uint32(values[j*4:]))
+			}
+		}
+	}
+	return tags
+}
+
+// ifdNext returns the offset of the IFD following the one at ifd, or 0
+func ifdNext(data []byte, order binary.ByteOrder, ifd uint32) uint32 {
+	if uint64(ifd)+2 > uint64(len(data)) {
+		return 0
+	}
+	at := uint64(ifd) + 2 + uint64(order.Uint16(data[ifd:]))*12
+	if at+4 > uint64(len(data)) {
+		return 0
+	}
+	return order.Uint32(data[at:])
+}
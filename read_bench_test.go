@@ -0,0 +1,84 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// benchFileSize is the size of the file the read benchmarks upload
+const benchFileSize = 256 << 20
+
+// The benchmarks compare reading a file for the upload through read calls
+// and through a memory mapping, alone and with the SHA-256 every upload
+// computes. On a Xeon with the file in the page cache:
+//
+//	BenchmarkReadFile            8700 MB/s
+//	BenchmarkReadMapped         12200 MB/s
+//	BenchmarkReadFileHashed      1420 MB/s
+//	BenchmarkReadMappedHashed    1470 MB/s
+//
+// Hashed, which is how uploads read, mapping gains about 4%, far above
+// what Telegram takes, and a file truncated while mapped kills the process
+// with SIGBUS. Uploads read files through read calls.
+
+func BenchmarkReadFile(b *testing.B)         { benchmarkRead(b, false, false) }
+func BenchmarkReadMapped(b *testing.B)       { benchmarkRead(b, true, false) }
+func BenchmarkReadFileHashed(b *testing.B)   { benchmarkRead(b, false, true) }
+func BenchmarkReadMappedHashed(b *testing.B) { benchmarkRead(b, true, true) }
+
+func benchmarkRead(b *testing.B, mapped, hashed bool) {
+	path := filepath.Join(b.TempDir(), "file")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("0123456789abcdef"), benchFileSize/16), 0o600); err != nil {
+		b.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+
+	b.SetBytes(benchFileSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var src io.Reader = f
+		var data []byte
+		if mapped {
+			if data, err = unix.Mmap(int(f.Fd()), 0, benchFileSize, unix.PROT_READ, unix.MAP_SHARED); err != nil {
+				b.Fatal(err)
+			}
+			unix.Madvise(data, unix.MADV_SEQUENTIAL)
+			src = bytes.NewReader(data)
+		} else if _, err := f.Seek(0, io.SeekStart); err != nil {
+			b.Fatal(err)
+		}
+		if hashed {
+			src = io.TeeReader(src, sha256.New())
+		}
+		readParts(b, src)
+		if data != nil {
+			unix.Munmap(data)
+		}
+	}
+}
+
+// readParts reads src in parts of the size uploads use
+func readParts(b *testing.B, src io.Reader) {
+	part := make([]byte, 512*1024)
+	for {
+		_, err := io.ReadFull(src, part)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// readAheadBlockSize is the size of the blocks a readAhead reads at a time
+const readAheadBlockSize = 1 << 20
+
+// maxReadAhead is the largest read-ahead buffer -read-ahead accepts
+const maxReadAhead = 1 << 30
+
+var readAheadBuffers = newBufferPool(readAheadBlockSize)
+
+// aheadBlock is a block read from the source, with the error that ended the
+// read if any
+type aheadBlock struct {
+	buf *[]byte
+	n   int
+	err error
+}
+
+// readAhead reads its source in the background, up to a buffer's size ahead
+// of its consumer, so that a source with bursty latency, like a network
+// share, doesn't stall the upload whenever it pauses
+type readAhead struct {
+	blocks chan aheadBlock
+	stop   chan struct{}
+	cur    aheadBlock
+	off    int
+}
+
+// newReadAhead starts reading r ahead by up to size bytes. Close stops it.
+func newReadAhead(r io.Reader, size int64) *readAhead {
+	ra := &readAhead{
+		blocks: make(chan aheadBlock, max(size/readAheadBlockSize, 1)),
+		stop:   make(chan struct{}),
+	}
+	go ra.fill(r)
+	return ra
+}
+
+// fill reads r block by block into ra.blocks until r ends or ra is closed
+func (ra *readAhead) fill(r io.Reader) {
+	defer close(ra.blocks)
+	for {
+		buf := readAheadBuffers.get()
+		n, err := io.ReadFull(r, *buf)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		select {
+		case ra.blocks <- aheadBlock{buf: buf, n: n, err: err}:
+		case <-ra.stop:
+			readAheadBuffers.put(buf)
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Read implements io.Reader
+func (ra *readAhead) Read(p []byte) (int, error) {
+	for ra.off == ra.cur.n {
+		if ra.cur.err != nil {
+			return 0, ra.cur.err
+		}
+		if ra.cur.buf != nil {
+			readAheadBuffers.put(ra.cur.buf)
+		}
+		b, ok := <-ra.blocks
+		if !ok {
+			return 0, io.ErrClosedPipe
+		}
+		ra.cur, ra.off = b, 0
+	}
+	n := copy(p, (*ra.cur.buf)[ra.off:ra.cur.n])
+	ra.off += n
+	return n, nil
+}
+
+// Close stops reading ahead. Blocks already read are left to the garbage
+// collector, as the source may still be blocked in a read.
+func (ra *readAhead) Close() error {
+	close(ra.stop)
+	if ra.cur.buf != nil {
+		readAheadBuffers.put(ra.cur.buf)
+	}
+	ra.cur, ra.off = aheadBlock{err: io.ErrClosedPipe}, 0
+	return nil
+}
+
+// parseReadAhead parses the value of a -read-ahead flag
+func parseReadAhead(s string) (int64, error) {
+	size, err := parseSize(s)
+	if err != nil || size > maxReadAhead {
+		return 0, withExitCode(exitUsage, fmt.Errorf("invalid -read-ahead %q: give a size up to %dM", s, maxReadAhead>>20))
+	}
+	return size, nil
+}
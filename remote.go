@@ -0,0 +1,584 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+)
+
+// remotePrefix starts a remote path, like telegram:backups/photos
+const remotePrefix = "telegram:"
+
+// remotePath is a location in Telegram storage: a chat, and a slash
+// separated path among the files uploaded to it. The journal is the index
+// of which file is at which path.
+type remotePath struct {
+	Chat string
+	Path string // "" for all files of the chat
+}
+
+func (r remotePath) String() string {
+	if r.Path == "" {
+		return remotePrefix + r.Chat
+	}
+	return remotePrefix + r.Chat + "/" + r.Path
+}
+
+// parseRemote parses s as a remote path, reporting false if it's a local one
+func parseRemote(s string) (remotePath, bool, error) {
+	rest, ok := strings.CutPrefix(s, remotePrefix)
+	if !ok {
+		return remotePath{}, false, nil
+	}
+	chat, p, _ := strings.Cut(rest, "/")
+	if chat == "" {
+		return remotePath{}, true, withExitCode(exitUsage, fmt.Errorf("%q names no chat; remote paths look like %sbackups/path", s, remotePrefix))
+	}
+	p = strings.Trim(path.Clean("/"+p), "/")
+	return remotePath{Chat: chat, Path: p}, true, nil
+}
+
+// objectPath returns where the file of e is in its chat
+func objectPath(e JournalEntry) string {
+	if e.Path != "" {
+		return e.Path
+	}
+	return e.Name
+}
+
+// remoteObjects returns the newest upload at every path at or under r,
+// sorted by path
+func remoteObjects(entries []JournalEntry, r remotePath) []JournalEntry {
+	latest := map[string]JournalEntry{}
+	var paths []string
+	for _, e := range liveEntries(entries) {
+		p := objectPath(e)
+		if normalizeTarget(e.Target) != normalizeTarget(r.Chat) {
+			continue
+		}
+		if r.Path != "" && p != r.Path && !strings.HasPrefix(p, r.Path+"/") {
+			continue
+		}
+		if _, seen := latest[p]; !seen {
+			paths = append(paths, p)
+		}
+		latest[p] = e
+	}
+	sort.Strings(paths)
+	objects := make([]JournalEntry, 0, len(paths))
+	for _, p := range paths {
+		objects = append(objects, latest[p])
+	}
+	return objects
+}
+
+// remoteFlags registers the flags shared by the remote commands
+func remoteFlags(name string, config *Config) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	credentialFlags(flags, config)
+	flags.StringVar(&config.JournalPath, "journal", defaultJournalPath, "Path of the upload journal, which indexes the remote files")
+	return flags
+}
+
+// runLs implements the "ls" subcommand, listing the files at a remote path
+// with their sizes, like rclone ls. It only reads the journal.
+func runLs(args []string) error {
+	config := &Config{}
+	flags := remoteFlags("ls", config)
+	long := flags.Bool("l", false, "Also show when each file was uploaded and its message ID")
+	positional := parseInterleaved(flags, args)
+	if len(positional) != 1 {
+		return withExitCode(exitUsage, errors.New("usage: ls [-l] telegram:<chat>[/path]"))
+	}
+	r, ok, err := parseRemote(positional[0])
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return withExitCode(exitUsage, fmt.Errorf("%q is not a remote path; remote paths look like %sbackups/path", positional[0], remotePrefix))
+	}
+
+	entries, err := readJournal(config.JournalPath)
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	for _, e := range remoteObjects(entries, r) {
+		if *long {
+			fmt.Printf("%12d %s %8d %s\n", e.Size, e.Time.Local().Format("2006-01-02 15:04:05"), e.MessageID, objectPath(e))
+		} else {
+			fmt.Printf("%12d %s\n", e.Size, objectPath(e))
+		}
+	}
+	return nil
+}
+
+// runCopy implements the "copy" subcommand, copying a local file or
+// directory into a remote directory, or remote files into a local one
+func runCopy(args []string) error {
+	config := &Config{}
+	flags := remoteFlags("copy", config)
+	flags.StringVar(&config.FileCachePath, "file-cache", defaultFileCachePath, "Keep the Telegram IDs of uploaded files here (empty to disable)")
+	dryRun := flags.Bool("dry-run", false, "Only show what would be copied")
+	timeoutFlags(flags, &config.Timeouts)
+	applyProgressFlags := progressFlags(flags)
+	applyFilterFlags := filterFlags(flags)
+	applyOrderFlag := orderFlag(flags)
+	positional := parseInterleaved(flags, args)
+	if err := applyProgressFlags(); err != nil {
+		return err
+	}
+	filter, err := applyFilterFlags()
+	if err != nil {
+		return err
+	}
+	order, err := applyOrderFlag()
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return withExitCode(exitUsage, errors.New("usage: copy <source> <destination directory>, one of them a telegram:<chat>/path"))
+	}
+	src, srcRemote, err := parseRemote(positional[0])
+	if err != nil {
+		return err
+	}
+	dst, dstRemote, err := parseRemote(positional[1])
+	if err != nil {
+		return err
+	}
+	if config.JournalPath == "" {
+		return withExitCode(exitUsage, errors.New("remote paths need the journal"))
+	}
+	entries, err := readJournal(config.JournalPath)
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	switch {
+	case srcRemote && dstRemote:
+		return withExitCode(exitUsage, errors.New("copying between remotes isn't supported; resend the files instead"))
+	case dstRemote:
+		return copyToRemote(config, entries, positional[0], dst, filter, order, *dryRun)
+	case srcRemote:
+		return copyFromRemote(config, entries, src, positional[1], *dryRun)
+	}
+	return withExitCode(exitUsage, fmt.Errorf("neither %q nor %q is a remote path", positional[0], positional[1]))
+}
+
+// copyToRemote uploads the file or directory tree at local into dst,
+// replacing the files already at the same paths. The files of a tree are
+// picked by filter and copied in order.
+func copyToRemote(config *Config, entries []JournalEntry, local string, dst remotePath, filter fileFilter, order fileOrder, dryRun bool) error {
+	info, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+	// Every file with the remote path it goes to
+	var files, paths []string
+	if info.IsDir() {
+		var found []fileKey
+		err = filter.walk(local, func(p string, info fs.FileInfo) error {
+			if reason := filter.skip(info); reason != "" {
+				fmt.Printf("Leaving out %s: %s\n", p, reason)
+				return nil
+			}
+			found = append(found, fileKey{p, info.Size(), info.ModTime()})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		slices.SortStableFunc(found, order.compare)
+		for _, f := range found {
+			rel, err := filepath.Rel(local, f.Path)
+			if err != nil {
+				return err
+			}
+			files = append(files, f.Path)
+			paths = append(paths, path.Join(dst.Path, filepath.ToSlash(rel)))
+		}
+	} else {
+		files = append(files, local)
+		paths = append(paths, path.Join(dst.Path, filepath.Base(local)))
+	}
+
+	existing := map[string]JournalEntry{}
+	for _, e := range remoteObjects(entries, remotePath{Chat: dst.Chat}) {
+		existing[objectPath(e)] = e
+	}
+	if dryRun {
+		for i, p := range paths {
+			fmt.Printf("copy  %s -> %s\n", files[i], remotePath{Chat: dst.Chat, Path: p})
+		}
+		return nil
+	}
+	if err := validateCredentials(config); err != nil {
+		return err
+	}
+
+	config.TargetID = dst.Chat
+	config.NoPrompt = true
+	var failed int
+	err = withClient(config, func(ctx context.Context, client *telegram.Client) error {
+		for i, file := range files {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fileConfig := *config
+			fileConfig.Input = telegramuploader.FileSource(file)
+			fileConfig.FileName = filepath.Base(file)
+			fileConfig.RemotePath = paths[i]
+			fileConfig.AsDocument = true
+			if abs, err := filepath.Abs(file); err == nil {
+				fileConfig.Source = abs
+			}
+			if old, ok := existing[paths[i]]; ok {
+				fileConfig.SupersedeMessageID = old.MessageID
+			}
+			fmt.Printf("Copying %s to %s\n", file, remotePath{Chat: dst.Chat, Path: paths[i]})
+			if err := uploadFile(ctx, client, &fileConfig); err != nil {
+				fmt.Printf("Failed to copy %s: %v\n", file, friendlyError(err))
+				failed++
+			}
+		}
+		return nil
+	})
+	if err == nil && failed > 0 {
+		err = withExitCode(exitPartialBatch, fmt.Errorf("%d of %d file(s) failed to copy", failed, len(files)))
+	}
+	return err
+}
+
+// copyFromRemote downloads the files at or under src into the directory
+// local, keeping their paths below src
+func copyFromRemote(config *Config, entries []JournalEntry, src remotePath, local string, dryRun bool) error {
+	objects := remoteObjects(entries, src)
+	if len(objects) == 0 {
+		return fmt.Errorf("no files at %s", src)
+	}
+	// The local path of every object
+	dests := make([]string, len(objects))
+	for i, e := range objects {
+		rel := strings.TrimPrefix(strings.TrimPrefix(objectPath(e), src.Path), "/")
+		if rel == "" {
+			rel = path.Base(objectPath(e))
+		}
+		dests[i] = filepath.Join(local, filepath.FromSlash(rel))
+	}
+	if dryRun {
+		for i, e := range objects {
+			fmt.Printf("copy  %s -> %s\n", remotePath{Chat: src.Chat, Path: objectPath(e)}, dests[i])
+		}
+		return nil
+	}
+	if err := validateCredentials(config); err != nil {
+		return err
+	}
+
+	var failed int
+	err := withClient(config, func(ctx context.Context, client *telegram.Client) error {
+		api := client.API()
+		peer, err := telegramuploader.ResolvePeer(ctx, api, src.Chat)
+		if err != nil {
+			return err
+		}
+		for i, e := range objects {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			err := func() error {
+				msg, err := getMessage(ctx, api, peer, e.MessageID)
+				if err != nil {
+					return fmt.Errorf("failed to get message %d: %w", e.MessageID, err)
+				}
+				location, name, size, ok := mediaFile(msg)
+				if !ok {
+					return fmt.Errorf("message %d no longer holds the file", e.MessageID)
+				}
+				tmp, err := downloadMedia(ctx, api, location, name, size, filepath.Dir(dests[i]))
+				if err != nil {
+					return err
+				}
+				if err := os.Rename(tmp, dests[i]); err != nil {
+					os.Remove(tmp)
+					return err
+				}
+				// Keep the modification time the file had when it was uploaded
+				if !e.ModTime.IsZero() {
+					os.Chtimes(dests[i], time.Now(), e.ModTime)
+				}
+				return nil
+			}()
+			if err != nil {
+				fmt.Printf("Failed to copy %s: %v\n", objectPath(e), friendlyError(err))
+				failed++
+				continue
+			}
+			fmt.Printf("Copied %s to %s\n", objectPath(e), dests[i])
+		}
+		return nil
+	})
+	if err == nil && failed > 0 {
+		err = withExitCode(exitPartialBatch, fmt.Errorf("%d of %d file(s) failed to copy", failed, len(objects)))
+	}
+	return err
+}
+
+// runDelete implements the "delete" subcommand, deleting the messages of
+// the files at or under a remote path
+func runDelete(args []string) error {
+	config := &Config{}
+	flags := remoteFlags("delete", config)
+	dryRun := flags.Bool("dry-run", false, "Only show what would be deleted")
+	positional := parseInterleaved(flags, args)
+	if len(positional) != 1 {
+		return withExitCode(exitUsage, errors.New("usage: delete [-dry-run] telegram:<chat>/path"))
+	}
+	r, ok, err := parseRemote(positional[0])
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return withExitCode(exitUsage, fmt.Errorf("%q is not a remote path; delete only removes files from Telegram", positional[0]))
+	}
+	if config.JournalPath == "" {
+		return withExitCode(exitUsage, errors.New("remote paths need the journal"))
+	}
+	entries, err := readJournal(config.JournalPath)
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	objects := remoteObjects(entries, r)
+	if len(objects) == 0 {
+		return fmt.Errorf("no files at %s", r)
+	}
+	if *dryRun {
+		for _, e := range objects {
+			fmt.Printf("delete  %s (message %d)\n", remotePath{Chat: r.Chat, Path: objectPath(e)}, e.MessageID)
+		}
+		return nil
+	}
+	if err := validateCredentials(config); err != nil {
+		return err
+	}
+
+	config.TargetID = r.Chat
+	return withClient(config, func(ctx context.Context, client *telegram.Client) error {
+		return deleteStale(ctx, client, config, objects)
+	})
+}
+
+// runMount implements the "mount" subcommand, mounting a remote path as a
+// file system. Files written into it are queued for upload, and files read
+// from it are fetched from Telegram, keeping what was read in a cache.
+func runMount(args []string) error {
+	config := &Config{}
+	flags := remoteFlags("mount", config)
+	flags.StringVar(&config.FileCachePath, "file-cache", defaultFileCachePath, "Keep the Telegram IDs of uploaded files here (empty to disable)")
+	cacheDir := flags.String("cache", "mount-cache", "Directory keeping what was read from Telegram and the files waiting for upload")
+	cacheSize := flags.String("cache-size", "1G", "Keep at most this much of what was read from Telegram in -cache, dropping what was read longest ago (0 for no limit)")
+	timeoutFlags(flags, &config.Timeouts)
+	applyProgressFlags := progressFlags(flags)
+	applySystemdFlags := systemdFlags(flags)
+	applyLogTargetFlags := logTargetFlags(flags)
+	applyTracingFlags := tracingFlags(flags)
+	applyRateFlags := uploadRateFlags(flags)
+	positional := parseInterleaved(flags, args)
+	if err := applyProgressFlags(); err != nil {
+		return err
+	}
+	applySystemdFlags()
+	if err := applyLogTargetFlags(); err != nil {
+		return err
+	}
+	if err := applyTracingFlags(); err != nil {
+		return err
+	}
+	rates, err := applyRateFlags()
+	if err != nil {
+		return err
+	}
+	config.Rates = rates
+	if len(positional) != 2 {
+		return withExitCode(exitUsage, errors.New("usage: mount telegram:<chat>[/path] <mountpoint>"))
+	}
+	r, ok, err := parseRemote(positional[0])
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return withExitCode(exitUsage, fmt.Errorf("%q is not a remote path; remote paths look like %sbackups/path", positional[0], remotePrefix))
+	}
+	if config.JournalPath == "" {
+		return withExitCode(exitUsage, errors.New("remote paths need the journal"))
+	}
+	maxCache, err := parseSize(*cacheSize)
+	if err != nil || maxCache < 0 {
+		return withExitCode(exitUsage, fmt.Errorf("invalid -cache-size %q", *cacheSize))
+	}
+	if err := validateCredentials(config); err != nil {
+		return err
+	}
+
+	// An interrupt unmounts, and the client keeps running to send what's
+	// still queued
+	stop, cancel := signal.NotifyContext(baseContext, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	config.OwnSignals = true
+	return withClient(config, func(ctx context.Context, client *telegram.Client) error {
+		return mountRemote(ctx, stop, newDavFS(client, config), r, *cacheDir, maxCache, positional[1])
+	})
+}
+
+// putRemote uploads body, size bytes long or -1 if that isn't known, to the
+// path r in config's target chat, replacing the file already there
+func putRemote(ctx context.Context, client *telegram.Client, config *Config, entries []JournalEntry, r remotePath, body io.Reader, size int64) error {
+	fileConfig := *config
+	fileConfig.FileName = path.Base(r.Path)
+	fileConfig.Input = telegramuploader.ReaderSource(fileConfig.FileName, body, size)
+	fileConfig.RemotePath = r.Path
+	fileConfig.Source = r.String()
+	fileConfig.AsDocument = true
+	fileConfig.NoPrompt = true
+	for _, e := range remoteObjects(entries, r) {
+		if objectPath(e) == r.Path {
+			fileConfig.SupersedeMessageID = e.MessageID
+		}
+	}
+	return uploadFile(ctx, client, &fileConfig)
+}
+
+// remoteBlockSize is the size of the blocks a remoteFile fetches. Telegram
+// serves files in aligned pieces of at most this size.
+const remoteBlockSize = 1 << 20
+
+// remoteFile reads a file stored in Telegram a block at a time, wherever it
+// seeks to, so that servers can answer range requests without downloading
+// the whole file
+type remoteFile struct {
+	ctx      context.Context
+	api      *tg.Client
+	location tg.InputFileLocationClass
+	size     int64
+	off      int64
+
+	block      []byte // the last block fetched
+	blockStart int64
+
+	// cacheDir keeps the fetched blocks when set, so that they're only
+	// fetched once
+	cacheDir string
+}
+
+// openRemote opens the file of the journal entry e, which is in the chat p
+func openRemote(ctx context.Context, api *tg.Client, p tg.InputPeerClass, e JournalEntry) (*remoteFile, error) {
+	msg, err := getMessage(ctx, api, p, e.MessageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message %d: %w", e.MessageID, err)
+	}
+	location, _, size, ok := mediaFile(msg)
+	if !ok {
+		return nil, fmt.Errorf("message %d no longer holds the file", e.MessageID)
+	}
+	return &remoteFile{ctx: ctx, api: api, location: location, size: size}, nil
+}
+
+// Read implements io.Reader
+func (f *remoteFile) Read(p []byte) (int, error) {
+	if f.off >= f.size {
+		return 0, io.EOF
+	}
+	start := f.off &^ (remoteBlockSize - 1)
+	if f.block == nil || f.blockStart != start {
+		block, err := f.fetch(start)
+		if err != nil {
+			return 0, err
+		}
+		f.block, f.blockStart = block, start
+	}
+	i := f.off - start
+	if i >= int64(len(f.block)) {
+		// Shorter than Telegram said
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, f.block[i:])
+	f.off += int64(n)
+	return n, nil
+}
+
+// fetch returns the block at start, from the cache if it's there
+func (f *remoteFile) fetch(start int64) ([]byte, error) {
+	var cached string
+	if f.cacheDir != "" {
+		cached = filepath.Join(f.cacheDir, strconv.FormatInt(start/remoteBlockSize, 10))
+		if block, err := os.ReadFile(cached); err == nil {
+			// The cache is trimmed by when blocks were last used
+			now := time.Now()
+			os.Chtimes(cached, now, now)
+			return block, nil
+		}
+	}
+	res, err := f.api.UploadGetFile(f.ctx, &tg.UploadGetFileRequest{
+		Precise:  true,
+		Location: f.location,
+		Offset:   start,
+		Limit:    remoteBlockSize,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read at offset %d: %w", start, err)
+	}
+	file, ok := res.(*tg.UploadFile)
+	if !ok {
+		return nil, fmt.Errorf("unexpected response %T", res)
+	}
+	if cached != "" {
+		// A failure to cache only costs fetching the block again
+		if err := os.MkdirAll(f.cacheDir, 0700); err == nil {
+			if tmp, err := os.CreateTemp(f.cacheDir, ".block-*"); err == nil {
+				_, err = tmp.Write(file.Bytes)
+				if closeErr := tmp.Close(); err == nil {
+					err = closeErr
+				}
+				if err == nil {
+					err = os.Rename(tmp.Name(), cached)
+				}
+				if err != nil {
+					os.Remove(tmp.Name())
+				}
+			}
+		}
+	}
+	return file.Bytes, nil
+}
+
+// Seek implements io.Seeker
+func (f *remoteFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before the start of the file")
+	}
+	f.off = offset
+	return offset, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// syncResult is the outcome for one file of a sync run
+type syncResult struct {
+	Path     string
+	Status   string // "uploaded", "skipped", "failed", "cancelled" or "deferred"
+	Size     int64
+	Duration time.Duration
+	Err      error
+	Sent     ManifestEntry // the file as it was sent, once uploaded
+}
+
+// printSummary prints totals for the results of a run that took elapsed
+func printSummary(results []syncResult, elapsed time.Duration) {
+	counts := map[string]int{}
+	var bytes int64
+	var busy time.Duration
+	for _, r := range results {
+		counts[r.Status]++
+		if r.Status == "uploaded" {
+			bytes += r.Size
+			busy += r.Duration
+		}
+	}
+	var speed float64
+	if busy > 0 {
+		speed = float64(bytes) / busy.Seconds() / (1024 * 1024)
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Uploaded\t%d\n", counts["uploaded"])
+	fmt.Fprintf(w, "Skipped\t%d\n", counts["skipped"])
+	fmt.Fprintf(w, "Failed\t%d\n", counts["failed"])
+	if counts["cancelled"] > 0 {
+		fmt.Fprintf(w, "Cancelled\t%d\n", counts["cancelled"])
+	}
+	if counts["deferred"] > 0 {
+		fmt.Fprintf(w, "Left for next run\t%d\n", counts["deferred"])
+	}
+	fmt.Fprintf(w, "Total\t%.2f MB\n", float64(bytes)/(1024*1024))
+	fmt.Fprintf(w, "Average speed\t%.2f MB/s\n", speed)
+	fmt.Fprintf(w, "Elapsed\t%s\n", elapsed.Round(time.Second))
+	w.Flush()
+}
+
+// writeReport writes one CSV row per result to path
+func writeReport(path string, results []syncResult) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"path", "status", "size", "seconds", "error"})
+	for _, r := range results {
+		var errText string
+		if r.Err != nil {
+			errText = r.Err.Error()
+		}
+		w.Write([]string{
+			r.Path,
+			r.Status,
+			strconv.FormatInt(r.Size, 10),
+			strconv.FormatFloat(r.Duration.Seconds(), 'f', 1, 64),
+			errText,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
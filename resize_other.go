@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package main
+
+import "os"
+
+// notifyResize does nothing where there is no SIGWINCH; the bar keeps the
+// width it started with
+func notifyResize(c chan<- os.Signal) {}
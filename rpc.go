@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/gotd/td/telegram"
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+)
+
+// rpcOptions are the middlewares wrapped around every Telegram API call
+type rpcOptions struct {
+	FloodWait time.Duration // FLOOD_WAITs up to this long are waited out and the call repeated
+	Retries   int           // attempts of calls failing with internal server errors
+	Rate      float64       // most calls a second; 0 for no limit
+	Log       bool          // log every call
+}
+
+// rpcFlags registers the API call middleware flags on fs
+func rpcFlags(fs *flag.FlagSet, o *rpcOptions) {
+	fs.DurationVar(&o.FloodWait, "flood-wait", 0, "Wait out FLOOD_WAITs of up to this long, like 30s, and repeat the request instead of failing (0 to fail at once)")
+	fs.IntVar(&o.Retries, "rpc-retries", 1, "Try API requests failing with internal server errors this many times in all")
+	fs.Float64Var(&o.Rate, "rpc-rate", 0, "Make at most this many API requests a second (0 for no limit)")
+	fs.BoolVar(&o.Log, "log-rpc", false, "Log every API request with how long it took")
+}
+
+// middlewares returns the middlewares o asks for, in the order they wrap
+// each call
+func (o rpcOptions) middlewares() []telegram.Middleware {
+	var m []telegram.Middleware
+	if o.FloodWait > 0 {
+		m = append(m, telegramuploader.FloodWaiter(o.FloodWait))
+	}
+	if o.Retries > 1 {
+		m = append(m, telegramuploader.Retrier(o.Retries))
+	}
+	if o.Rate > 0 {
+		m = append(m, telegramuploader.RateLimiter(o.Rate))
+	}
+	if o.Log {
+		m = append(m, telegramuploader.RequestLogger(log.Printf))
+	}
+	return m
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gotd/td/tgerr"
+	"golang.org/x/term"
+)
+
+// rpcHints explains common Telegram RPC errors in terms of what to do about them
+var rpcHints = map[string]string{
+	"CHAT_WRITE_FORBIDDEN":       "you can't post to this chat; are you a member, or an admin of the channel?",
+	"CHAT_ADMIN_REQUIRED":        "only admins can post to this chat; ask an admin to give you posting rights",
+	"CHAT_SEND_MEDIA_FORBIDDEN":  "media can't be sent to this chat; an admin has restricted it",
+	"CHAT_SEND_DOCS_FORBIDDEN":   "files can't be sent to this chat; an admin has restricted it",
+	"CHAT_SEND_PHOTOS_FORBIDDEN": "photos can't be sent to this chat; try again without sending it as a photo",
+	"CHAT_SEND_VIDEOS_FORBIDDEN": "videos can't be sent to this chat; an admin has restricted it",
+	"CHAT_RESTRICTED":            "this chat is restricted and can't be posted to",
+	"USER_BANNED_IN_CHANNEL":     "your account is banned from posting in this channel",
+	"USER_IS_BLOCKED":            "this user has blocked you",
+	"YOU_BLOCKED_USER":           "you have blocked this user; unblock them first",
+	"CHANNEL_PRIVATE":            "this channel is private or you were removed from it",
+	"PEER_ID_INVALID":            "this chat isn't known to your account; open it in Telegram once, or use its @username",
+	"USERNAME_NOT_OCCUPIED":      "no user or channel has this username; check the spelling",
+	"USERNAME_INVALID":           "this isn't a valid username",
+	"FILE_PARTS_INVALID":         "the file is too large for your account; files over 2000 MB need Telegram Premium",
+	"MESSAGE_ID_INVALID":         "there's no message with this ID in the target chat",
+	"MESSAGE_AUTHOR_REQUIRED":    "you can only replace the media of your own messages",
+	"MESSAGE_EDIT_TIME_EXPIRED":  "this message is too old to be edited; send a new one instead",
+	"PREMIUM_ACCOUNT_REQUIRED":   "this needs Telegram Premium",
+	"STORIES_TOO_MUCH":           "you've posted the most stories Telegram allows for now; try again later",
+	"STORY_PERIOD_INVALID":       "only Telegram Premium users can choose a story period other than 24h",
+	"AUTH_KEY_UNREGISTERED":      "the saved session is no longer valid; run again to log in",
+	"SESSION_REVOKED":            "the saved session was logged out; run again to log in",
+	"PHONE_CODE_INVALID":         "the login code was wrong; try again",
+	"PHONE_CODE_EXPIRED":         "the login code expired; try again and enter it sooner",
+	"PASSWORD_HASH_INVALID":      "the two-step verification password was wrong",
+}
+
+// friendlyError prefixes err with advice if it's a Telegram error we know
+func friendlyError(err error) error {
+	rpcErr, ok := tgerr.As(err)
+	if !ok {
+		return err
+	}
+	hint, ok := rpcHints[rpcErr.Type]
+	if !ok {
+		return err
+	}
+	return fmt.Errorf("%s (%w)", hint, err)
+}
+
+// sendForbidden reports whether err means this account can't post to the
+// target, so the file could go to Saved Messages instead
+func sendForbidden(err error) bool {
+	return tgerr.Is(err, "CHAT_WRITE_FORBIDDEN", "CHAT_ADMIN_REQUIRED", "CHAT_SEND_MEDIA_FORBIDDEN",
+		"CHAT_SEND_DOCS_FORBIDDEN", "CHAT_SEND_PHOTOS_FORBIDDEN", "CHAT_SEND_VIDEOS_FORBIDDEN",
+		"CHAT_RESTRICTED", "USER_BANNED_IN_CHANNEL", "USER_IS_BLOCKED", "YOU_BLOCKED_USER", "CHANNEL_PRIVATE")
+}
+
+// confirmSavedFallback asks whether to send to Saved Messages instead. It
+// only asks when someone is at the terminal.
+func confirmSavedFallback() bool {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false
+	}
+	return askYesNo("Send the file to your Saved Messages instead?")
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gotd/td/telegram"
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+)
+
+// s3TimeFormat is how S3 writes times in XML
+const s3TimeFormat = "2006-01-02T15:04:05.000Z"
+
+// s3Subresources are the query parameters of S3 requests the gateway
+// doesn't implement, like multipart uploads and ACLs
+var s3Subresources = []string{"acl", "cors", "delete", "lifecycle", "partNumber", "policy", "tagging", "uploadId", "uploads", "versioning", "versions"}
+
+// runServeS3 implements "serve s3", a minimal S3 API over the files of a
+// channel: buckets are the top directories of its remote paths, and objects
+// the documents below them. PutObject, GetObject, HeadObject and
+// ListObjects are supported, with path-style addressing only.
+func runServeS3(args []string) error {
+	config := &Config{}
+	flags := flag.NewFlagSet("serve s3", flag.ExitOnError)
+	credentialFlags(flags, config)
+	listen := flags.String("listen", "127.0.0.1:9000", "Address to serve the S3 API on; other than loopback addresses need -access-key")
+	accessKey := flags.String("access-key", "", "Access key clients must sign requests with (default: accept unsigned requests on loopback addresses)")
+	secretKey := flags.String("secret-key", os.Getenv(s3SecretKeyEnv), "Secret key of -access-key (default $"+s3SecretKeyEnv+")")
+	allowHost := allowHostFlag(flags)
+	flags.StringVar(&config.TargetID, "channel", "", "Chat the objects are stored in")
+	flags.StringVar(&config.JournalPath, "journal", defaultJournalPath, "Path of the upload journal, which indexes the objects")
+	flags.StringVar(&config.FileCachePath, "file-cache", defaultFileCachePath, "Keep the Telegram IDs of uploaded files here (empty to disable)")
+	timeoutFlags(flags, &config.Timeouts)
+	applyProgressFlags := progressFlags(flags)
+	applySystemdFlags := systemdFlags(flags)
+	applyLogTargetFlags := logTargetFlags(flags)
+	applyTracingFlags := tracingFlags(flags)
+	applyRateFlags := uploadRateFlags(flags)
+	flags.Parse(args)
+	if err := applyProgressFlags(); err != nil {
+		return err
+	}
+	applySystemdFlags()
+	if err := applyLogTargetFlags(); err != nil {
+		return err
+	}
+	if err := applyTracingFlags(); err != nil {
+		return err
+	}
+	rates, err := applyRateFlags()
+	if err != nil {
+		return err
+	}
+	config.Rates = rates
+
+	if config.TargetID == "" {
+		return withExitCode(exitUsage, errors.New("-channel is required"))
+	}
+	if config.JournalPath == "" {
+		return withExitCode(exitUsage, errors.New("the S3 gateway needs the journal to know which objects there are"))
+	}
+	if err := validateCredentials(config); err != nil {
+		return err
+	}
+	plainProgressForServers()
+	var auth *s3Auth
+	switch {
+	case *accessKey != "" && *secretKey != "":
+		auth = &s3Auth{accessKey: *accessKey, secretKey: *secretKey}
+	case *accessKey != "" || *secretKey != "":
+		return withExitCode(exitUsage, errors.New("-access-key and -secret-key go together"))
+	case !isLoopback(*listen):
+		return withExitCode(exitUsage, fmt.Errorf("-listen %s can be reached from other machines; set -access-key and -secret-key to require signed requests", *listen))
+	}
+
+	return withClient(config, func(ctx context.Context, client *telegram.Client) error {
+		p, err := telegramuploader.ResolvePeer(ctx, client.API(), config.TargetID)
+		if err != nil {
+			return err
+		}
+		config.Peer = p
+		fmt.Printf("Serving %s as S3 at http://%s; press Ctrl-C to stop\n", remotePath{Chat: config.TargetID}, *listen)
+		return serveHTTP(ctx, client, *listen, newHostGuard(*allowHost, &s3Gateway{client: client, config: config, auth: auth}))
+	})
+}
+
+// s3Gateway answers S3 requests. Its config names the channel and holds
+// the journal, which is reread for every request so that uploads made
+// meanwhile by other commands show up.
+type s3Gateway struct {
+	client *telegram.Client
+	config *Config
+	auth   *s3Auth // nil when requests aren't signed
+}
+
+// ServeHTTP implements http.Handler
+func (g *s3Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var sig *s3Signature
+	if g.auth != nil {
+		var err error
+		if sig, err = g.auth.verify(r, time.Now()); err != nil {
+			var authErr *s3AuthError
+			errors.As(err, &authErr)
+			log.Printf("Refusing %s %s from %s: %s", r.Method, r.URL.Path, r.RemoteAddr, authErr.message)
+			s3Error(w, http.StatusForbidden, authErr.code, authErr.message, r.URL.Path)
+			return
+		}
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	for _, sub := range s3Subresources {
+		if query.Has(sub) {
+			s3Error(w, http.StatusNotImplemented, "NotImplemented", "?"+sub+" isn't supported by this gateway", r.URL.Path)
+			return
+		}
+	}
+
+	switch {
+	case bucket == "" && r.Method == http.MethodGet:
+		g.listBuckets(w, r)
+	case key == "" && (r.Method == http.MethodPut || r.Method == http.MethodHead):
+		// Buckets are just the first directory of object paths, so they
+		// always exist
+		w.WriteHeader(http.StatusOK)
+	case key == "" && r.Method == http.MethodGet && query.Has("location"):
+		writeXML(w, http.StatusOK, s3Location{})
+	case key == "" && r.Method == http.MethodGet:
+		g.listObjects(w, r, bucket)
+	case key != "" && r.Method == http.MethodPut:
+		if r.Header.Get("X-Amz-Copy-Source") != "" {
+			s3Error(w, http.StatusNotImplemented, "NotImplemented", "copying objects isn't supported by this gateway", r.URL.Path)
+			return
+		}
+		g.putObject(w, r, bucket+"/"+key, sig)
+	case key != "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		g.getObject(w, r, bucket+"/"+key)
+	default:
+		s3Error(w, http.StatusNotImplemented, "NotImplemented", r.Method+" isn't supported here by this gateway", r.URL.Path)
+	}
+}
+
+// objects returns the objects at or under p in the channel, sorted by path
+func (g *s3Gateway) objects(p string) ([]JournalEntry, error) {
+	entries, err := readJournal(g.config.JournalPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+	return remoteObjects(entries, remotePath{Chat: g.config.TargetID, Path: p}), nil
+}
+
+// listBuckets answers ListBuckets with the top directories of the channel
+func (g *s3Gateway) listBuckets(w http.ResponseWriter, r *http.Request) {
+	objects, err := g.objects("")
+	if err != nil {
+		s3Error(w, http.StatusInternalServerError, "InternalError", err.Error(), r.URL.Path)
+		return
+	}
+	// A bucket was created when its first object was
+	created := map[string]time.Time{}
+	for _, e := range objects {
+		bucket, _, ok := strings.Cut(objectPath(e), "/")
+		if t, seen := created[bucket]; ok && (!seen || e.Time.Before(t)) {
+			created[bucket] = e.Time
+		}
+	}
+	result := s3BucketList{}
+	for bucket, t := range created {
+		result.Buckets = append(result.Buckets, s3Bucket{Name: bucket, CreationDate: t.UTC().Format(s3TimeFormat)})
+	}
+	sort.Slice(result.Buckets, func(i, j int) bool { return result.Buckets[i].Name < result.Buckets[j].Name })
+	writeXML(w, http.StatusOK, result)
+}
+
+// listObjects answers ListObjects and ListObjectsV2, grouping keys into
+// common prefixes at the delimiter like S3 does
+func (g *s3Gateway) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	v2 := query.Get("list-type") == "2"
+	result := s3ListResult{
+		Name:      bucket,
+		Prefix:    query.Get("prefix"),
+		Delimiter: query.Get("delimiter"),
+		MaxKeys:   1000,
+	}
+	if s := query.Get("max-keys"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			s3Error(w, http.StatusBadRequest, "InvalidArgument", "invalid max-keys", r.URL.Path)
+			return
+		}
+		result.MaxKeys = min(n, 1000)
+	}
+	// Keys up to marker have been listed already
+	marker := query.Get("marker")
+	if v2 {
+		result.ContinuationToken = query.Get("continuation-token")
+		result.StartAfter = query.Get("start-after")
+		marker = max(result.ContinuationToken, result.StartAfter)
+	} else {
+		result.Marker = marker
+	}
+
+	objects, err := g.objects(bucket)
+	if err != nil {
+		s3Error(w, http.StatusInternalServerError, "InternalError", err.Error(), r.URL.Path)
+		return
+	}
+	var last string
+	for _, e := range objects {
+		key := strings.TrimPrefix(objectPath(e), bucket+"/")
+		if key == objectPath(e) || !strings.HasPrefix(key, result.Prefix) || key <= marker {
+			continue
+		}
+		// A marker ending at the delimiter is a common prefix listed whole
+		if result.Delimiter != "" && strings.HasSuffix(marker, result.Delimiter) && strings.HasPrefix(key, marker) {
+			continue
+		}
+		commonPrefix := ""
+		if result.Delimiter != "" {
+			if i := strings.Index(key[len(result.Prefix):], result.Delimiter); i >= 0 {
+				commonPrefix = key[:len(result.Prefix)+i+len(result.Delimiter)]
+			}
+		}
+		if commonPrefix != "" && commonPrefix == last {
+			continue
+		}
+		if len(result.Contents)+len(result.CommonPrefixes) == result.MaxKeys {
+			result.IsTruncated = true
+			if v2 {
+				result.NextContinuationToken = last
+			} else {
+				result.NextMarker = last
+			}
+			break
+		}
+		if commonPrefix != "" {
+			result.CommonPrefixes = append(result.CommonPrefixes, s3Prefix{Prefix: commonPrefix})
+			last = commonPrefix
+			continue
+		}
+		result.Contents = append(result.Contents, s3Object{
+			Key:          key,
+			LastModified: e.Time.UTC().Format(s3TimeFormat),
+			ETag:         s3ETag(e),
+			Size:         e.Size,
+			StorageClass: "STANDARD",
+		})
+		last = key
+	}
+	if v2 {
+		result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// putObject answers PutObject, uploading the body to the channel at p
+func (g *s3Gateway) putObject(w http.ResponseWriter, r *http.Request, p string, sig *s3Signature) {
+	if path.Clean(p) != p || strings.HasSuffix(p, "/") {
+		s3Error(w, http.StatusBadRequest, "InvalidArgument", "keys must be clean slash separated paths, without empty or dot components", r.URL.Path)
+		return
+	}
+	body, size, err := s3Body(r, sig)
+	if err != nil {
+		s3Error(w, http.StatusNotImplemented, "NotImplemented", err.Error(), r.URL.Path)
+		return
+	}
+	if size == 0 {
+		s3Error(w, http.StatusBadRequest, "InvalidArgument", "Telegram can't store empty objects", r.URL.Path)
+		return
+	}
+	if size > telegramuploader.MaxFileSize {
+		s3Error(w, http.StatusBadRequest, "EntityTooLarge", fmt.Sprintf("objects may be at most %d MB", telegramuploader.MaxFileSize>>20), r.URL.Path)
+		return
+	}
+
+	entries, err := readJournal(g.config.JournalPath)
+	if err != nil {
+		s3Error(w, http.StatusInternalServerError, "InternalError", fmt.Sprintf("failed to read journal: %v", err), r.URL.Path)
+		return
+	}
+	hasher := sha256.New()
+	err = putRemote(r.Context(), g.client, g.config, entries, remotePath{Chat: g.config.TargetID, Path: p}, io.TeeReader(body, hasher), size)
+	if err != nil {
+		log.Printf("Failed to store %s: %v", p, friendlyError(err))
+		s3Error(w, http.StatusInternalServerError, "InternalError", friendlyError(err).Error(), r.URL.Path)
+		return
+	}
+	w.Header().Set("ETag", `"`+hex.EncodeToString(hasher.Sum(nil))+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+// getObject answers GetObject, including range requests, and HeadObject
+func (g *s3Gateway) getObject(w http.ResponseWriter, r *http.Request, p string) {
+	objects, err := g.objects(p)
+	if err != nil {
+		s3Error(w, http.StatusInternalServerError, "InternalError", err.Error(), r.URL.Path)
+		return
+	}
+	if len(objects) == 0 || objectPath(objects[0]) != p {
+		s3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.", r.URL.Path)
+		return
+	}
+	e := objects[0]
+	w.Header().Set("ETag", s3ETag(e))
+	if e.MimeType != "" {
+		w.Header().Set("Content-Type", e.MimeType)
+	}
+	if r.Method == http.MethodHead {
+		// Answered from the journal alone
+		w.Header().Set("Content-Length", strconv.FormatInt(e.Size, 10))
+		w.Header().Set("Last-Modified", e.Time.UTC().Format(http.TimeFormat))
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	f, err := openRemote(r.Context(), g.client.API(), g.config.Peer, e)
+	if err != nil {
+		log.Printf("Failed to open %s: %v", p, friendlyError(err))
+		s3Error(w, http.StatusInternalServerError, "InternalError", friendlyError(err).Error(), r.URL.Path)
+		return
+	}
+	http.ServeContent(w, r, path.Base(p), e.Time, f)
+}
+
+// s3ETag returns the ETag of the object of e: its SHA-256 rather than the
+// MD5 S3 uses, which clients only compare
+func s3ETag(e JournalEntry) string {
+	return `"` + e.SHA256 + `"`
+}
+
+// awsChunkedReader decodes the aws-chunked encoding of streamed uploads.
+// With sig, each chunk must carry its signature, which chains from the
+// request's.
+type awsChunkedReader struct {
+	r    *bufio.Reader
+	left int64 // bytes left of the current chunk
+	err  error
+
+	sig  *s3Signature
+	prev string    // signature of the previous chunk
+	want string    // signature of the current one
+	hash hash.Hash // of the current chunk
+}
+
+// Read implements io.Reader
+func (c *awsChunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.left == 0 {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			c.err = io.ErrUnexpectedEOF
+			return 0, c.err
+		}
+		size, ext, _ := strings.Cut(strings.TrimSpace(line), ";")
+		n, err := strconv.ParseInt(size, 16, 64)
+		if err != nil || n < 0 {
+			c.err = fmt.Errorf("malformed aws-chunked header %q", line)
+			return 0, c.err
+		}
+		if c.hash != nil {
+			c.want, _ = strings.CutPrefix(ext, "chunk-signature=")
+			c.hash.Reset()
+		}
+		if n == 0 {
+			// Trailers may follow, but nothing of the object
+			c.err = c.checkChunk()
+			if c.err == nil {
+				c.err = io.EOF
+			}
+			return 0, c.err
+		}
+		c.left = n
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	if c.hash != nil {
+		c.hash.Write(p[:n])
+	}
+	if c.left == 0 && err == nil {
+		// The line break after the chunk
+		if _, err = c.r.Discard(2); err == nil {
+			err = c.checkChunk()
+		}
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	c.err = err
+	return n, err
+}
+
+// checkChunk checks the signature of the chunk just read, when the upload
+// is signed
+func (c *awsChunkedReader) checkChunk() error {
+	if c.hash == nil {
+		return nil
+	}
+	stringToSign := "AWS4-HMAC-SHA256-PAYLOAD\n" + c.sig.date + "\n" + c.sig.scope + "\n" + c.prev + "\n" +
+		emptySHA256 + "\n" + hex.EncodeToString(c.hash.Sum(nil))
+	expected := s3Sign(c.sig.key, stringToSign)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(c.want)) != 1 {
+		return errors.New("a chunk of the upload doesn't match its signature")
+	}
+	c.prev = expected
+	return nil
+}
+
+// writeXML writes v as the XML body of a response with status
+func writeXML(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+// s3Error writes an S3 error response
+func s3Error(w http.ResponseWriter, status int, code, message, resource string) {
+	writeXML(w, status, s3ErrorResult{Code: code, Message: message, Resource: resource})
+}
+
+type s3ErrorResult struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string
+	Message  string
+	Resource string
+}
+
+type s3Location struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ LocationConstraint"`
+}
+
+type s3Bucket struct {
+	Name         string
+	CreationDate string
+}
+
+type s3BucketList struct {
+	XMLName xml.Name   `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	Buckets []s3Bucket `xml:"Buckets>Bucket"`
+}
+
+type s3Object struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type s3Prefix struct {
+	Prefix string
+}
+
+type s3ListResult struct {
+	XMLName               xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string
+	Prefix                string
+	Delimiter             string `xml:",omitempty"`
+	Marker                string `xml:",omitempty"`
+	NextMarker            string `xml:",omitempty"`
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	StartAfter            string `xml:",omitempty"`
+	KeyCount              int    `xml:",omitempty"`
+	MaxKeys               int
+	IsTruncated           bool
+	Contents              []s3Object
+	CommonPrefixes        []s3Prefix
+}
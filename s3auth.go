@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3SecretKeyEnv holds the secret key of the S3 gateway's -access-key
+const s3SecretKeyEnv = "FILEUPLOADER_S3_SECRET_KEY"
+
+const (
+	// s3Algorithm is the only signature algorithm the gateway checks
+	s3Algorithm = "AWS4-HMAC-SHA256"
+	// s3DateFormat is how SigV4 writes request times
+	s3DateFormat = "20060102T150405Z"
+	// s3MaxSkew is how far the clock of a client may be off, as S3 allows
+	s3MaxSkew = 15 * time.Minute
+	// emptySHA256 is the SHA-256 of no bytes
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// s3Auth checks the AWS Signature Version 4 of requests against one key
+// pair
+type s3Auth struct {
+	accessKey, secretKey string
+}
+
+// s3Signature is what a checked request was signed with, which the chunks
+// of a streamed upload are signed with too
+type s3Signature struct {
+	key       []byte // derived signing key
+	date      string // X-Amz-Date
+	scope     string // credential scope
+	signature string // of the request, the seed of the chunk signatures
+}
+
+// s3AuthError is an S3 error answering a request that failed the check
+type s3AuthError struct {
+	code, message string
+}
+
+func (e *s3AuthError) Error() string {
+	return e.code + ": " + e.message
+}
+
+// verify checks the signature in the Authorization header or the query of
+// a presigned URL of r. When the client signed the payload's hash, r.Body
+// is replaced by a reader that fails at the end if the body doesn't match.
+func (a *s3Auth) verify(r *http.Request, now time.Time) (*s3Signature, error) {
+	query := r.URL.Query()
+	var credential, signedHeaders, signature, date, payload string
+	presigned := query.Has("X-Amz-Signature")
+	if presigned {
+		if query.Get("X-Amz-Algorithm") != s3Algorithm {
+			return nil, &s3AuthError{"AuthorizationQueryParametersError", "X-Amz-Algorithm must be " + s3Algorithm}
+		}
+		credential, signedHeaders = query.Get("X-Amz-Credential"), query.Get("X-Amz-SignedHeaders")
+		signature, date = query.Get("X-Amz-Signature"), query.Get("X-Amz-Date")
+		payload = "UNSIGNED-PAYLOAD"
+	} else {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			return nil, &s3AuthError{"AccessDenied", "requests must be signed"}
+		}
+		params, ok := strings.CutPrefix(header, s3Algorithm+" ")
+		if !ok {
+			return nil, &s3AuthError{"AccessDenied", "only " + s3Algorithm + " signatures are supported"}
+		}
+		for _, param := range strings.Split(params, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			switch name {
+			case "Credential":
+				credential = value
+			case "SignedHeaders":
+				signedHeaders = value
+			case "Signature":
+				signature = value
+			}
+		}
+		date = r.Header.Get("X-Amz-Date")
+		payload = r.Header.Get("X-Amz-Content-Sha256")
+		if payload == "" {
+			return nil, &s3AuthError{"InvalidRequest", "X-Amz-Content-Sha256 is required"}
+		}
+	}
+	if credential == "" || signedHeaders == "" || signature == "" || date == "" {
+		return nil, &s3AuthError{"AccessDenied", "the signature is incomplete"}
+	}
+
+	t, err := time.Parse(s3DateFormat, date)
+	if err != nil {
+		return nil, &s3AuthError{"AccessDenied", fmt.Sprintf("invalid X-Amz-Date %q", date)}
+	}
+	if presigned {
+		expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+		if err != nil || expires < 1 || expires > 7*24*60*60 {
+			return nil, &s3AuthError{"AuthorizationQueryParametersError", "X-Amz-Expires must be between 1 second and 7 days"}
+		}
+		if now.Before(t.Add(-s3MaxSkew)) || now.After(t.Add(time.Duration(expires)*time.Second)) {
+			return nil, &s3AuthError{"AccessDenied", "the presigned URL has expired"}
+		}
+	} else if d := now.Sub(t); d > s3MaxSkew || d < -s3MaxSkew {
+		return nil, &s3AuthError{"RequestTimeTooSkewed", "the difference between the request time and the server's time is too large"}
+	}
+
+	// Credential is <key>/<date>/<region>/s3/aws4_request
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[1] != date[:8] || parts[3] != "s3" || parts[4] != "aws4_request" {
+		return nil, &s3AuthError{"AccessDenied", fmt.Sprintf("invalid credential scope %q", credential)}
+	}
+	if parts[0] != a.accessKey {
+		return nil, &s3AuthError{"InvalidAccessKeyId", "the access key doesn't exist"}
+	}
+	scope := strings.Join(parts[1:], "/")
+
+	headers := strings.Split(signedHeaders, ";")
+	if !sort.StringsAreSorted(headers) || !slices.Contains(headers, "host") {
+		return nil, &s3AuthError{"AccessDenied", "the signed headers must be sorted and include host"}
+	}
+	canonical := strings.Join([]string{
+		r.Method,
+		s3Escape(r.URL.Path, false),
+		s3CanonicalQuery(r),
+		s3CanonicalHeaders(r, headers),
+		signedHeaders,
+		payload,
+	}, "\n")
+	sum := sha256.Sum256([]byte(canonical))
+	key := s3SigningKey(a.secretKey, parts[1], parts[2])
+	expected := s3Sign(key, s3Algorithm+"\n"+date+"\n"+scope+"\n"+hex.EncodeToString(sum[:]))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(signature)) != 1 {
+		return nil, &s3AuthError{"SignatureDoesNotMatch", "the request signature doesn't match the one calculated with the secret key"}
+	}
+
+	if len(payload) == sha256.Size*2 && r.Body != nil {
+		if want, err := hex.DecodeString(payload); err == nil {
+			r.Body = &hashCheckReader{body: r.Body, hash: sha256.New(), want: want}
+		}
+	}
+	return &s3Signature{key: key, date: date, scope: scope, signature: signature}, nil
+}
+
+// s3SigningKey derives the key requests of date and region are signed
+// with from secret
+func s3SigningKey(secret, date, region string) []byte {
+	key := []byte("AWS4" + secret)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return key
+}
+
+// s3Sign returns the hex signature of stringToSign with key
+func s3Sign(key []byte, stringToSign string) string {
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	io.WriteString(mac, data)
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes s as SigV4 does: everything but unreserved
+// characters, and slashes too unless it's a path
+func s3Escape(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !slash {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3CanonicalQuery returns the sorted, encoded query of r without the
+// signature
+func s3CanonicalQuery(r *http.Request) string {
+	var pairs [][2]string
+	for name, values := range r.URL.Query() {
+		if name == "X-Amz-Signature" {
+			continue
+		}
+		for _, v := range values {
+			pairs = append(pairs, [2]string{s3Escape(name, true), s3Escape(v, true)})
+		}
+	}
+	// By name, then value
+	slices.SortFunc(pairs, func(a, b [2]string) int {
+		if c := strings.Compare(a[0], b[0]); c != 0 {
+			return c
+		}
+		return strings.Compare(a[1], b[1])
+	})
+	query := make([]string, len(pairs))
+	for i, p := range pairs {
+		query[i] = p[0] + "=" + p[1]
+	}
+	return strings.Join(query, "&")
+}
+
+// s3CanonicalHeaders returns the lines of the signed headers of r
+func s3CanonicalHeaders(r *http.Request, names []string) string {
+	var b strings.Builder
+	for _, name := range names {
+		values := slices.Clone(r.Header.Values(name))
+		switch {
+		case name == "host":
+			values = []string{r.Host}
+		case name == "content-length" && len(values) == 0 && r.ContentLength >= 0:
+			values = []string{strconv.FormatInt(r.ContentLength, 10)}
+		}
+		for i, v := range values {
+			values[i] = strings.Join(strings.Fields(v), " ")
+		}
+		b.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+	}
+	return b.String()
+}
+
+// errPayloadMismatch is returned at the end of a body that doesn't match
+// the hash it was signed with
+var errPayloadMismatch = errors.New("the body doesn't match its signed SHA-256")
+
+// hashCheckReader fails at the end of body unless it hashed to want
+type hashCheckReader struct {
+	body io.ReadCloser
+	hash hash.Hash
+	want []byte
+}
+
+// Read implements io.Reader
+func (h *hashCheckReader) Read(p []byte) (int, error) {
+	n, err := h.body.Read(p)
+	h.hash.Write(p[:n])
+	if err == io.EOF && !hmac.Equal(h.hash.Sum(nil), h.want) {
+		err = errPayloadMismatch
+	}
+	return n, err
+}
+
+// Close implements io.Closer
+func (h *hashCheckReader) Close() error {
+	return h.body.Close()
+}
+
+// s3Body returns the object in the body of r and its size, or -1 when it's
+// unknown, decoding streamed uploads and checking their chunk signatures
+// against sig unless it's nil
+func s3Body(r *http.Request, sig *s3Signature) (io.Reader, int64, error) {
+	payload := r.Header.Get("X-Amz-Content-Sha256")
+	if !strings.HasPrefix(payload, "STREAMING-") {
+		return r.Body, r.ContentLength, nil
+	}
+	chunked := &awsChunkedReader{r: bufio.NewReader(r.Body)}
+	switch payload {
+	case "STREAMING-UNSIGNED-PAYLOAD-TRAILER":
+	case "STREAMING-AWS4-HMAC-SHA256-PAYLOAD", "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER":
+		chunked.sig = sig
+		if sig != nil {
+			chunked.prev = sig.signature
+			chunked.hash = sha256.New()
+		}
+	default:
+		return nil, 0, fmt.Errorf("%s uploads aren't supported", payload)
+	}
+	size := int64(-1)
+	if n, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64); err == nil {
+		size = n
+	}
+	return chunked, size, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// sendSequence makes uploads running at the same time send their messages
+// in a fixed order, so the chat shows the files in the order they were
+// queued even when a later one finishes uploading first. Turns are numbered
+// from 0, and every turn has to be marked done, whether it sent anything or
+// not, for the later ones to go ahead.
+type sendSequence struct {
+	mu       sync.Mutex
+	next     int                   // the turn allowed to send
+	finished map[int]bool          // turns done out of order
+	turns    map[int]chan struct{} // closed when the turn comes
+}
+
+func newSendSequence() *sendSequence {
+	return &sendSequence{finished: map[int]bool{}, turns: map[int]chan struct{}{}}
+}
+
+// ready returns the channel that is closed when it's turn's turn; s.mu
+// must be held
+func (s *sendSequence) ready(turn int) chan struct{} {
+	c, ok := s.turns[turn]
+	if !ok {
+		c = make(chan struct{})
+		s.turns[turn] = c
+		if turn <= s.next {
+			close(c)
+		}
+	}
+	return c
+}
+
+// wait blocks until all turns before turn are done. A nil sequence never
+// waits.
+func (s *sendSequence) wait(ctx context.Context, turn int) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	c := s.ready(turn)
+	s.mu.Unlock()
+	select {
+	case <-c:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// done marks turn as finished, letting the next one send
+func (s *sendSequence) done(turn int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finished[turn] = true
+	for s.finished[s.next] {
+		delete(s.finished, s.next)
+		delete(s.turns, s.next)
+		s.next++
+		if c, ok := s.turns[s.next]; ok {
+			close(c)
+		}
+	}
+}
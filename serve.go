@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gotd/td/telegram"
+)
+
+// runServe implements the "serve" subcommand, which makes the files of a
+// chat available over another protocol
+func runServe(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "ftp":
+			return runServeFTP(args[1:])
+		case "s3":
+			return runServeS3(args[1:])
+		case "webdav":
+			return runServeWebDAV(args[1:])
+		}
+	}
+	return withExitCode(exitUsage, errors.New("usage: serve ftp|s3|webdav [flags]"))
+}
+
+// plainProgressForServers switches progress bars to plain lines, as a
+// server's requests run at the same time and their bars would overwrite
+// each other
+func plainProgressForServers() {
+	if progressMode == "bar" {
+		progressMode = "plain"
+	}
+}
+
+// isLoopback reports whether the listen address addr can only be reached
+// from this machine
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return host == "localhost" || (ip != nil && ip.IsLoopback())
+}
+
+// allowHostFlag registers -allow-host on fs
+func allowHostFlag(fs *flag.FlagSet) *string {
+	return fs.String("allow-host", "", "Comma-separated host names clients may reach the server by, besides IP addresses and localhost")
+}
+
+// hostGuard refuses requests whose Host isn't an IP address, localhost or
+// one of names, so that web pages can't reach the server through a DNS
+// name rebound to its address
+type hostGuard struct {
+	names []string
+	next  http.Handler
+}
+
+// ServeHTTP implements http.Handler
+func (g hostGuard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(strings.Trim(host, "[]"), "."))
+	if net.ParseIP(host) == nil && host != "localhost" && !slices.Contains(g.names, host) {
+		log.Printf("Refusing a request from %s for host %q; add it with -allow-host if it's meant to reach the server", r.RemoteAddr, r.Host)
+		http.Error(w, "unknown host", http.StatusMisdirectedRequest)
+		return
+	}
+	g.next.ServeHTTP(w, r)
+}
+
+// newHostGuard returns h guarded by the host names of the -allow-host
+// value allowed
+func newHostGuard(allowed string, h http.Handler) http.Handler {
+	var names []string
+	for _, name := range splitList(allowed) {
+		names = append(names, strings.ToLower(strings.TrimSuffix(name, ".")))
+	}
+	return hostGuard{names: names, next: h}
+}
+
+// basicAuth requires the user and password of HTTP basic authentication
+// for every request
+type basicAuth struct {
+	user, password string
+	realm          string
+	next           http.Handler
+}
+
+// ServeHTTP implements http.Handler
+func (a basicAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, password, ok := r.BasicAuth()
+	if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(a.user)) != 1 ||
+		subtle.ConstantTimeCompare([]byte(password), []byte(a.password)) != 1 {
+		if ok {
+			log.Printf("Failed login as %q from %s", user, r.RemoteAddr)
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+a.realm+`", charset="UTF-8"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	a.next.ServeHTTP(w, r)
+}
+
+// serveHTTP serves h on addr until ctx is done, and tells systemd it is
+// ready once it listens
+func serveHTTP(ctx context.Context, client *telegram.Client, addr string, h http.Handler) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	daemonReady(ctx, client)
+	server := &http.Server{
+		Handler:     h,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		// Let requests in progress finish for a moment
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	if err := server.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
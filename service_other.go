@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// runService is only available on Windows; elsewhere the long-running
+// commands are run by the init system, like systemd with -systemd
+func runService(args []string) error {
+	return errors.New("the service command is only supported on Windows; use a systemd unit or launchd job instead")
+}
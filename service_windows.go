@@ -0,0 +1,271 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// defaultServiceName is the name services are installed under by default
+const defaultServiceName = "fileuploader"
+
+// serviceStopTimeout is how long a stopping service waits for its command
+// to wind down, and "service stop" for the service to stop
+const serviceStopTimeout = 30 * time.Second
+
+// runService implements the "service" subcommand, which runs one of the
+// long-running commands as a Windows service, so that it starts at boot
+// without anyone logged in
+func runService(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "install":
+			return installService(args[1:])
+		case "remove":
+			return controlService("service remove", args[1:], removeService)
+		case "start":
+			return controlService("service start", args[1:], startService)
+		case "stop":
+			return controlService("service stop", args[1:], stopService)
+		case "run":
+			return runAsService(args[1:])
+		}
+	}
+	return withExitCode(exitUsage, errors.New("usage: service install|remove|start|stop [flags]"))
+}
+
+// installService implements "service install", which registers a command
+// and its flags, like "mirror -from a -to b", to be run at boot
+func installService(args []string) error {
+	flags := flag.NewFlagSet("service install", flag.ExitOnError)
+	name := flags.String("name", defaultServiceName, "Name of the service")
+	dir := flags.String("dir", "", "Directory to run the command in, which holds its sessions and journal (default: the current directory)")
+	flags.Parse(args)
+	command := flags.Args()
+	if len(command) == 0 {
+		return withExitCode(exitUsage, errors.New("usage: service install [flags] <command> [command flags]"))
+	}
+	if cmd := subcommand(command[0]); cmd == nil || command[0] == "service" {
+		return withExitCode(exitUsage, fmt.Errorf("%q isn't a command that can run as a service", command[0]))
+	}
+
+	if *dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get the current directory: %w", err)
+		}
+		*dir = wd
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the program's path: %w", err)
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(*name); err == nil {
+		s.Close()
+		return withExitCode(exitUsage, fmt.Errorf("service %s already exists; remove it first", *name))
+	}
+
+	runArgs := append([]string{"service", "run", "-name", *name, "-dir", *dir, "--"}, command...)
+	s, err := m.CreateService(*name, exe, mgr.Config{
+		DisplayName: "Telegram file uploader (" + *name + ")",
+		Description: "Runs: " + strings.Join(command, " "),
+		StartType:   mgr.StartAutomatic,
+	}, runArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to create service %s: %w", *name, err)
+	}
+	defer s.Close()
+	// Come back after a crash or a lost connection that ended the command
+	restart := []mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 10 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: time.Minute},
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Minute},
+	}
+	if err := s.SetRecoveryActions(restart, uint32((24 * time.Hour).Seconds())); err != nil {
+		s.Delete()
+		return fmt.Errorf("failed to set the restart policy of service %s: %w", *name, err)
+	}
+	if err := s.SetRecoveryActionsOnNonCrashFailures(true); err != nil {
+		s.Delete()
+		return fmt.Errorf("failed to set the restart policy of service %s: %w", *name, err)
+	}
+	if err := eventlog.InstallAsEventCreate(*name, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("failed to register the event log source of service %s: %w", *name, err)
+	}
+
+	fmt.Printf("Installed service %s running %s in %s\n", *name, strings.Join(command, " "), *dir)
+	fmt.Println("The service can't ask for a login code: run the command yourself in that directory once to log in, then start it with \"service start\"")
+	return nil
+}
+
+// controlService parses the flags of a service command that acts on an
+// installed service, and calls fn with it
+func controlService(name string, args []string, fn func(s *mgr.Service, name string) error) error {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	serviceName := flags.String("name", defaultServiceName, "Name of the service")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		return withExitCode(exitUsage, fmt.Errorf("usage: %s [-name service]", name))
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(*serviceName)
+	if err != nil {
+		return fmt.Errorf("failed to open service %s: %w", *serviceName, err)
+	}
+	defer s.Close()
+	return fn(s, *serviceName)
+}
+
+// startService starts the service s
+func startService(s *mgr.Service, name string) error {
+	if err := s.Start(); err != nil {
+		return fmt.Errorf("failed to start service %s: %w", name, err)
+	}
+	fmt.Printf("Started service %s\n", name)
+	return nil
+}
+
+// stopService stops the service s and waits for it to have stopped
+func stopService(s *mgr.Service, name string) error {
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return fmt.Errorf("failed to stop service %s: %w", name, err)
+	}
+	deadline := time.Now().Add(serviceStopTimeout)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service %s didn't stop within %s", name, serviceStopTimeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+		if status, err = s.Query(); err != nil {
+			return fmt.Errorf("failed to query service %s: %w", name, err)
+		}
+	}
+	fmt.Printf("Stopped service %s\n", name)
+	return nil
+}
+
+// removeService uninstalls the service s. A running service is removed
+// once it stops.
+func removeService(s *mgr.Service, name string) error {
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("failed to remove service %s: %w", name, err)
+	}
+	if err := eventlog.Remove(name); err != nil {
+		return fmt.Errorf("failed to remove the event log source of service %s: %w", name, err)
+	}
+	fmt.Printf("Removed service %s\n", name)
+	return nil
+}
+
+// runAsService implements "service run", which is how the service manager
+// starts an installed service
+func runAsService(args []string) error {
+	flags := flag.NewFlagSet("service run", flag.ExitOnError)
+	name := flags.String("name", defaultServiceName, "Name of the service")
+	dir := flags.String("dir", ".", "Directory to run the command in")
+	flags.Parse(args)
+	command := flags.Args()
+	if len(command) == 0 {
+		return withExitCode(exitUsage, errors.New("usage: service run [flags] <command> [command flags]"))
+	}
+	cmd := subcommand(command[0])
+	if cmd == nil {
+		return withExitCode(exitUsage, fmt.Errorf("unknown command %q", command[0]))
+	}
+	if isService, err := svc.IsWindowsService(); err != nil || !isService {
+		return withExitCode(exitUsage, errors.New("service run is for the service manager; run the command directly instead"))
+	}
+
+	// Services start in the system directory
+	if err := os.Chdir(*dir); err != nil {
+		return fmt.Errorf("failed to change to %s: %w", *dir, err)
+	}
+	elog, err := eventlog.Open(*name)
+	if err != nil {
+		return fmt.Errorf("failed to open the event log: %w", err)
+	}
+	defer elog.Close()
+	return svc.Run(*name, &serviceHandler{
+		run:  func() error { return cmd(command[1:]) },
+		elog: elog,
+	})
+}
+
+// serviceHandler runs a command as a Windows service until it ends or the
+// service manager stops it
+type serviceHandler struct {
+	run  func() error
+	elog *eventlog.Log
+}
+
+// Execute implements svc.Handler
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Commands stop when the context of their client is done
+	baseContext = ctx
+	done := make(chan error, 1)
+	go func() { done <- h.run() }()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	h.elog.Info(1, "Started")
+
+	for {
+		select {
+		case err := <-done:
+			return h.finish(err)
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32(serviceStopTimeout.Milliseconds())}
+				cancel()
+				select {
+				case err := <-done:
+					if errors.Is(err, context.Canceled) {
+						err = nil
+					}
+					return h.finish(err)
+				case <-time.After(serviceStopTimeout):
+					h.elog.Warning(1, fmt.Sprintf("The command didn't stop within %s", serviceStopTimeout))
+					return false, 0
+				}
+			}
+		}
+	}
+}
+
+// finish logs how the command of the service ended, and returns the exit
+// code the service manager should see
+func (h *serviceHandler) finish(err error) (bool, uint32) {
+	if err != nil {
+		h.elog.Error(1, friendlyError(err).Error())
+		return true, uint32(exitCode(err))
+	}
+	h.elog.Info(1, "Stopped")
+	return false, 0
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// sendPacer spaces out messages to a group in slow mode, or to keep to
+// -messages-per-minute, also between uploads running at the same time
+type sendPacer struct {
+	interval time.Duration
+	reason   string // shown when waiting, like "Slow mode"
+	mu       sync.Mutex
+	next     time.Time // earliest time the next message may be sent
+}
+
+// newSendPacer returns a pacer for target, or nil if target isn't a
+// supergroup in slow mode or this account is exempt from it
+func newSendPacer(ctx context.Context, api *tg.Client, target tg.InputPeerClass) (*sendPacer, error) {
+	p, ok := target.(*tg.InputPeerChannel)
+	if !ok {
+		return nil, nil
+	}
+	full, err := api.ChannelsGetFullChannel(ctx, &tg.InputChannel{ChannelID: p.ChannelID, AccessHash: p.AccessHash})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get channel details: %w", err)
+	}
+	info, ok := full.FullChat.(*tg.ChannelFull)
+	if !ok {
+		return nil, nil
+	}
+	seconds, ok := info.GetSlowmodeSeconds()
+	if !ok || seconds == 0 {
+		return nil, nil
+	}
+	for _, c := range full.Chats {
+		if c, ok := c.(*tg.Channel); ok && c.ID == p.ChannelID {
+			if _, isAdmin := c.GetAdminRights(); c.Creator || isAdmin {
+				return nil, nil // admins aren't slowed down
+			}
+		}
+	}
+
+	pacer := &sendPacer{interval: time.Duration(seconds) * time.Second, reason: "Slow mode"}
+	if next, ok := info.GetSlowmodeNextSendDate(); ok {
+		pacer.next = time.Unix(int64(next), 0)
+	}
+	return pacer, nil
+}
+
+// wait blocks until the next message may be sent, taking that turn so
+// that a concurrent wait gets the one after it
+func (p *sendPacer) wait(ctx context.Context) error {
+	p.mu.Lock()
+	now := time.Now()
+	d := p.next.Sub(now)
+	p.next = maxTime(p.next, now).Add(p.interval)
+	p.mu.Unlock()
+	if d <= 0 {
+		return nil
+	}
+	fmt.Printf("%s: waiting %s before sending...\n", p.reason, d.Round(time.Second))
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// withRate returns a pacer sending at most perMinute messages a minute,
+// evenly spaced, that also keeps to p's own pace if p isn't nil
+func (p *sendPacer) withRate(perMinute int) *sendPacer {
+	interval := time.Minute / time.Duration(perMinute)
+	if p == nil {
+		return &sendPacer{interval: interval, reason: "Pacing"}
+	}
+	if interval > p.interval {
+		p.interval, p.reason = interval, "Pacing"
+	}
+	return p
+}
+
+// sent records that a message was just sent
+func (p *sendPacer) sent() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.next = maxTime(p.next, time.Now().Add(p.interval))
+}
+
+// maxTime returns the later of a and b
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// slowModeWait returns how long Telegram asked to wait if err is a
+// SLOWMODE_WAIT error
+func slowModeWait(err error) (time.Duration, bool) {
+	rpcErr, ok := tgerr.AsType(err, "SLOWMODE_WAIT")
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(rpcErr.Argument) * time.Second, true
+}
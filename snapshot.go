@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// snapshot records the state of a directory tree at backup time. File
+// contents are referenced by chunk ID only, so a snapshot is small and
+// unchanged files cost nothing to back up again.
+type snapshot struct {
+	ID        string         `json:"id"`
+	Time      time.Time      `json:"time"`
+	Hostname  string         `json:"hostname"`
+	Root      string         `json:"root"`
+	Nodes     []snapshotNode `json:"nodes"`
+	MessageID int            `json:"message_id"` // copy of the snapshot stored in the repository
+}
+
+// snapshotNode is a file, directory or symlink in a snapshot
+type snapshotNode struct {
+	Path    string      `json:"path"` // slash-separated, relative to Root
+	Type    string      `json:"type"` // "dir", "file" or "symlink"
+	Mode    fs.FileMode `json:"mode"`
+	Size    int64       `json:"size,omitempty"`
+	ModTime time.Time   `json:"mtime"`
+	Chunks  []string    `json:"chunks,omitempty"`
+	Target  string      `json:"target,omitempty"`
+}
+
+// snapshotStats summarizes a backup run
+type snapshotStats struct {
+	Files     int
+	Unchanged int
+	Uploaded  int64
+}
+
+// ShortID returns the abbreviated snapshot ID shown to users
+func (s *snapshot) ShortID() string {
+	return s.ID[:8]
+}
+
+// latestSnapshot returns the most recent snapshot of root, if any
+func (idx *backupIndex) latestSnapshot(root string) *snapshot {
+	var latest *snapshot
+	for _, s := range idx.Snapshots {
+		if s.Root == root && (latest == nil || s.Time.After(latest.Time)) {
+			latest = s
+		}
+	}
+	return latest
+}
+
+// createSnapshot backs up the tree at dir and records a new snapshot.
+// Files whose size and modification time match the previous snapshot of the
+// same directory reuse its chunk list without being read again; everything
+// else is chunked, and only chunks missing from the repository are uploaded.
+func createSnapshot(ctx context.Context, store *chunkStore, dir string) (*snapshot, snapshotStats, error) {
+	var stats snapshotStats
+
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, stats, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, stats, err
+	}
+	hostname, _ := os.Hostname()
+	snap := &snapshot{
+		ID:       hex.EncodeToString(id),
+		Time:     time.Now().UTC(),
+		Hostname: hostname,
+		Root:     root,
+	}
+
+	previous := map[string]snapshotNode{}
+	if parent := store.index.latestSnapshot(root); parent != nil {
+		for _, n := range parent.Nodes {
+			previous[n.Path] = n
+		}
+	}
+
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		node := snapshotNode{
+			Path:    filepath.ToSlash(rel),
+			Mode:    info.Mode(),
+			ModTime: info.ModTime().UTC(),
+		}
+
+		switch {
+		case d.IsDir():
+			node.Type = "dir"
+		case d.Type()&fs.ModeSymlink != 0:
+			node.Type = "symlink"
+			if node.Target, err = os.Readlink(path); err != nil {
+				return err
+			}
+		case d.Type().IsRegular():
+			node.Type = "file"
+			node.Size = info.Size()
+			stats.Files++
+
+			if prev, ok := previous[node.Path]; ok && prev.Type == "file" &&
+				prev.Size == node.Size && prev.ModTime.Equal(node.ModTime) {
+				node.Chunks = prev.Chunks
+				stats.Unchanged++
+				break
+			}
+
+			chunks, uploaded, err := store.storeFile(ctx, path)
+			if err != nil {
+				return fmt.Errorf("failed to back up %s: %w", path, err)
+			}
+			node.Chunks = chunks
+			stats.Uploaded += uploaded
+			if uploaded > 0 {
+				fmt.Printf("Stored %s (%.2f MB new)\n", node.Path, float64(uploaded)/(1024*1024))
+				// Keep the index in step with the repository so an
+				// interrupted run doesn't lose track of stored chunks
+				if err := store.index.save(); err != nil {
+					return fmt.Errorf("failed to save backup index: %w", err)
+				}
+			}
+		default:
+			// Sockets, devices and pipes can't be backed up
+			return nil
+		}
+
+		snap.Nodes = append(snap.Nodes, node)
+		return nil
+	})
+	if err != nil {
+		return nil, stats, err
+	}
+
+	// Keep a copy of the snapshot in the repository itself
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return nil, stats, err
+	}
+	name := fmt.Sprintf("snapshot-%s.json", snap.ShortID())
+	msg, err := sendDocumentBytes(ctx, store.api, store.peer, name, "application/json", data, "Snapshot of "+root)
+	if err != nil {
+		return nil, stats, fmt.Errorf("failed to store snapshot: %w", err)
+	}
+	snap.MessageID = msg.ID
+
+	store.index.Snapshots = append(store.index.Snapshots, snap)
+	if err := store.index.save(); err != nil {
+		return nil, stats, fmt.Errorf("failed to save backup index: %w", err)
+	}
+	return snap, stats, nil
+}
+
+// findSnapshot returns the snapshot whose ID starts with prefix, or the
+// newest snapshot for "latest"
+func (idx *backupIndex) findSnapshot(prefix string) (*snapshot, error) {
+	if prefix == "latest" {
+		var latest *snapshot
+		for _, s := range idx.Snapshots {
+			if latest == nil || s.Time.After(latest.Time) {
+				latest = s
+			}
+		}
+		if latest == nil {
+			return nil, errors.New("repository has no snapshots")
+		}
+		return latest, nil
+	}
+
+	var found *snapshot
+	for _, s := range idx.Snapshots {
+		if strings.HasPrefix(s.ID, prefix) {
+			if found != nil {
+				return nil, fmt.Errorf("snapshot ID %q is ambiguous", prefix)
+			}
+			found = s
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("snapshot %q not found", prefix)
+	}
+	return found, nil
+}
+
+// listSnapshots prints the snapshots in the index, oldest first
+func listSnapshots(idx *backupIndex) {
+	snapshots := append([]*snapshot(nil), idx.Snapshots...)
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Time.Before(snapshots[j].Time) })
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTime\tHost\tFiles\tSize\tRoot")
+	for _, s := range snapshots {
+		var files int
+		var size int64
+		for _, n := range s.Nodes {
+			if n.Type == "file" {
+				files++
+				size += n.Size
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%.2f MB\t%s\n", s.ShortID(), s.Time.Local().Format("2006-01-02 15:04:05"),
+			s.Hostname, files, float64(size)/(1024*1024), s.Root)
+	}
+	w.Flush()
+	fmt.Printf("%d snapshot(s)\n", len(snapshots))
+}
+
+// listSnapshotTree prints the nodes of s below dir ("" for the whole tree)
+func listSnapshotTree(s *snapshot, dir string) {
+	dir = strings.Trim(filepath.ToSlash(dir), "/")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, n := range s.Nodes {
+		if dir != "" && n.Path != dir && !strings.HasPrefix(n.Path, dir+"/") {
+			continue
+		}
+		name := n.Path
+		if n.Type == "symlink" {
+			name += " -> " + n.Target
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", n.Mode, n.Size, n.ModTime.Local().Format("2006-01-02 15:04"), name)
+	}
+	w.Flush()
+}
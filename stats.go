@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+)
+
+// storageStats summarizes what is stored in a target
+type storageStats struct {
+	Files   int
+	Bytes   int64
+	Months  []monthStats
+	Largest []JournalEntry
+}
+
+// monthStats is the growth of a target in one calendar month
+type monthStats struct {
+	Month string // YYYY-MM
+	Files int
+	Bytes int64
+}
+
+// computeStats aggregates entries, keeping the top largest files
+func computeStats(entries []JournalEntry, top int) storageStats {
+	var stats storageStats
+	months := map[string]*monthStats{}
+	for _, e := range entries {
+		stats.Files++
+		stats.Bytes += e.Size
+
+		key := e.Time.Local().Format("2006-01")
+		m, ok := months[key]
+		if !ok {
+			m = &monthStats{Month: key}
+			months[key] = m
+		}
+		m.Files++
+		m.Bytes += e.Size
+	}
+	for _, m := range months {
+		stats.Months = append(stats.Months, *m)
+	}
+	sort.Slice(stats.Months, func(i, j int) bool { return stats.Months[i].Month < stats.Months[j].Month })
+
+	stats.Largest = append([]JournalEntry(nil), entries...)
+	sort.SliceStable(stats.Largest, func(i, j int) bool { return stats.Largest[i].Size > stats.Largest[j].Size })
+	if len(stats.Largest) > top {
+		stats.Largest = stats.Largest[:top]
+	}
+	return stats
+}
+
+// runStats implements the stats subcommand
+func runStats(args []string) error {
+	config := &Config{}
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	credentialFlags(fs, config)
+	fs.StringVar(&config.TargetID, "target", "", "Only count uploads to this target")
+	journalPath := fs.String("journal", defaultJournalPath, "Path of the upload journal")
+	top := fs.Int("top", 10, "Number of largest files to list")
+	remote := fs.Bool("remote", false, "Also scan the target's history for documents, including ones not in the journal (needs -target and credentials)")
+	fs.Parse(args)
+
+	entries, err := readJournal(*journalPath)
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	var selected []JournalEntry
+	for _, e := range liveEntries(entries) {
+		if config.TargetID == "" || normalizeTarget(e.Target) == normalizeTarget(config.TargetID) {
+			selected = append(selected, e)
+		}
+	}
+
+	stats := computeStats(selected, *top)
+	label := config.TargetID
+	if label == "" {
+		label = "all targets"
+	}
+	fmt.Printf("Journal: %d file(s), %.2f MB in %s\n", stats.Files, float64(stats.Bytes)/(1024*1024), label)
+
+	if len(stats.Months) > 0 {
+		fmt.Println()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "Month\tFiles\tAdded\tTotal")
+		var total int64
+		for _, m := range stats.Months {
+			total += m.Bytes
+			fmt.Fprintf(w, "%s\t%d\t%.2f MB\t%.2f MB\n", m.Month, m.Files, float64(m.Bytes)/(1024*1024), float64(total)/(1024*1024))
+		}
+		w.Flush()
+	}
+
+	if len(stats.Largest) > 0 {
+		fmt.Println()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "Size\tUploaded\tTarget\tName")
+		for _, e := range stats.Largest {
+			name := e.Name
+			if e.OriginalName != "" {
+				name = e.OriginalName
+			}
+			fmt.Fprintf(w, "%.2f MB\t%s\t%s\t%s\n", float64(e.Size)/(1024*1024), e.Time.Local().Format("2006-01-02"), e.Target, name)
+		}
+		w.Flush()
+	}
+
+	if !*remote {
+		return nil
+	}
+	if config.TargetID == "" {
+		return fmt.Errorf("-remote needs -target")
+	}
+	if err := validateCredentials(config); err != nil {
+		return err
+	}
+	known := map[int]bool{}
+	for _, e := range selected {
+		known[e.MessageID] = true
+	}
+	return withClient(config, func(ctx context.Context, client *telegram.Client) error {
+		api := client.API()
+		target, err := telegramuploader.ResolvePeer(ctx, api, config.TargetID)
+		if err != nil {
+			return err
+		}
+
+		var files, untracked int
+		var bytes, untrackedBytes int64
+		err = scanDocuments(ctx, api, target, func(msg *tg.Message, doc *tg.Document) error {
+			files++
+			bytes += doc.Size
+			if !known[msg.ID] {
+				untracked++
+				untrackedBytes += doc.Size
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\nRemote: %d document(s), %.2f MB; %d document(s), %.2f MB not in the journal\n",
+			files, float64(bytes)/(1024*1024), untracked, float64(untrackedBytes)/(1024*1024))
+		return nil
+	})
+}
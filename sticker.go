@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+)
+
+// Telegram's limits for stickers
+const (
+	stickerSide          = 512        // pixels of the longer side
+	maxStickerSize       = 512 * 1024 // bytes of a static sticker
+	maxVideoStickerSize  = 256 * 1024 // bytes of a video sticker
+	maxVideoStickerSecs  = 3
+	maxVideoStickerFrame = 30 // frames per second
+)
+
+// stickerConfig sends a file as a sticker, once prepareSticker has
+// converted it
+type stickerConfig struct {
+	Emoji    string // Emoji the sticker stands for
+	Set      string // Short name of a sticker set to add it to; empty for none
+	SetTitle string // Title of the set if it has to be created
+
+	Video         bool // WEBM video sticker rather than a static WEBP one
+	Width, Height int
+	Duration      float64
+}
+
+// prepareSticker converts the image or video at path with ffmpeg into a
+// sticker: a WEBP image, or a silent VP9 WEBM of at most three seconds for
+// videos and GIFs, whose longer side is 512 pixels. It returns the path of
+// a temporary file with the result and the name to upload it under.
+func prepareSticker(path, name string, sticker *stickerConfig) (string, string, error) {
+	ext := strings.ToLower(filepath.Ext(name))
+	if !telegramuploader.IsImage(ext) && !telegramuploader.IsVideo(ext) {
+		return "", "", fmt.Errorf("%s is neither an image nor a video", name)
+	}
+	sticker.Video = telegramuploader.IsVideo(ext) || ext == ".gif"
+	outExt := ".webp"
+	if sticker.Video {
+		outExt = ".webm"
+	}
+	tmp, err := os.CreateTemp("", "sticker-*"+outExt)
+	if err != nil {
+		return "", "", err
+	}
+	tmp.Close()
+
+	scale := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", stickerSide, stickerSide)
+	args := []string{"-hide_banner", "-nostdin", "-v", "error", "-y", "-i", path}
+	if sticker.Video {
+		args = append(args, "-t", strconv.Itoa(maxVideoStickerSecs), "-an",
+			"-vf", fmt.Sprintf("%s,fps=%d", scale, maxVideoStickerFrame),
+			"-c:v", "libvpx-vp9", "-pix_fmt", "yuva420p", "-b:v", "600k", "-crf", "40")
+	} else {
+		args = append(args, "-frames:v", "1", "-vf", scale, "-c:v", "libwebp", "-quality", "90")
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("ffmpeg", append(args, tmp.Name())...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(tmp.Name())
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", "", errors.New("stickers are converted with ffmpeg, which isn't installed")
+		}
+		return "", "", fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	// Check the result against Telegram's limits
+	info, err := os.Stat(tmp.Name())
+	if err == nil {
+		limit := int64(maxStickerSize)
+		if sticker.Video {
+			limit = maxVideoStickerSize
+		}
+		if info.Size() > limit {
+			err = fmt.Errorf("the sticker is %d KB, more than Telegram's limit of %d KB", info.Size()/1024, limit/1024)
+		}
+	}
+	if err == nil {
+		err = sticker.measure(tmp.Name())
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", "", err
+	}
+	name = strings.TrimSuffix(name, filepath.Ext(name)) + outExt
+	fmt.Printf("Converted to a %dx%d sticker: %s\n", sticker.Width, sticker.Height, name)
+	return tmp.Name(), name, nil
+}
+
+// measure reads the dimensions and duration of the converted sticker at path
+func (s *stickerConfig) measure(path string) error {
+	probe, err := probeVideo(path)
+	if err != nil {
+		return err
+	}
+	for _, stream := range probe.Streams {
+		if stream.CodecType == "video" {
+			s.Width, s.Height = stream.Width, stream.Height
+			break
+		}
+	}
+	if max(s.Width, s.Height) != stickerSide {
+		return fmt.Errorf("the sticker came out %dx%d instead of %d pixels on its longer side", s.Width, s.Height, stickerSide)
+	}
+	s.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	return nil
+}
+
+// media returns the sticker's uploaded file as a sticker document
+func (s *stickerConfig) media(upload tg.InputFileClass, fileName string) *tg.InputMediaUploadedDocument {
+	attrs := []tg.DocumentAttributeClass{
+		&tg.DocumentAttributeFilename{FileName: fileName},
+		&tg.DocumentAttributeSticker{Alt: s.Emoji, Stickerset: &tg.InputStickerSetEmpty{}},
+	}
+	mimeType := "image/webp"
+	if s.Video {
+		mimeType = "video/webm"
+		attrs = append(attrs, &tg.DocumentAttributeVideo{W: s.Width, H: s.Height, Duration: s.Duration})
+	} else {
+		attrs = append(attrs, &tg.DocumentAttributeImageSize{W: s.Width, H: s.Height})
+	}
+	return &tg.InputMediaUploadedDocument{File: upload, MimeType: mimeType, Attributes: attrs}
+}
+
+// addToSet adds the sticker sent as msg to the configured set, creating
+// the set if it doesn't exist yet
+func (s *stickerConfig) addToSet(ctx context.Context, api *tg.Client, msg *tg.Message) error {
+	media, ok := msg.Media.(*tg.MessageMediaDocument)
+	if !ok {
+		return errors.New("the sent message has no sticker")
+	}
+	doc, ok := media.Document.(*tg.Document)
+	if !ok {
+		return errors.New("the sent message has no sticker")
+	}
+	item := tg.InputStickerSetItem{Document: doc.AsInput(), Emoji: s.Emoji}
+	_, err := api.StickersAddStickerToSet(ctx, &tg.StickersAddStickerToSetRequest{
+		Stickerset: &tg.InputStickerSetShortName{ShortName: s.Set},
+		Sticker:    item,
+	})
+	if tgerr.Is(err, "STICKERSET_INVALID") {
+		title := s.SetTitle
+		if title == "" {
+			title = s.Set
+		}
+		_, err = api.StickersCreateStickerSet(ctx, &tg.StickersCreateStickerSetRequest{
+			UserID:    &tg.InputUserSelf{},
+			Title:     title,
+			ShortName: s.Set,
+			Stickers:  []tg.InputStickerSetItem{item},
+		})
+		if err == nil {
+			fmt.Printf("Created sticker set %s: https://t.me/addstickers/%s\n", s.Set, s.Set)
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Added the sticker to https://t.me/addstickers/%s\n", s.Set)
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+)
+
+// storyPrivacy maps the -privacy values of upload-story to privacy rules
+var storyPrivacy = map[string][]tg.InputPrivacyRuleClass{
+	"everyone":      {&tg.InputPrivacyValueAllowAll{}},
+	"contacts":      {&tg.InputPrivacyValueAllowContacts{}},
+	"close-friends": {&tg.InputPrivacyValueAllowCloseFriends{}},
+	"nobody":        {&tg.InputPrivacyValueDisallowAll{}},
+}
+
+// runStory implements the "upload-story" subcommand: posting a photo or
+// video as a story of the account or of a channel
+func runStory(args []string) error {
+	config := &Config{}
+	flags := flag.NewFlagSet("upload-story", flag.ExitOnError)
+	credentialFlags(flags, config)
+	filePath := flags.String("file", "", "Path of the photo or video to post")
+	flags.StringVar(&config.TargetID, "target", "me", "Post as this channel instead of your account")
+	privacy := flags.String("privacy", "everyone", "Who can see the story: everyone, contacts, close-friends or nobody")
+	period := flags.Duration("period", 24*time.Hour, "How long the story is shown: 6h, 12h, 24h or 48h (other than 24h needs Telegram Premium)")
+	caption := flags.String("caption", "", "Caption of the story")
+	pin := flags.Bool("pin", false, "Keep the story on the profile after it expires")
+	timeoutFlags(flags, &config.Timeouts)
+	applyProgressFlags := progressFlags(flags)
+	flags.Parse(args)
+	if err := applyProgressFlags(); err != nil {
+		return err
+	}
+
+	if *filePath == "" {
+		return withExitCode(exitUsage, errors.New("usage: upload-story -file <photo or video> [-privacy contacts]"))
+	}
+	rules, ok := storyPrivacy[*privacy]
+	if !ok {
+		return withExitCode(exitUsage, fmt.Errorf("invalid -privacy value %q", *privacy))
+	}
+	switch *period {
+	case 6 * time.Hour, 12 * time.Hour, 24 * time.Hour, 48 * time.Hour:
+	default:
+		return withExitCode(exitUsage, fmt.Errorf("invalid -period %s; use 6h, 12h, 24h or 48h", *period))
+	}
+	ext := strings.ToLower(filepath.Ext(*filePath))
+	if !telegramuploader.IsImage(ext) && !telegramuploader.IsVideo(ext) {
+		return withExitCode(exitUsage, errors.New("stories can only be photos or videos"))
+	}
+	if err := validateCredentials(config); err != nil {
+		return err
+	}
+	config.FileName = filepath.Base(*filePath)
+
+	return withClient(config, func(ctx context.Context, client *telegram.Client) error {
+		api := client.API()
+		resolveCtx, cancel := phaseContext(ctx, config.Timeouts.Resolve)
+		defer cancel()
+		target, err := telegramuploader.ResolvePeer(resolveCtx, api, config.TargetID)
+		if err != nil {
+			return phaseError(resolveCtx, "resolving the target", err)
+		}
+		cancel()
+
+		file, err := os.Open(*filePath)
+		if err != nil {
+			return fmt.Errorf("failed to open file: %w", err)
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to get file info: %w", err)
+		}
+
+		progress := newFileProgress("upload", config.FileName, info.Size(), nil)
+		uploadCtx, cancel := phaseContext(ctx, config.Timeouts.Upload)
+		defer cancel()
+		upload, err := uploader.NewUploader(api).WithPartSize(512*1024).Upload(uploadCtx, uploader.NewUpload(config.FileName, progress.reader(file), info.Size()))
+		progress.finish()
+		if err != nil {
+			return phaseError(uploadCtx, "uploading", fmt.Errorf("upload failed: %w", err))
+		}
+		cancel()
+
+		var media tg.InputMediaClass
+		if telegramuploader.IsImage(ext) {
+			media = &tg.InputMediaUploadedPhoto{File: upload}
+		} else {
+			media = &tg.InputMediaUploadedDocument{
+				File:     upload,
+				MimeType: telegramuploader.MimeType(config.FileName),
+				Attributes: []tg.DocumentAttributeClass{
+					&tg.DocumentAttributeFilename{FileName: config.FileName},
+					&tg.DocumentAttributeVideo{SupportsStreaming: true},
+				},
+			}
+		}
+
+		randomID, err := generateRandomID()
+		if err != nil {
+			return fmt.Errorf("failed to generate random ID: %w", err)
+		}
+		req := &tg.StoriesSendStoryRequest{
+			Pinned:       *pin,
+			Peer:         target,
+			Media:        media,
+			Caption:      *caption,
+			PrivacyRules: rules,
+			RandomID:     randomID,
+		}
+		req.SetPeriod(int(period.Seconds()))
+
+		fmt.Println("Posting story...")
+		sendCtx, cancel := phaseContext(ctx, config.Timeouts.Send)
+		defer cancel()
+		updates, err := api.StoriesSendStory(sendCtx, req)
+		if err != nil {
+			return phaseError(sendCtx, "sending", fmt.Errorf("failed to post story: %w", err))
+		}
+		if id, ok := sentStoryID(updates); ok {
+			fmt.Printf("✅ Story %d posted for %s, visible to %s\n", id, *period, *privacy)
+		} else {
+			fmt.Printf("✅ Story posted for %s, visible to %s\n", *period, *privacy)
+		}
+		return nil
+	})
+}
+
+// sentStoryID returns the ID of the story posted in updates
+func sentStoryID(updates tg.UpdatesClass) (int, bool) {
+	u, ok := updates.(*tg.Updates)
+	if !ok {
+		return 0, false
+	}
+	for _, update := range u.Updates {
+		if s, ok := update.(*tg.UpdateStoryID); ok {
+			return s.ID, true
+		}
+	}
+	return 0, false
+}
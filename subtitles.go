@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// subtitleModes are the -with-subs values: embed muxes the subtitles into
+// the video, reply sends them as documents replying to it
+var subtitleModes = []string{"embed", "reply"}
+
+// subtitleExts are the extensions of sidecar subtitle files
+var subtitleExts = []string{".srt", ".ass", ".ssa", ".vtt"}
+
+// textSubtitleCodecs are the embedded subtitle codecs that can be
+// extracted to a file; bitmap ones like PGS can't
+var textSubtitleCodecs = map[string]string{"subrip": ".srt", "ass": ".ass", "ssa": ".ass", "webvtt": ".vtt", "mov_text": ".srt"}
+
+// subtitleFile is a subtitle file going with a video
+type subtitleFile struct {
+	Path     string
+	Name     string // Name to send it under
+	Language string // like "en", if the name says
+}
+
+// findSubtitles returns the sidecar subtitles of the video at path: files
+// next to it named like it, such as movie.srt or movie.en.ass
+func findSubtitles(path string) ([]subtitleFile, error) {
+	dir, base := filepath.Dir(path), strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var subs []subtitleFile
+	for _, e := range entries {
+		name := e.Name()
+		ext := strings.ToLower(filepath.Ext(name))
+		if e.IsDir() || !slices.Contains(subtitleExts, ext) || !strings.HasPrefix(name, base+".") {
+			continue
+		}
+		// What's between the video's name and the extension, if anything,
+		// is the language
+		lang := strings.TrimPrefix(strings.TrimSuffix(name, filepath.Ext(name)), base)
+		subs = append(subs, subtitleFile{
+			Path:     filepath.Join(dir, name),
+			Name:     name,
+			Language: strings.TrimPrefix(lang, "."),
+		})
+	}
+	return subs, nil
+}
+
+// extractSubtitles writes the text subtitle tracks embedded in the video at
+// path to temporary files with ffmpeg. Bitmap tracks are skipped.
+func extractSubtitles(path, name string) ([]subtitleFile, error) {
+	probe, err := probeVideo(path)
+	if err != nil {
+		return nil, err
+	}
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	var subs []subtitleFile
+	index := 0
+	for _, s := range probe.Streams {
+		if s.CodecType != "subtitle" {
+			continue
+		}
+		track := index
+		index++
+		ext, ok := textSubtitleCodecs[s.CodecName]
+		if !ok {
+			fmt.Printf("Skipping subtitle track %d: %s subtitles are images\n", track, s.CodecName)
+			continue
+		}
+		tmp, err := os.CreateTemp("", "subs-*"+ext)
+		if err != nil {
+			removeSubtitles(subs)
+			return nil, err
+		}
+		tmp.Close()
+		var stderr bytes.Buffer
+		cmd := exec.Command("ffmpeg", "-hide_banner", "-nostdin", "-v", "error", "-y", "-i", path,
+			"-map", fmt.Sprintf("0:s:%d", track), tmp.Name())
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			os.Remove(tmp.Name())
+			removeSubtitles(subs)
+			return nil, fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		subs = append(subs, subtitleFile{Path: tmp.Name(), Name: fmt.Sprintf("%s.%d%s", base, track, ext)})
+	}
+	return subs, nil
+}
+
+// removeSubtitles deletes extracted subtitle files
+func removeSubtitles(subs []subtitleFile) {
+	for _, s := range subs {
+		os.Remove(s.Path)
+	}
+}
+
+// embedSubtitles remuxes the video at path with ffmpeg into an MP4 that
+// also carries subs as soft subtitle tracks. The video and audio are
+// copied as they are. It returns the path of a temporary file with the
+// result and the name to upload it under.
+func embedSubtitles(path, name string, subs []subtitleFile) (string, string, error) {
+	tmp, err := os.CreateTemp("", "subbed-*.mp4")
+	if err != nil {
+		return "", "", err
+	}
+	tmp.Close()
+
+	args := []string{"-hide_banner", "-nostdin", "-v", "error", "-y", "-i", path}
+	for _, s := range subs {
+		args = append(args, "-i", s.Path)
+	}
+	args = append(args, "-map", "0:v", "-map", "0:a?")
+	for i := range subs {
+		args = append(args, "-map", fmt.Sprint(i+1))
+	}
+	args = append(args, "-c", "copy", "-c:s", "mov_text")
+	for i, s := range subs {
+		if s.Language != "" {
+			args = append(args, fmt.Sprintf("-metadata:s:s:%d", i), "language="+s.Language)
+		}
+	}
+	args = append(args, "-movflags", "+faststart", "-progress", "pipe:1", "-nostats", tmp.Name())
+
+	probe, err := probeVideo(path)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", "", err
+	}
+	fmt.Printf("Embedding %d subtitle file(s) in %s...\n", len(subs), name)
+	if err := runFFmpeg(args, path, name, probe.Format.Duration); err != nil {
+		os.Remove(tmp.Name())
+		return "", "", err
+	}
+	return tmp.Name(), strings.TrimSuffix(name, filepath.Ext(name)) + ".mp4", nil
+}
@@ -0,0 +1,608 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/telegram"
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+)
+
+// syncOptions controls a sync run
+type syncOptions struct {
+	Mirror     bool   // delete messages of files removed locally
+	Superseded string // what to do with the message of a changed file: keep, delete or edit
+	DryRun     bool
+	TUI        bool          // show the interactive queue view
+	Report     string        // CSV file to write per-file results to
+	Notify     notifyOptions // who is told at the end
+
+	Concurrency int  // files uploaded at the same time
+	Ordered     bool // send the files in queue order even when uploading several at once
+	RateLimit   int  // most files started per minute across all uploads; 0 for no limit
+	SendRate    int  // most messages sent per minute, evenly spaced; 0 for no limit
+
+	MaxTotal int64 // most bytes uploaded by the run; 0 for no limit
+	MaxFiles int   // most files uploaded by the run; 0 for no limit
+
+	Root      string       // directory being synced
+	Structure string       // how the tree's layout is kept: none, topics or captions
+	Topics    *forumTopics // topics of the target with -structure topics
+	Tags      tagOptions
+	Index     bool   // post a table of contents of the tree after the run
+	IndexPath string // where the index messages are remembered
+	Events    string // file the jobs' events are written to as JSON lines
+}
+
+// syncItem is a file to upload, with the journal entry of its previous
+// version if it changed since it was last uploaded
+type syncItem struct {
+	Path     string
+	Size     int64
+	ModTime  time.Time
+	Previous *JournalEntry
+}
+
+// fileChanged reports whether the file at path differs from its journal
+// entry. Size and modification time are checked first; if they differ the
+// content hash decides, so touched but unmodified files aren't re-uploaded.
+func fileChanged(path string, info fs.FileInfo, e JournalEntry) (bool, error) {
+	if info.Size() != e.Size {
+		return true, nil
+	}
+	if info.ModTime().Equal(e.ModTime) {
+		return false, nil
+	}
+	if e.SHA256 == "" {
+		return true, nil
+	}
+	sum, _, err := hashFile(path)
+	if err != nil {
+		return false, err
+	}
+	return sum != e.SHA256, nil
+}
+
+// runSync implements the sync subcommand, which uploads the files of a
+// directory that are new or changed since the journal last saw them
+func runSync(args []string) error {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	config := &Config{}
+	credentialFlags(flags, config)
+	flags.StringVar(&config.TargetID, "target", "me", "Target username or chat ID")
+	flags.StringVar(&config.JournalPath, "journal", defaultJournalPath, "Path of the upload journal")
+	flags.StringVar(&config.FileCachePath, "file-cache", defaultFileCachePath, "Keep the Telegram IDs of uploaded files here for the resend command (empty to disable)")
+	flags.BoolVar(&config.SignManifest, "sign-manifest", false, "After the run, upload an ed25519-signed manifest of the files it uploaded as a companion document")
+	flags.StringVar(&config.ManifestKey, "manifest-key", defaultManifestKey, "Path of the manifest signing key")
+	flags.BoolVar(&config.PostChecksums, "post-checksums", false, "After the run, send a SHA256SUMS document listing the files it uploaded by their paths in the tree")
+	timeoutFlags(flags, &config.Timeouts)
+	readAhead := flags.String("read-ahead", "0", "Read up to this much of each file ahead of its upload, like 64M, to smooth over a slow source")
+	var opts syncOptions
+	flags.BoolVar(&opts.Mirror, "mirror", false, "Also delete messages of files no longer present locally")
+	flags.StringVar(&opts.Superseded, "superseded", "keep", "What to do with the previous message of a changed file: keep, delete or edit (replace its media in place)")
+	flags.BoolVar(&opts.DryRun, "dry-run", false, "Only show what would be uploaded or deleted")
+	flags.BoolVar(&opts.Notify.Desktop, "notify-desktop", false, "Show a desktop notification when the sync finishes or fails")
+	notifyPlugins := flags.String("notify", "", "Comma-separated notifier plugins to run when the sync finishes or fails, like slack for fileuploader-notify-slack in the plugin directory")
+	flags.StringVar(&opts.Report, "report", "", "Write per-file results to this CSV file")
+	flags.StringVar(&opts.Events, "events", "", "Write the events of the uploads, like parts uploaded and flood waits, to this file as JSON lines for other programs to follow")
+	flags.BoolVar(&opts.TUI, "tui", false, "Show an interactive view of the upload queue with pause, resume and cancel")
+	flags.IntVar(&opts.Concurrency, "concurrency", 1, "Upload this many files at the same time")
+	flags.BoolVar(&opts.Ordered, "ordered", true, "Send the files in order even when uploading several at once; with -ordered=false each is sent as soon as it's uploaded")
+	flags.IntVar(&opts.RateLimit, "rate-limit", 0, "Most files started per minute, across all concurrent uploads (0 for no limit)")
+	tagFlags(flags, &opts.Tags)
+	flags.BoolVar(&opts.Index, "index", false, "After the run, post an index message listing the tree's files with links to their messages, and edit it on later runs")
+	flags.StringVar(&opts.IndexPath, "index-state", defaultIndexStatePath, "Remember the index messages of -index in this file")
+	flags.StringVar(&opts.Structure, "structure", "none", "Keep the directory layout in the chat: topics for a forum topic per top-level directory, created as needed, or captions to put each file's relative path in its caption")
+	flags.IntVar(&opts.SendRate, "messages-per-minute", 0, "Space out the files' messages to at most this many a minute, to stay clear of flood limits when sending many small files (0 for no limit)")
+	maxTotal := flags.String("max-total", "0", "Upload at most this much per run, like 50G, leaving the files that don't fit for the next run; a single larger file goes alone (0 for no limit)")
+	flags.IntVar(&opts.MaxFiles, "max-files", 0, "Stop the run after uploading this many files, leaving the rest for the next run (0 for no limit)")
+	applyProgressFlags := progressFlags(flags)
+	applyProfileFlags := profileFlags(flags)
+	applyRateFlags := uploadRateFlags(flags)
+	applyFilterFlags := filterFlags(flags)
+	applyOrderFlag := orderFlag(flags)
+	applyLargeFileFlags := largeFileFlags(flags)
+	positional := parseInterleaved(flags, args)
+	opts.Notify.Plugins = splitList(*notifyPlugins)
+	if err := applyProgressFlags(); err != nil {
+		return err
+	}
+	if err := applyProfileFlags(); err != nil {
+		return err
+	}
+	rates, err := applyRateFlags()
+	if err != nil {
+		return err
+	}
+	config.Rates = rates
+	filter, err := applyFilterFlags()
+	if err != nil {
+		return err
+	}
+	order, err := applyOrderFlag()
+	if err != nil {
+		return err
+	}
+	largeFiles, err := applyLargeFileFlags()
+	if err != nil {
+		return err
+	}
+
+	if len(positional) != 1 {
+		return withExitCode(exitUsage, errors.New("usage: sync -target <chat> [-mirror] [-dry-run] <dir>"))
+	}
+	switch opts.Superseded {
+	case "keep", "delete", "edit":
+	default:
+		return withExitCode(exitUsage, fmt.Errorf("invalid -superseded value %q", opts.Superseded))
+	}
+	readAheadSize, err := parseReadAhead(*readAhead)
+	if err != nil {
+		return err
+	}
+	config.ReadAhead = readAheadSize
+	if opts.MaxTotal, err = parseSize(*maxTotal); err != nil {
+		return withExitCode(exitUsage, fmt.Errorf("invalid -max-total %q", *maxTotal))
+	}
+	if opts.MaxFiles < 0 {
+		return withExitCode(exitUsage, errors.New("-max-files can't be negative"))
+	}
+	if !slices.Contains(structureModes, opts.Structure) {
+		return withExitCode(exitUsage, fmt.Errorf("invalid -structure value %q", opts.Structure))
+	}
+	if opts.SendRate < 0 {
+		return withExitCode(exitUsage, errors.New("-messages-per-minute can't be negative"))
+	}
+	if opts.Concurrency < 1 {
+		return withExitCode(exitUsage, errors.New("-concurrency must be at least 1"))
+	}
+	if opts.Concurrency > 1 && opts.TUI {
+		return withExitCode(exitUsage, errors.New("-tui uploads one file at a time; it can't be combined with -concurrency"))
+	}
+	if config.JournalPath == "" {
+		return errors.New("sync needs the journal to know what was uploaded")
+	}
+	dir, err := filepath.Abs(positional[0])
+	if err != nil {
+		return err
+	}
+	opts.Root = dir
+
+	entries, err := readJournal(config.JournalPath)
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	uploaded := map[string]JournalEntry{}
+	for _, e := range liveEntries(entries) {
+		if normalizeTarget(e.Target) == normalizeTarget(config.TargetID) {
+			uploaded[e.Source] = e
+		}
+	}
+
+	// Work out what to do before connecting
+	var pending []syncItem
+	var results []syncResult // files left alone, for the summary
+	local := map[string]bool{}
+	err = filter.walk(dir, func(path string, info fs.FileInfo) error {
+		local[path] = true
+
+		// Files left out are still local, so -mirror keeps their messages
+		if reason := filter.skip(info); reason != "" {
+			if opts.DryRun {
+				fmt.Printf("skip    %s (%s)\n", path, reason)
+			}
+			results = append(results, syncResult{Path: path, Status: "skipped", Size: info.Size()})
+			return nil
+		}
+		e, ok := uploaded[path]
+		if !ok {
+			pending = append(pending, syncItem{Path: path, Size: info.Size(), ModTime: info.ModTime()})
+			return nil
+		}
+		changed, err := fileChanged(path, info, e)
+		if err != nil {
+			return err
+		}
+		if changed {
+			pending = append(pending, syncItem{Path: path, Size: info.Size(), ModTime: info.ModTime(), Previous: &e})
+		} else {
+			results = append(results, syncResult{Path: path, Status: "skipped", Size: info.Size()})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	slices.SortStableFunc(pending, func(a, b syncItem) int {
+		return order.compare(fileKey{a.Path, a.Size, a.ModTime}, fileKey{b.Path, b.Size, b.ModTime})
+	})
+
+	// Ask about unusually large files once for all of them; without an
+	// answer they're left out, so a scheduled run doesn't stop at them
+	var large []syncItem
+	for _, item := range pending {
+		if largeFiles.needsConfirming(item.Size) {
+			large = append(large, item)
+		}
+	}
+	if len(large) > 0 && !opts.DryRun {
+		var total int64
+		for _, item := range large {
+			fmt.Printf("  %s (%.2f MB)\n", item.Path, float64(item.Size)/(1024*1024))
+			total += item.Size
+		}
+		question := fmt.Sprintf("%d file(s) above are over -confirm-over, %.2f MB in all. Upload them?", len(large), float64(total)/(1024*1024))
+		if !largeFiles.confirm(question) {
+			fmt.Println("Leaving them out; pass -yes to upload them")
+			pending = slices.DeleteFunc(pending, func(item syncItem) bool {
+				if largeFiles.needsConfirming(item.Size) {
+					results = append(results, syncResult{Path: item.Path, Status: "skipped", Size: item.Size})
+					return true
+				}
+				return false
+			})
+		}
+	}
+
+	// Keep to the run's budget. The deferred files aren't in the journal,
+	// so the next run picks them up.
+	if fit, deferred := budgetSplit(pending, opts); len(deferred) > 0 {
+		var deferredSize int64
+		for _, item := range deferred {
+			deferredSize += item.Size
+			results = append(results, syncResult{Path: item.Path, Status: "deferred", Size: item.Size})
+			fmt.Printf("deferred  %s (%.2f MB)\n", item.Path, float64(item.Size)/(1024*1024))
+		}
+		fmt.Printf("Over the run's budget: leaving %d file(s) (%.2f MB) for the next run\n",
+			len(deferred), float64(deferredSize)/(1024*1024))
+		pending = fit
+	}
+
+	var stale []JournalEntry
+	if opts.Mirror {
+		for source, e := range uploaded {
+			if strings.HasPrefix(source, dir+string(filepath.Separator)) && !local[source] && !exists(source) {
+				stale = append(stale, e)
+			}
+		}
+	}
+
+	fmt.Printf("%d file(s) to upload, %d message(s) to delete\n", len(pending), len(stale))
+	if opts.DryRun {
+		for _, item := range pending {
+			if item.Previous != nil {
+				fmt.Printf("update  %s (message %d)\n", item.Path, item.Previous.MessageID)
+			} else {
+				fmt.Printf("upload  %s\n", item.Path)
+			}
+		}
+		for _, e := range stale {
+			fmt.Printf("delete  %s (message %d)\n", e.Source, e.MessageID)
+		}
+		return nil
+	}
+	var index *indexState
+	if opts.Index {
+		if index, err = loadIndexState(opts.IndexPath); err != nil {
+			return err
+		}
+	}
+	// A first index is posted even when there's nothing else to do
+	newIndex := index != nil && len(index.Indexes[indexKey(config.TargetID, dir)]) == 0
+	if len(pending) == 0 && len(stale) == 0 && !newIndex {
+		if opts.Report != "" {
+			return writeReport(opts.Report, results)
+		}
+		return nil
+	}
+
+	if err := validateCredentials(config); err != nil {
+		return err
+	}
+	var total int64
+	for _, item := range pending {
+		total += item.Size
+	}
+	config.Batch = newBatchProgress(len(pending), total)
+	if opts.Events != "" {
+		f, err := os.OpenFile(opts.Events, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open events file: %w", err)
+		}
+		defer f.Close()
+		config.Events = telegramuploader.EventWriter(f)
+		for _, item := range pending {
+			config.Events.HandleEvent(telegramuploader.JobQueued{Job: item.Path, Name: filepath.Base(item.Path), Size: item.Size})
+		}
+	}
+
+	start := time.Now()
+	err = withClient(config, func(ctx context.Context, client *telegram.Client) error {
+		if opts.Structure == "topics" {
+			target, err := telegramuploader.ResolvePeer(ctx, client.API(), config.TargetID)
+			if err != nil {
+				return err
+			}
+			if opts.Topics, err = newForumTopics(ctx, client.API(), target); err != nil {
+				return err
+			}
+		}
+		if len(pending) > 1 {
+			target, err := telegramuploader.ResolvePeer(ctx, client.API(), config.TargetID)
+			if err != nil {
+				return err
+			}
+			if config.Pacer, err = newSendPacer(ctx, client.API(), target); err != nil {
+				return err
+			}
+			if config.Pacer != nil {
+				fmt.Printf("Slow mode is on in %s: sending one file every %s\n", config.TargetID, config.Pacer.interval)
+			}
+			if opts.SendRate > 0 {
+				config.Pacer = config.Pacer.withRate(opts.SendRate)
+			}
+		}
+
+		var uploadResults []syncResult
+		switch {
+		case len(pending) == 0:
+			// Only the index to post
+		case opts.TUI:
+			var err error
+			if uploadResults, err = runSyncTUI(ctx, client, config, pending, opts); err != nil {
+				return err
+			}
+		default:
+			uploadResults = uploadPending(ctx, client, config, pending, opts)
+		}
+
+		for i, r := range uploadResults {
+			if item := pending[i]; r.Status == "uploaded" && item.Previous != nil && opts.Superseded == "delete" {
+				stale = append(stale, *item.Previous)
+			}
+		}
+		results = append(results, uploadResults...)
+		if err := deleteStale(ctx, client, config, stale); err != nil {
+			return err
+		}
+		if err := sendSyncSummaries(ctx, client, config, uploadResults); err != nil {
+			return err
+		}
+		if index != nil {
+			// A failed index doesn't fail the files' uploads
+			if err := postIndex(ctx, client.API(), config, dir, index); err != nil {
+				fmt.Printf("Failed to post the index: %v\n", friendlyError(err))
+			}
+		}
+		return nil
+	})
+
+	printSummary(results, time.Since(start))
+	if opts.Report != "" {
+		if err := writeReport(opts.Report, results); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+		fmt.Printf("Report written to %s\n", opts.Report)
+	}
+	if err == nil {
+		var failed int
+		for _, r := range results {
+			if r.Status == "failed" {
+				failed++
+			}
+		}
+		if failed > 0 {
+			err = withExitCode(exitPartialBatch, fmt.Errorf("%d file(s) failed to upload", failed))
+		}
+	}
+	opts.Notify.result("Sync of "+dir, err)
+	return err
+}
+
+// exists reports whether there is still something at path, like a file
+// the walk left out or a symlink it didn't follow
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// budgetSplit splits the pending files into those uploaded in this run,
+// within its -max-total and -max-files budget, and those deferred. Files
+// too large for what is left of -max-total are skipped for smaller ones
+// after them; a file larger than all of it goes alone when it comes first,
+// so that it doesn't hold the sync up forever.
+func budgetSplit(pending []syncItem, opts syncOptions) (fit, deferred []syncItem) {
+	var total int64
+	for _, item := range pending {
+		switch {
+		case opts.MaxFiles > 0 && len(fit) == opts.MaxFiles:
+			deferred = append(deferred, item)
+		case opts.MaxTotal > 0 && total+item.Size > opts.MaxTotal && (len(fit) > 0 || len(deferred) > 0):
+			deferred = append(deferred, item)
+		default:
+			fit = append(fit, item)
+			total += item.Size
+		}
+	}
+	return fit, deferred
+}
+
+// uploadPending uploads the files of a sync run, opts.Concurrency at a
+// time, and returns their results in the order of pending
+func uploadPending(ctx context.Context, client *telegram.Client, config *Config, pending []syncItem, opts syncOptions) []syncResult {
+	results := make([]syncResult, len(pending))
+	var order *sendSequence
+	if opts.Concurrency > 1 && opts.Ordered {
+		order = newSendSequence()
+	}
+	limiter := newRateLimiter(opts.RateLimit)
+	if opts.Concurrency > 1 && progressMode == "bar" {
+		// Draw the files' bars together, with the run's totals
+		bars := showBars(config.Batch)
+		defer bars.stop()
+	}
+
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for range opts.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				item := pending[i]
+				r := syncResult{Path: item.Path, Size: item.Size}
+				if ctx.Err() != nil || limiter.wait(ctx, 0) != nil {
+					r.Status = "cancelled"
+				} else {
+					fileConfig := *config
+					fileConfig.Order, fileConfig.Turn = order, i
+					itemStart := time.Now()
+					r.Sent, r.Err = uploadSyncItem(ctx, client, &fileConfig, item, opts)
+					r.Duration = time.Since(itemStart)
+					r.Status = "uploaded"
+					if r.Err != nil {
+						r.Status = "failed"
+						fmt.Println(friendlyError(r.Err))
+					}
+				}
+				order.done(i)
+				results[i] = r
+				if config.Events != nil {
+					config.Events.HandleEvent(jobEvent(r))
+				}
+			}
+		}()
+	}
+	for i := range pending {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	return results
+}
+
+// jobEvent is the event of a finished upload of a sync run
+func jobEvent(r syncResult) telegramuploader.Event {
+	switch r.Status {
+	case "uploaded":
+		return telegramuploader.JobCompleted{Job: r.Path, Result: telegramuploader.Result{Name: filepath.Base(r.Path), Size: r.Size}}
+	case "cancelled":
+		return telegramuploader.JobFailed{Job: r.Path, Err: context.Canceled}
+	default:
+		return telegramuploader.JobFailed{Job: r.Path, Err: r.Err}
+	}
+}
+
+// uploadSyncItem uploads one file of a sync run, and returns its manifest
+// entry named by its path in the tree
+func uploadSyncItem(ctx context.Context, client *telegram.Client, config *Config, item syncItem, opts syncOptions) (ManifestEntry, error) {
+	ctx = telegramuploader.WithJob(ctx, item.Path)
+	fileConfig := *config
+	fileConfig.Input = telegramuploader.FileSource(item.Path)
+	fileConfig.FileName = filepath.Base(item.Path)
+	fileConfig.Source = item.Path
+	if item.Previous != nil && opts.Superseded == "edit" {
+		fileConfig.ReplaceMessageID = item.Previous.MessageID
+	}
+	rel, err := filepath.Rel(opts.Root, item.Path)
+	if err != nil {
+		rel = filepath.Base(item.Path)
+	}
+	rel = filepath.ToSlash(rel)
+	var sent ManifestEntry
+	fileConfig.Sent = func(e ManifestEntry) {
+		sent = e
+		sent.Name = rel
+	}
+	fileConfig.Hashtags = opts.Tags.tags(rel)
+	if opts.Structure != "none" {
+		switch top, _, inDir := strings.Cut(rel, "/"); {
+		case opts.Structure == "captions":
+			fileConfig.DisplayPath = rel
+		case inDir && opts.Topics != nil:
+			// Files at the top go to the chat itself
+			id, err := opts.Topics.get(ctx, client.API(), top)
+			if err != nil {
+				return ManifestEntry{}, err
+			}
+			fileConfig.TopicID = id
+		}
+	}
+	if err := uploadFile(ctx, client, &fileConfig); err != nil {
+		return ManifestEntry{}, fmt.Errorf("failed to upload %s: %w", item.Path, err)
+	}
+	return sent, nil
+}
+
+// sendSyncSummaries sends one signed manifest and one SHA256SUMS of the
+// files a sync run uploaded, as the run asks for them
+func sendSyncSummaries(ctx context.Context, client *telegram.Client, config *Config, results []syncResult) error {
+	manifest := Manifest{Created: time.Now().UTC(), Target: config.TargetID}
+	for _, r := range results {
+		if r.Status == "uploaded" {
+			manifest.Files = append(manifest.Files, r.Sent)
+		}
+	}
+	if (!config.SignManifest && !config.PostChecksums) || len(manifest.Files) == 0 {
+		return nil
+	}
+	api := client.API()
+	target, err := telegramuploader.ResolvePeer(ctx, api, config.TargetID)
+	if err != nil {
+		return err
+	}
+	if config.SignManifest {
+		if err := sendManifest(ctx, api, target, manifest, config.ManifestKey); err != nil {
+			return err
+		}
+	}
+	if config.PostChecksums {
+		return sendChecksums(ctx, api, target, manifest.Files)
+	}
+	return nil
+}
+
+// deleteStale deletes the messages of files removed locally and records the
+// deletions in the journal
+func deleteStale(ctx context.Context, client *telegram.Client, config *Config, stale []JournalEntry) error {
+	if len(stale) == 0 {
+		return nil
+	}
+	api := client.API()
+	target, err := telegramuploader.ResolvePeer(ctx, api, config.TargetID)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]int, 0, len(stale))
+	for _, e := range stale {
+		ids = append(ids, e.MessageID)
+	}
+	if err := deleteMessagesBatched(ctx, api, target, ids); err != nil {
+		return fmt.Errorf("failed to delete messages: %w", err)
+	}
+	for _, e := range stale {
+		fmt.Printf("Deleted %s (message %d)\n", e.Source, e.MessageID)
+		err := appendJournal(config.JournalPath, JournalEntry{
+			Time:      time.Now().UTC(),
+			Source:    e.Source,
+			Name:      e.Name,
+			Target:    e.Target,
+			MessageID: e.MessageID,
+			Deleted:   true,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record deletion in journal: %w", err)
+		}
+	}
+	return nil
+}
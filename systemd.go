@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gotd/td/telegram"
+)
+
+// systemdLogging is set by -systemd: log lines go to the journal, which
+// adds timestamps itself and reads priorities from "<n>" prefixes
+var systemdLogging bool
+
+// systemdFlags registers -systemd on fs. The returned function applies it
+// once fs has been parsed, after the progress flags.
+func systemdFlags(fs *flag.FlagSet) func() {
+	enabled := fs.Bool("systemd", false, "Log for the systemd journal: no timestamps or progress bars, and errors marked as such")
+	return func() {
+		if !*enabled {
+			return
+		}
+		systemdLogging = true
+		log.SetFlags(0)
+		if progressMode == "bar" {
+			progressMode = "plain"
+		}
+	}
+}
+
+// sdNotify sends state to systemd when it runs the program as a
+// Type=notify service, and does nothing otherwise
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+	}
+}
+
+// daemonReady tells systemd that a long-running command is up, and that
+// it's stopping once ctx is done. If the service has a watchdog, it's
+// pinged for as long as Telegram answers pings, so that a hung connection
+// gets the service restarted.
+func daemonReady(ctx context.Context, client *telegram.Client) {
+	sdNotify("READY=1")
+	go func() {
+		<-ctx.Done()
+		sdNotify("STOPPING=1")
+	}()
+
+	interval := watchdogInterval()
+	if interval == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			pingCtx, cancel := context.WithTimeout(ctx, interval)
+			err := client.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				log.Printf("Telegram didn't answer a ping: %v", err)
+				continue
+			}
+			sdNotify("WATCHDOG=1")
+		}
+	}()
+}
+
+// watchdogInterval returns how often the systemd watchdog wants to hear
+// from the program, half its timeout to be safe, or 0 if there is none
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		// Meant for another process
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
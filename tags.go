@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// maxCaptionLength is Telegram's limit on captions, in UTF-16 code units
+const maxCaptionLength = 1024
+
+// tagOptions adds hashtags to captions, so a channel's files can be found
+// with Telegram's hashtag search
+type tagOptions struct {
+	FromPath bool     // tag files with the names of the directories they're in
+	Rules    tagRules // more tags by extension or directory name
+}
+
+// tagRules maps an extension like ".pdf", or a directory name, to a tag
+type tagRules map[string]string
+
+func (r tagRules) String() string {
+	var s []string
+	for match, tag := range r {
+		s = append(s, fmt.Sprintf("%s=%s", match, tag))
+	}
+	return strings.Join(s, ", ")
+}
+
+func (r tagRules) Set(value string) error {
+	match, tag, ok := strings.Cut(value, "=")
+	if !ok || strings.TrimSpace(match) == "" || hashtag(tag) == "" {
+		return fmt.Errorf("expected \".ext=tag\" or \"directory=tag\", got %q", value)
+	}
+	r[strings.ToLower(strings.TrimSpace(match))] = hashtag(tag)
+	return nil
+}
+
+// tagFlags registers the hashtag flags on fs
+func tagFlags(fs *flag.FlagSet, t *tagOptions) {
+	t.Rules = tagRules{}
+	fs.BoolVar(&t.FromPath, "tags-from-path", false, "Add the names of the directories a file is in to its caption as hashtags")
+	fs.Var(t.Rules, "tag-rule", "Add a hashtag to the captions of files with an extension or in a directory, given as \".pdf=docs\" or \"photos=pictures\" (repeatable)")
+}
+
+// tags returns the hashtags of the file at the slash-separated path rel,
+// relative to the directory being uploaded
+func (t tagOptions) tags(rel string) []string {
+	var tags []string
+	add := func(tag string) {
+		if tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	dir, name := path.Split(rel)
+	var dirs []string
+	for _, d := range strings.Split(dir, "/") {
+		if d != "" && d != "." && d != ".." {
+			dirs = append(dirs, d)
+		}
+	}
+	if t.FromPath {
+		for _, d := range dirs {
+			add(hashtag(d))
+		}
+	}
+	if tag, ok := t.Rules[strings.ToLower(path.Ext(name))]; ok {
+		add(tag)
+	}
+	for _, d := range dirs {
+		if tag, ok := t.Rules[strings.ToLower(d)]; ok {
+			add(tag)
+		}
+	}
+	return tags
+}
+
+// hashtag turns s into a hashtag without the #: letters, digits and
+// underscores, not only digits. It returns "" if nothing is left.
+func hashtag(s string) string {
+	tag := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			return r
+		}
+		return '_'
+	}, strings.TrimPrefix(strings.TrimSpace(s), "#"))
+	tag = strings.Trim(tag, "_")
+	if strings.IndexFunc(tag, func(r rune) bool { return !unicode.IsDigit(r) }) < 0 {
+		return ""
+	}
+	return tag
+}
+
+// captionWithTags appends a line of tags to caption, leaving out those
+// that don't fit Telegram's caption limit, and shortens a caption that is
+// too long by itself
+func captionWithTags(caption string, tags []string) string {
+	length := func(s string) int { return len(utf16.Encode([]rune(s))) }
+	if length(caption) > maxCaptionLength {
+		runes := []rune(caption)
+		for length(string(runes))+1 > maxCaptionLength {
+			runes = runes[:len(runes)-1]
+		}
+		caption = string(runes) + "…"
+	}
+	var line string
+	var dropped int
+	for _, tag := range tags {
+		next := line + " #" + tag
+		if line == "" {
+			next = "\n\n#" + tag
+		}
+		if length(caption+next) > maxCaptionLength {
+			dropped++
+			continue
+		}
+		line = next
+	}
+	if dropped > 0 {
+		fmt.Printf("Left %d hashtag(s) out of the caption to keep it within %d characters\n", dropped, maxCaptionLength)
+	}
+	return caption + line
+}
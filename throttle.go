@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bandwidthLimit caps the combined speed of the uploads sharing it, as a
+// token bucket holding up to a second's worth of bytes
+type bandwidthLimit struct {
+	mu   sync.Mutex
+	rate float64   // bytes per second
+	next time.Time // when the bytes taken so far are paid off
+}
+
+func newBandwidthLimit(rate int64) *bandwidthLimit {
+	return &bandwidthLimit{rate: float64(rate)}
+}
+
+// wait takes n bytes from the bucket, blocking while it's in debt by more
+// than a second
+func (l *bandwidthLimit) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	d := l.next.Sub(now) - time.Second
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	l.mu.Unlock()
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// uploadRates holds the upload speed caps of a run: one for all uploads
+// together and one per target, shared by all uploads to that target
+type uploadRates struct {
+	all     *bandwidthLimit
+	targets map[string]*bandwidthLimit // by normalized target
+}
+
+// reader returns src slowed down to the caps that apply to uploads to
+// target; a nil uploadRates caps nothing
+func (r *uploadRates) reader(ctx context.Context, target string, src io.Reader) io.Reader {
+	if r == nil {
+		return src
+	}
+	var limits []*bandwidthLimit
+	if r.all != nil {
+		limits = append(limits, r.all)
+	}
+	if l := r.targets[normalizeTarget(target)]; l != nil {
+		limits = append(limits, l)
+	}
+	if len(limits) == 0 {
+		return src
+	}
+	return &throttledReader{Reader: src, ctx: ctx, limits: limits}
+}
+
+// throttledReader is an io.Reader kept below the rate of its limits
+type throttledReader struct {
+	io.Reader
+	ctx    context.Context
+	limits []*bandwidthLimit
+}
+
+// Read implements io.Reader
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	n, err := tr.Reader.Read(p)
+	for _, l := range tr.limits {
+		if werr := l.wait(tr.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// targetRateFlags collects the repeatable -max-rate-for flag
+type targetRateFlags map[string]int64
+
+func (f targetRateFlags) String() string {
+	var s []string
+	for target, rate := range f {
+		s = append(s, fmt.Sprintf("%s=%d", target, rate))
+	}
+	return strings.Join(s, ", ")
+}
+
+func (f targetRateFlags) Set(value string) error {
+	target, size, ok := strings.Cut(value, "=")
+	if !ok || strings.TrimSpace(target) == "" {
+		return fmt.Errorf("expected \"chat=rate\", got %q", value)
+	}
+	rate, err := parseSize(size)
+	if err != nil || rate <= 0 {
+		return fmt.Errorf("invalid rate %q", size)
+	}
+	f[normalizeTarget(target)] = rate
+	return nil
+}
+
+// uploadRateFlags registers the upload speed cap flags on fs. The returned
+// function builds the caps, nil for none, once fs has been parsed.
+func uploadRateFlags(fs *flag.FlagSet) func() (*uploadRates, error) {
+	maxRate := fs.String("max-rate", "0", "Cap the combined upload speed at this many bytes per second, like 3M (0 for no cap)")
+	perTarget := targetRateFlags{}
+	fs.Var(perTarget, "max-rate-for", "Cap the upload speed to one chat, given as \"chat=rate\" like backups=3M (repeatable)")
+	return func() (*uploadRates, error) {
+		rate, err := parseSize(*maxRate)
+		if err != nil {
+			return nil, withExitCode(exitUsage, fmt.Errorf("invalid -max-rate %q", *maxRate))
+		}
+		if rate == 0 && len(perTarget) == 0 {
+			return nil, nil
+		}
+		rates := &uploadRates{targets: map[string]*bandwidthLimit{}}
+		if rate > 0 {
+			rates.all = newBandwidthLimit(rate)
+		}
+		for target, rate := range perTarget {
+			rates.targets[target] = newBandwidthLimit(rate)
+		}
+		return rates, nil
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gotd/td/tg"
+)
+
+// structureModes are the -structure values for keeping a directory tree's
+// layout in a chat: none, a forum topic per top-level directory, or the
+// relative path in each caption
+var structureModes = []string{"none", "topics", "captions"}
+
+// forumTopics finds or creates the topics of a forum group by title, and
+// remembers them for the rest of the run
+type forumTopics struct {
+	channel *tg.InputChannel
+	mu      sync.Mutex
+	ids     map[string]int
+}
+
+// newForumTopics returns the topics of target, which has to be a group
+// with topics enabled
+func newForumTopics(ctx context.Context, api *tg.Client, target tg.InputPeerClass) (*forumTopics, error) {
+	p, ok := target.(*tg.InputPeerChannel)
+	if !ok {
+		return nil, errors.New("topics need a group with topics enabled")
+	}
+	channel := &tg.InputChannel{ChannelID: p.ChannelID, AccessHash: p.AccessHash}
+	chats, err := api.ChannelsGetChannels(ctx, []tg.InputChannelClass{channel})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat details: %w", err)
+	}
+	for _, c := range chats.GetChats() {
+		if c, ok := c.(*tg.Channel); ok && c.ID == p.ChannelID && !c.Forum {
+			return nil, errors.New("topics need a group with topics enabled")
+		}
+	}
+	return &forumTopics{channel: channel, ids: map[string]int{}}, nil
+}
+
+// get returns the ID of the topic titled title, creating it if there is
+// none yet
+func (t *forumTopics) get(ctx context.Context, api *tg.Client, title string) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if id, ok := t.ids[title]; ok {
+		return id, nil
+	}
+
+	found, err := api.ChannelsGetForumTopics(ctx, &tg.ChannelsGetForumTopicsRequest{
+		Channel: t.channel,
+		Q:       title,
+		Limit:   100,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up topic %q: %w", title, err)
+	}
+	for _, topic := range found.Topics {
+		// The search also matches titles that only contain title
+		if topic, ok := topic.(*tg.ForumTopic); ok && topic.Title == title {
+			t.ids[title] = topic.ID
+			return topic.ID, nil
+		}
+	}
+
+	randomID, err := generateRandomID()
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate random ID: %w", err)
+	}
+	updates, err := api.ChannelsCreateForumTopic(ctx, &tg.ChannelsCreateForumTopicRequest{
+		Channel:  t.channel,
+		Title:    title,
+		RandomID: randomID,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create topic %q: %w", title, err)
+	}
+	// A topic's ID is that of the service message that opened it
+	if u, ok := updates.(*tg.Updates); ok {
+		for _, update := range u.Updates {
+			if u, ok := update.(*tg.UpdateNewChannelMessage); ok {
+				if _, ok := u.Message.(*tg.MessageService); ok {
+					fmt.Printf("Created topic %q\n", title)
+					t.ids[title] = u.Message.GetID()
+					return u.Message.GetID(), nil
+				}
+			}
+		}
+	}
+	return 0, fmt.Errorf("topic %q not found in the response", title)
+}
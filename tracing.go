@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerProvider is set when traces are exported, and also traces the
+// Telegram API calls of the client
+var tracerProvider trace.TracerProvider
+
+// stopTracing sends the spans not exported yet. Like stopProfiling it's
+// called when main returns and by fatal.
+var stopTracing = func() {}
+
+// tracingFlags registers -otlp-endpoint on fs. The returned function starts
+// exporting traces once fs has been parsed, if there is an endpoint.
+func tracingFlags(fs *flag.FlagSet) func() error {
+	endpoint := fs.String("otlp-endpoint", "", "Export OpenTelemetry traces of the uploads over OTLP/HTTP to this URL, like http://localhost:4318/v1/traces (default: $OTEL_EXPORTER_OTLP_ENDPOINT if set)")
+	return func() error {
+		var opts []otlptracehttp.Option
+		switch {
+		case *endpoint != "":
+			opts = append(opts, otlptracehttp.WithEndpointURL(*endpoint))
+		case os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "":
+			return nil
+		}
+		// The exporter reads the other OTEL_EXPORTER_OTLP_* variables itself
+		exporter, err := otlptracehttp.New(context.Background(), opts...)
+		if err != nil {
+			return withExitCode(exitUsage, fmt.Errorf("failed to set up trace export: %w", err))
+		}
+		res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(logIdentifier)))
+		if err != nil {
+			return fmt.Errorf("failed to describe the traced service: %w", err)
+		}
+		provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+		otel.SetTracerProvider(provider)
+		tracerProvider = provider
+
+		var once sync.Once
+		stopTracing = func() {
+			once.Do(func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if err := provider.Shutdown(ctx); err != nil {
+					log.Printf("Failed to export traces: %v", err)
+				}
+			})
+		}
+		return nil
+	}
+}
+
+// startSpan starts a span of the upload pipeline as a child of the one in
+// ctx. Without -otlp-endpoint, spans cost next to nothing.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(logIdentifier).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends span, marking it as failed if err is set
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, friendlyError(err).Error())
+	}
+	span.End()
+}
+
+// tracedParts gives each uploaded part a span, so slow parts show up in
+// the trace of their upload
+type tracedParts struct {
+	uploader.Client
+}
+
+func (t tracedParts) UploadSaveFilePart(ctx context.Context, request *tg.UploadSaveFilePartRequest) (bool, error) {
+	ctx, span := startSpan(ctx, "upload part",
+		attribute.Int("part", request.FilePart),
+		attribute.Int("part.bytes", len(request.Bytes)),
+	)
+	ok, err := t.Client.UploadSaveFilePart(ctx, request)
+	endSpan(span, err)
+	return ok, err
+}
+
+func (t tracedParts) UploadSaveBigFilePart(ctx context.Context, request *tg.UploadSaveBigFilePartRequest) (bool, error) {
+	ctx, span := startSpan(ctx, "upload part",
+		attribute.Int("part", request.FilePart),
+		attribute.Int("part.bytes", len(request.Bytes)),
+		attribute.Int("parts", request.FileTotalParts),
+	)
+	ok, err := t.Client.UploadSaveBigFilePart(ctx, request)
+	endSpan(span, err)
+	return ok, err
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// transcodeProfiles are the -transcode values
+var transcodeProfiles = []string{"telegram"}
+
+// videoProbe is what transcodeVideo needs to know of a video, from ffprobe
+type videoProbe struct {
+	Streams []struct {
+		CodecType string `json:"codec_type"`
+		CodecName string `json:"codec_name"`
+		Width     int    `json:"width"`
+		Height    int    `json:"height"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+}
+
+// probeVideo runs ffprobe on the video at path
+func probeVideo(path string) (*videoProbe, error) {
+	out, err := exec.Command("ffprobe", "-v", "error",
+		"-show_entries", "stream=codec_type,codec_name,width,height:format=duration",
+		"-of", "json", path).Output()
+	if err != nil {
+		if exit, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("ffprobe failed: %s", strings.TrimSpace(string(exit.Stderr)))
+		}
+		return nil, fmt.Errorf("failed to run ffprobe: %w", err)
+	}
+	var probe videoProbe
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return nil, fmt.Errorf("failed to read ffprobe output: %w", err)
+	}
+	return &probe, nil
+}
+
+// codec returns the codec of the first stream of the given type, or "" if
+// there is none
+func (v *videoProbe) codec(codecType string) string {
+	for _, s := range v.Streams {
+		if s.CodecType == codecType {
+			return s.CodecName
+		}
+	}
+	return ""
+}
+
+// transcodeVideo converts the video at path with ffmpeg into an MP4 that
+// Telegram clients play inline: H.264 video and AAC audio, with the index
+// at the start so it streams. Streams already in those codecs are copied
+// rather than encoded again. It returns the path of a temporary file with
+// the result and the name to upload it under.
+func transcodeVideo(path, name string) (string, string, error) {
+	probe, err := probeVideo(path)
+	if err != nil {
+		return "", "", err
+	}
+	videoCodec := probe.codec("video")
+	if videoCodec == "" {
+		return "", "", fmt.Errorf("%s has no video", name)
+	}
+	args := []string{"-hide_banner", "-nostdin", "-v", "error", "-y", "-i", path,
+		"-map", "0:v:0", "-map", "0:a:0?", "-sn", "-dn"}
+	if videoCodec == "h264" {
+		args = append(args, "-c:v", "copy")
+	} else {
+		args = append(args, "-c:v", "libx264", "-preset", "medium", "-crf", "23", "-pix_fmt", "yuv420p")
+	}
+	if audioCodec := probe.codec("audio"); audioCodec == "aac" || audioCodec == "" {
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(args, "-c:a", "aac", "-b:a", "160k")
+	}
+
+	tmp, err := os.CreateTemp("", "transcode-*.mp4")
+	if err != nil {
+		return "", "", err
+	}
+	tmp.Close()
+	args = append(args, "-movflags", "+faststart", "-progress", "pipe:1", "-nostats", tmp.Name())
+
+	fmt.Printf("Transcoding %s (%s video) for Telegram...\n", name, videoCodec)
+	if err := runFFmpeg(args, path, name, probe.Format.Duration); err != nil {
+		os.Remove(tmp.Name())
+		return "", "", err
+	}
+	name = strings.TrimSuffix(name, filepath.Ext(name)) + ".mp4"
+	return tmp.Name(), name, nil
+}
+
+// runFFmpeg runs ffmpeg with args, which write progress to stdout, and
+// shows how far it is. The progress is shown in bytes of the source at
+// path, estimated from how much of its duration has been converted.
+func runFFmpeg(args []string, path, name, duration string) error {
+	var size int64 = -1
+	if info, err := os.Stat(path); err == nil {
+		size = info.Size()
+	}
+	seconds, _ := strconv.ParseFloat(duration, 64)
+	if seconds <= 0 {
+		size = -1
+	}
+
+	cmd := exec.Command("ffmpeg", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to run ffmpeg: %w", err)
+	}
+
+	progress := newFileProgress("transcode", name, size, nil)
+	var shown int64
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		// Lines like out_time_us=12345678; out_time_ms is in microseconds too
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		if size < 0 || (key != "out_time_us" && key != "out_time_ms") {
+			continue
+		}
+		us, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		done := time.Duration(us) * time.Microsecond
+		reached := min(int64(done.Seconds()/seconds*float64(size)), size)
+		if reached > shown {
+			progress.add(int(reached - shown))
+			shown = reached
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		progress.finish()
+		return fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if size > shown {
+		progress.add(int(size - shown))
+	}
+	progress.finish()
+	return nil
+}
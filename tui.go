@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/gotd/td/telegram"
+)
+
+// tuiItem is a file in the interactive sync queue
+type tuiItem struct {
+	item    syncItem
+	control *transferControl
+	state   string // "queued", "uploading", "done", "failed" or "cancelled"
+	err     error
+	start   time.Time
+	cancel  context.CancelFunc
+	skip    atomic.Bool // set when the item is cancelled
+}
+
+// abort cancels the item, whether it has started or not
+func (it *tuiItem) abort() {
+	it.skip.Store(true)
+	if it.cancel != nil {
+		it.cancel()
+	}
+	// A paused upload has to run to notice the cancellation
+	it.control.setPaused(false)
+}
+
+// Messages sent to the view by the upload loop
+type (
+	tuiStartMsg struct {
+		index  int
+		cancel context.CancelFunc
+	}
+	tuiDoneMsg struct {
+		index int
+		err   error
+	}
+	tuiFinishedMsg struct{}
+	tuiTickMsg     time.Time
+)
+
+// tuiModel is the interactive view of a sync run
+type tuiModel struct {
+	target string
+	items  []*tuiItem
+	cursor int
+	errors []string // most recent last
+	height int
+}
+
+func tuiTick() tea.Cmd {
+	return tea.Tick(500*time.Millisecond, func(t time.Time) tea.Msg { return tuiTickMsg(t) })
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return tuiTick()
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.height = msg.Height
+	case tuiTickMsg:
+		return m, tuiTick()
+	case tuiStartMsg:
+		it := m.items[msg.index]
+		it.cancel = msg.cancel
+		if it.skip.Load() {
+			// Cancelled while it was being started
+			it.abort()
+			break
+		}
+		it.state = "uploading"
+		it.start = time.Now()
+	case tuiDoneMsg:
+		it := m.items[msg.index]
+		switch {
+		case it.skip.Load():
+			it.state = "cancelled"
+		case msg.err != nil:
+			it.state = "failed"
+			it.err = msg.err
+			m.errors = append(m.errors, friendlyError(msg.err).Error())
+			if len(m.errors) > 5 {
+				m.errors = m.errors[1:]
+			}
+		default:
+			it.state = "done"
+		}
+	case tuiFinishedMsg:
+		return m, tea.Quit
+	case tea.KeyMsg:
+		it := m.items[m.cursor]
+		switch msg.String() {
+		case "up", "k":
+			if m.cursor > 0 {
+				m.cursor--
+			}
+		case "down", "j":
+			if m.cursor < len(m.items)-1 {
+				m.cursor++
+			}
+		case " ", "p":
+			if it.state == "uploading" {
+				it.control.setPaused(!it.control.isPaused())
+			}
+		case "c", "x":
+			if it.state == "queued" || it.state == "uploading" {
+				it.abort()
+				if it.state == "queued" {
+					it.state = "cancelled"
+				}
+			}
+		case "q", "ctrl+c":
+			for _, it := range m.items {
+				if it.state == "queued" || it.state == "uploading" {
+					it.abort()
+				}
+			}
+			return m, tea.Quit
+		}
+	}
+	return m, nil
+}
+
+func (m *tuiModel) View() string {
+	var b strings.Builder
+	var finished int
+	for _, it := range m.items {
+		if it.state != "queued" && it.state != "uploading" {
+			finished++
+		}
+	}
+	fmt.Fprintf(&b, "Syncing to %s: %d/%d file(s) finished\n\n", m.target, finished, len(m.items))
+
+	// Show a window of the queue around the cursor
+	rows := len(m.items)
+	if fit := m.height - 12; fit > 0 && rows > fit {
+		rows = fit
+	}
+	first := min(max(m.cursor-rows/2, 0), len(m.items)-rows)
+	for i := first; i < first+rows; i++ {
+		it := m.items[i]
+		cursor := " "
+		if i == m.cursor {
+			cursor = ">"
+		}
+		state := it.state
+		size := fmt.Sprintf("%.2f MB", float64(it.item.Size)/(1024*1024))
+		var speed string
+		if it.state == "uploading" {
+			done := it.control.bytes.Load()
+			size = fmt.Sprintf("%.2f/%.2f MB", float64(done)/(1024*1024), float64(it.item.Size)/(1024*1024))
+			if elapsed := time.Since(it.start).Seconds(); elapsed > 0 {
+				speed = fmt.Sprintf("%.2f MB/s", float64(done)/elapsed/(1024*1024))
+			}
+			if it.control.isPaused() {
+				state = "paused"
+			}
+		}
+		fmt.Fprintf(&b, "%s %-10s %-40s %18s %12s\n", cursor, state, filepath.Base(it.item.Path), size, speed)
+	}
+
+	if len(m.errors) > 0 {
+		b.WriteString("\nRecent errors:\n")
+		for _, e := range m.errors {
+			fmt.Fprintf(&b, "  %s\n", e)
+		}
+	}
+	b.WriteString("\n↑/↓ select  space/p pause/resume  c cancel  q quit\n")
+	return b.String()
+}
+
+// runSyncTUI uploads pending while showing the interactive queue view and
+// returns the result for each item
+func runSyncTUI(ctx context.Context, client *telegram.Client, config *Config, pending []syncItem, opts syncOptions) ([]syncResult, error) {
+	if len(pending) == 0 {
+		return nil, nil
+	}
+	m := &tuiModel{target: config.TargetID}
+	for _, item := range pending {
+		m.items = append(m.items, &tuiItem{item: item, control: newTransferControl(), state: "queued"})
+	}
+
+	// uploadFile reports on stdout and the progress display; the view
+	// replaces both while it's shown
+	stdout := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer devNull.Close()
+	os.Stdout = devNull
+	defer func() { os.Stdout = stdout }()
+	mode := progressMode
+	progressMode = "none"
+	defer func() { progressMode = mode }()
+
+	prog := tea.NewProgram(m, tea.WithContext(ctx), tea.WithOutput(stdout))
+
+	results := make([]syncResult, len(m.items))
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for i, it := range m.items {
+			results[i] = syncResult{Path: it.item.Path, Status: "cancelled", Size: it.item.Size}
+			if it.skip.Load() || ctx.Err() != nil {
+				continue
+			}
+			itemCtx, cancel := context.WithCancel(ctx)
+			prog.Send(tuiStartMsg{index: i, cancel: cancel})
+
+			fileConfig := *config
+			fileConfig.Control = it.control
+			fileConfig.NoPrompt = true // the view owns the terminal
+			start := time.Now()
+			sent, err := uploadSyncItem(itemCtx, client, &fileConfig, it.item, opts)
+			cancel()
+			results[i].Duration = time.Since(start)
+			switch {
+			case err == nil:
+				results[i].Status, results[i].Sent = "uploaded", sent
+			case !it.skip.Load():
+				results[i].Status = "failed"
+				results[i].Err = err
+			}
+			if config.Events != nil {
+				config.Events.HandleEvent(jobEvent(results[i]))
+			}
+			prog.Send(tuiDoneMsg{index: i, err: err})
+		}
+		prog.Send(tuiFinishedMsg{})
+	}()
+
+	_, err = prog.Run()
+	<-finished
+	if ctx.Err() != nil {
+		return results, ctx.Err()
+	}
+	if err != nil {
+		return results, fmt.Errorf("interactive view failed: %w", err)
+	}
+	return results, nil
+}
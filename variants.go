@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+)
+
+// maxAlbumSize is the most files Telegram groups into one album
+const maxAlbumSize = 10
+
+// videoVariant is one rendition of a video posted with -qualities
+type videoVariant struct {
+	Label    string // like "720p"
+	Path     string
+	Name     string
+	Width    int
+	Height   int
+	Duration float64
+}
+
+// parseQualities parses a -qualities list like "480p,720p,1080p" into
+// heights in pixels
+func parseQualities(s string) ([]int, error) {
+	var heights []int
+	for _, q := range splitList(s) {
+		h, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(q), "p"))
+		if err != nil || h < 144 || h > 4320 {
+			return nil, fmt.Errorf("invalid quality %q, like 720p", q)
+		}
+		heights = append(heights, h)
+	}
+	if len(heights) == 0 {
+		return nil, fmt.Errorf("no qualities in %q", s)
+	}
+	if len(heights) > maxAlbumSize {
+		return nil, fmt.Errorf("an album holds at most %d videos", maxAlbumSize)
+	}
+	return heights, nil
+}
+
+// transcodeVariants renders the video at path with ffmpeg as an H.264/AAC
+// MP4 for each height. Heights above the source's are skipped, since they
+// would only be larger, not better.
+func transcodeVariants(path, name string, heights []int) ([]videoVariant, error) {
+	probe, err := probeVideo(path)
+	if err != nil {
+		return nil, err
+	}
+	var sourceHeight int
+	for _, s := range probe.Streams {
+		if s.CodecType == "video" {
+			sourceHeight = s.Height
+			break
+		}
+	}
+	if sourceHeight == 0 {
+		return nil, fmt.Errorf("%s has no video", name)
+	}
+
+	var variants []videoVariant
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	for _, h := range heights {
+		label := fmt.Sprintf("%dp", h)
+		if h > sourceHeight {
+			fmt.Printf("Skipping %s: the video is only %dp\n", label, sourceHeight)
+			continue
+		}
+		tmp, err := os.CreateTemp("", "variant-*.mp4")
+		if err != nil {
+			removeVariants(variants)
+			return nil, err
+		}
+		tmp.Close()
+		v := videoVariant{Label: label, Path: tmp.Name(), Name: base + "-" + label + ".mp4"}
+		args := []string{"-hide_banner", "-nostdin", "-v", "error", "-y", "-i", path,
+			"-map", "0:v:0", "-map", "0:a:0?", "-sn", "-dn",
+			"-vf", fmt.Sprintf("scale=-2:%d", h),
+			"-c:v", "libx264", "-preset", "medium", "-crf", "23", "-pix_fmt", "yuv420p",
+			"-c:a", "aac", "-b:a", "128k",
+			"-movflags", "+faststart", "-progress", "pipe:1", "-nostats", v.Path}
+		fmt.Printf("Transcoding %s to %s...\n", name, label)
+		if err := runFFmpeg(args, path, v.Name, probe.Format.Duration); err != nil {
+			os.Remove(v.Path)
+			removeVariants(variants)
+			return nil, err
+		}
+		if err := v.measure(); err != nil {
+			os.Remove(v.Path)
+			removeVariants(variants)
+			return nil, err
+		}
+		variants = append(variants, v)
+	}
+	if len(variants) == 0 {
+		return nil, fmt.Errorf("the video is %dp, below all the qualities asked for", sourceHeight)
+	}
+	return variants, nil
+}
+
+// measure reads the dimensions and duration of the rendition
+func (v *videoVariant) measure() error {
+	probe, err := probeVideo(v.Path)
+	if err != nil {
+		return err
+	}
+	for _, s := range probe.Streams {
+		if s.CodecType == "video" {
+			v.Width, v.Height = s.Width, s.Height
+			break
+		}
+	}
+	v.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	return nil
+}
+
+// removeVariants deletes the renditions' temporary files
+func removeVariants(variants []videoVariant) {
+	for _, v := range variants {
+		os.Remove(v.Path)
+	}
+}
+
+// uploadVariants uploads the renditions of config.Variants and posts them
+// to the target as one album, each captioned with its quality
+func uploadVariants(ctx context.Context, client *telegram.Client, config *Config) error {
+	api := client.API()
+	targetID := config.TargetID
+	target := config.Peer
+	if target == nil {
+		var err error
+		if target, err = telegramuploader.ResolvePeer(ctx, api, targetID); err != nil {
+			return err
+		}
+	}
+
+	u := uploader.NewUploader(api).WithPartSize(512 * 1024).WithThreads(config.Connections)
+	album := make([]tg.InputSingleMedia, 0, len(config.Variants))
+	entries := make([]JournalEntry, 0, len(config.Variants))
+	var total int64
+	for _, v := range config.Variants {
+		if info, err := os.Stat(v.Path); err == nil {
+			total += info.Size()
+		}
+	}
+	batch := newBatchProgress(len(config.Variants), total)
+	for _, v := range config.Variants {
+		entry, media, err := uploadVariant(ctx, api, u, target, v, batch)
+		if err != nil {
+			return fmt.Errorf("failed to upload %s: %w", v.Label, err)
+		}
+		// Every post of the album is a new one, so the IDs are drawn afresh
+		randomID, err := generateRandomID()
+		if err != nil {
+			return err
+		}
+		album = append(album, tg.InputSingleMedia{
+			Media:    media,
+			RandomID: randomID,
+			Message:  fmt.Sprintf("%s (%s)", config.FileName, v.Label),
+		})
+		entry.Source, entry.Target = config.Source, targetID
+		entries = append(entries, entry)
+	}
+
+	fmt.Printf("Sending %d videos as an album to %s...\n", len(album), targetLabel(target, targetID))
+	updates, err := api.MessagesSendMultiMedia(ctx, &tg.MessagesSendMultiMediaRequest{
+		Peer:       target,
+		MultiMedia: album,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send album: %w", err)
+	}
+	ids := sentMessageIDs(updates)
+	for i := range entries {
+		if i < len(ids) {
+			entries[i].MessageID = ids[i]
+		}
+		if config.JournalPath != "" {
+			if err := appendJournal(config.JournalPath, entries[i]); err != nil {
+				return fmt.Errorf("failed to record upload in journal: %w", err)
+			}
+		}
+	}
+	fmt.Printf("Album of %d qualities sent\n", len(album))
+	if local, ok := config.Input.(telegramuploader.LocalFile); ok && config.ContactSheet != nil && len(ids) > 0 {
+		if err := config.ContactSheet.post(ctx, api, target, local.Path(), config.FileName, ids[0]); err != nil {
+			fmt.Printf("Failed to send the contact sheet: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// uploadVariant uploads one rendition and turns it into a document that
+// can go into an album
+func uploadVariant(ctx context.Context, api *tg.Client, u *uploader.Uploader, target tg.InputPeerClass, v videoVariant, batch *batchProgress) (JournalEntry, tg.InputMediaClass, error) {
+	file, err := os.Open(v.Path)
+	if err != nil {
+		return JournalEntry{}, nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return JournalEntry{}, nil, err
+	}
+
+	hasher := sha256.New()
+	progress := newFileProgress("upload", v.Name, info.Size(), batch)
+	upload, err := u.Upload(ctx, uploader.NewUpload(v.Name, progress.reader(io.TeeReader(file, hasher)), info.Size()))
+	progress.finish()
+	if err != nil {
+		return JournalEntry{}, nil, err
+	}
+
+	// Albums take documents that are on Telegram already
+	uploaded, err := api.MessagesUploadMedia(ctx, &tg.MessagesUploadMediaRequest{
+		Peer: target,
+		Media: &tg.InputMediaUploadedDocument{
+			File:     upload,
+			MimeType: "video/mp4",
+			Attributes: []tg.DocumentAttributeClass{
+				&tg.DocumentAttributeFilename{FileName: v.Name},
+				&tg.DocumentAttributeVideo{SupportsStreaming: true, W: v.Width, H: v.Height, Duration: v.Duration},
+			},
+		},
+	})
+	if err != nil {
+		return JournalEntry{}, nil, err
+	}
+	media, ok := uploaded.(*tg.MessageMediaDocument)
+	if !ok {
+		return JournalEntry{}, nil, fmt.Errorf("unexpected media type %T", uploaded)
+	}
+	doc, ok := media.Document.(*tg.Document)
+	if !ok {
+		return JournalEntry{}, nil, fmt.Errorf("unexpected document type %T", media.Document)
+	}
+	entry := JournalEntry{
+		Time:     time.Now().UTC(),
+		Name:     v.Name,
+		Size:     info.Size(),
+		SHA256:   hex.EncodeToString(hasher.Sum(nil)),
+		MimeType: "video/mp4",
+	}
+	return entry, &tg.InputMediaDocument{ID: doc.AsInput()}, nil
+}
+
+// sentMessageIDs returns the IDs of the messages created by a send
+// request, in the order they were sent
+func sentMessageIDs(updates tg.UpdatesClass) []int {
+	var list []tg.UpdateClass
+	switch u := updates.(type) {
+	case *tg.Updates:
+		list = u.Updates
+	case *tg.UpdatesCombined:
+		list = u.Updates
+	}
+	var ids []int
+	for _, update := range list {
+		switch u := update.(type) {
+		case *tg.UpdateNewMessage:
+			ids = append(ids, u.Message.GetID())
+		case *tg.UpdateNewChannelMessage:
+			ids = append(ids, u.Message.GetID())
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/pranaykumar2/telegram-file-uploader/pkg/telegramuploader"
+	"golang.org/x/net/webdav"
+)
+
+// webdavPasswordEnv holds the password of the WebDAV server's -user
+const webdavPasswordEnv = "FILEUPLOADER_WEBDAV_PASSWORD"
+
+// runServeWebDAV implements "serve webdav", a WebDAV share of the files in
+// the journal: /uploads/<chat>/<path> for every chat uploaded to, and with
+// -repo /snapshots/<snapshot>/<path> for the snapshots of a backup
+// repository. Files copied into a chat's directory are uploaded there.
+func runServeWebDAV(args []string) error {
+	config := &Config{}
+	flags := flag.NewFlagSet("serve webdav", flag.ExitOnError)
+	credentialFlags(flags, config)
+	listen := flags.String("listen", "127.0.0.1:8080", "Address to serve WebDAV on")
+	user := flags.String("user", "", "User name clients must log in with")
+	password := flags.String("password", os.Getenv(webdavPasswordEnv), "Password of -user (default $"+webdavPasswordEnv+")")
+	allowHost := allowHostFlag(flags)
+	repo := flags.String("repo", "", "Also serve the snapshots of this backup repository, read-only")
+	indexPath := flags.String("index", "", "Path of the local backup index (default: backups/<repo>.json)")
+	cacheDir := flags.String("cache", filepath.Join("backups", "cache"), "Directory caching downloaded backup chunks")
+	flags.StringVar(&config.JournalPath, "journal", defaultJournalPath, "Path of the upload journal, which indexes the files")
+	flags.StringVar(&config.FileCachePath, "file-cache", defaultFileCachePath, "Keep the Telegram IDs of uploaded files here (empty to disable)")
+	timeoutFlags(flags, &config.Timeouts)
+	applyProgressFlags := progressFlags(flags)
+	applySystemdFlags := systemdFlags(flags)
+	applyLogTargetFlags := logTargetFlags(flags)
+	applyTracingFlags := tracingFlags(flags)
+	applyRateFlags := uploadRateFlags(flags)
+	flags.Parse(args)
+	if err := applyProgressFlags(); err != nil {
+		return err
+	}
+	applySystemdFlags()
+	if err := applyLogTargetFlags(); err != nil {
+		return err
+	}
+	if err := applyTracingFlags(); err != nil {
+		return err
+	}
+	rates, err := applyRateFlags()
+	if err != nil {
+		return err
+	}
+	config.Rates = rates
+
+	if *user == "" || *password == "" {
+		return withExitCode(exitUsage, errors.New("-user and -password are required, as anyone who can reach the server could read and write the files"))
+	}
+	if config.JournalPath == "" {
+		return withExitCode(exitUsage, errors.New("the WebDAV server needs the journal to know which files there are"))
+	}
+	var idx *backupIndex
+	if *repo != "" {
+		if *indexPath == "" {
+			*indexPath = defaultIndexPath(*repo)
+		}
+		if idx, err = loadBackupIndex(*indexPath, *repo); err != nil {
+			return err
+		}
+	}
+	if err := validateCredentials(config); err != nil {
+		return err
+	}
+	plainProgressForServers()
+	return withClient(config, func(ctx context.Context, client *telegram.Client) error {
+		dav := newDavFS(client, config)
+		if idx != nil {
+			store, err := openChunkStore(ctx, client.API(), *repo, idx)
+			if err != nil {
+				return err
+			}
+			dav.fetcher = &chunkFetcher{api: store.api, peer: store.peer, index: idx, cacheDir: *cacheDir}
+		}
+		handler := &webdav.Handler{
+			FileSystem: dav,
+			LockSystem: webdav.NewMemLS(),
+			Logger: func(r *http.Request, err error) {
+				if err != nil {
+					log.Printf("WebDAV %s %s: %v", r.Method, r.URL.Path, friendlyError(err))
+				}
+			},
+		}
+		fmt.Printf("Serving the journal's files over WebDAV at http://%s; press Ctrl-C to stop\n", *listen)
+		auth := basicAuth{user: *user, password: *password, realm: "fileuploader", next: handler}
+		return serveHTTP(ctx, client, *listen, newHostGuard(*allowHost, auth))
+	})
+}
+
+// davNode is a file or directory of the WebDAV tree. It is the
+// os.FileInfo of itself.
+type davNode struct {
+	name     string
+	dir      bool
+	size     int64
+	modTime  time.Time
+	children map[string]*davNode // of a directory
+
+	upload *JournalEntry // set for uploaded files
+	snap   *snapshotNode // set for files of snapshots
+}
+
+func (n *davNode) Name() string       { return n.name }
+func (n *davNode) Size() int64        { return n.size }
+func (n *davNode) ModTime() time.Time { return n.modTime }
+func (n *davNode) IsDir() bool        { return n.dir }
+func (n *davNode) Sys() any           { return nil }
+
+func (n *davNode) Mode() fs.FileMode {
+	switch {
+	case n.dir:
+		return fs.ModeDir | 0755
+	case n.snap != nil:
+		return n.snap.Mode.Perm()
+	}
+	return 0644
+}
+
+// ContentType implements webdav.ContentTyper, so that listings needn't
+// download the start of every file to sniff it
+func (n *davNode) ContentType(ctx context.Context) (string, error) {
+	if n.upload != nil && n.upload.MimeType != "" {
+		return n.upload.MimeType, nil
+	}
+	if t := mime.TypeByExtension(path.Ext(n.name)); t != "" {
+		return t, nil
+	}
+	return "application/octet-stream", nil
+}
+
+// ETag implements webdav.ETager with the hash of uploads
+func (n *davNode) ETag(ctx context.Context) (string, error) {
+	if n.upload == nil || n.upload.SHA256 == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return `"` + n.upload.SHA256 + `"`, nil
+}
+
+// add returns the node at the slash separated path p below n, creating it
+// and missing directories on the way. The modification times of the
+// directories follow their newest content.
+func (n *davNode) add(p string, dir bool, modTime time.Time) *davNode {
+	node := n
+	for _, name := range strings.Split(p, "/") {
+		if modTime.After(node.modTime) {
+			node.modTime = modTime
+		}
+		child := node.children[name]
+		if child == nil || !child.dir {
+			child = &davNode{name: name, dir: true, children: map[string]*davNode{}}
+			node.children[name] = child
+		}
+		node = child
+	}
+	if !dir {
+		// A file can't be where a directory is already
+		if len(node.children) > 0 {
+			return &davNode{}
+		}
+		node.dir, node.children = false, nil
+	}
+	if modTime.After(node.modTime) {
+		node.modTime = modTime
+	}
+	return node
+}
+
+// lookup returns the node at the slash separated path p below n, or nil
+func (n *davNode) lookup(p string) *davNode {
+	node := n
+	for _, name := range strings.Split(p, "/") {
+		if name == "" {
+			continue
+		}
+		if node = node.children[name]; node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// davFS is the webdav.FileSystem of the WebDAV share, which the FTP server
+// serves as well. Directories only
+// exist while they hold files, so the ones made over WebDAV are remembered
+// until something is put into them, as are empty files, which Telegram
+// can't store.
+type davFS struct {
+	client  *telegram.Client
+	config  *Config
+	fetcher *chunkFetcher // nil unless snapshots are served
+
+	mu      sync.Mutex
+	peers   map[string]tg.InputPeerClass // resolved chats, by directory name
+	dirs    map[string]time.Time         // directories made over WebDAV, by path
+	empty   map[string]time.Time         // empty files, by path
+	tree    *davNode                     // cached until stamp changes
+	stamp   os.FileInfo                  // of the journal the tree was built from
+	changed bool                         // dirs or empty changed since the tree was built
+}
+
+func newDavFS(client *telegram.Client, config *Config) *davFS {
+	return &davFS{client: client, config: config, peers: map[string]tg.InputPeerClass{}, dirs: map[string]time.Time{}, empty: map[string]time.Time{}}
+}
+
+// root returns the tree, rebuilt whenever the journal has changed
+func (d *davFS) root() (*davNode, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stamp, _ := os.Stat(d.config.JournalPath)
+	if d.tree != nil && !d.changed && sameStamp(stamp, d.stamp) {
+		return d.tree, nil
+	}
+	entries, err := readJournal(d.config.JournalPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	root := &davNode{dir: true, children: map[string]*davNode{}}
+	uploads := root.add("uploads", true, time.Time{})
+	for _, e := range liveEntries(entries) {
+		node := uploads.add(normalizeTarget(e.Target)+"/"+objectPath(e), false, e.Time)
+		node.size, node.upload = e.Size, &e
+	}
+	for p, t := range d.dirs {
+		root.add(p, true, t)
+	}
+	for p, t := range d.empty {
+		root.add(p, false, t)
+	}
+	if d.fetcher != nil {
+		snapshots := root.add("snapshots", true, time.Time{})
+		for _, snap := range d.fetcher.index.Snapshots {
+			dir := snapshots.add(snap.ShortID(), true, snap.Time)
+			for i, node := range snap.Nodes {
+				switch {
+				case node.Path == ".":
+				case node.Type == "dir":
+					dir.add(node.Path, true, node.ModTime)
+				case node.Type == "file":
+					file := dir.add(node.Path, false, node.ModTime)
+					file.size, file.snap = node.Size, &snap.Nodes[i]
+				}
+			}
+		}
+	}
+	d.tree, d.stamp, d.changed = root, stamp, false
+	return root, nil
+}
+
+// sameStamp reports whether a and b describe the same version of a file
+func sameStamp(a, b os.FileInfo) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
+
+// uploadPath splits name into the chat and the path of a file in it, for
+// names under /uploads/<chat>/
+func uploadPath(name string) (remotePath, bool) {
+	rest, ok := strings.CutPrefix(strings.Trim(name, "/"), "uploads/")
+	if !ok {
+		return remotePath{}, false
+	}
+	chat, p, _ := strings.Cut(rest, "/")
+	return remotePath{Chat: chat, Path: p}, p != ""
+}
+
+// config returns the config of uploads to chat
+func (d *davFS) configFor(ctx context.Context, chat string) (*Config, error) {
+	d.mu.Lock()
+	p, ok := d.peers[chat]
+	d.mu.Unlock()
+	if !ok {
+		var err error
+		if p, err = telegramuploader.ResolvePeer(ctx, d.client.API(), chat); err != nil {
+			return nil, err
+		}
+		d.mu.Lock()
+		d.peers[chat] = p
+		d.mu.Unlock()
+	}
+	config := *d.config
+	config.TargetID, config.Peer = chat, p
+	return &config, nil
+}
+
+// forget drops the remembered directories and empty files at or under name
+func (d *davFS) forget(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, m := range []map[string]time.Time{d.dirs, d.empty} {
+		for p := range m {
+			if p == name || strings.HasPrefix(p, name+"/") {
+				delete(m, p)
+				d.changed = true
+			}
+		}
+	}
+}
+
+// keepEmpty remembers an empty file at name, which Telegram can't store
+func (d *davFS) keepEmpty(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.empty[strings.Trim(name, "/")] = time.Now()
+	d.changed = true
+}
+
+// Mkdir implements webdav.FileSystem
+func (d *davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	root, err := d.root()
+	if err != nil {
+		return err
+	}
+	name = strings.Trim(name, "/")
+	if root.lookup(name) != nil {
+		return os.ErrExist
+	}
+	if parent := root.lookup(path.Dir(name)); parent == nil || !parent.dir {
+		return os.ErrNotExist
+	}
+	if !strings.HasPrefix(name, "uploads/") {
+		return os.ErrPermission
+	}
+	d.mu.Lock()
+	d.dirs[name] = time.Now()
+	d.changed = true
+	d.mu.Unlock()
+	return nil
+}
+
+// OpenFile implements webdav.FileSystem. Files opened for writing are
+// uploaded as they're written.
+func (d *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	root, err := d.root()
+	if err != nil {
+		return nil, err
+	}
+	node := root.lookup(name)
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		if node == nil {
+			return nil, os.ErrNotExist
+		}
+		return &davFile{ctx: ctx, fs: d, node: node}, nil
+	}
+
+	r, ok := uploadPath(name)
+	if !ok || (node != nil && node.dir) {
+		return nil, os.ErrPermission
+	}
+	if parent := root.lookup(path.Dir(name)); parent == nil || !parent.dir {
+		return nil, os.ErrNotExist
+	}
+	return &davUpload{ctx: ctx, fs: d, r: r, name: strings.Trim(name, "/")}, nil
+}
+
+// RemoveAll implements webdav.FileSystem, deleting the messages of the
+// files at or under name. Whole chats can't be removed.
+func (d *davFS) RemoveAll(ctx context.Context, name string) error {
+	r, ok := uploadPath(name)
+	if !ok {
+		return os.ErrPermission
+	}
+	d.forget(strings.Trim(name, "/"))
+	config, err := d.configFor(ctx, r.Chat)
+	if err != nil {
+		return err
+	}
+	entries, err := readJournal(config.JournalPath)
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	return deleteStale(ctx, d.client, config, remoteObjects(entries, r))
+}
+
+// Rename implements webdav.FileSystem within a chat. Only the journal
+// changes; the documents keep the names they were uploaded under.
+func (d *davFS) Rename(ctx context.Context, oldName, newName string) error {
+	from, ok := uploadPath(oldName)
+	to, ok2 := uploadPath(newName)
+	if !ok || !ok2 || from.Chat != to.Chat {
+		return os.ErrPermission
+	}
+	entries, err := readJournal(d.config.JournalPath)
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	for _, e := range remoteObjects(entries, from) {
+		e.Path = to.Path + strings.TrimPrefix(objectPath(e), from.Path)
+		if err := appendJournal(d.config.JournalPath, e); err != nil {
+			return fmt.Errorf("failed to record the move in the journal: %w", err)
+		}
+	}
+
+	// Move what's only remembered along
+	oldName, newName = strings.Trim(oldName, "/"), strings.Trim(newName, "/")
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, m := range []map[string]time.Time{d.dirs, d.empty} {
+		for p, t := range m {
+			if p == oldName || strings.HasPrefix(p, oldName+"/") {
+				delete(m, p)
+				m[newName+strings.TrimPrefix(p, oldName)] = t
+				d.changed = true
+			}
+		}
+	}
+	return nil
+}
+
+// Stat implements webdav.FileSystem
+func (d *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	root, err := d.root()
+	if err != nil {
+		return nil, err
+	}
+	node := root.lookup(name)
+	if node == nil {
+		return nil, os.ErrNotExist
+	}
+	return node, nil
+}
+
+// davFile is a file or directory of the tree opened for reading. Uploads
+// are only fetched from Telegram once they're read.
+type davFile struct {
+	ctx    context.Context
+	fs     *davFS
+	node   *davNode
+	off    int64
+	remote *remoteFile
+	listed bool // Readdir has returned the directory's entries
+}
+
+// Read implements io.Reader
+func (f *davFile) Read(p []byte) (int, error) {
+	switch {
+	case f.node.dir:
+		return 0, fmt.Errorf("%s is a directory", f.node.name)
+	case f.off >= f.node.size:
+		return 0, io.EOF
+	case f.node.snap != nil:
+		n, err := f.fs.fetcher.readAt(f.ctx, f.node.snap.Chunks, p[:min(int64(len(p)), f.node.size-f.off)], f.off)
+		f.off += int64(n)
+		return n, err
+	case f.node.upload != nil:
+		if f.remote == nil {
+			config, err := f.fs.configFor(f.ctx, f.node.upload.Target)
+			if err != nil {
+				return 0, err
+			}
+			if f.remote, err = openRemote(f.ctx, f.fs.client.API(), config.Peer, *f.node.upload); err != nil {
+				return 0, err
+			}
+		}
+		f.remote.off = f.off
+		n, err := f.remote.Read(p)
+		f.off += int64(n)
+		return n, err
+	}
+	return 0, io.EOF
+}
+
+// Seek implements io.Seeker
+func (f *davFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.node.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before the start of the file")
+	}
+	f.off = offset
+	return offset, nil
+}
+
+// Readdir implements http.File, returning all entries at once
+func (f *davFile) Readdir(count int) ([]fs.FileInfo, error) {
+	if !f.node.dir {
+		return nil, fmt.Errorf("%s is not a directory", f.node.name)
+	}
+	if f.listed && count > 0 {
+		return nil, io.EOF
+	}
+	f.listed = true
+	infos := make([]fs.FileInfo, 0, len(f.node.children))
+	for _, child := range f.node.children {
+		infos = append(infos, child)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+func (f *davFile) Stat() (fs.FileInfo, error)  { return f.node, nil }
+func (f *davFile) Write(p []byte) (int, error) { return 0, os.ErrPermission }
+func (f *davFile) Close() error                { return nil }
+
+// davUpload is a file opened for writing, streamed into an upload to its
+// chat as it's written. The upload only starts with the first write;
+// closing the file waits for it to be sent.
+type davUpload struct {
+	ctx  context.Context
+	fs   *davFS
+	r    remotePath
+	name string // path in the tree
+	size int64
+
+	pw   *io.PipeWriter
+	done chan error
+}
+
+// Write implements io.Writer
+func (u *davUpload) Write(p []byte) (int, error) {
+	if u.pw == nil {
+		config, err := u.fs.configFor(u.ctx, u.r.Chat)
+		if err != nil {
+			return 0, err
+		}
+		entries, err := readJournal(config.JournalPath)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read journal: %w", err)
+		}
+		pr, pw := io.Pipe()
+		u.pw, u.done = pw, make(chan error, 1)
+		go func() {
+			err := putRemote(u.ctx, u.fs.client, config, entries, u.r, pr, -1)
+			// Fail the writes still blocked if the upload gave up early
+			pr.CloseWithError(err)
+			u.done <- err
+		}()
+	}
+	n, err := u.pw.Write(p)
+	u.size += int64(n)
+	return n, err
+}
+
+// Close implements io.Closer
+func (u *davUpload) Close() error {
+	u.fs.forget(u.name)
+	if u.pw == nil {
+		u.fs.keepEmpty(u.name)
+		return nil
+	}
+	u.pw.Close()
+	return <-u.done
+}
+
+func (u *davUpload) Stat() (fs.FileInfo, error) {
+	return &davNode{name: path.Base(u.name), size: u.size, modTime: time.Now()}, nil
+}
+
+func (u *davUpload) Read(p []byte) (int, error) { return 0, os.ErrPermission }
+func (u *davUpload) Seek(offset int64, whence int) (int64, error) {
+	return 0, errors.New("files being uploaded can't seek")
+}
+func (u *davUpload) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, fmt.Errorf("%s is not a directory", u.name)
+}